@@ -70,18 +70,20 @@ export class Api {
 		}
 	}
 
-	async uuk(entityID: string): Promise<[UUK | undefined, Error | undefined]> {
+	// uuk returns the UUK of the user and the entity id its 2SKD is salted
+	// with, which is the id of a merged entity for merged users
+	async uuk(entityID: string): Promise<[UUK | undefined, string, Error | undefined]> {
 		let response = await this.get(`${this.mount}/users/${entityID}`);
 
 		if (response.status != 200) {
 			let err = await response.text();
-			return [undefined, new Error(`error registering: ${err}`)];
+			return [undefined, '', new Error(`error registering: ${err}`)];
 		}
 
 		let json = await response.json();
 		let uuk = revertCase<UUK>(json['data']['uuk'], false) as UUK;
 
-		return [uuk, undefined];
+		return [uuk, json['data']['uuk_entity_id'] ?? entityID, undefined];
 	}
 
 	async getMetadata(b: Bundle): Promise<[HvMetadata | undefined, Error | undefined]> {
@@ -0,0 +1,44 @@
+import { expect, test } from 'vitest';
+import { readFileSync } from 'fs';
+import { bytesToHex, hexToBytes, importJWKkey, prikeyDecrypt, symmetricDecrypt } from './helper';
+
+// Shared with the Go bundle crypto tests in plugin/bundle_crypto_test.go
+const vectors = JSON.parse(
+	readFileSync(
+		new URL('../../../plugin/testdata/bundle_crypto_vectors.json', import.meta.url),
+		'utf-8'
+	)
+);
+
+async function importPriKey(): Promise<CryptoKey> {
+	return await crypto.subtle.importKey(
+		'jwk',
+		vectors.private_key,
+		{ name: 'RSA-OAEP', hash: 'SHA-256' },
+		true,
+		['decrypt']
+	);
+}
+
+test('unwrap bundle keys wrapped by the web client and Go', async () => {
+	let priKey = await importPriKey();
+	for (let wrapped of [vectors.wrapped_bundle_key, vectors.go_wrapped_bundle_key]) {
+		let jwk = JSON.parse(await prikeyDecrypt(wrapped, priKey));
+		expect(jwk.k, 'should unwrap the bundle key').toEqual(vectors.bundle_key.k);
+	}
+});
+
+test('encrypt/decrypt payloads', async () => {
+	let key = await importJWKkey(vectors.bundle_key);
+	for (let p of vectors.payloads) {
+		let plaintext = await symmetricDecrypt(p.entry, p.iv, key);
+		expect(plaintext, `should decrypt ${p.name}`).toEqual(p.plaintext);
+
+		let encrypted = await crypto.subtle.encrypt(
+			{ name: 'AES-GCM', iv: hexToBytes(p.iv) },
+			key,
+			new TextEncoder().encode(JSON.stringify(JSON.parse(plaintext)))
+		);
+		expect(bytesToHex(new Uint8Array(encrypted)), `should encrypt ${p.name}`).toEqual(p.entry);
+	}
+});
@@ -13,6 +13,8 @@ export function getInputComponent(type: string) {
 			return ItemInput;
 		case 'date':
 			return ItemInput;
+		case 'otp':
+			return ItemInput;
 	}
 }
 
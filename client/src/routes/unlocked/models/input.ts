@@ -33,3 +33,13 @@ export class DateInput implements Input {
 	Value: string = '';
 	Metadata: any;
 }
+
+/**
+ * An OTPInput stores an `otpauth://` URI of a TOTP or HOTP 2FA seed.
+ */
+export class OTPInput implements Input {
+	Type: string = 'otp';
+	Label: string = 'one-time password';
+	Placeholder: string = 'otpauth://';
+	Value: string = '';
+}
@@ -0,0 +1,101 @@
+package secretsengine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"time"
+)
+
+// Account is used to perform account portability operations on Vault.
+type Account struct {
+	c *pwmanagerClient
+}
+
+// Account is used to return the client for account API calls.
+func (c *pwmanagerClient) Account() *Account {
+	return &Account{c: c}
+}
+
+// AccountImport is the result of an account import.
+type AccountImport struct {
+	Bundles []Bundle `json:"bundles"`
+	// Members are the entity names of the users each bundle was shared with
+	// keyed by bundle id
+	Members map[string][]string `json:"members"`
+}
+
+// Export returns the callers account as a package signed by the mount that
+// can be imported on the target mount until the ttl expires. A zero ttl uses
+// the default of the mount.
+func (c *Account) Export(ctx context.Context, mount, target string, ttl time.Duration) (AccountPackage, error) {
+	params := neturl.Values{"mount": {target}}
+	if ttl > 0 {
+		params.Set("ttl", strconv.Itoa(int(ttl.Seconds())))
+	}
+	secret, err := c.c.readParams(ctx, fmt.Sprintf("/v1/%s/account/export", mount), params)
+	if err != nil {
+		return AccountPackage{}, err
+	}
+
+	var result struct {
+		Package AccountPackage `json:"package"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return AccountPackage{}, err
+	}
+	return result.Package, nil
+}
+
+// Export decodes the payload of the package without verifying it, the
+// importing mount verifies it.
+func (p AccountPackage) Export() (AccountExport, error) {
+	var export AccountExport
+	if err := json.Unmarshal(p.Payload, &export); err != nil {
+		return AccountExport{}, fmt.Errorf("error decoding package payload: %w", err)
+	}
+	return export, nil
+}
+
+// Import registers the caller with the account of the package. uuk is the
+// UUK of the package rewrapped for the mount and the callers entity id, see
+// UUK.Rewrap. The exported UUK is salted with the SaltEntityID of the
+// exported user.
+func (c *Account) Import(ctx context.Context, mount string, pkg AccountPackage, uuk UUK) (AccountImport, error) {
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/account/import", mount), map[string]interface{}{"package": pkg, "uuk": uuk})
+	if err != nil {
+		return AccountImport{}, err
+	}
+
+	var result AccountImport
+	if err := decodeData(secret, &result); err != nil {
+		return AccountImport{}, err
+	}
+	return result, nil
+}
+
+// SigningKey returns the base64 encoded public key the mount signs account
+// packages with.
+func (c *Account) SigningKey(ctx context.Context, mount string) (string, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/account/signing_key", mount))
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return "", err
+	}
+	return result.PublicKey, nil
+}
+
+// TrustKey allows the mount to import packages signed by the public key of
+// another mount, see SigningKey.
+func (c *Account) TrustKey(ctx context.Context, mount, name, publicKey string) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/account/trusted_keys/%s", mount, name), map[string]string{"public_key": publicKey})
+	return err
+}
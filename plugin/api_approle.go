@@ -4,111 +4,74 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/hashicorp/vault/api"
 )
 
 // AppRole is used to perform AppRole operations on Vault.
 type AppRole struct {
-	c *api.Client
+	c *pwmanagerClient
 }
 
 // AppRole is used to return the client for AppRole API calls.
 func (c *pwmanagerClient) AppRole() *AppRole {
-	return &AppRole{c: c.c}
+	return &AppRole{c: c}
 }
 
-func (c *AppRole) Enable(path string) error {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/sys/auth/%s", path))
-	r.Body = strings.NewReader(`{"type": "approle"}`)
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+func (c *AppRole) Enable(ctx context.Context, path string) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/sys/auth/%s", path), map[string]string{"type": "approle"})
+	return err
 }
 
-func (c *AppRole) CreateRole(mount, roleName string, jsonData string) error {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/role/%s", mount, roleName))
-	r.Body = strings.NewReader(jsonData)
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+func (c *AppRole) CreateRole(ctx context.Context, mount, roleName string, jsonData string) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/auth/%s/role/%s", mount, roleName), json.RawMessage(jsonData))
+	return err
 }
 
-func (c *AppRole) RoleID(mount, roleName string) (RoleResponse, error) {
-	r := c.c.NewRequest("GET", fmt.Sprintf("/v1/auth/%s/role/%s/role-id", mount, roleName))
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return RoleResponse{}, err
-	}
-	defer resp.Body.Close()
+func (c *AppRole) RoleID(ctx context.Context, mount, roleName string) (RoleResponse, error) {
+	r := c.c.c.NewRequest("GET", fmt.Sprintf("/v1/auth/%s/role/%s/role-id", mount, roleName))
 
 	var result RoleResponse
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
+	if err := c.decode(ctx, r, &result); err != nil {
 		return RoleResponse{}, err
 	}
 
 	return result, nil
 }
 
-func (c *AppRole) SecretID(mount, roleName string, jsonData string) (SecretIDResponse, error) {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/role/%s/secret-id", mount, roleName))
-	r.Body = strings.NewReader(jsonData)
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return SecretIDResponse{}, err
-	}
-	defer resp.Body.Close()
+func (c *AppRole) SecretID(ctx context.Context, mount, roleName string, jsonData string) (SecretIDResponse, error) {
+	r := c.c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/role/%s/secret-id", mount, roleName))
+	r.BodyBytes = []byte(jsonData)
 
 	var result SecretIDResponse
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
+	if err := c.decode(ctx, r, &result); err != nil {
 		return SecretIDResponse{}, err
 	}
 
 	return result, nil
 }
 
-func (c *AppRole) Login(mount, jsonData string) (LoginResponse, error) {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/login", mount))
-	r.Body = strings.NewReader(jsonData)
+func (c *AppRole) Login(ctx context.Context, mount, jsonData string) (LoginResponse, error) {
+	r := c.c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/login", mount))
+	r.BodyBytes = []byte(jsonData)
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
+	var result LoginResponse
+	if err := c.decode(ctx, r, &result); err != nil {
 		return LoginResponse{}, err
 	}
-	defer resp.Body.Close()
 
-	var result LoginResponse
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result, nil
+}
+
+// decode sends the request and decodes the json response into v
+func (c *AppRole) decode(ctx context.Context, r *api.Request, v interface{}) error {
+	resp, err := c.c.do(ctx, r)
 	if err != nil {
-		return LoginResponse{}, err
+		return err
 	}
+	defer resp.Body.Close()
 
-	return result, nil
+	return json.NewDecoder(resp.Body).Decode(v)
 }
 
 type RoleResponse struct {
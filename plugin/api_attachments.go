@@ -0,0 +1,49 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Attachments is used to perform attachment operations on Vault.
+type Attachments struct {
+	c *pwmanagerClient
+}
+
+// Attachments is used to return the client for attachment API calls.
+func (c *pwmanagerClient) Attachments() *Attachments {
+	return &Attachments{c: c}
+}
+
+// AttachmentUsage is the attachment quota of a bundle and the bytes used.
+type AttachmentUsage struct {
+	Quota       int64            `json:"quota"`
+	Used        int64            `json:"used"`
+	Attachments []AttachmentSize `json:"attachments"`
+}
+
+// Usage returns the attachment quota and usage of a bundle
+func (c *Attachments) Usage(ctx context.Context, mount, ownerEntityID, bundleID string) (AttachmentUsage, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/attachments", mount, ownerEntityID, bundleID))
+	if err != nil {
+		return AttachmentUsage{}, err
+	}
+
+	var result AttachmentUsage
+	if err := decodeData(secret, &result); err != nil {
+		return AttachmentUsage{}, err
+	}
+	return result, nil
+}
+
+// PutChunk writes an encrypted chunk of an attachment. chunk is the index of
+// the chunk or manifest.
+func (c *Attachments) PutChunk(ctx context.Context, mount, ownerEntityID, bundleID, entryPath, attachmentID, chunk string, ee EncryptedEntry) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/attachments/%s/%s/%s", mount, ownerEntityID, bundleID, entryPath, attachmentID, chunk), map[string]interface{}{"data": ee})
+	return err
+}
+
+// Delete destroys the chunks of an attachment
+func (c *Attachments) Delete(ctx context.Context, mount, ownerEntityID, bundleID, entryPath, attachmentID string) error {
+	return c.c.delete(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/attachments/%s/%s", mount, ownerEntityID, bundleID, entryPath, attachmentID))
+}
@@ -0,0 +1,56 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+)
+
+// Backup is used to perform backup and restore operations on Vault.
+type Backup struct {
+	c *pwmanagerClient
+}
+
+// Backup is used to return the client for backup API calls.
+func (c *pwmanagerClient) Backup() *Backup {
+	return &Backup{c: c}
+}
+
+// RestoreResult counts what a restore wrote.
+type RestoreResult struct {
+	Records  int `json:"records"`
+	Secrets  int `json:"secrets"`
+	Policies int `json:"policies"`
+}
+
+// Snapshot returns a snapshot of the plugin records of the mount and, with
+// includeKVData, of the kv-v2 data of its bundles.
+func (c *Backup) Snapshot(ctx context.Context, mount string, includeKVData bool) (Snapshot, error) {
+	secret, err := c.c.readParams(ctx, fmt.Sprintf("/v1/%s/backup", mount), neturl.Values{"include_kv_data": {strconv.FormatBool(includeKVData)}})
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var result struct {
+		Snapshot Snapshot `json:"snapshot"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return Snapshot{}, err
+	}
+	return result.Snapshot, nil
+}
+
+// Restore writes the snapshot into the mount, which must have no records.
+func (c *Backup) Restore(ctx context.Context, mount string, snapshot Snapshot) (RestoreResult, error) {
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/restore", mount), map[string]interface{}{"snapshot": snapshot})
+	if err != nil {
+		return RestoreResult{}, err
+	}
+
+	var result RestoreResult
+	if err := decodeData(secret, &result); err != nil {
+		return RestoreResult{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Bundles is used to perform Bundles operations on Vault.
+type Bundles struct {
+	c *pwmanagerClient
+}
+
+// Bundles is used to return the client for Bundles API calls.
+func (c *pwmanagerClient) Bundles() *Bundles {
+	return &Bundles{c: c}
+}
+
+// BundlesResponse contains the bundles a user owns and the bundles shared with the user.
+type BundlesResponse struct {
+	Bundles       []Bundle       `json:"bundles"`
+	SharedBundles []SharedBundle `json:"shared_bundles"`
+}
+
+// CreateBundleResponse contains the kv-v2 path of the new bundle and the users bundles.
+type CreateBundleResponse struct {
+	Bundles []Bundle `json:"bundles"`
+	Path    string   `json:"path"`
+}
+
+// BundleUsersRequest sets the users of a bundle. Users are identified by
+// EntityName, which is an entity name, an entity id or an auth alias given as
+// <auth mount>/<alias name>.
+type BundleUsersRequest struct {
+	Users []BundleUser `json:"users"`
+}
+
+// BundleUsersResponse contains the public keys of the bundle users keyed by entity id.
+// The bundle key must be encrypted with each public key.
+type BundleUsersResponse struct {
+	PubKeys map[string]PubKey `json:"pubkeys"`
+}
+
+// List returns the bundles owned by and shared with the caller
+func (c *Bundles) List(ctx context.Context, mount string) (BundlesResponse, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/bundles", mount))
+	if err != nil {
+		return BundlesResponse{}, err
+	}
+
+	var result BundlesResponse
+	if err := decodeData(secret, &result); err != nil {
+		return BundlesResponse{}, err
+	}
+	return result, nil
+}
+
+// Shared returns the bundles shared with the caller
+func (c *Bundles) Shared(ctx context.Context, mount string) ([]SharedBundle, error) {
+	bundles, err := c.List(ctx, mount)
+	if err != nil {
+		return nil, err
+	}
+	return bundles.SharedBundles, nil
+}
+
+// Get returns a single bundle
+func (c *Bundles) Get(ctx context.Context, mount, ownerEntityID, bundleID string) (Bundle, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s", mount, ownerEntityID, bundleID))
+	if err != nil {
+		return Bundle{}, err
+	}
+
+	var result struct {
+		Bundle Bundle `json:"bundle"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return Bundle{}, err
+	}
+	return result.Bundle, nil
+}
+
+// Create creates a new bundle owned by the caller
+func (c *Bundles) Create(ctx context.Context, mount string) (CreateBundleResponse, error) {
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/bundles", mount), nil)
+	if err != nil {
+		return CreateBundleResponse{}, err
+	}
+
+	var result CreateBundleResponse
+	if err := decodeData(secret, &result); err != nil {
+		return CreateBundleResponse{}, err
+	}
+	return result, nil
+}
+
+// Delete deletes a bundle owned by the caller and revokes access for all bundle users
+func (c *Bundles) Delete(ctx context.Context, mount, ownerEntityID, bundleID string) error {
+	return c.c.delete(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s", mount, ownerEntityID, bundleID))
+}
+
+// UpdateUsers replaces the users of a bundle and returns the public keys of the bundle users
+func (c *Bundles) UpdateUsers(ctx context.Context, mount, ownerEntityID, bundleID string, users []BundleUser) (BundleUsersResponse, error) {
+	body := BundleUsersRequest{Users: users}
+
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/users", mount, ownerEntityID, bundleID), body)
+	if err != nil {
+		return BundleUsersResponse{}, err
+	}
+
+	var result BundleUsersResponse
+	if err := decodeData(secret, &result); err != nil {
+		return BundleUsersResponse{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package secretsengine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundlesClient(t *testing.T) {
+	const (
+		mount    = "pwmanager"
+		ownerID  = "928e91c7-db18-9673-4342-6f731c7f561a"
+		bundleID = "0bbf993d-8e10-6dd0-1aa3-80019b69e332"
+	)
+
+	t.Run("List", func(t *testing.T) {
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodGet, r.Method)
+			require.Equal(t, "/v1/pwmanager/bundles", r.URL.Path)
+			w.Write([]byte(`{"data":{
+				"bundles":[{"id":"` + bundleID + `","path":"bundles/data/` + ownerID + `/` + bundleID + `","created":1,"owner_entity_id":"` + ownerID + `","users":[{"entity_name":"bob","is_admin":true,"capabilities":"read"}]}],
+				"shared_bundles":[{"id":"b","path":"bundles/data/a/b","created":2,"owner_entity_id":"a","is_admin":false,"capabilities":"read"}]
+			}}`))
+		})
+
+		resp, err := c.Bundles().List(context.Background(), mount)
+		require.NoError(t, err)
+		require.Len(t, resp.Bundles, 1)
+		require.Equal(t, bundleID, resp.Bundles[0].ID)
+		require.Equal(t, int64(1), resp.Bundles[0].Created)
+		require.Equal(t, "bob", resp.Bundles[0].Users[0].EntityName)
+		require.Len(t, resp.SharedBundles, 1)
+		require.Equal(t, "read", resp.SharedBundles[0].Capabilities)
+	})
+
+	t.Run("UpdateUsers", func(t *testing.T) {
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/v1/pwmanager/bundles/"+ownerID+"/"+bundleID+"/users", r.URL.Path)
+
+			var body BundleUsersRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Len(t, body.Users, 1)
+			require.Equal(t, "bob", body.Users[0].EntityName)
+
+			w.Write([]byte(`{"data":{"pubkeys":{"bob-id":{"kty":"RSA","n":"abc","e":"AQAB"}}}}`))
+		})
+
+		resp, err := c.Bundles().UpdateUsers(context.Background(), mount, ownerID, bundleID, []BundleUser{
+			{EntityName: "bob", Capabilities: "read"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "RSA", resp.PubKeys["bob-id"]["kty"])
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodDelete, r.Method)
+			require.Equal(t, "/v1/pwmanager/bundles/"+ownerID+"/"+bundleID, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		require.NoError(t, c.Bundles().Delete(context.Background(), mount, ownerID, bundleID))
+	})
+}
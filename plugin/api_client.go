@@ -2,27 +2,58 @@
 package secretsengine
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
 
 	vault "github.com/hashicorp/vault/api"
 )
 
+const (
+	defaultMaxRetries   = 3
+	defaultMinRetryWait = 100 * time.Millisecond
+	defaultMaxRetryWait = 2 * time.Second
+)
+
 // pwmanger client wrapper over the vault api client. I want to be able
 // to extend the api client but can't since the api client exists in the
 // vault repo.
 type pwmanagerClient struct {
 	c *vault.Client
+
+	// retries are handled by the wrapper instead of the vault api client
+	// so only idempotent requests are retried.
+	maxRetries   int
+	minRetryWait time.Duration
+	maxRetryWait time.Duration
 }
 
-// NewClient returns a wrapped vault api client.
+// Client is the exported name of pwmanagerClient.
+//
+// The backend types are unexported. The SDK exports an alias for each type
+// its requests and responses carry, e.g. Bundle for pwmgrBundle, so SDK
+// consumers and the backend share one struct and its JSON encoding.
+type Client = pwmanagerClient
+
+// NewClient returns a wrapped vault api client. hostPort may include the
+// scheme e.g. https://vault:8200, http is used when it is omitted.
 func NewClient(token string, hostPort string) (*pwmanagerClient, error) {
 	config := vault.DefaultConfig()
-	config.Address = "http://" + hostPort
+	config.Address = hostPort
+	if !strings.Contains(hostPort, "://") {
+		config.Address = "http://" + hostPort
+	}
 
-	// leaving this here as a reminder that this client
-	// sets default retries
-	// config.MaxRetryWait = 30 * time.Second
-	// config.MaxRetries = 0
+	// the vault api client retries every request including non
+	// idempotent writes. Disable it, do() retries idempotent requests.
+	config.MaxRetries = 0
 	client, err := vault.NewClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize Vault client: %v", err)
@@ -32,5 +63,140 @@ func NewClient(token string, hostPort string) (*pwmanagerClient, error) {
 		client.SetToken(token)
 	}
 
-	return &pwmanagerClient{c: client}, nil
+	return &pwmanagerClient{
+		c:            client,
+		maxRetries:   defaultMaxRetries,
+		minRetryWait: defaultMinRetryWait,
+		maxRetryWait: defaultMaxRetryWait,
+	}, nil
+}
+
+// SetToken sets the token used by the client.
+func (c *pwmanagerClient) SetToken(token string) {
+	c.c.SetToken(token)
+}
+
+// do sends the request to Vault. Vault error payloads are decoded into an
+// *APIError and idempotent requests are retried with exponential backoff.
+// The caller must close the response body when err is nil.
+func (c *pwmanagerClient) do(ctx context.Context, r *vault.Request) (*vault.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.c.RawRequestWithContext(ctx, r)
+		if err == nil {
+			return resp, nil
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		err = toAPIError(r, err)
+		if !isIdempotent(r.Method) || attempt >= c.maxRetries || !isRetryable(err) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns the wait duration before the next attempt. The wait doubles
+// each attempt with jitter and is capped at maxRetryWait.
+func (c *pwmanagerClient) backoff(attempt int) time.Duration {
+	wait := c.minRetryWait << attempt
+	if wait <= 0 || wait > c.maxRetryWait {
+		wait = c.maxRetryWait
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(wait)/2 + 1))
+	return wait/2 + jitter
+}
+
+// read performs a GET request and returns the parsed secret.
+func (c *pwmanagerClient) read(ctx context.Context, path string) (*vault.Secret, error) {
+	return c.send(ctx, c.c.NewRequest(http.MethodGet, path))
+}
+
+// readParams performs a GET request with the query parameters and returns
+// the parsed secret.
+func (c *pwmanagerClient) readParams(ctx context.Context, path string, params neturl.Values) (*vault.Secret, error) {
+	r := c.c.NewRequest(http.MethodGet, path)
+	r.Params = params
+	return c.send(ctx, r)
+}
+
+// list performs a LIST request and returns the parsed secret.
+func (c *pwmanagerClient) list(ctx context.Context, path string) (*vault.Secret, error) {
+	return c.send(ctx, c.c.NewRequest("LIST", path))
+}
+
+// write performs a POST request with body encoded as JSON and returns the
+// parsed secret. The secret is nil when Vault responds with no content.
+func (c *pwmanagerClient) write(ctx context.Context, path string, body interface{}) (*vault.Secret, error) {
+	r := c.c.NewRequest(http.MethodPost, path)
+	if body != nil {
+		if err := r.SetJSONBody(body); err != nil {
+			return nil, err
+		}
+	}
+	return c.send(ctx, r)
+}
+
+// delete performs a DELETE request.
+func (c *pwmanagerClient) delete(ctx context.Context, path string) error {
+	_, err := c.send(ctx, c.c.NewRequest(http.MethodDelete, path))
+	return err
+}
+
+// send performs the request and parses the response body into a secret.
+func (c *pwmanagerClient) send(ctx context.Context, r *vault.Request) (*vault.Secret, error) {
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	secret, err := vault.ParseSecret(resp.Body)
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing response from %s %s: %w", r.Method, r.URL.Path, err)
+	}
+
+	return secret, nil
+}
+
+// isIdempotent reports if a request with the method can safely be retried.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, "LIST":
+		return true
+	}
+	return false
+}
+
+// decodeData decodes the data of the secret into out using the json tags of out.
+func decodeData(secret *vault.Secret, out interface{}) error {
+	if secret == nil || secret.Data == nil {
+		return fmt.Errorf("data from server response is empty")
+	}
+
+	b, err := json.Marshal(secret.Data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, out)
 }
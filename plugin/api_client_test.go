@@ -0,0 +1,127 @@
+package secretsengine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testVaultServer returns a pwmanagerClient pointed at a test server that
+// responds with handler.
+func testVaultServer(t *testing.T, handler http.HandlerFunc) *pwmanagerClient {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient("token", strings.TrimPrefix(srv.URL, "http://"))
+	require.NoError(t, err)
+
+	c.minRetryWait = time.Millisecond
+	c.maxRetryWait = 5 * time.Millisecond
+	return c
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		kind   error
+	}{
+		{"not found", http.StatusNotFound, `{"errors":[]}`, ErrNotFound},
+		{"permission denied", http.StatusForbidden, `{"errors":["permission denied"]}`, ErrPermissionDenied},
+		{"cas mismatch", http.StatusBadRequest, `{"errors":["check-and-set parameter did not match the current version"]}`, ErrCASMismatch},
+		{"sealed", http.StatusServiceUnavailable, `{"errors":["Vault is sealed"]}`, ErrSealed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			_, err := c.read(context.Background(), "/v1/pwmanager/users/id")
+			require.ErrorIs(t, err, tt.kind)
+
+			var apiErr *APIError
+			require.True(t, errors.As(err, &apiErr))
+			require.Equal(t, tt.status, apiErr.StatusCode)
+		})
+	}
+
+	t.Run("unclassified", func(t *testing.T) {
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["user already registered"]}`))
+		})
+
+		_, err := c.write(context.Background(), "/v1/pwmanager/register", nil)
+
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		require.Nil(t, apiErr.Unwrap())
+		require.Equal(t, []string{"user already registered"}, apiErr.Errors)
+	})
+}
+
+func TestClientRetries(t *testing.T) {
+	t.Run("idempotent request is retried", func(t *testing.T) {
+		var calls int32
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`{"data":{"keys":["a"]}}`))
+		})
+
+		secret, err := c.list(context.Background(), "/v1/pwmanager/users/")
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"a"}, secret.Data["keys"])
+		require.EqualValues(t, 3, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("write is not retried", func(t *testing.T) {
+		var calls int32
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+
+		_, err := c.write(context.Background(), "/v1/pwmanager/bundles", nil)
+		require.Error(t, err)
+		require.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		var calls int32
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+
+		_, err := c.read(context.Background(), "/v1/pwmanager/bundles")
+		require.Error(t, err)
+		require.EqualValues(t, c.maxRetries+1, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("cancelled context stops retries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+
+		_, err := c.read(ctx, "/v1/pwmanager/bundles")
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,106 @@
+package secretsengine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Errors returned by the pwmanagerClient. Vault error responses are
+// wrapped in an APIError, use errors.Is to check the error kind.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrCASMismatch      = errors.New("check-and-set version mismatch")
+	ErrSealed           = errors.New("vault is sealed")
+)
+
+// APIError is returned when Vault responds with a non success status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Errors returned by Vault in the response payload
+	Errors []string
+
+	kind error
+}
+
+// Error returns a human readable error string for the response error.
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d", e.Method, e.Path, e.StatusCode)
+	if len(e.Errors) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, strings.Join(e.Errors, "; "))
+	}
+	return msg
+}
+
+// Unwrap returns the error kind e.g. ErrNotFound. It returns nil if the
+// error could not be classified.
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// toAPIError converts a vault api ResponseError into an APIError. Transport
+// errors are returned as is.
+func toAPIError(r *vault.Request, err error) error {
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) {
+		return fmt.Errorf("%s %s: %w", r.Method, r.URL.Path, err)
+	}
+
+	apiErr := &APIError{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		StatusCode: respErr.StatusCode,
+		Errors:     respErr.Errors,
+	}
+
+	switch {
+	case respErr.StatusCode == http.StatusNotFound:
+		apiErr.kind = ErrNotFound
+	case respErr.StatusCode == http.StatusForbidden:
+		apiErr.kind = ErrPermissionDenied
+	case respErr.StatusCode == http.StatusServiceUnavailable && containsError(respErr.Errors, "sealed"):
+		apiErr.kind = ErrSealed
+	case respErr.StatusCode == http.StatusBadRequest && containsError(respErr.Errors, "check-and-set"):
+		apiErr.kind = ErrCASMismatch
+	}
+
+	return apiErr
+}
+
+// isRetryable reports if the request that returned err may succeed if it
+// is sent again.
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// transport error
+		return true
+	}
+
+	if apiErr.kind != nil {
+		return false
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusPreconditionFailed, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented:
+		return false
+	}
+
+	return apiErr.StatusCode >= 500
+}
+
+func containsError(errs []string, substr string) bool {
+	for _, e := range errs {
+		if strings.Contains(strings.ToLower(e), substr) {
+			return true
+		}
+	}
+	return false
+}
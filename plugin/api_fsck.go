@@ -0,0 +1,52 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Fsck is used to check and repair the consistency of the plugin records.
+type Fsck struct {
+	c *pwmanagerClient
+}
+
+// Fsck is used to return the client for fsck API calls.
+func (c *pwmanagerClient) Fsck() *Fsck {
+	return &Fsck{c: c}
+}
+
+// FsckResult lists the inconsistencies of the plugin records and how many
+// of them were repaired.
+type FsckResult struct {
+	Issues   []FsckIssue `json:"issues"`
+	Repaired int         `json:"repaired"`
+}
+
+// Check reports the inconsistencies of the plugin records of the mount.
+func (c *Fsck) Check(ctx context.Context, mount string) (FsckResult, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/fsck", mount))
+	if err != nil {
+		return FsckResult{}, err
+	}
+
+	var result FsckResult
+	if err := decodeData(secret, &result); err != nil {
+		return FsckResult{}, err
+	}
+	return result, nil
+}
+
+// Repair repairs the inconsistencies of the plugin records of the mount
+// that can be repaired and reports all of them.
+func (c *Fsck) Repair(ctx context.Context, mount string) (FsckResult, error) {
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/fsck", mount), map[string]interface{}{"repair": true})
+	if err != nil {
+		return FsckResult{}, err
+	}
+
+	var result FsckResult
+	if err := decodeData(secret, &result); err != nil {
+		return FsckResult{}, err
+	}
+	return result, nil
+}
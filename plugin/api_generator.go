@@ -0,0 +1,69 @@
+package secretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	mapstructure "github.com/go-viper/mapstructure/v2"
+)
+
+// Generator is used to perform password generator operations on Vault.
+type Generator struct {
+	c *pwmanagerClient
+}
+
+// Generator is used to return the client for generator API calls.
+func (c *pwmanagerClient) Generator() *Generator {
+	return &Generator{c: c}
+}
+
+// Rules returns the generation rules with the name. Empty rules are returned
+// when no rules with the name are defined.
+func (c *Generator) Rules(ctx context.Context, mount, name string) (GeneratorRules, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/generator/rules/%s", mount, name))
+	if errors.Is(err, ErrNotFound) || (err == nil && secret == nil) {
+		return GeneratorRules{}, nil
+	}
+	if err != nil {
+		return GeneratorRules{}, err
+	}
+
+	var result GeneratorRules
+	if err := decodeData(secret, &result); err != nil {
+		return GeneratorRules{}, err
+	}
+	return result, nil
+}
+
+// PutRules creates or replaces the generation rules with the name
+func (c *Generator) PutRules(ctx context.Context, mount, name string, rules GeneratorRules) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/generator/rules/%s", mount, name), rules)
+	return err
+}
+
+// DeleteRules deletes the generation rules with the name
+func (c *Generator) DeleteRules(ctx context.Context, mount, name string) error {
+	return c.c.delete(ctx, fmt.Sprintf("/v1/%s/generator/rules/%s", mount, name))
+}
+
+// ListRules returns the names of the generation rules
+func (c *Generator) ListRules(ctx context.Context, mount string) ([]string, error) {
+	secret, err := c.c.list(ctx, fmt.Sprintf("/v1/%s/generator/rules/", mount))
+	if errors.Is(err, ErrNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return []string{}, nil
+	}
+
+	var result []string
+	err = mapstructure.Decode(secret.Data["keys"], &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -4,27 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
-
-	"github.com/hashicorp/vault/api"
 )
 
 // Identity is used to perform Identity operations on Vault.
 type Identity struct {
-	c *api.Client
+	c *pwmanagerClient
 }
 
 // Identity is used to return the client for Identity API calls.
 func (c *pwmanagerClient) Identity() *Identity {
-	return &Identity{c: c.c}
+	return &Identity{c: c}
 }
 
-func (c *Identity) EntityByID(entityID string) (Entity, error) {
-	r := c.c.NewRequest("GET", fmt.Sprintf("/v1/identity/entity/id/%s", entityID))
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
+func (c *Identity) EntityByID(ctx context.Context, entityID string) (Entity, error) {
+	resp, err := c.c.do(ctx, c.c.c.NewRequest("GET", fmt.Sprintf("/v1/identity/entity/id/%s", entityID)))
 	if err != nil {
 		return Entity{}, err
 	}
@@ -39,6 +34,44 @@ func (c *Identity) EntityByID(entityID string) (Entity, error) {
 	return result.Data, nil
 }
 
+// AuthMountAccessor returns the accessor of the auth method mounted at the
+// path, e.g. `ldap`.
+func (c *Identity) AuthMountAccessor(ctx context.Context, mount string) (string, error) {
+	secret, err := c.c.read(ctx, "/v1/sys/auth")
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("auth mount %s: %w", mount, ErrNotFound)
+	}
+
+	m, _ := secret.Data[strings.Trim(mount, "/")+"/"].(map[string]interface{})
+	accessor, _ := m["accessor"].(string)
+	if accessor == "" {
+		return "", fmt.Errorf("auth mount %s: %w", mount, ErrNotFound)
+	}
+	return accessor, nil
+}
+
+// EntityIDByAlias returns the id of the entity with the alias name on the
+// auth mount with the accessor.
+func (c *Identity) EntityIDByAlias(ctx context.Context, mountAccessor, aliasName string) (string, error) {
+	secret, err := c.c.write(ctx, "/v1/identity/lookup/entity", map[string]interface{}{
+		"alias_name":           aliasName,
+		"alias_mount_accessor": mountAccessor,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// vault replies with no content when no entity has the alias
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("alias %s: %w", aliasName, ErrNotFound)
+	}
+	id, _ := secret.Data["id"].(string)
+	return id, nil
+}
+
 type IdentityResponse struct {
 	RequestID     string `json:"request_id"`
 	LeaseID       string `json:"lease_id"`
@@ -65,6 +98,19 @@ type Aliases struct {
 	MountType              string    `json:"mount_type"`
 	Name                   string    `json:"name"`
 }
+
+// mergedEntityIDs returns the ids of the entities merged into the entity.
+func (e Entity) mergedEntityIDs() []string {
+	ids, _ := e.MergedEntityIds.([]interface{})
+	merged := []string{}
+	for _, id := range ids {
+		if s, ok := id.(string); ok {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
 type Entity struct {
 	Aliases           []Aliases `json:"aliases"`
 	CreationTime      time.Time `json:"creation_time"`
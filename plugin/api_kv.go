@@ -0,0 +1,729 @@
+package secretsengine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// KVBundle is used to read and write the encrypted kv-v2 layout of a bundle.
+// It is the Go counterpart of the web client KVBundleService. A bundle path
+// has the form `bundles/data/<owner entity id>/<bundle uuid>` and contains:
+//   - keys/<entity id>: the bundle key wrapped with the users public key
+//   - metadata/entries: the encrypted BundleMetadata
+//   - entries/<entry path>: encrypted entries, each update is a new version
+type KVBundle struct {
+	c    *pwmanagerClient
+	path string
+	key  *BundleKey
+
+	// entityID is the user the bundle was unlocked by, entries are written
+	// with it as their author
+	entityID string
+}
+
+// KVBundle is used to return the client for the bundle stored at path.
+func (c *pwmanagerClient) KVBundle(path string) *KVBundle {
+	return &KVBundle{c: c, path: strings.Trim(path, "/")}
+}
+
+// kvWriteRequest is the body of a kv-v2 write.
+type kvWriteRequest struct {
+	Data    interface{}    `json:"data"`
+	Options map[string]int `json:"options"`
+}
+
+// kvReadResponse is the data of a kv-v2 read.
+type kvReadResponse struct {
+	Data     EncryptedEntry `json:"data"`
+	Metadata kvMetadata     `json:"metadata"`
+}
+
+// kvMetadata is the metadata of the kv-v2 secret version read.
+type kvMetadata struct {
+	Version     int       `json:"version"`
+	CreatedTime time.Time `json:"created_time"`
+}
+
+// kvSecretMetadata is the data of a kv-v2 metadata read.
+type kvSecretMetadata struct {
+	CurrentVersion int `json:"current_version"`
+	Versions       map[string]struct {
+		CreatedTime  time.Time `json:"created_time"`
+		DeletionTime string    `json:"deletion_time"`
+		Destroyed    bool      `json:"destroyed"`
+	} `json:"versions"`
+}
+
+// bundleKeyData is the data stored at `keys/<entity id>`.
+type bundleKeyData struct {
+	Key string `json:"key"`
+}
+
+// Init creates the bundle key and empty bundle metadata of a new bundle. Both
+// are written with a CAS of 0 so an existing bundle is never overwritten.
+func (b *KVBundle) Init(ctx context.Context, entityID string, pubKey PubKey, name string) error {
+	key, err := NewBundleKey()
+	if err != nil {
+		return err
+	}
+	b.key = key
+	b.entityID = entityID
+
+	if err := b.ShareKey(ctx, entityID, pubKey); err != nil {
+		return err
+	}
+
+	return b.PutMetadata(ctx, &BundleMetadata{Entries: []EntryMetadata{}, BundleName: name})
+}
+
+// Unlock reads the bundle key wrapped for entityID and decrypts it with the
+// users private key.
+func (b *KVBundle) Unlock(ctx context.Context, entityID string, priKey jwk.Key) error {
+	secret, err := b.c.read(ctx, fmt.Sprintf("/v1/%s/keys/%s", b.path, entityID))
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Data bundleKeyData `json:"data"`
+	}
+	if err := decodeData(secret, &resp); err != nil {
+		return err
+	}
+
+	key, err := UnwrapBundleKey(resp.Data.Key, priKey)
+	if err != nil {
+		return err
+	}
+
+	b.Lock()
+	b.key = key
+	b.entityID = entityID
+	return nil
+}
+
+// Lock zeros the bundle key.
+func (b *KVBundle) Lock() {
+	if b.key != nil {
+		b.key.Zero()
+		b.key = nil
+	}
+}
+
+// ShareKey wraps the bundle key with pubKey and writes it for entityID. The
+// write uses a CAS of 0 since a bundle key must never be overwritten.
+func (b *KVBundle) ShareKey(ctx context.Context, entityID string, pubKey PubKey) error {
+	key, err := b.bundleKey()
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := key.Wrap(pubKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.c.write(ctx, fmt.Sprintf("/v1/%s/keys/%s", b.path, entityID), kvWriteRequest{
+		Data:    bundleKeyData{Key: wrapped},
+		Options: map[string]int{"cas": 0},
+	})
+	return err
+}
+
+// Metadata reads and decrypts the bundle metadata. The returned version is
+// the current kv-v2 version of the metadata.
+func (b *KVBundle) Metadata(ctx context.Context) (*BundleMetadata, error) {
+	var bm BundleMetadata
+	md, err := b.get(ctx, "metadata/entries", 0, &bm)
+	if err != nil {
+		return nil, err
+	}
+	bm.Version = md.Version
+	return &bm, nil
+}
+
+// PutMetadata encrypts and writes the bundle metadata using its version as the
+// CAS value. ErrCASMismatch is returned when the metadata was modified since
+// it was read.
+func (b *KVBundle) PutMetadata(ctx context.Context, bm *BundleMetadata) error {
+	version, err := b.put(ctx, "metadata/entries", bm, bm.Version)
+	if err != nil {
+		return err
+	}
+	bm.Version = version
+	return nil
+}
+
+// Entry reads and decrypts the current version of the entry described by m.
+func (b *KVBundle) Entry(ctx context.Context, m EntryMetadata) (*Entry, error) {
+	return b.EntryVersion(ctx, m, 0)
+}
+
+// PutEntry writes e and updates bm. New entries are given an ID and a random
+// path. Updates are written as a new kv-v2 version of the entry path using
+// the current version as the CAS value so earlier versions are kept, see
+// EntryVersions. When the metadata update fails a new entry is destroyed and
+// an updated entry is reverted to its previous version.
+func (b *KVBundle) PutEntry(ctx context.Context, e *Entry, bm *BundleMetadata) error {
+	cas := 0
+
+	newEntry := e.Metadata.ID == ""
+	if newEntry {
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return err
+		}
+		p, err := uuid.GenerateUUID()
+		if err != nil {
+			return err
+		}
+		e.Metadata.ID = id
+		e.Metadata.Path = p
+		e.Metadata.Version = 0
+	} else {
+		md, err := b.secretMetadata(ctx, "entries/"+e.Metadata.Path)
+		if err != nil {
+			return fmt.Errorf("error reading entry versions: %w", err)
+		}
+		cas = md.CurrentVersion
+	}
+	e.ModifiedBy = b.entityID
+
+	version, err := b.put(ctx, "entries/"+e.Metadata.Path, e, cas)
+	if err != nil {
+		return fmt.Errorf("error putting entry: %w", err)
+	}
+
+	e.Metadata.Version++
+
+	if newEntry {
+		bm.Entries = append(bm.Entries, e.Metadata)
+	} else {
+		for i, m := range bm.Entries {
+			if m.ID == e.Metadata.ID {
+				bm.Entries[i] = e.Metadata
+			}
+		}
+	}
+
+	if err := b.PutMetadata(ctx, bm); err != nil {
+		if newEntry {
+			b.destroy(ctx, e.Metadata.Path)
+		} else {
+			b.revert(ctx, e.Metadata.Path, cas, version)
+		}
+		return fmt.Errorf("error putting metadata: %w", err)
+	}
+
+	return nil
+}
+
+// ImportEntries writes entries as new entries and adds them to bm with a
+// single metadata update. Each entry is written with CAS 0 so nothing in the
+// bundle is overwritten. When a write or the metadata update fails the
+// entries already written are destroyed and bm is left unchanged.
+func (b *KVBundle) ImportEntries(ctx context.Context, entries []*Entry, bm *BundleMetadata) error {
+	written := []EntryMetadata{}
+	rollback := func() {
+		for _, m := range written {
+			b.destroy(ctx, m.Path)
+		}
+	}
+
+	for _, e := range entries {
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			rollback()
+			return err
+		}
+		p, err := uuid.GenerateUUID()
+		if err != nil {
+			rollback()
+			return err
+		}
+		e.Metadata.ID = id
+		e.Metadata.Path = p
+		e.Metadata.Version = 0
+		e.ModifiedBy = b.entityID
+
+		if _, err := b.put(ctx, "entries/"+p, e, 0); err != nil {
+			rollback()
+			return fmt.Errorf("error putting entry %q: %w", e.Name, err)
+		}
+		e.Metadata.Version++
+		written = append(written, e.Metadata)
+	}
+
+	entriesBefore := bm.Entries
+	bm.Entries = append(slices.Clone(bm.Entries), written...)
+	if err := b.PutMetadata(ctx, bm); err != nil {
+		bm.Entries = entriesBefore
+		rollback()
+		return fmt.Errorf("error putting metadata: %w", err)
+	}
+
+	return nil
+}
+
+// EntryVersions returns the kv-v2 versions of the entry described by m,
+// oldest first. The author of each version is read from the decrypted entry
+// so it can't be learned from the kv-v2 metadata.
+func (b *KVBundle) EntryVersions(ctx context.Context, m EntryMetadata) ([]EntryVersion, error) {
+	md, err := b.secretMetadata(ctx, "entries/"+m.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]EntryVersion, 0, len(md.Versions))
+	for k, v := range md.Versions {
+		n, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry version %q", k)
+		}
+		versions = append(versions, EntryVersion{
+			Version: n,
+			Created: v.CreatedTime,
+			Current: n == md.CurrentVersion,
+			Deleted: v.Destroyed || v.DeletionTime != "",
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+
+	for i := range versions {
+		if versions[i].Deleted {
+			continue
+		}
+		e, err := b.EntryVersion(ctx, m, versions[i].Version)
+		if err != nil {
+			return nil, fmt.Errorf("error reading version %d: %w", versions[i].Version, err)
+		}
+		versions[i].ModifiedBy = e.ModifiedBy
+	}
+	return versions, nil
+}
+
+// EntryVersion reads and decrypts the kv-v2 version of the entry described
+// by m. Version 0 is the current version.
+func (b *KVBundle) EntryVersion(ctx context.Context, m EntryMetadata, version int) (*Entry, error) {
+	var e Entry
+	md, err := b.get(ctx, "entries/"+m.Path, version, &e)
+	if err != nil {
+		return nil, err
+	}
+	e.Modified = md.CreatedTime
+	return &e, nil
+}
+
+// RestoreEntry writes the version of the entry described by m as a new
+// version and updates bm. m must be the current metadata of the entry from
+// bm.
+func (b *KVBundle) RestoreEntry(ctx context.Context, m EntryMetadata, version int, bm *BundleMetadata) (*Entry, error) {
+	e, err := b.EntryVersion(ctx, m, version)
+	if err != nil {
+		return nil, fmt.Errorf("error reading version %d: %w", version, err)
+	}
+
+	e.Metadata = m
+	e.SyncMetadata()
+	if err := b.PutEntry(ctx, e, bm); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// DeleteEntry removes the entry with id from the latest bundle metadata and
+// destroys the entry.
+func (b *KVBundle) DeleteEntry(ctx context.Context, id string) error {
+	bm, err := b.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving latest bundle metadata: %w", err)
+	}
+
+	var path string
+	entries := []EntryMetadata{}
+	for _, m := range bm.Entries {
+		if m.ID == id {
+			path = m.Path
+			continue
+		}
+		entries = append(entries, m)
+	}
+
+	if path == "" {
+		return fmt.Errorf("entry %s: %w", id, ErrNotFound)
+	}
+
+	bm.Entries = entries
+	if err := b.PutMetadata(ctx, bm); err != nil {
+		return fmt.Errorf("error putting metadata: %w", err)
+	}
+
+	return b.destroy(ctx, path)
+}
+
+// TrashEntry moves the entry with the id from the bundle metadata to its
+// trash and registers the entry with the trash of the pwmanager mount. The
+// entry is destroyed by the plugin once the trash retention has passed.
+func (b *KVBundle) TrashEntry(ctx context.Context, mount, id string) (EntryMetadata, error) {
+	bm, err := b.Metadata(ctx)
+	if err != nil {
+		return EntryMetadata{}, fmt.Errorf("error retrieving latest bundle metadata: %w", err)
+	}
+
+	i := slices.IndexFunc(bm.Entries, func(m EntryMetadata) bool { return m.ID == id })
+	if i < 0 {
+		return EntryMetadata{}, fmt.Errorf("entry %s: %w", id, ErrNotFound)
+	}
+	m := bm.Entries[i]
+
+	bm.Entries = slices.Delete(bm.Entries, i, i+1)
+	bm.Trash = append(bm.Trash, m)
+	if err := b.PutMetadata(ctx, bm); err != nil {
+		return EntryMetadata{}, fmt.Errorf("error putting metadata: %w", err)
+	}
+
+	owner, bundleID := b.ids()
+	if _, err := b.c.Trash().TrashEntry(ctx, mount, owner, bundleID, m.Path); err != nil {
+		return EntryMetadata{}, fmt.Errorf("error moving entry to trash: %w", err)
+	}
+	return m, nil
+}
+
+// RestoreTrashedEntry removes the deleted entry with the id from the trash of
+// the pwmanager mount and moves it back to the entries of the bundle.
+func (b *KVBundle) RestoreTrashedEntry(ctx context.Context, mount, id string) (EntryMetadata, error) {
+	bm, err := b.Metadata(ctx)
+	if err != nil {
+		return EntryMetadata{}, fmt.Errorf("error retrieving latest bundle metadata: %w", err)
+	}
+
+	i := slices.IndexFunc(bm.Trash, func(m EntryMetadata) bool { return m.ID == id })
+	if i < 0 {
+		return EntryMetadata{}, fmt.Errorf("deleted entry %s: %w", id, ErrNotFound)
+	}
+	m := bm.Trash[i]
+
+	// the entry is restored in the plugin first so it can't be purged
+	// while it is listed in the bundle entries
+	owner, bundleID := b.ids()
+	if _, err := b.c.Trash().RestoreEntry(ctx, mount, owner, bundleID, m.Path); err != nil {
+		return EntryMetadata{}, fmt.Errorf("error restoring entry from trash: %w", err)
+	}
+
+	bm.Trash = slices.Delete(bm.Trash, i, i+1)
+	bm.Entries = append(bm.Entries, m)
+	if err := b.PutMetadata(ctx, bm); err != nil {
+		return EntryMetadata{}, fmt.Errorf("error putting metadata: %w", err)
+	}
+	return m, nil
+}
+
+// PurgeTrashedEntry destroys the deleted entry with the id and removes it
+// from the trash of the bundle.
+func (b *KVBundle) PurgeTrashedEntry(ctx context.Context, mount, id string) error {
+	bm, err := b.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving latest bundle metadata: %w", err)
+	}
+
+	i := slices.IndexFunc(bm.Trash, func(m EntryMetadata) bool { return m.ID == id })
+	if i < 0 {
+		return fmt.Errorf("deleted entry %s: %w", id, ErrNotFound)
+	}
+
+	owner, bundleID := b.ids()
+	if err := b.c.Trash().PurgeEntry(ctx, mount, owner, bundleID, bm.Trash[i].Path); err != nil {
+		return fmt.Errorf("error purging entry: %w", err)
+	}
+
+	bm.Trash = slices.Delete(bm.Trash, i, i+1)
+	if err := b.PutMetadata(ctx, bm); err != nil {
+		return fmt.Errorf("error putting metadata: %w", err)
+	}
+	return nil
+}
+
+// PutAttachment encrypts the file read from r in chunks and uploads it
+// through the pwmanager mount, which enforces the attachment quota of the
+// bundle. The attachment is added to the entry which is saved as a new
+// version. The chunks of a failed upload are deleted.
+func (b *KVBundle) PutAttachment(ctx context.Context, mount string, e *Entry, bm *BundleMetadata, name string, r io.Reader) (Attachment, error) {
+	key, err := b.bundleKey()
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	if e.Metadata.Path == "" {
+		return Attachment{}, fmt.Errorf("entry must be saved before adding attachments")
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	owner, bundleID := b.ids()
+	manifest := AttachmentManifest{ID: id, Name: name, ChunkSize: AttachmentChunkSize, Chunks: []string{}}
+
+	upload := func() error {
+		file := sha256.New()
+		buf := make([]byte, AttachmentChunkSize)
+		for i := 0; ; i++ {
+			n, err := io.ReadFull(r, buf)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("error reading attachment: %w", err)
+			}
+
+			sum := sha256.Sum256(buf[:n])
+			manifest.Chunks = append(manifest.Chunks, hex.EncodeToString(sum[:]))
+			manifest.Size += int64(n)
+			file.Write(buf[:n])
+
+			ee, err := key.Encrypt(buf[:n])
+			if err != nil {
+				return err
+			}
+			if err := b.c.Attachments().PutChunk(ctx, mount, owner, bundleID, e.Metadata.Path, id, strconv.Itoa(i), ee); err != nil {
+				return fmt.Errorf("error uploading chunk %d: %w", i, err)
+			}
+
+			if n < len(buf) {
+				break
+			}
+		}
+		manifest.SHA256 = hex.EncodeToString(file.Sum(nil))
+
+		ee, err := key.EncryptJSON(manifest)
+		if err != nil {
+			return err
+		}
+		if err := b.c.Attachments().PutChunk(ctx, mount, owner, bundleID, e.Metadata.Path, id, attachmentManifestChunk, ee); err != nil {
+			return fmt.Errorf("error uploading manifest: %w", err)
+		}
+		return nil
+	}
+
+	if err := upload(); err != nil {
+		b.c.Attachments().Delete(ctx, mount, owner, bundleID, e.Metadata.Path, id)
+		return Attachment{}, err
+	}
+
+	a := Attachment{ID: id, Name: name, Size: manifest.Size}
+	e.Attachments = append(e.Attachments, a)
+	if err := b.PutEntry(ctx, e, bm); err != nil {
+		e.Attachments = e.Attachments[:len(e.Attachments)-1]
+		b.c.Attachments().Delete(ctx, mount, owner, bundleID, e.Metadata.Path, id)
+		return Attachment{}, err
+	}
+	return a, nil
+}
+
+// ReadAttachment decrypts the attachment with the id of the entry to w.
+// Every chunk is verified against the manifest before it is written, an
+// ErrAttachmentIntegrity error is returned when a chunk or the file doesn't
+// match.
+func (b *KVBundle) ReadAttachment(ctx context.Context, m EntryMetadata, id string, w io.Writer) (AttachmentManifest, error) {
+	key, err := b.bundleKey()
+	if err != nil {
+		return AttachmentManifest{}, err
+	}
+
+	var manifest AttachmentManifest
+	if _, err := b.get(ctx, attachmentPath(m.Path, id, attachmentManifestChunk), 0, &manifest); err != nil {
+		return AttachmentManifest{}, fmt.Errorf("error reading attachment manifest: %w", err)
+	}
+	if manifest.ID != id {
+		return AttachmentManifest{}, fmt.Errorf("manifest of attachment %s: %w", id, ErrAttachmentIntegrity)
+	}
+
+	file := sha256.New()
+	var size int64
+	for i, want := range manifest.Chunks {
+		resp, err := b.read(ctx, attachmentPath(m.Path, id, strconv.Itoa(i)), 0)
+		if err != nil {
+			return manifest, fmt.Errorf("error reading chunk %d: %w", i, err)
+		}
+
+		chunk, err := key.Open(resp.Data)
+		if err != nil {
+			return manifest, fmt.Errorf("error decrypting chunk %d: %w", i, err)
+		}
+
+		if sum := sha256.Sum256(chunk); hex.EncodeToString(sum[:]) != want {
+			return manifest, fmt.Errorf("chunk %d of attachment %s: %w", i, id, ErrAttachmentIntegrity)
+		}
+
+		file.Write(chunk)
+		size += int64(len(chunk))
+		if _, err := w.Write(chunk); err != nil {
+			return manifest, err
+		}
+	}
+
+	if size != manifest.Size || hex.EncodeToString(file.Sum(nil)) != manifest.SHA256 {
+		return manifest, fmt.Errorf("attachment %s: %w", id, ErrAttachmentIntegrity)
+	}
+	return manifest, nil
+}
+
+// DeleteAttachment removes the attachment with the id from the entry, which
+// is saved as a new version, and deletes its chunks.
+func (b *KVBundle) DeleteAttachment(ctx context.Context, mount string, e *Entry, bm *BundleMetadata, id string) error {
+	i := slices.IndexFunc(e.Attachments, func(a Attachment) bool { return a.ID == id })
+	if i < 0 {
+		return fmt.Errorf("attachment %s: %w", id, ErrNotFound)
+	}
+
+	e.Attachments = slices.Delete(e.Attachments, i, i+1)
+	if err := b.PutEntry(ctx, e, bm); err != nil {
+		return err
+	}
+
+	owner, bundleID := b.ids()
+	if err := b.c.Attachments().Delete(ctx, mount, owner, bundleID, e.Metadata.Path, id); err != nil {
+		return fmt.Errorf("error deleting attachment: %w", err)
+	}
+	return nil
+}
+
+// get reads and decrypts the version of the kv-v2 secret at name into v and
+// returns the metadata of the version read. Version 0 is the current version.
+func (b *KVBundle) get(ctx context.Context, name string, version int, v interface{}) (kvMetadata, error) {
+	key, err := b.bundleKey()
+	if err != nil {
+		return kvMetadata{}, err
+	}
+
+	resp, err := b.read(ctx, name, version)
+	if err != nil {
+		return kvMetadata{}, err
+	}
+
+	if err := key.DecryptJSON(resp.Data, v); err != nil {
+		return kvMetadata{}, err
+	}
+
+	return resp.Metadata, nil
+}
+
+// read reads the version of the kv-v2 secret at name without decrypting it.
+func (b *KVBundle) read(ctx context.Context, name string, version int) (*kvReadResponse, error) {
+	var params neturl.Values
+	if version > 0 {
+		params = neturl.Values{"version": {strconv.Itoa(version)}}
+	}
+
+	secret, err := b.c.readParams(ctx, fmt.Sprintf("/v1/%s/%s", b.path, name), params)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp kvReadResponse
+	if err := decodeData(secret, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// secretMetadata reads the kv-v2 metadata of the secret at name.
+func (b *KVBundle) secretMetadata(ctx context.Context, name string) (*kvSecretMetadata, error) {
+	secret, err := b.c.read(ctx, fmt.Sprintf("/v1/%s/%s", b.metadataPath(), name))
+	if err != nil {
+		return nil, err
+	}
+
+	var md kvSecretMetadata
+	if err := decodeData(secret, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// revert writes version from of the entry at path back as a new version.
+// cas is the version written since.
+func (b *KVBundle) revert(ctx context.Context, path string, from, cas int) error {
+	resp, err := b.read(ctx, "entries/"+path, from)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.c.write(ctx, fmt.Sprintf("/v1/%s/entries/%s", b.path, path), kvWriteRequest{
+		Data:    resp.Data,
+		Options: map[string]int{"cas": cas},
+	})
+	return err
+}
+
+// put encrypts v and writes it to the kv-v2 secret at name with cas. The new
+// version of the secret is returned.
+func (b *KVBundle) put(ctx context.Context, name string, v interface{}, cas int) (int, error) {
+	key, err := b.bundleKey()
+	if err != nil {
+		return 0, err
+	}
+
+	ee, err := key.EncryptJSON(v)
+	if err != nil {
+		return 0, err
+	}
+
+	secret, err := b.c.write(ctx, fmt.Sprintf("/v1/%s/%s", b.path, name), kvWriteRequest{
+		Data:    ee,
+		Options: map[string]int{"cas": cas},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var resp struct {
+		Version int `json:"version"`
+	}
+	if err := decodeData(secret, &resp); err != nil {
+		return 0, err
+	}
+
+	return resp.Version, nil
+}
+
+// destroy permanently deletes all versions of the entry at path. In kv-v2 the
+// metadata path is needed to destroy a secret.
+func (b *KVBundle) destroy(ctx context.Context, path string) error {
+	return b.c.delete(ctx, fmt.Sprintf("/v1/%s/entries/%s", b.metadataPath(), path))
+}
+
+// ids returns the owner entity id and bundle id of the bundle path.
+func (b *KVBundle) ids() (string, string) {
+	parts := strings.Split(b.path, "/")
+	if len(parts) < 2 {
+		return "", ""
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// metadataPath returns the kv-v2 metadata path of the bundle.
+func (b *KVBundle) metadataPath() string {
+	return strings.Replace(b.path, "/data/", "/metadata/", 1)
+}
+
+func (b *KVBundle) bundleKey() (*BundleKey, error) {
+	if b.key == nil {
+		return nil, fmt.Errorf("bundle is locked")
+	}
+	return b.key, nil
+}
@@ -3,32 +3,36 @@ package secretsengine
 import (
 	"context"
 	"fmt"
-	"strings"
-
-	"github.com/hashicorp/vault/api"
 )
 
 // PwManager is used to perform PwManager operations on Vault.
 type PwManager struct {
-	c *api.Client
+	c *pwmanagerClient
 }
 
 // PwManager is used to return the client for PwManager API calls.
 func (c *pwmanagerClient) PwManager() *PwManager {
-	return &PwManager{c: c.c}
+	return &PwManager{c: c}
 }
 
-func (c *PwManager) Config(mount, jsonData string) error {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/%s/config", mount))
-	r.Body = strings.NewReader(jsonData)
+// Config writes the pwmanager mount configuration.
+func (c *PwManager) Config(ctx context.Context, mount string, config Config) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/config", mount), config)
+	return err
+}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
+// ReadConfig returns the pwmanager mount configuration. The secret id
+// is never returned.
+func (c *PwManager) ReadConfig(ctx context.Context, mount string) (Config, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/config", mount))
 	if err != nil {
-		return err
+		return Config{}, err
+	}
+
+	var config Config
+	if err := decodeData(secret, &config); err != nil {
+		return Config{}, err
 	}
-	defer resp.Body.Close()
 
-	return nil
+	return config, nil
 }
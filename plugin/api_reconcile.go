@@ -0,0 +1,33 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Reconcile is used to migrate users to the current name and id of their
+// Vault entity.
+type Reconcile struct {
+	c *pwmanagerClient
+}
+
+// Reconcile is used to return the client for reconcile API calls.
+func (c *pwmanagerClient) Reconcile() *Reconcile {
+	return &Reconcile{c: c}
+}
+
+// Run reconciles every user of the mount with their Vault entity.
+func (c *Reconcile) Run(ctx context.Context, mount string) (ReconcileReport, error) {
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/reconcile", mount), map[string]interface{}{})
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	var result struct {
+		Report ReconcileReport `json:"report"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return ReconcileReport{}, err
+	}
+	return result.Report, nil
+}
@@ -0,0 +1,31 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Sys is used to perform Sys operations on Vault.
+type Sys struct {
+	c *pwmanagerClient
+}
+
+// Sys is used to return the client for Sys API calls.
+func (c *pwmanagerClient) Sys() *Sys {
+	return &Sys{c: c}
+}
+
+// PutPolicy creates or updates the ACL policy name with rules.
+func (c *Sys) PutPolicy(ctx context.Context, name, rules string) error {
+	body := map[string]string{
+		"policy": rules,
+	}
+
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/sys/policies/acl/%s", name), body)
+	return err
+}
+
+// DeletePolicy deletes the ACL policy name.
+func (c *Sys) DeletePolicy(ctx context.Context, name string) error {
+	return c.c.delete(ctx, fmt.Sprintf("/v1/sys/policies/acl/%s", name))
+}
@@ -0,0 +1,40 @@
+package secretsengine
+
+import (
+	"context"
+)
+
+// Token is used to perform Token operations on Vault.
+type Token struct {
+	c *pwmanagerClient
+}
+
+// Token is used to return the client for Token API calls.
+func (c *pwmanagerClient) Token() *Token {
+	return &Token{c: c}
+}
+
+// TokenInfo is the information of the client token returned by lookup-self.
+type TokenInfo struct {
+	Accessor    string            `json:"accessor"`
+	DisplayName string            `json:"display_name"`
+	EntityID    string            `json:"entity_id"`
+	Meta        map[string]string `json:"meta"`
+	Policies    []string          `json:"policies"`
+	TTL         int64             `json:"ttl"`
+}
+
+// LookupSelf returns the information of the client token.
+func (c *Token) LookupSelf(ctx context.Context) (TokenInfo, error) {
+	secret, err := c.c.read(ctx, "/v1/auth/token/lookup-self")
+	if err != nil {
+		return TokenInfo{}, err
+	}
+
+	var info TokenInfo
+	if err := decodeData(secret, &info); err != nil {
+		return TokenInfo{}, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,102 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Trash is used to perform trash operations on Vault.
+type Trash struct {
+	c *pwmanagerClient
+}
+
+// Trash is used to return the client for trash API calls.
+func (c *pwmanagerClient) Trash() *Trash {
+	return &Trash{c: c}
+}
+
+// trashItemsResponse contains the items of a trash.
+type trashItemsResponse struct {
+	Items []TrashItem `json:"items"`
+}
+
+// Entries returns the deleted entries of a bundle
+func (c *Trash) Entries(ctx context.Context, mount, ownerEntityID, bundleID string) ([]TrashItem, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/trash", mount, ownerEntityID, bundleID))
+	if err != nil {
+		return nil, err
+	}
+
+	var result trashItemsResponse
+	if err := decodeData(secret, &result); err != nil {
+		return nil, err
+	}
+	return result.Items, nil
+}
+
+// TrashEntry moves the entry at the kv-v2 entry path to the trash of the bundle
+func (c *Trash) TrashEntry(ctx context.Context, mount, ownerEntityID, bundleID, entryPath string) (TrashItem, error) {
+	return c.item(c.c.write(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/trash", mount, ownerEntityID, bundleID), map[string]string{"entry_path": entryPath}))
+}
+
+// RestoreEntry removes the entry from the trash of the bundle so it isn't purged
+func (c *Trash) RestoreEntry(ctx context.Context, mount, ownerEntityID, bundleID, entryPath string) (TrashItem, error) {
+	return c.item(c.c.write(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/trash/%s/restore", mount, ownerEntityID, bundleID, entryPath), nil))
+}
+
+// PurgeEntry destroys the deleted entry
+func (c *Trash) PurgeEntry(ctx context.Context, mount, ownerEntityID, bundleID, entryPath string) error {
+	return c.c.delete(ctx, fmt.Sprintf("/v1/%s/bundles/%s/%s/trash/%s", mount, ownerEntityID, bundleID, entryPath))
+}
+
+// Bundles returns the deleted bundles of the caller
+func (c *Trash) Bundles(ctx context.Context, mount string) ([]TrashItem, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/bundles/trash", mount))
+	if err != nil {
+		return nil, err
+	}
+
+	var result trashItemsResponse
+	if err := decodeData(secret, &result); err != nil {
+		return nil, err
+	}
+	return result.Items, nil
+}
+
+// RestoreBundle restores the deleted bundle and shares it again with its users
+func (c *Trash) RestoreBundle(ctx context.Context, mount, bundleID string) (Bundle, error) {
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/bundles/trash/%s/restore", mount, bundleID), nil)
+	if err != nil {
+		return Bundle{}, err
+	}
+
+	var result struct {
+		Bundle Bundle `json:"bundle"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return Bundle{}, err
+	}
+	return result.Bundle, nil
+}
+
+// PurgeBundle destroys the deleted bundle and its entries
+func (c *Trash) PurgeBundle(ctx context.Context, mount, bundleID string) error {
+	return c.c.delete(ctx, fmt.Sprintf("/v1/%s/bundles/trash/%s", mount, bundleID))
+}
+
+// item decodes the trash item of a trash write response.
+func (c *Trash) item(secret *vault.Secret, err error) (TrashItem, error) {
+	if err != nil {
+		return TrashItem{}, err
+	}
+
+	var result struct {
+		Item TrashItem `json:"item"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return TrashItem{}, err
+	}
+	return result.Item, nil
+}
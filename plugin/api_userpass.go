@@ -4,46 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-
-	"github.com/hashicorp/vault/api"
 )
 
 // Userpass is used to perform Userpass operations on Vault.
 type Userpass struct {
-	c *api.Client
+	c *pwmanagerClient
 }
 
 // Userpass is used to return the client for userpass API calls.
 func (c *pwmanagerClient) Userpass() *Userpass {
-	return &Userpass{c: c.c}
+	return &Userpass{c: c}
 }
 
-func (c *Userpass) User(mount, path string, userInfo UserInfo) error {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/users/%s", mount, path))
-	if err := r.SetJSONBody(userInfo); err != nil {
-		return err
-	}
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+func (c *Userpass) User(ctx context.Context, mount, path string, userInfo UserInfo) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/auth/%s/users/%s", mount, path), userInfo)
+	return err
 }
 
-func (c *Userpass) Login(mount, path string, userInfo UserInfo) (LoginResponse, error) {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/login/%s", mount, path))
+func (c *Userpass) Login(ctx context.Context, mount, path string, userInfo UserInfo) (LoginResponse, error) {
+	r := c.c.c.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/login/%s", mount, path))
 	if err := r.SetJSONBody(userInfo); err != nil {
 		return LoginResponse{}, err
 	}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
+	resp, err := c.c.do(ctx, r)
 	if err != nil {
 		return LoginResponse{}, err
 	}
@@ -6,16 +6,17 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	neturl "net/url"
 
 	mapstructure "github.com/go-viper/mapstructure/v2"
 	"github.com/hashicorp/go-uuid"
-	"github.com/hashicorp/vault/api"
+	vault "github.com/hashicorp/vault/api"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwe"
@@ -26,69 +27,35 @@ import (
 
 // Users is used to perform Users operations on Vault.
 type Users struct {
-	c *api.Client
+	c *pwmanagerClient
 }
 
 // Users is used to return the client for Users API calls.
 func (c *pwmanagerClient) Users() *Users {
-	return &Users{c: c.c}
+	return &Users{c: c}
 }
 
-func (c *Users) Register(mount string, uuk UUK) error {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/%s/register", mount))
-	if err := r.SetJSONBody(uuk); err != nil {
-		return err
-	}
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+func (c *Users) Register(ctx context.Context, mount string, uuk UUK) error {
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/register", mount), uuk)
+	return err
 }
 
-func (c *Users) Update(mount string, entityID string, uuk UUK) error {
-	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/%s/users/%s", mount, entityID))
+func (c *Users) Update(ctx context.Context, mount string, entityID string, uuk UUK) error {
 	data := struct {
 		EntityID string `json:"entity_id"`
-		UUK      UUK    `string:"uuk"`
+		UUK      UUK    `json:"uuk"`
 	}{
 		EntityID: entityID,
 		UUK:      uuk,
 	}
 
-	if err := r.SetJSONBody(data); err != nil {
-		return err
-	}
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+	_, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/users/%s", mount, entityID), data)
+	return err
 }
 
-// List returns a list of users
-func (c *Users) List(mount string) ([]string, error) {
-	r := c.c.NewRequest("LIST", fmt.Sprintf("/v1/%s/users/", mount))
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	secret, err := api.ParseSecret(resp.Body)
+// List returns the entity ids of the registered users
+func (c *Users) List(ctx context.Context, mount string) ([]string, error) {
+	secret, err := c.c.list(ctx, fmt.Sprintf("/v1/%s/users/", mount))
 	if err != nil {
 		return nil, err
 	}
@@ -104,44 +71,103 @@ func (c *Users) List(mount string) ([]string, error) {
 	return result, nil
 }
 
-func (c *Users) Delete(mount string, entityID string) error {
-	r := c.c.NewRequest("DELETE", fmt.Sprintf("/v1/%s/users/%s", mount, entityID))
+// DirectoryPage is a page of the user directory. Next is passed as the
+// after of the next page, it is empty on the last page.
+type DirectoryPage struct {
+	Users []DirectoryUser `json:"users"`
+	Next  string          `json:"next"`
+}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
+// Search returns a page of the users the caller can share bundles with whose
+// entity name, auth alias or display name starts with query.
+func (c *Users) Search(ctx context.Context, mount, query, after string, limit int) (DirectoryPage, error) {
+	params := neturl.Values{}
+	if query != "" {
+		params.Set("query", query)
+	}
+	if after != "" {
+		params.Set("after", after)
+	}
+	if limit > 0 {
+		params.Set("limit", fmt.Sprint(limit))
+	}
+
+	secret, err := c.c.readParams(ctx, fmt.Sprintf("/v1/%s/directory", mount), params)
 	if err != nil {
-		return err
+		return DirectoryPage{}, err
 	}
-	defer resp.Body.Close()
 
-	return nil
+	var result DirectoryPage
+	if err := decodeData(secret, &result); err != nil {
+		return DirectoryPage{}, err
+	}
+	return result, nil
 }
 
-// Get returns a users UUK
-func (c *Users) Get(mount string, entityID string) (pwManagerUserEntry, error) {
-	r := c.c.NewRequest("GET", fmt.Sprintf("/v1/%s/users/%s", mount, entityID))
+func (c *Users) Delete(ctx context.Context, mount string, entityID string) error {
+	return c.c.delete(ctx, fmt.Sprintf("/v1/%s/users/%s", mount, entityID))
+}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-	resp, err := c.c.RawRequestWithContext(ctx, r)
+// OffboardOptions choose what offboarding does with the bundles the user
+// owns. OwnedBundles is freeze, delete or transfer to the user named by
+// TransferTo.
+type OffboardOptions struct {
+	OwnedBundles string `json:"owned_bundles,omitempty"`
+	TransferTo   string `json:"transfer_to,omitempty"`
+}
+
+// Offboard deregisters the caller and returns what was changed.
+func (c *Users) Offboard(ctx context.Context, mount string, opts OffboardOptions) (OffboardReport, error) {
+	secret, err := c.c.write(ctx, fmt.Sprintf("/v1/%s/offboard", mount), opts)
 	if err != nil {
-		return pwManagerUserEntry{}, err
+		return OffboardReport{}, err
+	}
+	return decodeOffboardReport(secret)
+}
+
+// OffboardUser deregisters the user as an admin and returns what was
+// changed.
+func (c *Users) OffboardUser(ctx context.Context, mount string, entityID string, opts OffboardOptions) (OffboardReport, error) {
+	r := c.c.c.NewRequest(http.MethodDelete, fmt.Sprintf("/v1/%s/users/%s", mount, entityID))
+	r.Params = neturl.Values{}
+	if opts.OwnedBundles != "" {
+		r.Params.Set("owned_bundles", opts.OwnedBundles)
+	}
+	if opts.TransferTo != "" {
+		r.Params.Set("transfer_to", opts.TransferTo)
 	}
-	defer resp.Body.Close()
 
-	secret, err := api.ParseSecret(resp.Body)
+	secret, err := c.c.send(ctx, r)
 	if err != nil {
-		return pwManagerUserEntry{}, err
+		return OffboardReport{}, err
+	}
+	return decodeOffboardReport(secret)
+}
+
+func decodeOffboardReport(secret *vault.Secret) (OffboardReport, error) {
+	var result struct {
+		Report OffboardReport `json:"report"`
+	}
+	if err := decodeData(secret, &result); err != nil {
+		return OffboardReport{}, err
+	}
+	return result.Report, nil
+}
+
+// Get returns a users UUK
+func (c *Users) Get(ctx context.Context, mount string, entityID string) (UserEntry, error) {
+	secret, err := c.c.read(ctx, fmt.Sprintf("/v1/%s/users/%s", mount, entityID))
+	if err != nil {
+		return UserEntry{}, err
 	}
 	if secret == nil || secret.Data == nil {
-		return pwManagerUserEntry{}, fmt.Errorf("data from server response is empty")
+		return UserEntry{}, fmt.Errorf("data from server response is empty")
 	}
 
-	var result pwManagerUserEntry
+	var result UserEntry
 	err = mapstructure.Decode(secret.Data, &result)
 	if err != nil {
-		return pwManagerUserEntry{}, err
+		return UserEntry{}, err
 	}
 	return result, nil
 }
@@ -197,6 +223,18 @@ type UUK struct {
 	PubKey interface{} `json:"pub_key"`
 }
 
+// UUK returns the UUK of a registered user so it can be unlocked with
+// UUK.DecryptEncPriKey.
+func (r pwManagerUUKEntry) UUK() UUK {
+	return UUK{
+		UUID:        r.UUID,
+		EncSymKey:   r.EncSymKey,
+		EncryptedBy: r.EncryptedBy,
+		EncPriKey:   r.EncPriKey,
+		PubKey:      r.PubKey,
+	}
+}
+
 // withInitializationSalt generates a random 16 byte salt and stores the result in UUK.EncSymKey.P2s
 // this salt is required in the 2SKD func
 func (uuk *UUK) withInitializationSalt() error {
@@ -226,23 +264,32 @@ func (uuk *UUK) withEncSymKey(twoSKD []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	if err := uuk.sealEncSymKey(twoSKD, symmetricKey); err != nil {
+		return nil, err
+	}
+	return symmetricKey, nil
+}
+
+// sealEncSymKey encrypts the symmetric key using the 2SKD key and stores the
+// encrypted value in UUK.EncSymKey.Data
+func (uuk *UUK) sealEncSymKey(twoSKD, symmetricKey []byte) error {
 	//16, 24, or 32 bytes to select
 	// AES-128, AES-192, or AES-256.
 	// Since symmetric key is 32 bytes this is AES-256
 	c, err := aes.NewCipher(twoSKD)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	iv := make([]byte, gcm.NonceSize())
 	_, err = io.ReadFull(rand.Reader, iv)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	encSymKeyIvPrefix := gcm.Seal(iv, iv, symmetricKey, nil)
@@ -255,7 +302,7 @@ func (uuk *UUK) withEncSymKey(twoSKD []byte) ([]byte, error) {
 	uuk.EncSymKey.Kid = uuk.UUID
 	uuk.EncSymKey.Alg = "pbkdf2-hkdf"
 
-	return symmetricKey, nil
+	return nil
 }
 
 // withEncPriKey encrypts creates a private key and encrypts it using the symmetric
@@ -333,14 +380,16 @@ func (uuk *UUK) withEncryptedBy(eb string) {
 	uuk.EncryptedBy = eb
 }
 
-// derives the 2SKD from provided parameters
+// derives the 2SKD from provided parameters. The derivation must match
+// twoSkd in `client/src/lib/uuk.ts` so users registered with the web client
+// can unlock their UUK with the Go client.
 func (uuk *UUK) twoSkd(password, mount, secretKey, entityID []byte) ([]byte, error) {
 	initialSalt, err := hex.DecodeString(uuk.EncSymKey.P2s)
 	if err != nil {
 		return nil, err
 	}
 	// hkdf 1
-	saltHash := hkdf.New(sha256.New, initialSalt, entityID, nil)
+	saltHash := hkdf.New(sha256.New, initialSalt, entityID, []byte("2SKD HKDF 1"))
 	saltDerivedKey := make([]byte, 32)
 	if _, err := io.ReadFull(saltHash, saltDerivedKey); err != nil {
 		return nil, err
@@ -348,10 +397,10 @@ func (uuk *UUK) twoSkd(password, mount, secretKey, entityID []byte) ([]byte, err
 
 	// pbkdf2
 	keyLen := 32
-	passwordDerivedKey := pbkdf2.Key(password, saltDerivedKey, uuk.EncSymKey.P2c, keyLen, sha1.New)
+	passwordDerivedKey := pbkdf2.Key(password, saltDerivedKey, uuk.EncSymKey.P2c, keyLen, sha256.New)
 
 	// hkdf 2
-	secretKeyHash := hkdf.New(sha256.New, secretKey, mount, nil)
+	secretKeyHash := hkdf.New(sha256.New, secretKey, mount, []byte("2SKD HKDF 2"))
 	secretDerivedKey := make([]byte, 32)
 	if _, err := io.ReadFull(secretKeyHash, secretDerivedKey); err != nil {
 		return nil, err
@@ -438,9 +487,31 @@ func (uuk *UUK) Build(password, mount, secretKey, entityID []byte) error {
 	return nil
 }
 
-// DecryptEncPriKey decrypts the UUK EncPriKey using the EncSymKey and the the users SecretKey
-// returns priv key used to encrypt payloads
-func (uuk *UUK) DecryptEncPriKey(password, mount, secretKey, entityID []byte) (jwk.Key, error) {
+// Rewrap re-encrypts the EncSymKey for another mount and entity id, e.g.
+// before importing an account on another mount. The 2SKD is salted with the
+// mount and entity id so the UUK can't be unlocked elsewhere without it.
+// The private key and its EncPriKey are unchanged.
+func (uuk *UUK) Rewrap(password, secretKey, mount, entityID, newMount, newEntityID []byte) error {
+	symmetricKey, err := uuk.decryptEncSymKey(password, mount, secretKey, entityID)
+	if err != nil {
+		return err
+	}
+
+	if err := uuk.withInitializationSalt(); err != nil {
+		return err
+	}
+
+	twoSKD, err := uuk.twoSkd(password, newMount, secretKey, newEntityID)
+	if err != nil {
+		return err
+	}
+
+	return uuk.sealEncSymKey(twoSKD, symmetricKey)
+}
+
+// decryptEncSymKey decrypts the UUK EncSymKey using the 2SKD of the users
+// password and SecretKey
+func (uuk *UUK) decryptEncSymKey(password, mount, secretKey, entityID []byte) ([]byte, error) {
 	twoSKD, err := uuk.twoSkd(password, mount, secretKey, entityID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create 2SKD %s", err)
@@ -465,7 +536,13 @@ func (uuk *UUK) DecryptEncPriKey(password, mount, secretKey, entityID []byte) (j
 		return nil, fmt.Errorf("error decoding symmetric data: %s", err)
 	}
 
-	symmetricKey, err := twoSkdGcm.Open(nil, symIv, encSymKey, nil)
+	return twoSkdGcm.Open(nil, symIv, encSymKey, nil)
+}
+
+// DecryptEncPriKey decrypts the UUK EncPriKey using the EncSymKey and the the users SecretKey
+// returns priv key used to encrypt payloads
+func (uuk *UUK) DecryptEncPriKey(password, mount, secretKey, entityID []byte) (jwk.Key, error) {
+	symmetricKey, err := uuk.decryptEncSymKey(password, mount, secretKey, entityID)
 	if err != nil {
 		return nil, err
 	}
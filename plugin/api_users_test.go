@@ -0,0 +1,89 @@
+package secretsengine
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testUUKVectors is a UUK built by the web client and the parameters it was
+// built with.
+type testUUKVectors struct {
+	Password  string            `json:"password"`
+	Mount     string            `json:"mount"`
+	SecretKey string            `json:"secret_key"`
+	EntityID  string            `json:"entity_id"`
+	UUK       pwManagerUUKEntry `json:"uuk"`
+}
+
+// readUUKVectors reads testdata/uuk_vectors.json.
+func readUUKVectors(t *testing.T) testUUKVectors {
+	t.Helper()
+	data, err := os.ReadFile("testdata/uuk_vectors.json")
+	require.NoError(t, err)
+	var vectors testUUKVectors
+	require.NoError(t, json.Unmarshal(data, &vectors))
+	return vectors
+}
+
+// TestUUKWebClientCompatibility unlocks a UUK built by the web client
+// `buildUUK` and returned by the users endpoint.
+func TestUUKWebClientCompatibility(t *testing.T) {
+	data, err := os.ReadFile("testdata/uuk_vectors.json")
+	require.NoError(t, err)
+
+	c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/pwmanager/users/928e91c7-db18-9673-4342-6f731c7f561a", r.URL.Path)
+		w.Write([]byte(`{"data":`))
+		w.Write(data)
+		w.Write([]byte(`}`))
+	})
+
+	entry, err := c.Users().Get(context.Background(), "pwmanager", "928e91c7-db18-9673-4342-6f731c7f561a")
+	require.NoError(t, err)
+
+	uuk := entry.UUK.UUK()
+	priKey, err := uuk.DecryptEncPriKey(
+		[]byte("correct horse battery staple"),
+		[]byte("pwmanager"),
+		[]byte("A3K9Q2ZP7M4XW8R6T1YB5N0C"),
+		[]byte(entry.EntityID),
+	)
+	require.NoError(t, err)
+
+	var n []byte
+	require.NoError(t, priKey.Get("n", &n))
+	require.Equal(t, entry.UUK.PubKey["n"], base64.RawURLEncoding.EncodeToString(n))
+
+	_, err = uuk.DecryptEncPriKey([]byte("wrong"), []byte("pwmanager"), []byte("A3K9Q2ZP7M4XW8R6T1YB5N0C"), []byte(entry.EntityID))
+	require.Error(t, err)
+}
+
+// TestUUKRewrap tests that a rewrapped UUK is unlocked with the new mount and
+// entity id and keeps its private key.
+func TestUUKRewrap(t *testing.T) {
+	v := readUUKVectors(t)
+	uuk := v.UUK.UUK()
+	encPriKey := uuk.EncPriKey
+
+	err := uuk.Rewrap([]byte("wrong"), []byte(v.SecretKey), []byte(v.Mount), []byte(v.EntityID), []byte("other"), []byte("new-entity"))
+	require.Error(t, err)
+
+	require.NoError(t, uuk.Rewrap([]byte(v.Password), []byte(v.SecretKey), []byte(v.Mount), []byte(v.EntityID), []byte("other"), []byte("new-entity")))
+	require.Equal(t, encPriKey, uuk.EncPriKey)
+	require.NotEqual(t, v.UUK.EncSymKey.P2s, uuk.EncSymKey.P2s)
+
+	_, err = uuk.DecryptEncPriKey([]byte(v.Password), []byte(v.Mount), []byte(v.SecretKey), []byte(v.EntityID))
+	require.Error(t, err)
+	priKey, err := uuk.DecryptEncPriKey([]byte(v.Password), []byte("other"), []byte(v.SecretKey), []byte("new-entity"))
+	require.NoError(t, err)
+
+	var n []byte
+	require.NoError(t, priKey.Get("n", &n))
+	require.Equal(t, v.UUK.PubKey["n"], base64.RawURLEncoding.EncodeToString(n))
+}
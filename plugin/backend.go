@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -39,18 +41,34 @@ type pwManagerBackend struct {
 	storage logical.Storage
 
 	policyService PolicyService
+
+	// reconciled is when the entity of a caller was last reconciled and
+	// lastReconcile when every user was
+	reconciledMu  sync.Mutex
+	reconciled    map[string]time.Time
+	lastReconcile time.Time
+
+	// directoryEntities caches the entities of the users read by directory
+	// searches
+	directoryMu       sync.Mutex
+	directoryEntities map[string]directoryEntity
 }
 
 type PolicyService interface {
-	PutPolicy(name, rules string) error
+	PutPolicy(ctx context.Context, name, rules string) error
+	DeletePolicy(ctx context.Context, name string) error
 }
 
 type PolicyServicer struct {
 	c *pwmanagerClient
 }
 
-func (p *PolicyServicer) PutPolicy(name, rules string) error {
-	return p.c.c.Sys().PutPolicy(name, rules)
+func (p *PolicyServicer) PutPolicy(ctx context.Context, name, rules string) error {
+	return p.c.Sys().PutPolicy(ctx, name, rules)
+}
+
+func (p *PolicyServicer) DeletePolicy(ctx context.Context, name string) error {
+	return p.c.Sys().DeletePolicy(ctx, name)
 }
 
 func NewPolicyService(c *pwmanagerClient) PolicyService {
@@ -65,6 +83,8 @@ func backend() *pwManagerBackend {
 
 	b.renew = make(chan interface{})
 	b.done = make(chan interface{})
+	b.reconciled = map[string]time.Time{}
+	b.directoryEntities = map[string]directoryEntity{}
 
 	appLogger := hclog.New(&hclog.LoggerOptions{
 		Name:  "pwManager",
@@ -80,12 +100,23 @@ func backend() *pwManagerBackend {
 		Paths: framework.PathAppend(
 			pathUser(&b),
 			pathBundle(&b),
+			pathGenerator(&b),
+			pathTrash(&b),
+			pathAttachments(&b),
+			pathBackup(&b),
+			pathAccount(&b),
+			pathSchema(&b),
+			pathFsck(&b),
+			pathOffboard(&b),
+			pathReconcile(&b),
+			pathDirectory(&b),
 			[]*framework.Path{
 				pathConfig(&b),
 			},
 		),
 		BackendType:    logical.TypeLogical,
 		InitializeFunc: b.initialize,
+		PeriodicFunc:   b.periodicFunc,
 	}
 
 	go b.renewLoop()
@@ -95,7 +126,19 @@ func backend() *pwManagerBackend {
 func (b *pwManagerBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
 	b.storage = req.Storage
 
-	return nil
+	return b.migrate(ctx, req.Storage)
+}
+
+// periodicFunc is called by Vault about once a minute. It purges the trash
+// items whose retention has passed and hourly reconciles the users with
+// their Vault entities.
+func (b *pwManagerBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	now := time.Now()
+	b.expireDirectoryEntities(now)
+	return errors.Join(
+		b.purgeTrash(ctx, req.Storage, now),
+		b.periodicReconcile(ctx, req.Storage, now),
+	)
 }
 
 func (p *pwManagerBackend) renewLoop() {
@@ -103,13 +146,13 @@ func (p *pwManagerBackend) renewLoop() {
 	for {
 		select {
 		case <-p.renew:
-			if err := p.Login(); err != nil {
+			if err := p.Login(context.Background()); err != nil {
 				p.logger.Error(err.Error())
 			} else {
 				t.Reset(45 * time.Minute)
 			}
 		case <-t.C:
-			if err := p.Login(); err != nil {
+			if err := p.Login(context.Background()); err != nil {
 				p.logger.Error(err.Error())
 			}
 		case <-p.done:
@@ -118,8 +161,8 @@ func (p *pwManagerBackend) renewLoop() {
 	}
 }
 
-func (p *pwManagerBackend) Login() error {
-	config, err := getConfig(context.TODO(), p.storage)
+func (p *pwManagerBackend) Login(ctx context.Context) error {
+	config, err := getConfig(ctx, p.storage)
 
 	if config == nil || err != nil {
 		return fmt.Errorf("pwmanager mount not configured. configure at /config")
@@ -144,7 +187,7 @@ func (p *pwManagerBackend) Login() error {
 	}
 
 	// TODO config the app role mount
-	response, err := p.c.AppRole().Login("approle", b.String())
+	response, err := p.c.AppRole().Login(ctx, "approle", b.String())
 	if err != nil {
 		p.logger.Debug("error doing app role request")
 		return fmt.Errorf("do: %w", err)
@@ -0,0 +1,61 @@
+package secretsengine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AttachmentChunkSize is the size of the plaintext chunks attachments are
+// encrypted in.
+const AttachmentChunkSize = 512 << 10
+
+// ErrAttachmentIntegrity is returned when a chunk of an attachment doesn't
+// match the hashes of its manifest.
+var ErrAttachmentIntegrity = errors.New("attachment integrity check failed")
+
+// Attachment is a file attached to an entry. The entry lists its
+// attachments, the encrypted chunks and manifest are stored at
+// `attachments/<entry path>/<attachment id>/`.
+type Attachment struct {
+	ID   string `json:"ID"`
+	Name string `json:"Name"`
+	Size int64  `json:"Size"`
+}
+
+// AttachmentManifest describes the chunks of an attachment. Chunks are the
+// hex encoded SHA-256 hashes of the plaintext chunks in order and SHA256 is
+// the hash of the whole file. The manifest is encrypted with the bundle key.
+type AttachmentManifest struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Size      int64    `json:"size"`
+	ChunkSize int      `json:"chunk_size"`
+	Chunks    []string `json:"chunks"`
+	SHA256    string   `json:"sha256"`
+}
+
+// Attachment returns the attachment with the id or name. Names are compared
+// case insensitively.
+func (e Entry) Attachment(ref string) (Attachment, error) {
+	var found []Attachment
+	for _, a := range e.Attachments {
+		if a.ID == ref || strings.EqualFold(a.Name, ref) {
+			found = append(found, a)
+		}
+	}
+
+	switch {
+	case len(found) == 0:
+		return Attachment{}, fmt.Errorf("attachment %q: %w", ref, ErrNotFound)
+	case len(found) > 1:
+		return Attachment{}, fmt.Errorf("attachment name %q is ambiguous, use the attachment id", ref)
+	}
+	return found[0], nil
+}
+
+// attachmentPath returns the kv-v2 name of a chunk of an attachment relative
+// to the bundle path.
+func attachmentPath(entryPath, id, chunk string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", ATTACHMENT_SCHEMA, entryPath, id, chunk)
+}
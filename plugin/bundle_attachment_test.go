@@ -0,0 +1,102 @@
+package secretsengine
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVBundleAttachments(t *testing.T) {
+	const (
+		entityID = "928e91c7-db18-9673-4342-6f731c7f561a"
+		path     = "bundles/data/928e91c7-db18-9673-4342-6f731c7f561a/0bbf993d-8e10-6dd0-1aa3-80019b69e332"
+		mount    = "pwmanager"
+	)
+
+	v, _ := loadBundleCryptoVectors(t)
+	ctx := context.Background()
+
+	// chunks written to the attachments endpoint are stored in the kv
+	// mount the same way the plugin writes them
+	kv, data := testKVHandler(t)
+	var deleted []string
+	c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+		prefix := "/v1/" + mount + "/bundles/" + strings.TrimPrefix(path, "bundles/data/") + "/attachments/"
+		if rest, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+			if r.Method == http.MethodDelete {
+				deleted = append(deleted, rest)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			r.URL.Path = "/v1/" + path + "/attachments/" + rest
+		}
+		kv(w, r)
+	})
+
+	kvb := c.KVBundle(path)
+	require.NoError(t, kvb.Init(ctx, entityID, v.pubKey(), "personal"))
+	bm, err := kvb.Metadata(ctx)
+	require.NoError(t, err)
+
+	e := NewPasswordEntry()
+	e.Name = "github"
+	e.SyncMetadata()
+	require.NoError(t, kvb.PutEntry(ctx, e, bm))
+
+	file := make([]byte, 2*AttachmentChunkSize+100)
+	_, err = rand.Read(file)
+	require.NoError(t, err)
+
+	a, err := kvb.PutAttachment(ctx, mount, e, bm, "recovery.bin", bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(file)), a.Size)
+	require.Contains(t, data, path+"/attachments/"+e.Metadata.Path+"/"+a.ID+"/2")
+
+	current, err := kvb.Entry(ctx, bm.Entries[0])
+	require.NoError(t, err)
+	found, err := current.Attachment("Recovery.bin")
+	require.NoError(t, err)
+	require.Equal(t, a, found)
+
+	t.Run("read", func(t *testing.T) {
+		var buf bytes.Buffer
+		manifest, err := kvb.ReadAttachment(ctx, e.Metadata, a.ID, &buf)
+		require.NoError(t, err)
+		require.Len(t, manifest.Chunks, 3)
+		require.Equal(t, file, buf.Bytes())
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		empty, err := kvb.PutAttachment(ctx, mount, e, bm, "empty", bytes.NewReader(nil))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		_, err = kvb.ReadAttachment(ctx, e.Metadata, empty.ID, &buf)
+		require.NoError(t, err)
+		require.Zero(t, buf.Len())
+	})
+
+	t.Run("swapped chunks fail the integrity check", func(t *testing.T) {
+		chunks := path + "/attachments/" + e.Metadata.Path + "/" + a.ID + "/"
+		_, err := c.write(ctx, "/v1/"+chunks+"0", map[string]interface{}{"data": data[chunks+"1"]})
+		require.NoError(t, err)
+
+		_, err = kvb.ReadAttachment(ctx, e.Metadata, a.ID, &bytes.Buffer{})
+		require.ErrorIs(t, err, ErrAttachmentIntegrity)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, kvb.DeleteAttachment(ctx, mount, e, bm, a.ID))
+		require.Equal(t, []string{e.Metadata.Path + "/" + a.ID}, deleted)
+
+		current, err := kvb.Entry(ctx, bm.Entries[0])
+		require.NoError(t, err)
+		_, err = current.Attachment(a.ID)
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+}
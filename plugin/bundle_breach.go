@@ -0,0 +1,118 @@
+package secretsengine
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachDB checks passwords against a local copy of the Have I Been Pwned
+// range dataset, see https://haveibeenpwned.com/Passwords. The dataset is a
+// directory with a file per 5 hex character SHA-1 prefix, e.g. 5BAA6.txt,
+// holding `<35 hex character suffix>:<count>` lines. This is the layout of
+// the official downloader and of the range API responses saved to disk.
+//
+// Passwords are only hashed in memory and nothing is sent to the network.
+type BreachDB struct {
+	dir string
+}
+
+// OpenBreachDB opens the range dataset in dir.
+func OpenBreachDB(dir string) (*BreachDB, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &BreachDB{dir: dir}, nil
+}
+
+// Count returns how often the password appears in the dataset. Zero means
+// the password wasn't found.
+func (db *BreachDB) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	return db.CountHash(sum)
+}
+
+// CountHash returns how often the password with the SHA-1 hash appears in
+// the dataset.
+func (db *BreachDB) CountHash(sum [sha1.Size]byte) (int, error) {
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := h[:5], h[5:]
+
+	f, err := db.openRange(prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) < len(suffix) || !strings.EqualFold(line[:len(suffix)], suffix) {
+			continue
+		}
+
+		// padding lines of the range API have a count of 0
+		count, err := strconv.Atoi(strings.TrimPrefix(line[len(suffix):], ":"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid line in range %s: %q", prefix, line)
+		}
+		return count, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, fmt.Errorf("error reading range %s: %w", prefix, err)
+	}
+	return 0, nil
+}
+
+// openRange opens the range file of the prefix. Files are named by the
+// prefix with or without a .txt extension in upper or lower case.
+func (db *BreachDB) openRange(prefix string) (*os.File, error) {
+	for _, name := range []string{prefix + ".txt", prefix, strings.ToLower(prefix) + ".txt", strings.ToLower(prefix)} {
+		f, err := os.Open(filepath.Join(db.dir, name))
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("range %s is missing from the dataset %s", prefix, db.dir)
+}
+
+// BreachedField is a password field of an entry found in the dataset.
+type BreachedField struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// CheckEntry returns the password inputs of the entry found in the dataset.
+func (db *BreachDB) CheckEntry(e *Entry) ([]BreachedField, error) {
+	var breached []BreachedField
+	for _, items := range [][]Input{e.Core.Items, e.More.Items} {
+		for _, i := range items {
+			if i.Type != "password" || i.Value == "" {
+				continue
+			}
+
+			count, err := db.Count(i.Value)
+			if err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				breached = append(breached, BreachedField{Label: i.Label, Count: count})
+			}
+		}
+	}
+	return breached, nil
+}
@@ -0,0 +1,60 @@
+package secretsengine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeBreachDB writes a range dataset with the SHA-1 of "password" and
+// "hunter2".
+func writeBreachDB(t *testing.T) string {
+	dir := t.TempDir()
+
+	// SHA-1 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(
+		"1E4BE4E8D1A5D4E1C5DC9C36A3E27E2C36E:0\r\n"+
+			"1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n"+
+			"1E4D4FDDBE7B0A0EE2F9B0D6C80B10F0F5E:3\r\n"), 0o600))
+
+	// SHA-1 F3BBBD66A63D4BF1747940578EC3D0103530E21D
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "f3bbb"), []byte(
+		"d66a63d4bf1747940578ec3d0103530e21d:24230\n"), 0o600))
+
+	return dir
+}
+
+func TestBreachDB(t *testing.T) {
+	db, err := OpenBreachDB(writeBreachDB(t))
+	require.NoError(t, err)
+
+	count, err := db.Count("password")
+	require.NoError(t, err)
+	require.Equal(t, 9659365, count)
+
+	count, err = db.Count("hunter2")
+	require.NoError(t, err)
+	require.Equal(t, 24230, count)
+
+	// SHA-1 5BAA61E4BE4E8D1A5D4E1C5DC9C36A3E27E2C36E is a padding line
+	count, err = db.CountHash([20]byte{0x5b, 0xaa, 0x61, 0xe4, 0xbe, 0x4e, 0x8d, 0x1a, 0x5d, 0x4e, 0x1c, 0x5d, 0xc9, 0xc3, 0x6a, 0x3e, 0x27, 0xe2, 0xc3, 0x6e})
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	_, err = db.Count("correct horse battery staple")
+	require.ErrorContains(t, err, "missing from the dataset")
+
+	e := NewPasswordEntry()
+	e.SetField("username", "password")
+	e.SetField("password", "hunter2")
+	e.More.Items = append(e.More.Items, Input{Type: "password", Label: "pin", Value: "password"})
+
+	breached, err := db.CheckEntry(e)
+	require.NoError(t, err)
+	require.Equal(t, []BreachedField{{Label: "Password", Count: 24230}, {Label: "pin", Count: 9659365}}, breached)
+
+	_, err = OpenBreachDB(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
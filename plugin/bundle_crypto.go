@@ -0,0 +1,443 @@
+package secretsengine
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// The bundle crypto functions are the Go counterpart of the web client
+// `client/src/lib/helper.ts` and `KVBundleService`. Every bundle has a
+// symmetric AES-256-GCM key. The key is exported as a JWK and encrypted with
+// each bundle users public key using RSA-OAEP SHA-256. Entries and bundle
+// metadata are json encoded and encrypted with the bundle key. All binary
+// values are hex encoded.
+
+// BundleKey is the symmetric key used to encrypt a bundles entries and metadata.
+type BundleKey struct {
+	k []byte
+}
+
+// bundleKeyJWK is the JWK format of a BundleKey exported by WebCrypto.
+type bundleKeyJWK struct {
+	Alg    string   `json:"alg"`
+	Ext    bool     `json:"ext"`
+	K      string   `json:"k"`
+	KeyOps []string `json:"key_ops"`
+	Kty    string   `json:"kty"`
+}
+
+// NewBundleKey creates a random AES-256-GCM bundle key.
+func NewBundleKey() (*BundleKey, error) {
+	k := make([]byte, 32)
+	if _, err := rand.Read(k); err != nil {
+		return nil, fmt.Errorf("error generating bundle key: %w", err)
+	}
+	return &BundleKey{k: k}, nil
+}
+
+// ParseBundleKey parses a bundle key from its JWK json format.
+func ParseBundleKey(data []byte) (*BundleKey, error) {
+	var j bundleKeyJWK
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("error parsing bundle key: %w", err)
+	}
+
+	if j.Kty != "oct" {
+		return nil, fmt.Errorf("bundle key has unexpected kty %q", j.Kty)
+	}
+
+	k, err := base64.RawURLEncoding.DecodeString(j.K)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding bundle key: %w", err)
+	}
+
+	if len(k) != 32 {
+		return nil, fmt.Errorf("bundle key must be 32 bytes got %d", len(k))
+	}
+
+	return &BundleKey{k: k}, nil
+}
+
+// JWK returns the bundle key in the JWK json format exported by WebCrypto.
+func (bk *BundleKey) JWK() ([]byte, error) {
+	return json.Marshal(bundleKeyJWK{
+		Alg:    "A256GCM",
+		Ext:    true,
+		K:      base64.RawURLEncoding.EncodeToString(bk.k),
+		KeyOps: []string{"encrypt", "decrypt"},
+		Kty:    "oct",
+	})
+}
+
+// Zero overwrites the key material. The key can't be used afterwards.
+func (bk *BundleKey) Zero() {
+	clear(bk.k)
+	bk.k = nil
+}
+
+// Wrap encrypts the bundle key with a users public key. The result is the hex
+// encoded value stored at `keys/<entity id>` in the bundle.
+func (bk *BundleKey) Wrap(pubKey PubKey) (string, error) {
+	pub, err := pubKey.rsaPublicKey()
+	if err != nil {
+		return "", err
+	}
+
+	j, err := bk.JWK()
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, j, nil)
+	if err != nil {
+		return "", fmt.Errorf("error wrapping bundle key: %w", err)
+	}
+
+	return hex.EncodeToString(wrapped), nil
+}
+
+// UnwrapBundleKey decrypts a wrapped bundle key with the users private key
+// returned by UUK.DecryptEncPriKey.
+func UnwrapBundleKey(wrapped string, priKey jwk.Key) (*BundleKey, error) {
+	var pri rsa.PrivateKey
+	if err := jwk.Export(priKey, &pri); err != nil {
+		return nil, fmt.Errorf("error exporting private key: %w", err)
+	}
+
+	ct, err := hex.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding wrapped bundle key: %w", err)
+	}
+
+	// the exported copy of the private key is only needed to unwrap
+	defer zeroRSAPrivateKey(&pri)
+
+	j, err := rsa.DecryptOAEP(sha256.New(), nil, &pri, ct, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping bundle key: %w", err)
+	}
+	defer clear(j)
+
+	return ParseBundleKey(j)
+}
+
+// ZeroPrivateKey overwrites the private parameters of a users rsa private
+// key returned by UUK.DecryptEncPriKey. The key can't be used afterwards.
+func ZeroPrivateKey(key jwk.Key) {
+	k, ok := key.(jwk.RSAPrivateKey)
+	if !ok {
+		return
+	}
+
+	for _, param := range []func() ([]byte, bool){k.D, k.P, k.Q, k.DP, k.DQ, k.QI} {
+		if b, ok := param(); ok {
+			clear(b)
+		}
+	}
+}
+
+// zeroRSAPrivateKey overwrites the private parameters of an rsa private key.
+func zeroRSAPrivateKey(k *rsa.PrivateKey) {
+	zero := func(i *big.Int) {
+		if i != nil {
+			clear(i.Bits())
+			i.SetInt64(0)
+		}
+	}
+
+	zero(k.D)
+	for _, p := range k.Primes {
+		zero(p)
+	}
+	zero(k.Precomputed.Dp)
+	zero(k.Precomputed.Dq)
+	zero(k.Precomputed.Qinv)
+}
+
+// EncryptedEntry is the encrypted payload stored in the kv-v2 data of
+// entries and bundle metadata.
+type EncryptedEntry struct {
+	Entry string `json:"entry" mapstructure:"entry"`
+	Iv    string `json:"iv" mapstructure:"iv"`
+}
+
+// Seal encrypts plaintext with the bundle key using the provided 12 byte iv.
+func (bk *BundleKey) Seal(iv, plaintext []byte) (EncryptedEntry, error) {
+	gcm, err := bk.gcm()
+	if err != nil {
+		return EncryptedEntry{}, err
+	}
+
+	if len(iv) != gcm.NonceSize() {
+		return EncryptedEntry{}, fmt.Errorf("iv must be %d bytes", gcm.NonceSize())
+	}
+
+	return EncryptedEntry{
+		Entry: hex.EncodeToString(gcm.Seal(nil, iv, plaintext, nil)),
+		Iv:    hex.EncodeToString(iv),
+	}, nil
+}
+
+// Open decrypts an encrypted entry with the bundle key.
+func (bk *BundleKey) Open(ee EncryptedEntry) ([]byte, error) {
+	gcm, err := bk.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := hex.DecodeString(ee.Iv)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding iv: %w", err)
+	}
+
+	ct, err := hex.DecodeString(ee.Entry)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding entry: %w", err)
+	}
+
+	if len(iv) != gcm.NonceSize() {
+		return nil, fmt.Errorf("iv must be %d bytes", gcm.NonceSize())
+	}
+
+	plaintext, err := gcm.Open(nil, iv, ct, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting entry: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// EncryptJSON json encodes v the same way as JSON.stringify and encrypts it
+// with a random iv.
+func (bk *BundleKey) EncryptJSON(v interface{}) (EncryptedEntry, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return EncryptedEntry{}, err
+	}
+
+	// Encode appends a newline JSON.stringify does not
+	return bk.Encrypt(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// Encrypt encrypts plaintext with a random iv.
+func (bk *BundleKey) Encrypt(plaintext []byte) (EncryptedEntry, error) {
+	iv := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return EncryptedEntry{}, err
+	}
+	return bk.Seal(iv, plaintext)
+}
+
+// DecryptJSON decrypts the encrypted entry and json decodes it into v.
+func (bk *BundleKey) DecryptJSON(ee EncryptedEntry, v interface{}) error {
+	plaintext, err := bk.Open(ee)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+func (bk *BundleKey) gcm() (cipher.AEAD, error) {
+	if len(bk.k) == 0 {
+		return nil, fmt.Errorf("bundle key is locked")
+	}
+
+	c, err := aes.NewCipher(bk.k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// rsaPublicKey returns the rsa public key of the users JWK public key.
+func (p PubKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	if p["kty"] != "" && p["kty"] != "RSA" {
+		return nil, fmt.Errorf("public key has unexpected kty %q", p["kty"])
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(p["n"])
+	if err != nil || len(n) == 0 {
+		return nil, fmt.Errorf("error decoding public key modulus")
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(p["e"])
+	if err != nil || len(e) == 0 {
+		return nil, fmt.Errorf("error decoding public key exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// Input is a field of an entry e.g. a username or password. See
+// `client/src/routes/unlocked/models/input.ts`.
+type Input struct {
+	Type        string `json:"Type"`
+	Label       string `json:"Label"`
+	Placeholder string `json:"Placeholder"`
+	Value       string `json:"Value"`
+}
+
+// EntryMetadata is the non secret information of an entry stored in the
+// bundle metadata. Path is the name of the entry under `entries/`.
+type EntryMetadata struct {
+	Name    string `json:"Name"`
+	Type    string `json:"Type"`
+	Value   string `json:"Value"`
+	ID      string `json:"ID"`
+	Version int    `json:"Version"`
+	Path    string `json:"Path"`
+}
+
+// Items holds the inputs of an entry.
+type Items struct {
+	Items []Input `json:"Items"`
+}
+
+// Entry is a password entry. See `client/src/routes/unlocked/models/entry.ts`.
+type Entry struct {
+	Name     string        `json:"Name"`
+	Type     string        `json:"Type"`
+	Metadata EntryMetadata `json:"Metadata"`
+	Core     Items         `json:"Core"`
+	More     Items         `json:"More"`
+	Tags     []string      `json:"Tags"`
+
+	// Attachments are the files attached to the entry, see
+	// KVBundle.PutAttachment
+	Attachments []Attachment `json:"Attachments,omitempty"`
+
+	// ModifiedBy is the entity id of the user who wrote the entry. It is
+	// set by KVBundle.PutEntry and empty for entries saved by the web client.
+	ModifiedBy string `json:"ModifiedBy,omitempty"`
+
+	// Modified is when the kv-v2 version the entry was read from was
+	// created. Every update is a new version so this is when the entry was
+	// last modified. It isn't encrypted with the entry.
+	Modified time.Time `json:"-"`
+}
+
+// NewPasswordEntry returns an empty password entry with a username and
+// password input. See newPasswordEntry in `client/src/routes/unlocked/models/entry.ts`.
+func NewPasswordEntry() *Entry {
+	return &Entry{
+		Name: "",
+		Type: "password",
+		Metadata: EntryMetadata{
+			Name: "Password",
+			Type: "password",
+		},
+		Core: Items{Items: []Input{
+			{Type: "text", Label: "username", Placeholder: "username"},
+			{Type: "password", Label: "Password", Placeholder: "Password"},
+		}},
+		More: Items{Items: []Input{}},
+		Tags: []string{},
+	}
+}
+
+// NewSSHKeyEntry returns an empty SSH key entry. The private key is in the
+// OpenSSH or PEM format and is decrypted with the passphrase when it is
+// encrypted.
+func NewSSHKeyEntry() *Entry {
+	return &Entry{
+		Name: "",
+		Type: "ssh",
+		Metadata: EntryMetadata{
+			Name: "SSH Key",
+			Type: "ssh",
+		},
+		Core: Items{Items: []Input{
+			{Type: "text", Label: "public key", Placeholder: "public key"},
+			{Type: "password", Label: "private key", Placeholder: "private key"},
+			{Type: "password", Label: "passphrase", Placeholder: "passphrase"},
+		}},
+		More: Items{Items: []Input{}},
+		Tags: []string{},
+	}
+}
+
+// SetField sets the value of the first input with label. A new text input is
+// added to More when no input has the label.
+func (e *Entry) SetField(label, value string) {
+	for _, items := range [][]Input{e.Core.Items, e.More.Items} {
+		for i := range items {
+			if strings.EqualFold(items[i].Label, label) {
+				items[i].Value = value
+				return
+			}
+		}
+	}
+	e.More.Items = append(e.More.Items, Input{Type: "text", Label: label, Placeholder: label, Value: value})
+}
+
+// RemoveField removes the More inputs with label. Core inputs can't be removed
+// and are cleared instead.
+func (e *Entry) RemoveField(label string) {
+	for i := range e.Core.Items {
+		if strings.EqualFold(e.Core.Items[i].Label, label) {
+			e.Core.Items[i].Value = ""
+		}
+	}
+
+	items := []Input{}
+	for _, i := range e.More.Items {
+		if !strings.EqualFold(i.Label, label) {
+			items = append(items, i)
+		}
+	}
+	e.More.Items = items
+}
+
+// SyncMetadata updates the entry metadata from the entry the same way the web
+// client does before saving an entry. The metadata value is the first core
+// input e.g. the username of a password entry.
+func (e *Entry) SyncMetadata() {
+	e.Metadata.Name = e.Name
+	e.Metadata.Type = e.Type
+	e.Metadata.Value = ""
+	if len(e.Core.Items) > 0 {
+		e.Metadata.Value = e.Core.Items[0].Value
+	}
+}
+
+// Field returns the value of the first core or more input with label. Labels
+// are compared case insensitively.
+func (e Entry) Field(label string) (string, bool) {
+	for _, items := range [][]Input{e.Core.Items, e.More.Items} {
+		for _, i := range items {
+			if strings.EqualFold(i.Label, label) {
+				return i.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// BundleMetadata lists the entries of a bundle. Version is the kv-v2 version
+// of the metadata and is used as the CAS value when the metadata is written.
+// Trash lists the deleted entries until they are restored or purged.
+type BundleMetadata struct {
+	Entries    []EntryMetadata `json:"entries"`
+	Trash      []EntryMetadata `json:"trash,omitempty"`
+	BundleName string          `json:"bundleName"`
+	Version    int             `json:"version"`
+}
@@ -0,0 +1,340 @@
+package secretsengine
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/stretchr/testify/require"
+)
+
+// bundleCryptoVectors are generated by the web client WebCrypto functions in
+// `client/src/lib/helper.ts` and prove the Go implementation is compatible.
+type bundleCryptoVectors struct {
+	PrivateKey json.RawMessage `json:"private_key"`
+	PublicKey  struct {
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"public_key"`
+	BundleKey        json.RawMessage `json:"bundle_key"`
+	WrappedBundleKey string          `json:"wrapped_bundle_key"`
+	Payloads         []struct {
+		Name      string `json:"name"`
+		Plaintext string `json:"plaintext"`
+		Iv        string `json:"iv"`
+		Entry     string `json:"entry"`
+	} `json:"payloads"`
+}
+
+// pubKey returns the vector public key in the format returned by the plugin.
+func (v bundleCryptoVectors) pubKey() PubKey {
+	return PubKey{"kty": v.PublicKey.Kty, "n": v.PublicKey.N, "e": v.PublicKey.E}
+}
+
+func loadBundleCryptoVectors(t *testing.T) (bundleCryptoVectors, jwk.Key) {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/bundle_crypto_vectors.json")
+	require.NoError(t, err)
+
+	var v bundleCryptoVectors
+	require.NoError(t, json.Unmarshal(data, &v))
+
+	priKey, err := jwk.ParseKey(v.PrivateKey)
+	require.NoError(t, err)
+
+	return v, priKey
+}
+
+func TestBundleCryptoVectors(t *testing.T) {
+	v, priKey := loadBundleCryptoVectors(t)
+
+	t.Run("unwrap bundle key", func(t *testing.T) {
+		bk, err := UnwrapBundleKey(v.WrappedBundleKey, priKey)
+		require.NoError(t, err)
+
+		expected, err := ParseBundleKey(v.BundleKey)
+		require.NoError(t, err)
+		require.Equal(t, expected.k, bk.k)
+	})
+
+	t.Run("wrap bundle key", func(t *testing.T) {
+		bk, err := ParseBundleKey(v.BundleKey)
+		require.NoError(t, err)
+
+		wrapped, err := bk.Wrap(v.pubKey())
+		require.NoError(t, err)
+
+		unwrapped, err := UnwrapBundleKey(wrapped, priKey)
+		require.NoError(t, err)
+		require.Equal(t, bk.k, unwrapped.k)
+	})
+
+	bk, err := ParseBundleKey(v.BundleKey)
+	require.NoError(t, err)
+
+	for _, p := range v.Payloads {
+		t.Run(p.Name, func(t *testing.T) {
+			iv, err := hex.DecodeString(p.Iv)
+			require.NoError(t, err)
+
+			ee, err := bk.Seal(iv, []byte(p.Plaintext))
+			require.NoError(t, err)
+			require.Equal(t, p.Entry, ee.Entry)
+
+			plaintext, err := bk.Open(EncryptedEntry{Entry: p.Entry, Iv: p.Iv})
+			require.NoError(t, err)
+			require.Equal(t, p.Plaintext, string(plaintext))
+
+			// Re-encoding the decoded payload must match JSON.stringify
+			// so entries written by Go are byte-compatible.
+			var decoded interface{}
+			switch p.Name {
+			case "entry":
+				decoded = &Entry{}
+			case "metadata":
+				decoded = &BundleMetadata{}
+			}
+			require.NoError(t, bk.DecryptJSON(EncryptedEntry{Entry: p.Entry, Iv: p.Iv}, decoded))
+
+			reencrypted, err := bk.EncryptJSON(decoded)
+			require.NoError(t, err)
+
+			plaintext, err = bk.Open(reencrypted)
+			require.NoError(t, err)
+			require.JSONEq(t, p.Plaintext, string(plaintext))
+		})
+	}
+
+	t.Run("locked key", func(t *testing.T) {
+		bk, err := ParseBundleKey(v.BundleKey)
+		require.NoError(t, err)
+		bk.Zero()
+
+		_, err = bk.Open(EncryptedEntry{Entry: v.Payloads[0].Entry, Iv: v.Payloads[0].Iv})
+		require.Error(t, err)
+	})
+}
+
+// testKVServer is an in memory kv-v2 mount supporting CAS writes, versioned
+// reads and metadata reads. The returned map holds the current version of
+// each secret.
+func testKVServer(t *testing.T) (*pwmanagerClient, map[string]json.RawMessage) {
+	handler, data := testKVHandler(t)
+	return testVaultServer(t, handler), data
+}
+
+// testKVHandler returns the handler of testKVServer.
+func testKVHandler(t *testing.T) (http.HandlerFunc, map[string]json.RawMessage) {
+	var mu sync.Mutex
+	data := map[string]json.RawMessage{}
+	history := map[string][]json.RawMessage{}
+	created := map[string][]time.Time{}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		p := strings.TrimPrefix(r.URL.Path, "/v1/")
+		switch r.Method {
+		case http.MethodGet:
+			if mount, rest, _ := strings.Cut(p, "/"); strings.HasPrefix(rest, "metadata/") {
+				p = mount + "/data/" + strings.TrimPrefix(rest, "metadata/")
+				versions := map[string]interface{}{}
+				for i, ct := range created[p] {
+					versions[strconv.Itoa(i+1)] = map[string]interface{}{"created_time": ct, "deletion_time": "", "destroyed": false}
+				}
+				if len(versions) == 0 {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"errors":[]}`))
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]interface{}{"current_version": len(history[p]), "versions": versions},
+				})
+				return
+			}
+
+			version := len(history[p])
+			if v := r.URL.Query().Get("version"); v != "" {
+				version, _ = strconv.Atoi(v)
+			}
+			if version < 1 || version > len(history[p]) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     history[p][version-1],
+					"metadata": map[string]interface{}{"version": version, "created_time": created[p][version-1]},
+				},
+			})
+		case http.MethodPost, http.MethodPut:
+			var body kvWriteRequest
+			var raw struct {
+				Data json.RawMessage `json:"data"`
+			}
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(b, &body))
+			require.NoError(t, json.Unmarshal(b, &raw))
+
+			if cas, ok := body.Options["cas"]; ok && cas != len(history[p]) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+				return
+			}
+			data[p] = raw.Data
+			history[p] = append(history[p], raw.Data)
+			created[p] = append(created[p], time.Now().UTC())
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]int{"version": len(history[p])},
+			})
+		case http.MethodDelete:
+			p = strings.Replace(p, "/metadata/", "/data/", 1)
+			delete(data, p)
+			delete(history, p)
+			delete(created, p)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+
+	return handler, data
+}
+
+func TestKVBundle(t *testing.T) {
+	const (
+		entityID = "928e91c7-db18-9673-4342-6f731c7f561a"
+		path     = "bundles/data/928e91c7-db18-9673-4342-6f731c7f561a/0bbf993d-8e10-6dd0-1aa3-80019b69e332"
+	)
+
+	v, priKey := loadBundleCryptoVectors(t)
+	ctx := context.Background()
+	c, data := testKVServer(t)
+
+	kvb := c.KVBundle(path)
+	require.NoError(t, kvb.Init(ctx, entityID, v.pubKey(), "personal"))
+	require.ErrorIs(t, kvb.Init(ctx, entityID, v.pubKey(), "personal"), ErrCASMismatch)
+
+	// A fresh client unlocks the bundle with the private key.
+	kvb = c.KVBundle(path)
+	require.NoError(t, kvb.Unlock(ctx, entityID, priKey))
+
+	bm, err := kvb.Metadata(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "personal", bm.BundleName)
+	require.Equal(t, 1, bm.Version)
+
+	e := &Entry{
+		Name: "github",
+		Type: "password",
+		Metadata: EntryMetadata{
+			Name: "github",
+			Type: "password",
+		},
+		Core: Items{Items: []Input{{Type: "password", Label: "Password", Value: "hunter2"}}},
+		More: Items{Items: []Input{}},
+		Tags: []string{},
+	}
+	require.NoError(t, kvb.PutEntry(ctx, e, bm))
+	require.NotEmpty(t, e.Metadata.ID)
+	require.Equal(t, 1, e.Metadata.Version)
+	require.Equal(t, 2, bm.Version)
+	firstPath := e.Metadata.Path
+
+	// Updating an entry writes a new version of its path.
+	e.Core.Items[0].Value = "hunter3"
+	require.NoError(t, kvb.PutEntry(ctx, e, bm))
+	require.Equal(t, firstPath, e.Metadata.Path)
+	require.Equal(t, 2, e.Metadata.Version)
+	require.Equal(t, entityID, e.ModifiedBy)
+
+	bm, err = kvb.Metadata(ctx)
+	require.NoError(t, err)
+	require.Len(t, bm.Entries, 1)
+
+	got, err := kvb.Entry(ctx, bm.Entries[0])
+	require.NoError(t, err)
+	value, ok := got.Field("password")
+	require.True(t, ok)
+	require.Equal(t, "hunter3", value)
+
+	// A stale metadata version is rejected.
+	stale := *bm
+	stale.Version = 1
+	require.ErrorIs(t, kvb.PutMetadata(ctx, &stale), ErrCASMismatch)
+
+	require.NoError(t, kvb.DeleteEntry(ctx, e.Metadata.ID))
+	bm, err = kvb.Metadata(ctx)
+	require.NoError(t, err)
+	require.Empty(t, bm.Entries)
+	require.NotContains(t, data, path+"/entries/"+e.Metadata.Path)
+
+	kvb.Lock()
+	_, err = kvb.Metadata(ctx)
+	require.Error(t, err)
+}
+
+func TestKVBundleImportEntries(t *testing.T) {
+	const (
+		entityID = "928e91c7-db18-9673-4342-6f731c7f561a"
+		path     = "bundles/data/928e91c7-db18-9673-4342-6f731c7f561a/0bbf993d-8e10-6dd0-1aa3-80019b69e332"
+	)
+
+	v, _ := loadBundleCryptoVectors(t)
+	ctx := context.Background()
+	c, data := testKVServer(t)
+
+	kvb := c.KVBundle(path)
+	require.NoError(t, kvb.Init(ctx, entityID, v.pubKey(), "personal"))
+	bm, err := kvb.Metadata(ctx)
+	require.NoError(t, err)
+
+	entries := func() []*Entry {
+		var entries []*Entry
+		for _, name := range []string{"github", "gitlab"} {
+			e := NewPasswordEntry()
+			e.Name = name
+			e.SetField("Password", "hunter2")
+			e.SyncMetadata()
+			entries = append(entries, e)
+		}
+		return entries
+	}
+
+	t.Run("stale metadata", func(t *testing.T) {
+		stale := *bm
+		stale.Version = 0
+		err := kvb.ImportEntries(ctx, entries(), &stale)
+		require.ErrorIs(t, err, ErrCASMismatch)
+		require.Empty(t, stale.Entries)
+		for p := range data {
+			require.NotContains(t, p, "/entries/")
+		}
+	})
+
+	require.NoError(t, kvb.ImportEntries(ctx, entries(), bm))
+	require.Equal(t, 2, bm.Version)
+
+	bm, err = kvb.Metadata(ctx)
+	require.NoError(t, err)
+	require.Len(t, bm.Entries, 2)
+	for _, m := range bm.Entries {
+		require.Equal(t, 1, m.Version)
+		e, err := kvb.Entry(ctx, m)
+		require.NoError(t, err)
+		require.Equal(t, m.Name, e.Name)
+		require.Equal(t, entityID, e.ModifiedBy)
+	}
+}
@@ -0,0 +1,97 @@
+package secretsengine
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// EntryVersion is a kv-v2 version of an entry, see KVBundle.EntryVersions.
+type EntryVersion struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// ModifiedBy is the entity id of the author, empty when unknown or
+	// the version is deleted
+	ModifiedBy string `json:"modified_by,omitempty"`
+	Current    bool   `json:"current"`
+	// Deleted versions were deleted or destroyed in kv-v2 and can't be
+	// read
+	Deleted bool `json:"deleted"`
+}
+
+// Change is how a field changed between two versions of an entry.
+type Change string
+
+const (
+	FieldAdded   Change = "added"
+	FieldRemoved Change = "removed"
+	FieldChanged Change = "changed"
+)
+
+// FieldDiff is a change to a field between two versions of an entry. Type is
+// the input type, the entry name and tags are compared as fields of type
+// "name" and "tags" and attachments as fields of type "attachment".
+type FieldDiff struct {
+	Label  string `json:"label"`
+	Type   string `json:"type"`
+	Change Change `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// DiffEntries compares two versions of an entry field by field. Inputs are
+// matched by label case insensitively, the nth input with a label is matched
+// with the nth input with the same label in the other version. Changes are
+// returned in the order of the old version followed by the added inputs and
+// the removed and added attachments.
+func DiffEntries(old, new *Entry) []FieldDiff {
+	diffs := []FieldDiff{}
+	if old.Name != new.Name {
+		diffs = append(diffs, FieldDiff{Label: "Name", Type: "name", Change: FieldChanged, Old: old.Name, New: new.Name})
+	}
+	if oldTags, newTags := strings.Join(old.Tags, ","), strings.Join(new.Tags, ","); oldTags != newTags {
+		diffs = append(diffs, FieldDiff{Label: "Tags", Type: "tags", Change: FieldChanged, Old: oldTags, New: newTags})
+	}
+
+	newInputs := append(append([]Input{}, new.Core.Items...), new.More.Items...)
+	matched := make([]bool, len(newInputs))
+
+	for _, o := range append(append([]Input{}, old.Core.Items...), old.More.Items...) {
+		found := false
+		for i, n := range newInputs {
+			if matched[i] || !strings.EqualFold(o.Label, n.Label) {
+				continue
+			}
+			matched[i] = true
+			found = true
+			if o.Value != n.Value || o.Type != n.Type {
+				diffs = append(diffs, FieldDiff{Label: n.Label, Type: n.Type, Change: FieldChanged, Old: o.Value, New: n.Value})
+			}
+			break
+		}
+		if !found {
+			diffs = append(diffs, FieldDiff{Label: o.Label, Type: o.Type, Change: FieldRemoved, Old: o.Value})
+		}
+	}
+
+	for i, n := range newInputs {
+		if !matched[i] {
+			diffs = append(diffs, FieldDiff{Label: n.Label, Type: n.Type, Change: FieldAdded, New: n.Value})
+		}
+	}
+
+	hasAttachment := func(attachments []Attachment, id string) bool {
+		return slices.ContainsFunc(attachments, func(a Attachment) bool { return a.ID == id })
+	}
+	for _, a := range old.Attachments {
+		if !hasAttachment(new.Attachments, a.ID) {
+			diffs = append(diffs, FieldDiff{Label: a.Name, Type: "attachment", Change: FieldRemoved, Old: a.Name})
+		}
+	}
+	for _, a := range new.Attachments {
+		if !hasAttachment(old.Attachments, a.ID) {
+			diffs = append(diffs, FieldDiff{Label: a.Name, Type: "attachment", Change: FieldAdded, New: a.Name})
+		}
+	}
+	return diffs
+}
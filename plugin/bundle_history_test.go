@@ -0,0 +1,127 @@
+package secretsengine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffEntries(t *testing.T) {
+	old := NewPasswordEntry()
+	old.Name = "github"
+	old.SetField("username", "octocat")
+	old.SetField("password", "hunter2")
+	old.SetField("url", "https://github.com")
+	old.SetField("note", "a")
+	old.More.Items = append(old.More.Items, Input{Type: "text", Label: "note", Value: "b"})
+	old.Attachments = []Attachment{{ID: "1", Name: "recovery.pdf"}, {ID: "2", Name: "id.png"}}
+
+	new := NewPasswordEntry()
+	new.Name = "GitHub"
+	new.Tags = []string{"work"}
+	new.SetField("username", "octocat")
+	new.SetField("password", "hunter3")
+	new.SetField("note", "a")
+	new.More.Items = append(new.More.Items, Input{Type: "text", Label: "note", Value: "c"})
+	new.SetField("recovery", "1234")
+	new.Attachments = []Attachment{{ID: "2", Name: "id.png"}, {ID: "3", Name: "recovery.pdf"}}
+
+	require.Equal(t, []FieldDiff{
+		{Label: "Name", Type: "name", Change: FieldChanged, Old: "github", New: "GitHub"},
+		{Label: "Tags", Type: "tags", Change: FieldChanged, New: "work"},
+		{Label: "Password", Type: "password", Change: FieldChanged, Old: "hunter2", New: "hunter3"},
+		{Label: "url", Type: "text", Change: FieldRemoved, Old: "https://github.com"},
+		{Label: "note", Type: "text", Change: FieldChanged, Old: "b", New: "c"},
+		{Label: "recovery", Type: "text", Change: FieldAdded, New: "1234"},
+		{Label: "recovery.pdf", Type: "attachment", Change: FieldRemoved, Old: "recovery.pdf"},
+		{Label: "recovery.pdf", Type: "attachment", Change: FieldAdded, New: "recovery.pdf"},
+	}, DiffEntries(old, new))
+
+	require.Empty(t, DiffEntries(old, old))
+}
+
+func TestKVBundleHistory(t *testing.T) {
+	const (
+		entityID = "928e91c7-db18-9673-4342-6f731c7f561a"
+		path     = "bundles/data/928e91c7-db18-9673-4342-6f731c7f561a/0bbf993d-8e10-6dd0-1aa3-80019b69e332"
+	)
+
+	v, _ := loadBundleCryptoVectors(t)
+	ctx := context.Background()
+	c, data := testKVServer(t)
+
+	kvb := c.KVBundle(path)
+	require.NoError(t, kvb.Init(ctx, entityID, v.pubKey(), "personal"))
+	bm, err := kvb.Metadata(ctx)
+	require.NoError(t, err)
+
+	e := NewPasswordEntry()
+	e.Name = "github"
+	e.SetField("password", "hunter2")
+	e.SyncMetadata()
+	require.NoError(t, kvb.PutEntry(ctx, e, bm))
+
+	e.SetField("password", "hunter3")
+	require.NoError(t, kvb.PutEntry(ctx, e, bm))
+
+	versions, err := kvb.EntryVersions(ctx, e.Metadata)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 1, versions[0].Version)
+	require.False(t, versions[0].Current)
+	require.True(t, versions[1].Current)
+	require.Equal(t, entityID, versions[0].ModifiedBy)
+	require.False(t, versions[0].Created.IsZero())
+
+	first, err := kvb.EntryVersion(ctx, e.Metadata, 1)
+	require.NoError(t, err)
+	password, _ := first.Field("password")
+	require.Equal(t, "hunter2", password)
+
+	t.Run("restore", func(t *testing.T) {
+		restored, err := kvb.RestoreEntry(ctx, bm.Entries[0], 1, bm)
+		require.NoError(t, err)
+		require.Equal(t, e.Metadata.ID, restored.Metadata.ID)
+		require.Equal(t, 3, restored.Metadata.Version)
+		require.Equal(t, 3, bm.Entries[0].Version)
+
+		current, err := kvb.Entry(ctx, bm.Entries[0])
+		require.NoError(t, err)
+		password, _ := current.Field("password")
+		require.Equal(t, "hunter2", password)
+
+		versions, err := kvb.EntryVersions(ctx, bm.Entries[0])
+		require.NoError(t, err)
+		require.Len(t, versions, 3)
+	})
+
+	t.Run("failed metadata update reverts the entry", func(t *testing.T) {
+		stale := *bm
+		stale.Version--
+		stale.Entries = append([]EntryMetadata{}, bm.Entries...)
+
+		current, err := kvb.Entry(ctx, bm.Entries[0])
+		require.NoError(t, err)
+		current.Metadata = bm.Entries[0]
+		current.SetField("password", "hunter4")
+		require.ErrorIs(t, kvb.PutEntry(ctx, current, &stale), ErrCASMismatch)
+
+		current, err = kvb.Entry(ctx, bm.Entries[0])
+		require.NoError(t, err)
+		password, _ := current.Field("password")
+		require.Equal(t, "hunter2", password)
+
+		versions, err := kvb.EntryVersions(ctx, bm.Entries[0])
+		require.NoError(t, err)
+		require.Len(t, versions, 5)
+	})
+
+	t.Run("delete destroys all versions", func(t *testing.T) {
+		require.NoError(t, kvb.DeleteEntry(ctx, e.Metadata.ID))
+		require.NotContains(t, data, path+"/entries/"+e.Metadata.Path)
+
+		_, err := kvb.EntryVersions(ctx, e.Metadata)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,231 @@
+package secretsengine
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTPInputType is the input type of an otpauth:// URI. An entry has at most
+// one OTP input, see Entry.OTP.
+const OTPInputType = "otp"
+
+// OTPLabel is the label of the OTP input added by Entry.SetOTP.
+const OTPLabel = "one-time password"
+
+// OTP is a HOTP (RFC 4226) or TOTP (RFC 6238) generator parsed from an
+// otpauth:// URI, see https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+type OTP struct {
+	// Type is hotp or totp
+	Type      string
+	Issuer    string
+	Account   string
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	// Period is the TOTP time step
+	Period time.Duration
+	// Counter is the HOTP counter of the next code
+	Counter uint64
+}
+
+// ParseOTPAuthURI parses an otpauth:// URI. Missing parameters default to
+// SHA1, 6 digits and a 30 second period.
+func ParseOTPAuthURI(s string) (*OTP, error) {
+	u, err := neturl.Parse(strings.TrimSpace(s))
+	if err != nil || u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("invalid otpauth URI")
+	}
+
+	o := &OTP{Type: strings.ToLower(u.Host), Algorithm: "SHA1", Digits: 6, Period: 30 * time.Second}
+	if o.Type != "totp" && o.Type != "hotp" {
+		return nil, fmt.Errorf("unsupported OTP type %q", u.Host)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		o.Issuer, o.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+	} else {
+		o.Account = label
+	}
+
+	q := u.Query()
+	if issuer := q.Get("issuer"); issuer != "" {
+		o.Issuer = issuer
+	}
+
+	o.Secret, err = decodeOTPSecret(q.Get("secret"))
+	if err != nil {
+		return nil, err
+	}
+
+	if alg := q.Get("algorithm"); alg != "" {
+		o.Algorithm = strings.ToUpper(alg)
+	}
+	if o.hash() == nil {
+		return nil, fmt.Errorf("unsupported OTP algorithm %q", o.Algorithm)
+	}
+
+	if d := q.Get("digits"); d != "" {
+		o.Digits, err = strconv.Atoi(d)
+		if err != nil || o.Digits < 6 || o.Digits > 10 {
+			return nil, fmt.Errorf("invalid OTP digits %q", d)
+		}
+	}
+
+	if p := q.Get("period"); p != "" {
+		seconds, err := strconv.Atoi(p)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid OTP period %q", p)
+		}
+		o.Period = time.Duration(seconds) * time.Second
+	}
+
+	if c := q.Get("counter"); c != "" {
+		o.Counter, err = strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTP counter %q", c)
+		}
+	} else if o.Type == "hotp" {
+		return nil, fmt.Errorf("hotp URI requires a counter")
+	}
+
+	return o, nil
+}
+
+// decodeOTPSecret decodes the base32 secret. Authenticator apps show secrets
+// in lower case, grouped by spaces and without padding so these are accepted.
+func decodeOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(s))
+	if s == "" {
+		return nil, fmt.Errorf("OTP secret is required")
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTP secret: %w", err)
+	}
+	return secret, nil
+}
+
+// URI returns the otpauth:// URI of the generator.
+func (o *OTP) URI() string {
+	q := neturl.Values{}
+	q.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(o.Secret))
+	if o.Issuer != "" {
+		q.Set("issuer", o.Issuer)
+	}
+	q.Set("algorithm", o.Algorithm)
+	q.Set("digits", strconv.Itoa(o.Digits))
+	if o.Type == "hotp" {
+		q.Set("counter", strconv.FormatUint(o.Counter, 10))
+	} else {
+		q.Set("period", strconv.Itoa(int(o.Period/time.Second)))
+	}
+
+	label := o.Account
+	if o.Issuer != "" {
+		label = o.Issuer + ":" + o.Account
+	}
+
+	u := neturl.URL{Scheme: "otpauth", Host: o.Type, Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+func (o *OTP) hash() func() hash.Hash {
+	switch o.Algorithm {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// HOTP returns the RFC 4226 code for the counter.
+func (o *OTP) HOTP(counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(o.hash(), o.Secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+
+	mod := uint64(1)
+	for i := 0; i < o.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", o.Digits, code%mod)
+}
+
+// TOTP returns the RFC 6238 code at t and when it expires.
+func (o *OTP) TOTP(t time.Time) (string, time.Time) {
+	period := int64(o.Period / time.Second)
+	step := t.Unix() / period
+	return o.HOTP(uint64(step)), time.Unix((step+1)*period, 0)
+}
+
+// OTP returns the OTP generator of the entry. ok is false when the entry has
+// no OTP input.
+func (e Entry) OTP() (o *OTP, ok bool, err error) {
+	for _, items := range [][]Input{e.Core.Items, e.More.Items} {
+		for _, i := range items {
+			if i.Type == OTPInputType {
+				o, err := ParseOTPAuthURI(i.Value)
+				return o, true, err
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+// SetOTP sets the otpauth:// URI of the OTP input, adding one to More when
+// the entry has none.
+func (e *Entry) SetOTP(uri string) {
+	for _, items := range [][]Input{e.Core.Items, e.More.Items} {
+		for i := range items {
+			if items[i].Type == OTPInputType {
+				items[i].Value = uri
+				return
+			}
+		}
+	}
+	e.More.Items = append(e.More.Items, Input{Type: OTPInputType, Label: OTPLabel, Placeholder: "otpauth://", Value: uri})
+}
+
+// NextOTP returns the current code of the entry's OTP input. A HOTP counter
+// is incremented in the entry which must be saved so the code isn't reused.
+// expires is zero for HOTP codes.
+func (e *Entry) NextOTP(now time.Time) (code string, expires time.Time, err error) {
+	o, ok, err := e.OTP()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("entry %q has no one-time password", e.Name)
+	}
+
+	if o.Type == "totp" {
+		code, expires = o.TOTP(now)
+		return code, expires, nil
+	}
+
+	code = o.HOTP(o.Counter)
+	o.Counter++
+	e.SetOTP(o.URI())
+	return code, time.Time{}, nil
+}
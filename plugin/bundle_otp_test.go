@@ -0,0 +1,103 @@
+package secretsengine
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOTP(t *testing.T) {
+	t.Run("RFC 4226 HOTP", func(t *testing.T) {
+		o := &OTP{Type: "hotp", Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 6}
+		codes := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+		for counter, code := range codes {
+			require.Equal(t, code, o.HOTP(uint64(counter)))
+		}
+	})
+
+	t.Run("RFC 6238 TOTP", func(t *testing.T) {
+		secrets := map[string]string{
+			"SHA1":   "12345678901234567890",
+			"SHA256": "12345678901234567890123456789012",
+			"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+		}
+		vectors := []struct {
+			time  int64
+			codes map[string]string
+		}{
+			{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+			{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+			{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+			{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		}
+
+		for _, v := range vectors {
+			for alg, code := range v.codes {
+				secret := base32.StdEncoding.EncodeToString([]byte(secrets[alg]))
+				o, err := ParseOTPAuthURI("otpauth://totp/ACME:bob?digits=8&algorithm=" + alg + "&secret=" + secret)
+				require.NoError(t, err)
+
+				got, expires := o.TOTP(time.Unix(v.time, 0))
+				require.Equal(t, code, got, "%s at %d", alg, v.time)
+				require.Equal(t, (v.time/30+1)*30, expires.Unix())
+			}
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		o, err := ParseOTPAuthURI("otpauth://totp/ACME%20Co:john@example.com?secret=jbsw y3dp ehpk 3pxp&issuer=ACME+Co&period=60")
+		require.NoError(t, err)
+		require.Equal(t, "totp", o.Type)
+		require.Equal(t, "ACME Co", o.Issuer)
+		require.Equal(t, "john@example.com", o.Account)
+		require.Equal(t, []byte("Hello!\xde\xad\xbe\xef"), o.Secret)
+		require.Equal(t, "SHA1", o.Algorithm)
+		require.Equal(t, 6, o.Digits)
+		require.Equal(t, time.Minute, o.Period)
+
+		round, err := ParseOTPAuthURI(o.URI())
+		require.NoError(t, err)
+		require.Equal(t, o, round)
+
+		for _, uri := range []string{
+			"https://totp/ACME?secret=JBSWY3DPEHPK3PXP",
+			"otpauth://motp/ACME?secret=JBSWY3DPEHPK3PXP",
+			"otpauth://totp/ACME",
+			"otpauth://totp/ACME?secret=not-base32!",
+			"otpauth://totp/ACME?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+			"otpauth://totp/ACME?secret=JBSWY3DPEHPK3PXP&digits=4",
+			"otpauth://hotp/ACME?secret=JBSWY3DPEHPK3PXP",
+		} {
+			_, err := ParseOTPAuthURI(uri)
+			require.Error(t, err, uri)
+		}
+	})
+
+	t.Run("entry", func(t *testing.T) {
+		e := NewPasswordEntry()
+		_, ok, err := e.OTP()
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		e.SetOTP("otpauth://hotp/ACME:bob?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=1")
+		e.SetOTP("otpauth://hotp/ACME:bob?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=2")
+		require.Len(t, e.More.Items, 1)
+		require.Equal(t, OTPLabel, e.More.Items[0].Label)
+
+		o, ok, err := e.OTP()
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "359152", o.HOTP(o.Counter))
+
+		code, expires, err := e.NextOTP(time.Now())
+		require.NoError(t, err)
+		require.Equal(t, "359152", code)
+		require.True(t, expires.IsZero())
+
+		code, _, err = e.NextOTP(time.Now())
+		require.NoError(t, err)
+		require.Equal(t, "969429", code)
+	})
+}
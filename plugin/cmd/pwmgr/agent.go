@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// The agent holds the unlocked private key and bundle keys in memory so the
+// 2SKD is only derived once. It serves the CLI over a unix socket readable
+// only by the user and locks after it has been idle for the idle timeout.
+// Locking zeros the private key and bundle keys.
+
+const defaultIdleTimeout = 15 * time.Minute
+
+// agentRequest is sent by the CLI to the agent. One request is sent per
+// connection.
+type agentRequest struct {
+	Op        string           `json:"op"`
+	Password  string           `json:"password,omitempty"`
+	SecretKey string           `json:"secret_key,omitempty"`
+	Bundle    string           `json:"bundle,omitempty"`
+	Entry     string           `json:"entry,omitempty"`
+	Version   int              `json:"version,omitempty"`
+	Value     *pwManager.Entry `json:"value,omitempty"`
+	// Attachment is the attachment id or name, Name and Data the name and
+	// content of a new attachment
+	Attachment string `json:"attachment,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Data       []byte `json:"data,omitempty"`
+	// Values are the entries of an import
+	Values []*pwManager.Entry `json:"values,omitempty"`
+}
+
+// agentResponse is the agents response to an agentRequest.
+type agentResponse struct {
+	Error      string                    `json:"error,omitempty"`
+	Locked     bool                      `json:"locked"`
+	Bundles    []*bundle                 `json:"bundles,omitempty"`
+	Entries    []pwManager.EntryMetadata `json:"entries,omitempty"`
+	Versions   []pwManager.EntryVersion  `json:"versions,omitempty"`
+	Trash      []trashedEntry            `json:"trash,omitempty"`
+	Attachment pwManager.Attachment      `json:"attachment"`
+	Data       []byte                    `json:"data,omitempty"`
+	Entry      *pwManager.Entry          `json:"entry,omitempty"`
+	Metadata   pwManager.EntryMetadata   `json:"metadata"`
+	// Modified is the entry's Modified time which isn't json encoded with
+	// the entry.
+	Modified time.Time `json:"modified"`
+}
+
+// agent serves agentRequests.
+type agent struct {
+	a           *app
+	idleTimeout time.Duration
+
+	mu sync.Mutex
+	u  *unlocked
+	// gen is incremented on every request so a stale idle timer doesn't
+	// lock the agent.
+	gen   uint64
+	timer *time.Timer
+
+	// lockHooks are called with ag.mu held when the agent locks
+	lockHooks []func()
+}
+
+// agentSocket returns PWMGR_AGENT_SOCK or the socket in the config directory.
+func (a *app) agentSocket() string {
+	if s := a.getenv("PWMGR_AGENT_SOCK"); s != "" {
+		return s
+	}
+	return filepath.Join(a.configDir, "agent.sock")
+}
+
+// listenAgent listens on the unix socket at path. The socket is only
+// accessible by the user. A stale socket left by an agent that exited is
+// removed.
+func listenAgent(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("an agent is already listening on %s", path)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// serve accepts connections until ctx is done.
+func (ag *agent) serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	defer func() {
+		ag.mu.Lock()
+		defer ag.mu.Unlock()
+		ag.lock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go ag.handle(ctx, conn)
+	}
+}
+
+func (ag *agent) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(agentResponse{Error: fmt.Sprintf("invalid request: %s", err)})
+		return
+	}
+
+	json.NewEncoder(conn).Encode(ag.do(ctx, &req))
+}
+
+// do performs the request. Requests are handled one at a time.
+func (ag *agent) do(ctx context.Context, req *agentRequest) agentResponse {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+
+	switch req.Op {
+	case "status":
+		return agentResponse{Locked: ag.u == nil}
+	case "lock":
+		ag.lock()
+		return agentResponse{Locked: true}
+	case "unlock":
+		s, err := ag.a.loadSession()
+		if err != nil {
+			return agentResponse{Locked: ag.u == nil, Error: err.Error()}
+		}
+
+		u, err := unlockWith(ctx, s, req.Password, req.SecretKey)
+		if err != nil {
+			return agentResponse{Locked: ag.u == nil, Error: err.Error()}
+		}
+
+		ag.lock()
+		ag.u = u
+		ag.touch()
+		return agentResponse{}
+	}
+
+	if ag.u == nil {
+		return agentResponse{Locked: true, Error: "agent is locked, run `pwmgr unlock`"}
+	}
+	ag.touch()
+
+	var resp agentResponse
+	var err error
+	switch req.Op {
+	case "bundles":
+		resp.Bundles, err = ag.u.Bundles(ctx)
+	case "entries":
+		resp.Entries, err = ag.u.Entries(ctx, req.Bundle)
+	case "entry":
+		resp.Entry, err = ag.u.Entry(ctx, req.Bundle, req.Entry)
+		if err == nil {
+			resp.Modified = resp.Entry.Modified
+		}
+	case "entries_in":
+		resp.Entries, err = ag.u.EntriesIn(ctx, &bundle{ID: req.Bundle})
+	case "entry_in":
+		resp.Entry, err = ag.u.EntryIn(ctx, &bundle{ID: req.Bundle}, req.Entry)
+		if err == nil {
+			resp.Modified = resp.Entry.Modified
+		}
+	case "put":
+		if req.Value == nil {
+			err = fmt.Errorf("entry is required")
+			break
+		}
+		resp.Metadata, err = ag.u.PutEntry(ctx, req.Bundle, req.Value)
+	case "delete":
+		resp.Metadata, err = ag.u.DeleteEntry(ctx, req.Bundle, req.Entry)
+	case "versions":
+		resp.Versions, err = ag.u.EntryVersions(ctx, req.Bundle, req.Entry)
+	case "version":
+		resp.Entry, err = ag.u.EntryVersion(ctx, req.Bundle, req.Entry, req.Version)
+		if err == nil {
+			resp.Modified = resp.Entry.Modified
+		}
+	case "restore":
+		resp.Metadata, err = ag.u.RestoreEntry(ctx, req.Bundle, req.Entry, req.Version)
+	case "trash":
+		resp.Trash, err = ag.u.TrashedEntries(ctx, req.Bundle)
+	case "undelete":
+		resp.Metadata, err = ag.u.UndeleteEntry(ctx, req.Bundle, req.Entry)
+	case "purge":
+		resp.Metadata, err = ag.u.PurgeEntry(ctx, req.Bundle, req.Entry)
+	case "attach":
+		resp.Attachment, err = ag.u.PutAttachment(ctx, req.Bundle, req.Entry, req.Name, req.Data)
+	case "download":
+		resp.Attachment, resp.Data, err = ag.u.ReadAttachment(ctx, req.Bundle, req.Entry, req.Attachment)
+	case "detach":
+		resp.Attachment, err = ag.u.DeleteAttachment(ctx, req.Bundle, req.Entry, req.Attachment)
+	case "import":
+		resp.Entries, err = ag.u.ImportEntries(ctx, req.Bundle, req.Values)
+	default:
+		err = fmt.Errorf("unknown op %q", req.Op)
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// touch restarts the idle timer. ag.mu must be held.
+func (ag *agent) touch() {
+	ag.gen++
+	if ag.timer != nil {
+		ag.timer.Stop()
+	}
+	if ag.idleTimeout <= 0 {
+		return
+	}
+
+	gen := ag.gen
+	ag.timer = time.AfterFunc(ag.idleTimeout, func() {
+		ag.mu.Lock()
+		defer ag.mu.Unlock()
+		if gen == ag.gen {
+			ag.lock()
+		}
+	})
+}
+
+// onLock registers f to be called when the agent locks. f removes the key
+// material derived from the unlocked session, e.g. the SSH keys.
+func (ag *agent) onLock(f func()) {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	ag.lockHooks = append(ag.lockHooks, f)
+}
+
+// lock zeros the key material. ag.mu must be held.
+func (ag *agent) lock() {
+	if ag.timer != nil {
+		ag.timer.Stop()
+		ag.timer = nil
+	}
+	for _, f := range ag.lockHooks {
+		f()
+	}
+	if ag.u != nil {
+		ag.u.lock()
+		ag.u = nil
+	}
+}
+
+// agentClient is a store backed by a running agent.
+type agentClient struct {
+	socket string
+}
+
+// errNoAgent is returned when no agent is listening on the socket.
+var errNoAgent = errors.New("no agent running, start one with `pwmgr agent`")
+
+func (c *agentClient) call(ctx context.Context, req agentRequest) (*agentResponse, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.socket)
+	if err != nil {
+		return nil, errNoAgent
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error reading agent response: %w", err)
+	}
+
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+func (c *agentClient) Bundles(ctx context.Context) ([]*bundle, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "bundles"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Bundles, nil
+}
+
+func (c *agentClient) Entries(ctx context.Context, bundle string) ([]pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "entries", Bundle: bundle})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+func (c *agentClient) Entry(ctx context.Context, bundle, entry string) (*pwManager.Entry, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "entry", Bundle: bundle, Entry: entry})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Entry != nil {
+		resp.Entry.Modified = resp.Modified
+	}
+	return resp.Entry, nil
+}
+
+// EntriesIn and EntryIn read the bundle as opened by the agent on the last
+// bundles request.
+func (c *agentClient) EntriesIn(ctx context.Context, b *bundle) ([]pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "entries_in", Bundle: b.ID})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+func (c *agentClient) EntryIn(ctx context.Context, b *bundle, entry string) (*pwManager.Entry, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "entry_in", Bundle: b.ID, Entry: entry})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Entry != nil {
+		resp.Entry.Modified = resp.Modified
+	}
+	return resp.Entry, nil
+}
+
+func (c *agentClient) PutEntry(ctx context.Context, bundle string, e *pwManager.Entry) (pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "put", Bundle: bundle, Value: e})
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+	return resp.Metadata, nil
+}
+
+func (c *agentClient) DeleteEntry(ctx context.Context, bundle, entry string) (pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "delete", Bundle: bundle, Entry: entry})
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+	return resp.Metadata, nil
+}
+
+func (c *agentClient) EntryVersions(ctx context.Context, bundle, entry string) ([]pwManager.EntryVersion, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "versions", Bundle: bundle, Entry: entry})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Versions, nil
+}
+
+func (c *agentClient) EntryVersion(ctx context.Context, bundle, entry string, version int) (*pwManager.Entry, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "version", Bundle: bundle, Entry: entry, Version: version})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Entry != nil {
+		resp.Entry.Modified = resp.Modified
+	}
+	return resp.Entry, nil
+}
+
+func (c *agentClient) RestoreEntry(ctx context.Context, bundle, entry string, version int) (pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "restore", Bundle: bundle, Entry: entry, Version: version})
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+	return resp.Metadata, nil
+}
+
+func (c *agentClient) TrashedEntries(ctx context.Context, bundle string) ([]trashedEntry, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "trash", Bundle: bundle})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Trash, nil
+}
+
+func (c *agentClient) UndeleteEntry(ctx context.Context, bundle, entry string) (pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "undelete", Bundle: bundle, Entry: entry})
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+	return resp.Metadata, nil
+}
+
+func (c *agentClient) PurgeEntry(ctx context.Context, bundle, entry string) (pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "purge", Bundle: bundle, Entry: entry})
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+	return resp.Metadata, nil
+}
+
+func (c *agentClient) PutAttachment(ctx context.Context, bundle, entry, name string, data []byte) (pwManager.Attachment, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "attach", Bundle: bundle, Entry: entry, Name: name, Data: data})
+	if err != nil {
+		return pwManager.Attachment{}, err
+	}
+	return resp.Attachment, nil
+}
+
+func (c *agentClient) ReadAttachment(ctx context.Context, bundle, entry, attachment string) (pwManager.Attachment, []byte, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "download", Bundle: bundle, Entry: entry, Attachment: attachment})
+	if err != nil {
+		return pwManager.Attachment{}, nil, err
+	}
+	return resp.Attachment, resp.Data, nil
+}
+
+func (c *agentClient) ImportEntries(ctx context.Context, bundle string, entries []*pwManager.Entry) ([]pwManager.EntryMetadata, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "import", Bundle: bundle, Values: entries})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+func (c *agentClient) DeleteAttachment(ctx context.Context, bundle, entry, attachment string) (pwManager.Attachment, error) {
+	resp, err := c.call(ctx, agentRequest{Op: "detach", Bundle: bundle, Entry: entry, Attachment: attachment})
+	if err != nil {
+		return pwManager.Attachment{}, err
+	}
+	return resp.Attachment, nil
+}
+
+// store returns the agent when one is running, unlocking it first when it
+// is locked. Without an agent the session is unlocked in process.
+func (a *app) store(ctx context.Context) (store, error) {
+	s, err := a.loadSession()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &agentClient{socket: a.agentSocket()}
+	resp, err := c.call(ctx, agentRequest{Op: "status"})
+	if errors.Is(err, errNoAgent) {
+		return a.unlock(ctx, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Locked {
+		if err := a.unlockAgent(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// unlockAgent prompts for the password and secret key and unlocks the agent.
+func (a *app) unlockAgent(ctx context.Context, c *agentClient) error {
+	password, err := a.secret("PWMGR_PASSWORD", "Password")
+	if err != nil {
+		return err
+	}
+
+	secretKey, err := a.secret("PWMGR_SECRET_KEY", "Secret key")
+	if err != nil {
+		return err
+	}
+
+	_, err = c.call(ctx, agentRequest{Op: "unlock", Password: password, SecretKey: secretKey})
+	return err
+}
+
+func cmdAgent(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("agent")
+	idle := fs.Duration("idle-timeout", defaultIdleTimeout, "lock after no requests for the duration, 0 never locks")
+	sshAuth := fs.Bool("ssh", false, "serve the SSH key entries as an SSH agent")
+	sshConfirm := fs.Bool("ssh-confirm", false, "confirm every use of an SSH key with SSH_ASKPASS")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	socket := a.agentSocket()
+	l, err := listenAgent(socket)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fmt.Fprintf(a.stderr, "agent listening on %s\n", socket)
+	ag := &agent{a: a, idleTimeout: *idle}
+
+	if *sshAuth {
+		sshSocket := a.sshAgentSocket()
+		sl, err := listenAgent(sshSocket)
+		if err != nil {
+			l.Close()
+			return err
+		}
+
+		fmt.Fprintf(a.stderr, "ssh agent listening on %s\n", sshSocket)
+		fmt.Fprintf(a.stdout, "SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", sshSocket)
+
+		s := newSSHAgent(ctx, ag, *sshConfirm)
+		go func() {
+			if err := s.serve(ctx, sl); err != nil {
+				fmt.Fprintf(a.stderr, "ssh agent: %s\n", err)
+			}
+		}()
+	}
+
+	return ag.serve(ctx, l)
+}
+
+func cmdUnlock(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("unlock")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	if _, err := a.loadSession(); err != nil {
+		return err
+	}
+
+	return a.unlockAgent(ctx, &agentClient{socket: a.agentSocket()})
+}
+
+// lockAgent locks the agent if one is running.
+func (a *app) lockAgent(ctx context.Context) {
+	(&agentClient{socket: a.agentSocket()}).call(ctx, agentRequest{Op: "lock"})
+}
+
+func cmdLock(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("lock")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	_, err := (&agentClient{socket: a.agentSocket()}).call(ctx, agentRequest{Op: "lock"})
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/stretchr/testify/require"
+)
+
+// startAgent starts an agent for the app and returns it.
+func startAgent(t *testing.T, a *app, idleTimeout time.Duration) *agent {
+	l, err := listenAgent(a.agentSocket())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ag := &agent{a: a, idleTimeout: idleTimeout}
+	done := make(chan struct{})
+	go func() {
+		ag.serve(ctx, l)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ag
+}
+
+func (ag *agent) locked() bool {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	return ag.u == nil
+}
+
+func TestAgent(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, stdout := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+	require.NoError(t, a.run(ctx, []string{"login", "-addr", srv.URL}))
+
+	ag := startAgent(t, a, time.Hour)
+
+	info, err := os.Stat(a.agentSocket())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = listenAgent(a.agentSocket())
+	require.ErrorContains(t, err, "already listening")
+
+	t.Run("commands unlock the agent", func(t *testing.T) {
+		require.True(t, ag.locked())
+		require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "github", "password=hunter2"}))
+		require.False(t, ag.locked())
+	})
+
+	t.Run("unlocked agent doesn't prompt", func(t *testing.T) {
+		noPrompt, out := testApp(t, map[string]string{})
+		noPrompt.configDir = a.configDir
+
+		require.NoError(t, noPrompt.run(ctx, []string{"get", testBundleID, "github", "password"}))
+		require.Equal(t, "hunter2\n", out.String())
+
+		e, err := (&agentClient{socket: a.agentSocket()}).Entry(ctx, testBundleID, "github")
+		require.NoError(t, err)
+		require.False(t, e.Modified.IsZero())
+	})
+
+	t.Run("lock zeros keys", func(t *testing.T) {
+		ag.mu.Lock()
+		priKey := ag.u.priKey.(jwk.RSAPrivateKey)
+		ag.mu.Unlock()
+
+		require.NoError(t, a.run(ctx, []string{"lock"}))
+		require.True(t, ag.locked())
+
+		d, ok := priKey.D()
+		require.True(t, ok)
+		require.Equal(t, make([]byte, len(d)), d)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		wrong, _ := testApp(t, map[string]string{"Password": "wrong", "Secret key": testSecretKey})
+		wrong.configDir = a.configDir
+
+		require.ErrorContains(t, wrong.run(ctx, []string{"unlock"}), "unable to unlock")
+		require.True(t, ag.locked())
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		ag.mu.Lock()
+		ag.idleTimeout = 50 * time.Millisecond
+		ag.mu.Unlock()
+
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"unlock"}))
+		require.False(t, ag.locked())
+		require.Eventually(t, ag.locked, time.Second, 10*time.Millisecond)
+	})
+}
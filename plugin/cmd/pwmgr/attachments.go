@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// openEntry returns the bundle and decrypted entry with its latest metadata.
+func (u *unlocked) openEntry(ctx context.Context, bundleRef, entryRef string) (*bundle, *pwManager.Entry, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := b.entry(entryRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e, err := b.kv.Entry(ctx, m)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting entry: %w", err)
+	}
+	e.Metadata = m
+	return b, e, nil
+}
+
+// PutAttachment attaches the file to the entry.
+func (u *unlocked) PutAttachment(ctx context.Context, bundleRef, entryRef, name string, data []byte) (pwManager.Attachment, error) {
+	b, e, err := u.openEntry(ctx, bundleRef, entryRef)
+	if err != nil {
+		return pwManager.Attachment{}, err
+	}
+
+	a, err := b.kv.PutAttachment(ctx, u.s.Mount, e, b.metadata, name, bytes.NewReader(data))
+	if err != nil {
+		return pwManager.Attachment{}, fmt.Errorf("error attaching file: %w", err)
+	}
+	return a, nil
+}
+
+// ReadAttachment returns the decrypted attachment. Nothing is returned when
+// the attachment fails its integrity check.
+func (u *unlocked) ReadAttachment(ctx context.Context, bundleRef, entryRef, attachmentRef string) (pwManager.Attachment, []byte, error) {
+	b, e, err := u.openEntry(ctx, bundleRef, entryRef)
+	if err != nil {
+		return pwManager.Attachment{}, nil, err
+	}
+
+	a, err := e.Attachment(attachmentRef)
+	if err != nil {
+		return pwManager.Attachment{}, nil, err
+	}
+
+	var buf bytes.Buffer
+	if _, err := b.kv.ReadAttachment(ctx, e.Metadata, a.ID, &buf); err != nil {
+		return pwManager.Attachment{}, nil, fmt.Errorf("error reading attachment: %w", err)
+	}
+	return a, buf.Bytes(), nil
+}
+
+// DeleteAttachment removes the attachment from the entry.
+func (u *unlocked) DeleteAttachment(ctx context.Context, bundleRef, entryRef, attachmentRef string) (pwManager.Attachment, error) {
+	b, e, err := u.openEntry(ctx, bundleRef, entryRef)
+	if err != nil {
+		return pwManager.Attachment{}, err
+	}
+
+	a, err := e.Attachment(attachmentRef)
+	if err != nil {
+		return pwManager.Attachment{}, err
+	}
+
+	if err := b.kv.DeleteAttachment(ctx, u.s.Mount, e, b.metadata, a.ID); err != nil {
+		return pwManager.Attachment{}, fmt.Errorf("error deleting attachment: %w", err)
+	}
+	return a, nil
+}
+
+func cmdAttach(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("attach")
+	name := fs.String("name", "", "name of the attachment, defaults to the file name")
+	pos, err := parse(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(pos[2])
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		*name = filepath.Base(pos[2])
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	att, err := st.PutAttachment(ctx, pos[0], pos[1], *name, data)
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(att)
+	}
+	fmt.Fprintf(a.stdout, "attached %s (%s)\n", att.Name, att.ID)
+	return nil
+}
+
+func cmdAttachments(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("attachments")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	e, err := st.Entry(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+
+	attachments := e.Attachments
+	if attachments == nil {
+		attachments = []pwManager.Attachment{}
+	}
+
+	if a.json {
+		return a.printJSON(attachments)
+	}
+
+	rows := [][]string{}
+	for _, att := range attachments {
+		rows = append(rows, []string{att.Name, att.ID, strconv.FormatInt(att.Size, 10)})
+	}
+	return a.table([]string{"NAME", "ID", "SIZE"}, rows)
+}
+
+func cmdDownload(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("download")
+	out := fs.String("o", "", "write the attachment to the file instead of stdout")
+	pos, err := parse(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	_, data, err := st.ReadAttachment(ctx, pos[0], pos[1], pos[2])
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = a.stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*out, data, 0o600)
+}
+
+func cmdDetach(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("detach")
+	pos, err := parse(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	att, err := st.DeleteAttachment(ctx, pos[0], pos[1], pos[2])
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(att)
+	}
+	fmt.Fprintf(a.stdout, "detached %s (%s)\n", att.Name, att.ID)
+	return nil
+}
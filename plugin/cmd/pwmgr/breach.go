@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// walkEntries decrypts every entry of the bundles, or of all the users
+// bundles when refs is empty, and calls fn with it. Bundles that can't be
+// opened are reported and skipped.
+func (a *app) walkEntries(ctx context.Context, st store, refs []string, fn func(b *bundle, e *pwManager.Entry) error) error {
+	bundles, err := st.Bundles(ctx)
+	if err != nil {
+		return err
+	}
+
+	selected := bundles
+	if len(refs) > 0 {
+		selected = nil
+		for _, ref := range refs {
+			b, err := findBundle(bundles, ref)
+			if err != nil {
+				return err
+			}
+			selected = append(selected, b)
+		}
+	}
+
+	for _, b := range selected {
+		if b.Error != "" {
+			fmt.Fprintf(a.stderr, "skipping bundle %s: %s\n", b.ID, b.Error)
+			continue
+		}
+
+		// the bundle is resolved once, not per entry
+		entries, err := st.EntriesIn(ctx, b)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range entries {
+			e, err := st.EntryIn(ctx, b, m.ID)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", b.Name, m.Name, err)
+			}
+			if err := fn(b, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// breach is an entry with passwords found in the breach dataset.
+type breach struct {
+	BundleID   string                    `json:"bundle_id"`
+	BundleName string                    `json:"bundle_name"`
+	EntryID    string                    `json:"entry_id"`
+	EntryName  string                    `json:"entry_name"`
+	Fields     []pwManager.BreachedField `json:"fields"`
+}
+
+func cmdBreach(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("breach")
+	dataset := fs.String("dataset", a.getenv("PWMGR_BREACH_DATASET"), "directory of the HIBP SHA-1 range files")
+	pos, err := parse(fs, args, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	if *dataset == "" {
+		return fmt.Errorf("-dataset is required")
+	}
+
+	db, err := pwManager.OpenBreachDB(*dataset)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	breaches := []breach{}
+	err = a.walkEntries(ctx, st, pos, func(b *bundle, e *pwManager.Entry) error {
+		fields, err := db.CheckEntry(e)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 0 {
+			breaches = append(breaches, breach{
+				BundleID:   b.ID,
+				BundleName: b.Name,
+				EntryID:    e.Metadata.ID,
+				EntryName:  e.Name,
+				Fields:     fields,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(breaches)
+	}
+
+	if len(breaches) == 0 {
+		fmt.Fprintln(a.stdout, "no breached passwords found")
+		return nil
+	}
+
+	rows := [][]string{}
+	for _, br := range breaches {
+		for _, f := range br.Fields {
+			rows = append(rows, []string{br.BundleName, br.EntryName, br.EntryID, f.Label, strconv.Itoa(f.Count)})
+		}
+	}
+	return a.table([]string{"BUNDLE", "ENTRY", "ID", "FIELD", "COUNT"}, rows)
+}
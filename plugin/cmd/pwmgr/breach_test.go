@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreach(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, stdout := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+	require.NoError(t, a.run(ctx, []string{"login", "-addr", srv.URL}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "github", "username=octocat", "password=hunter2"}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "gitlab", "username=hunter2", "password=correct horse battery staple"}))
+
+	// SHA-1 of hunter2 is F3BBBD66A63D4BF1747940578EC3D0103530E21D and of
+	// "correct horse battery staple" ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42
+	dataset := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataset, "F3BBB.txt"), []byte("D66A63D4BF1747940578EC3D0103530E21D:24230\r\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dataset, "ABF7A.txt"), []byte("00000000000000000000000000000000000:1\r\n"), 0o600))
+
+	stdout.Reset()
+	fv := srv.Config.Handler.(*fakeVault)
+	fv.bundleLists = 0
+	require.NoError(t, a.run(ctx, []string{"breach", "-dataset", dataset, "-json"}))
+	// the bundles are listed once, not per entry
+	require.Equal(t, 1, fv.bundleLists)
+
+	var breaches []breach
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &breaches))
+	require.Len(t, breaches, 1)
+	require.Equal(t, "github", breaches[0].EntryName)
+	require.Equal(t, testBundleID, breaches[0].BundleID)
+	require.Len(t, breaches[0].Fields, 1)
+	require.Equal(t, "Password", breaches[0].Fields[0].Label)
+	require.Equal(t, 24230, breaches[0].Fields[0].Count)
+
+	// the dataset is never written to
+	files, err := os.ReadDir(dataset)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	require.ErrorContains(t, a.run(ctx, []string{"breach", "-dataset", filepath.Join(dataset, "missing")}), "no such file")
+	require.ErrorContains(t, a.run(ctx, []string{"breach", "-dataset", dataset, "unknown"}), `bundle "unknown" not found`)
+}
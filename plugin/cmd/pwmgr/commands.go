@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"github.com/gradientsearch/vault-plugin-secrets-pwmanager/generator"
+)
+
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// flags returns the flag set of the command with the -json flag.
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.BoolVar(&a.json, "json", false, "print json output")
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "usage: pwmgr %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags anywhere in args and returns the positional
+// arguments. The standard flag package stops at the first positional
+// argument which makes `pwmgr get personal github -json` surprising.
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) < min || (max >= 0 && len(positional) > max) {
+		fs.Usage()
+		return nil, fmt.Errorf("%s: wrong number of arguments", fs.Name())
+	}
+	return positional, nil
+}
+
+// parseFields parses `label=value` arguments.
+func parseFields(args []string) ([][2]string, error) {
+	var fields [][2]string
+	for _, arg := range args {
+		label, value, ok := strings.Cut(arg, "=")
+		if !ok || label == "" {
+			return nil, fmt.Errorf("invalid field %q, expected label=value", arg)
+		}
+		fields = append(fields, [2]string{label, value})
+	}
+	return fields, nil
+}
+
+// readFileFields parses `label=path` arguments and reads the values from
+// the files so secrets like private keys aren't passed as arguments.
+func readFileFields(args []string) ([][2]string, error) {
+	fields, err := parseFields(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range fields {
+		data, err := os.ReadFile(f[1])
+		if err != nil {
+			return nil, err
+		}
+		fields[i][1] = string(data)
+	}
+	return fields, nil
+}
+
+func cmdLogin(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("login")
+	addr := fs.String("addr", a.getenv("VAULT_ADDR"), "Vault address")
+	mount := fs.String("mount", "pwmanager", "pwmanager mount")
+	method := fs.String("method", "token", "auth method, token or userpass")
+	path := fs.String("path", "", "auth method mount, defaults to the method")
+	username := fs.String("username", "", "userpass username")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	if *addr == "" {
+		*addr = "http://127.0.0.1:8200"
+	}
+	if *path == "" {
+		*path = *method
+	}
+
+	s := &session{Addr: *addr, Mount: *mount}
+	c, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	switch *method {
+	case "token":
+		s.Token, err = a.secret("VAULT_TOKEN", "Token")
+		if err != nil {
+			return err
+		}
+	case "userpass":
+		if *username == "" {
+			return fmt.Errorf("-username is required for userpass")
+		}
+		password, err := a.secret("PWMGR_LOGIN_PASSWORD", "Login password")
+		if err != nil {
+			return err
+		}
+		resp, err := c.Userpass().Login(ctx, *path, *username, pwManager.UserInfo{Password: password})
+		if err != nil {
+			return fmt.Errorf("error logging in: %w", err)
+		}
+		s.Token = resp.Auth.ClientToken
+	default:
+		return fmt.Errorf("unsupported auth method %q", *method)
+	}
+	c.SetToken(s.Token)
+
+	info, err := c.Token().LookupSelf(ctx)
+	if err != nil {
+		return fmt.Errorf("error looking up token: %w", err)
+	}
+	if info.EntityID == "" {
+		return fmt.Errorf("token is not associated with an entity")
+	}
+	s.EntityID = info.EntityID
+
+	entity, err := c.Identity().EntityByID(ctx, s.EntityID)
+	if err != nil {
+		return fmt.Errorf("error reading entity: %w", err)
+	}
+	s.EntityName = entity.Name
+
+	if err := a.saveSession(s); err != nil {
+		return fmt.Errorf("error saving session: %w", err)
+	}
+
+	// a running agent is unlocked for the previous session
+	a.lockAgent(ctx)
+
+	if a.json {
+		return a.printJSON(map[string]string{
+			"addr":        s.Addr,
+			"mount":       s.Mount,
+			"entity_id":   s.EntityID,
+			"entity_name": s.EntityName,
+		})
+	}
+	fmt.Fprintf(a.stdout, "logged in as %s (%s)\n", s.EntityName, s.EntityID)
+	return nil
+}
+
+func cmdLogout(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("logout")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	a.lockAgent(ctx)
+	return a.removeSession()
+}
+
+func cmdBundles(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("bundles")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	bundles, err := st.Bundles(ctx)
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		if bundles == nil {
+			bundles = []*bundle{}
+		}
+		return a.printJSON(bundles)
+	}
+
+	rows := [][]string{}
+	for _, b := range bundles {
+		name := b.Name
+		if b.Error != "" {
+			name = "<" + b.Error + ">"
+		}
+		rows = append(rows, []string{b.ID, name, b.Owner, strconv.FormatBool(b.Shared), b.Capabilities})
+	}
+	return a.table([]string{"ID", "NAME", "OWNER", "SHARED", "CAPABILITIES"}, rows)
+}
+
+func cmdEntries(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("entries")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries, err := st.Entries(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(entries)
+	}
+
+	rows := [][]string{}
+	for _, m := range entries {
+		rows = append(rows, []string{m.ID, m.Name, m.Type, m.Value})
+	}
+	return a.table([]string{"ID", "NAME", "TYPE", "VALUE"}, rows)
+}
+
+func cmdGet(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("get")
+	pos, err := parse(fs, args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	e, err := st.Entry(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+
+	if len(pos) == 3 {
+		value, ok := e.Field(pos[2])
+		if !ok {
+			return fmt.Errorf("entry %q has no field %q", e.Name, pos[2])
+		}
+		if a.json {
+			return a.printJSON(map[string]string{"label": pos[2], "value": value})
+		}
+		fmt.Fprintln(a.stdout, value)
+		return nil
+	}
+
+	if a.json {
+		return a.printJSON(e)
+	}
+
+	rows := [][]string{}
+	for _, i := range append(e.Core.Items, e.More.Items...) {
+		rows = append(rows, []string{i.Label, i.Value})
+	}
+	fmt.Fprintf(a.stdout, "%s (%s)\n", e.Name, e.Metadata.ID)
+	return a.table([]string{"FIELD", "VALUE"}, rows)
+}
+
+func cmdCreate(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("create")
+	name := fs.String("name", "", "entry name")
+	typ := fs.String("type", "password", "entry type, password or ssh")
+	var tags, files stringsFlag
+	fs.Var(&tags, "tag", "entry tag, may be repeated")
+	fs.Var(&files, "file", "label=path of a field read from a file, may be repeated")
+	otp := fs.String("otp", "", "otpauth:// URI of the one-time password")
+	generate := fs.Bool("generate", false, "generate the password, see `pwmgr generate` for the options")
+	options := generatorFlags(fs)
+	pos, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return fmt.Errorf("-name is required")
+	}
+
+	var e *pwManager.Entry
+	switch *typ {
+	case "password":
+		e = pwManager.NewPasswordEntry()
+	case "ssh":
+		e = pwManager.NewSSHKeyEntry()
+	default:
+		return fmt.Errorf("unsupported entry type %q", *typ)
+	}
+
+	fields, err := parseFields(pos[1:])
+	if err != nil {
+		return err
+	}
+
+	fileFields, err := readFileFields(files)
+	if err != nil {
+		return err
+	}
+	fields = append(fields, fileFields...)
+
+	if *generate {
+		opts, name := options()
+		rules, err := a.generatorRules(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		p, err := generator.Generate(opts, rules)
+		if err != nil {
+			return err
+		}
+		fields = append([][2]string{{"password", p.Value}}, fields...)
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.Name = *name
+	e.Tags = append(e.Tags, tags...)
+	for _, f := range fields {
+		e.SetField(f[0], f[1])
+	}
+	if *otp != "" {
+		if err := setOTP(e, *otp); err != nil {
+			return err
+		}
+	}
+
+	m, err := st.PutEntry(ctx, pos[0], e)
+	if err != nil {
+		return err
+	}
+	return a.printEntryMetadata(m, "created")
+}
+
+func cmdEdit(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("edit")
+	name := fs.String("name", "", "new entry name")
+	var remove, files stringsFlag
+	fs.Var(&remove, "remove", "label of a field to remove, may be repeated")
+	fs.Var(&files, "file", "label=path of a field read from a file, may be repeated")
+	otp := fs.String("otp", "", "otpauth:// URI of the one-time password")
+	pos, err := parse(fs, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	fields, err := parseFields(pos[2:])
+	if err != nil {
+		return err
+	}
+
+	fileFields, err := readFileFields(files)
+	if err != nil {
+		return err
+	}
+	fields = append(fields, fileFields...)
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	e, err := st.Entry(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+
+	if *name != "" {
+		e.Name = *name
+	}
+	for _, label := range remove {
+		e.RemoveField(label)
+	}
+	for _, f := range fields {
+		e.SetField(f[0], f[1])
+	}
+	if *otp != "" {
+		if err := setOTP(e, *otp); err != nil {
+			return err
+		}
+	}
+
+	m, err := st.PutEntry(ctx, pos[0], e)
+	if err != nil {
+		return err
+	}
+	return a.printEntryMetadata(m, "updated")
+}
+
+// setOTP validates the otpauth:// URI and sets it on the entry.
+func setOTP(e *pwManager.Entry, uri string) error {
+	if _, err := pwManager.ParseOTPAuthURI(uri); err != nil {
+		return err
+	}
+	e.SetOTP(uri)
+	return nil
+}
+
+func cmdOTP(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("otp")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	e, err := st.Entry(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+
+	o, _, _ := e.OTP()
+	code, expires, err := e.NextOTP(time.Now())
+	if err != nil {
+		return err
+	}
+
+	// the next HOTP code must be used next time
+	if o.Type == "hotp" {
+		if _, err := st.PutEntry(ctx, pos[0], e); err != nil {
+			return fmt.Errorf("error saving the HOTP counter: %w", err)
+		}
+	}
+
+	if a.json {
+		out := map[string]interface{}{"code": code, "type": o.Type}
+		if !expires.IsZero() {
+			out["expires_at"] = expires.UTC().Format(time.RFC3339)
+		}
+		return a.printJSON(out)
+	}
+	fmt.Fprintln(a.stdout, code)
+	return nil
+}
+
+func cmdDelete(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("delete")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	m, err := st.DeleteEntry(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	return a.printEntryMetadata(m, "deleted")
+}
+
+// printEntryMetadata prints the metadata of an entry changed by action.
+func (a *app) printEntryMetadata(m pwManager.EntryMetadata, action string) error {
+	if a.json {
+		return a.printJSON(m)
+	}
+	fmt.Fprintf(a.stdout, "%s entry %s (%s)\n", action, m.Name, m.ID)
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// refScheme prefixes values that reference a field of a bundle entry,
+// pwmgr://<bundle>/<entry>/<field>. Each part is the id or the name and may
+// be percent encoded, e.g. pwmgr://personal/my%20bank/pin.
+const refScheme = "pwmgr://"
+
+// ref is a parsed pwmgr:// reference.
+type ref struct {
+	Bundle string
+	Entry  string
+	Field  string
+}
+
+func parseRef(s string) (ref, error) {
+	parts := strings.Split(strings.TrimPrefix(s, refScheme), "/")
+	if !strings.HasPrefix(s, refScheme) || len(parts) != 3 {
+		return ref{}, fmt.Errorf("invalid reference %q, expected %s<bundle>/<entry>/<field>", s, refScheme)
+	}
+
+	for i, p := range parts {
+		v, err := url.PathUnescape(p)
+		if err != nil || v == "" {
+			return ref{}, fmt.Errorf("invalid reference %q, expected %s<bundle>/<entry>/<field>", s, refScheme)
+		}
+		parts[i] = v
+	}
+	return ref{Bundle: parts[0], Entry: parts[1], Field: parts[2]}, nil
+}
+
+// envVar is a NAME=value pair of the mapping.
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// parseEnvFile parses the lines of a mapping file. Lines are NAME=value,
+// blank lines and lines starting with # are ignored and values may be quoted.
+func parseEnvFile(data string) ([]envVar, error) {
+	var vars []envVar
+	s := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		v, err := parseEnvVar(strings.TrimPrefix(line, "export "))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		vars = append(vars, v)
+	}
+	return vars, s.Err()
+}
+
+func parseEnvVar(s string) (envVar, error) {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return envVar{}, fmt.Errorf("invalid variable %q, expected NAME=value", s)
+	}
+
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return envVar{Name: name, Value: value}, nil
+}
+
+// resolve replaces the pwmgr:// references in vars with the field values.
+// Each entry is only decrypted once.
+func resolve(ctx context.Context, st store, vars []envVar) ([]envVar, error) {
+	entries := map[[2]string]*pwManager.Entry{}
+
+	resolved := make([]envVar, 0, len(vars))
+	for _, v := range vars {
+		if !strings.HasPrefix(v.Value, refScheme) {
+			resolved = append(resolved, v)
+			continue
+		}
+
+		r, err := parseRef(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Name, err)
+		}
+
+		key := [2]string{r.Bundle, r.Entry}
+		e, ok := entries[key]
+		if !ok {
+			e, err = st.Entry(ctx, r.Bundle, r.Entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", v.Name, err)
+			}
+			entries[key] = e
+		}
+
+		value, ok := e.Field(r.Field)
+		if !ok {
+			return nil, fmt.Errorf("%s: entry %q has no field %q", v.Name, e.Name, r.Field)
+		}
+		resolved = append(resolved, envVar{Name: v.Name, Value: value})
+	}
+	return resolved, nil
+}
+
+// childEnv returns the environment of the child. The variables unlocking
+// pwmgr are not passed on so the child can't read other entries.
+func childEnv(environ []string, vars []envVar) []string {
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == "PWMGR_PASSWORD" || name == "PWMGR_SECRET_KEY" {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	for _, v := range vars {
+		env = append(env, v.Name+"="+v.Value)
+	}
+	return env
+}
+
+// exitError exits pwmgr with the exit code of the child.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func cmdExec(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("exec")
+	envFile := fs.String("env-file", "", "file of NAME=value lines, values may be pwmgr:// references")
+	var set stringsFlag
+	fs.Var(&set, "e", "NAME=value variable, may be repeated")
+	noInherit := fs.Bool("no-inherit", false, "don't pass the pwmgr environment to the child")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	argv := fs.Args()
+	if len(argv) == 0 {
+		fs.Usage()
+		return fmt.Errorf("exec: a command is required")
+	}
+
+	var vars []envVar
+	if *envFile != "" {
+		data, err := os.ReadFile(*envFile)
+		if err != nil {
+			return err
+		}
+		vars, err = parseEnvFile(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", *envFile, err)
+		}
+	}
+	for _, s := range set {
+		v, err := parseEnvVar(s)
+		if err != nil {
+			return err
+		}
+		vars = append(vars, v)
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	vars, err = resolve(ctx, st, vars)
+	// the keys aren't needed while the child runs
+	if u, ok := st.(*unlocked); ok {
+		u.lock()
+	}
+	if err != nil {
+		return err
+	}
+
+	var environ []string
+	if !*noInherit {
+		environ = a.environ()
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = childEnv(environ, vars)
+	cmd.Stdin = a.stdin
+	cmd.Stdout = a.stdout
+	cmd.Stderr = a.stderr
+	// pass the signal cancelling ctx on to the child instead of killing it
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = 10 * time.Second
+
+	err = cmd.Run()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() >= 0 {
+		return &exitError{code: ee.ExitCode()}
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRef(t *testing.T) {
+	r, err := parseRef("pwmgr://personal/my%20bank/pin")
+	require.NoError(t, err)
+	require.Equal(t, ref{Bundle: "personal", Entry: "my bank", Field: "pin"}, r)
+
+	for _, s := range []string{
+		"pwmgr://personal/github",
+		"pwmgr://personal/github/password/extra",
+		"pwmgr://personal//password",
+		"pwmgr://personal/%zz/password",
+		"personal/github/password",
+	} {
+		_, err := parseRef(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	vars, err := parseEnvFile(`
+# github
+GITHUB_TOKEN=pwmgr://personal/github/password
+export USER_NAME = "pwmgr://personal/github/username"
+REGION='us-east-1'
+`)
+	require.NoError(t, err)
+	require.Equal(t, []envVar{
+		{"GITHUB_TOKEN", "pwmgr://personal/github/password"},
+		{"USER_NAME", "pwmgr://personal/github/username"},
+		{"REGION", "us-east-1"},
+	}, vars)
+
+	_, err = parseEnvFile("A=b\nnot a variable\n")
+	require.ErrorContains(t, err, "line 2")
+}
+
+func TestExec(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, stdout := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+	require.NoError(t, a.run(ctx, []string{"login", "-addr", srv.URL}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "github", "username=octocat", "password=hunter2"}))
+
+	a.environ = func() []string {
+		return []string{"HOME=/home/bob", "PWMGR_PASSWORD=" + testPassword, "PWMGR_SECRET_KEY=" + testSecretKey}
+	}
+
+	envFile := filepath.Join(t.TempDir(), "env")
+	require.NoError(t, os.WriteFile(envFile, []byte("GITHUB_TOKEN=pwmgr://"+testBundleID+"/GitHub/password\nREGION=us-east-1\n"), 0o600))
+
+	t.Run("injects references", func(t *testing.T) {
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{
+			"exec", "-env-file", envFile, "-e", "GITHUB_USER=pwmgr://" + testBundleID + "/github/username",
+			"--", "sh", "-c", `echo "$GITHUB_USER:$GITHUB_TOKEN:$REGION:$HOME:$PWMGR_PASSWORD$PWMGR_SECRET_KEY"`,
+		}))
+		require.Equal(t, "octocat:hunter2:us-east-1:/home/bob:\n", stdout.String())
+	})
+
+	t.Run("no inherit", func(t *testing.T) {
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"exec", "-no-inherit", "-env-file", envFile, "--", "sh", "-c", `echo "$GITHUB_TOKEN:$HOME"`}))
+		require.Equal(t, "hunter2:\n", stdout.String())
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		err := a.run(ctx, []string{"exec", "--", "sh", "-c", "exit 3"})
+		require.Equal(t, &exitError{code: 3}, err)
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		err := a.run(ctx, []string{"exec", "-e", "PIN=pwmgr://" + testBundleID + "/github/pin", "--", "true"})
+		require.ErrorContains(t, err, `PIN: entry "github" has no field "pin"`)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"github.com/gradientsearch/vault-plugin-secrets-pwmanager/exporter"
+)
+
+// exportBundles decrypts the entries of the bundles with their attachments
+// and, with history, their earlier versions that can still be read.
+func (a *app) exportBundles(ctx context.Context, st store, refs []string, history bool) ([]exporter.Bundle, error) {
+	var bundles []exporter.Bundle
+	err := a.walkEntries(ctx, st, refs, func(b *bundle, e *pwManager.Entry) error {
+		if len(bundles) == 0 || bundles[len(bundles)-1].ID != b.ID {
+			bundles = append(bundles, exporter.Bundle{ID: b.ID, Name: b.Name, Entries: []exporter.Entry{}})
+		}
+
+		entry := exporter.Entry{Entry: e, Modified: e.Modified}
+		for _, att := range e.Attachments {
+			_, data, err := st.ReadAttachment(ctx, b.ID, e.Metadata.ID, att.ID)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", b.Name, e.Name, err)
+			}
+			entry.Attachments = append(entry.Attachments, exporter.Attachment{Attachment: att, Data: data})
+		}
+
+		if history {
+			versions, err := st.EntryVersions(ctx, b.ID, e.Metadata.ID)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", b.Name, e.Name, err)
+			}
+			for _, v := range versions {
+				if v.Current || v.Deleted {
+					continue
+				}
+				old, err := st.EntryVersion(ctx, b.ID, e.Metadata.ID, v.Version)
+				if err != nil {
+					return fmt.Errorf("%s/%s version %d: %w", b.Name, e.Name, v.Version, err)
+				}
+				entry.History = append(entry.History, exporter.Version{Version: v.Version, Modified: v.Created, Entry: old})
+			}
+			slices.SortFunc(entry.History, func(x, y exporter.Version) int { return x.Version - y.Version })
+		}
+
+		bundles[len(bundles)-1].Entries = append(bundles[len(bundles)-1].Entries, entry)
+		return nil
+	})
+	return bundles, err
+}
+
+// exportPassword returns the password of a KDBX export. A prompted password
+// is confirmed.
+func (a *app) exportPassword() (string, error) {
+	if v := a.getenv("PWMGR_EXPORT_PASSWORD"); v != "" {
+		return v, nil
+	}
+
+	password, err := a.prompt("Export password")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := a.prompt("Confirm export password")
+	if err != nil {
+		return "", err
+	}
+	if password != confirm {
+		return "", fmt.Errorf("export passwords don't match")
+	}
+	return password, nil
+}
+
+func cmdExport(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("export")
+	format := fs.String("format", "", "format of the export: kdbx, age or openpgp")
+	history := fs.Bool("history", false, "include the earlier versions of the entries")
+	out := fs.String("o", "", "write the export to the file instead of stdout")
+	var recipients stringsFlag
+	fs.Var(&recipients, "r", "age recipient or recipients file, or OpenPGP public key file, can be repeated")
+	pos, err := parse(fs, args, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(exporter.Formats, exporter.Format(*format)) {
+		return fmt.Errorf("unknown -format %q", *format)
+	}
+
+	// the recipients and password are checked before anything is decrypted
+	var encrypt func(w io.Writer) (io.WriteCloser, error)
+	var password string
+	switch exporter.Format(*format) {
+	case exporter.KDBX:
+		if len(recipients) > 0 {
+			return fmt.Errorf("-r can't be used with -format kdbx")
+		}
+		if password, err = a.exportPassword(); err != nil {
+			return err
+		}
+		if password == "" {
+			return fmt.Errorf("export password is required")
+		}
+	case exporter.Age:
+		keys, err := exporter.ParseAgeRecipients(recipients)
+		if err != nil {
+			return err
+		}
+		encrypt = func(w io.Writer) (io.WriteCloser, error) { return exporter.EncryptAge(w, keys) }
+	case exporter.OpenPGP:
+		keys, err := exporter.ReadOpenPGPRecipients(recipients)
+		if err != nil {
+			return err
+		}
+		encrypt = func(w io.Writer) (io.WriteCloser, error) { return exporter.EncryptOpenPGP(w, keys) }
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	bundles, err := a.exportBundles(ctx, st, pos, *history)
+	if err != nil {
+		return err
+	}
+
+	w := a.stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if encrypt == nil {
+		err = exporter.WriteKDBX(w, password, bundles)
+	} else {
+		var enc io.WriteCloser
+		if enc, err = encrypt(w); err == nil {
+			err = exporter.WriteArchive(enc, bundles)
+			if cerr := enc.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error writing export: %w", err)
+	}
+
+	if *out != "" {
+		n := 0
+		for _, b := range bundles {
+			n += len(b.Entries)
+		}
+		fmt.Fprintf(a.stderr, "exported %d entries of %d bundles to %s\n", n, len(bundles), *out)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"github.com/gradientsearch/vault-plugin-secrets-pwmanager/generator"
+)
+
+// generatorRules returns the generation rules defined on the mount. Without
+// a session passwords are generated without rules.
+func (a *app) generatorRules(ctx context.Context, name string) (generator.Rules, error) {
+	s, err := a.loadSession()
+	if err != nil {
+		if name != pwManager.DefaultGeneratorRules {
+			return generator.Rules{}, err
+		}
+		return generator.Rules{}, nil
+	}
+
+	c, err := s.client()
+	if err != nil {
+		return generator.Rules{}, err
+	}
+
+	rules, err := c.Generator().Rules(ctx, s.Mount, name)
+	if err != nil {
+		return generator.Rules{}, fmt.Errorf("error reading generator rules: %w", err)
+	}
+	return rules, nil
+}
+
+// generatorFlags adds the flags configuring generated passwords.
+func generatorFlags(fs *flag.FlagSet) func() (generator.Options, string) {
+	kind := fs.String("kind", string(generator.Random), "random, pronounceable or passphrase")
+	length := fs.Int("length", 0, "characters of random and pronounceable passwords")
+	words := fs.Int("words", 0, "words of passphrases")
+	separator := fs.String("separator", "", "separator of passphrase words")
+	classes := fs.String("classes", "", "comma separated character classes of random passwords, lower, upper, digits and symbols")
+	exclude := fs.String("exclude", "", "characters to exclude")
+	rules := fs.String("rules", pwManager.DefaultGeneratorRules, "name of the generation rules")
+
+	return func() (generator.Options, string) {
+		opts := generator.Options{
+			Kind:      generator.Kind(*kind),
+			Length:    *length,
+			Words:     *words,
+			Separator: *separator,
+			Exclude:   *exclude,
+		}
+		for _, c := range strings.Split(*classes, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				opts.Classes = append(opts.Classes, generator.Class(c))
+			}
+		}
+		return opts, *rules
+	}
+}
+
+func cmdGenerate(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("generate")
+	options := generatorFlags(fs)
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	opts, name := options()
+	rules, err := a.generatorRules(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	p, err := generator.Generate(opts, rules)
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(p)
+	}
+	fmt.Fprintln(a.stdout, p.Value)
+	return nil
+}
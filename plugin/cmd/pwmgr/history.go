@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// authorNames returns the entity names of the authors keyed by entity id.
+// Authors the user can't read are shown by their entity id.
+func (a *app) authorNames(ctx context.Context, versions []pwManager.EntryVersion) map[string]string {
+	names := map[string]string{}
+	for _, v := range versions {
+		if v.ModifiedBy != "" {
+			names[v.ModifiedBy] = v.ModifiedBy
+		}
+	}
+
+	s, err := a.loadSession()
+	if err != nil {
+		return names
+	}
+	c, err := s.client()
+	if err != nil {
+		return names
+	}
+
+	for id := range names {
+		e, err := c.Identity().EntityByID(ctx, id)
+		if err == nil && e.Name != "" {
+			names[id] = e.Name
+		}
+	}
+	return names
+}
+
+// parseVersion parses an entry version argument.
+func parseVersion(s string) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid version %q", s)
+	}
+	return v, nil
+}
+
+func cmdHistory(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("history")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	versions, err := st.EntryVersions(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(versions)
+	}
+
+	names := a.authorNames(ctx, versions)
+	rows := [][]string{}
+	for _, v := range versions {
+		status := ""
+		switch {
+		case v.Current:
+			status = "current"
+		case v.Deleted:
+			status = "deleted"
+		}
+		rows = append(rows, []string{strconv.Itoa(v.Version), v.Created.Local().Format(time.DateTime), names[v.ModifiedBy], status})
+	}
+	return a.table([]string{"VERSION", "CREATED", "AUTHOR", "STATUS"}, rows)
+}
+
+// secretInput reports whether values of the input type are hidden unless
+// revealed.
+func secretInput(typ string) bool {
+	return typ == "password" || typ == pwManager.OTPInputType
+}
+
+func cmdDiff(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("diff")
+	reveal := fs.Bool("reveal", false, "show the values of password fields")
+	pos, err := parse(fs, args, 3, 4)
+	if err != nil {
+		return err
+	}
+
+	from, err := parseVersion(pos[2])
+	if err != nil {
+		return err
+	}
+	to := 0
+	if len(pos) == 4 {
+		if to, err = parseVersion(pos[3]); err != nil {
+			return err
+		}
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	old, err := st.EntryVersion(ctx, pos[0], pos[1], from)
+	if err != nil {
+		return err
+	}
+	new, err := st.EntryVersion(ctx, pos[0], pos[1], to)
+	if err != nil {
+		return err
+	}
+
+	diffs := pwManager.DiffEntries(old, new)
+	if !*reveal {
+		for i := range diffs {
+			if secretInput(diffs[i].Type) {
+				if diffs[i].Old != "" {
+					diffs[i].Old = "********"
+				}
+				if diffs[i].New != "" {
+					diffs[i].New = "********"
+				}
+			}
+		}
+	}
+
+	if a.json {
+		return a.printJSON(diffs)
+	}
+
+	if len(diffs) == 0 {
+		fmt.Fprintln(a.stdout, "no changes")
+		return nil
+	}
+
+	rows := [][]string{}
+	for _, d := range diffs {
+		rows = append(rows, []string{d.Label, string(d.Change), d.Old, d.New})
+	}
+	return a.table([]string{"FIELD", "CHANGE", "OLD", "NEW"}, rows)
+}
+
+func cmdRestore(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("restore")
+	pos, err := parse(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	version, err := parseVersion(pos[2])
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	m, err := st.RestoreEntry(ctx, pos[0], pos[1], version)
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(m)
+	}
+	fmt.Fprintf(a.stdout, "restored version %d of %s (%s)\n", version, m.Name, m.ID)
+	return nil
+}
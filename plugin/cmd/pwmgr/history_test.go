@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, stdout := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+	require.NoError(t, a.run(ctx, []string{"login", "-addr", srv.URL}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "github", "username=octocat", "password=hunter2"}))
+	require.NoError(t, a.run(ctx, []string{"edit", testBundleID, "github", "password=hunter3", "url=https://github.com"}))
+
+	t.Run("history", func(t *testing.T) {
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"history", testBundleID, "github", "-json"}))
+
+		var versions []pwManager.EntryVersion
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &versions))
+		require.Len(t, versions, 2)
+		require.Equal(t, testEntityID, versions[0].ModifiedBy)
+		require.True(t, versions[1].Current)
+
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"history", testBundleID, "github"}))
+		require.Contains(t, stdout.String(), "bob")
+		require.Contains(t, stdout.String(), "current")
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"diff", testBundleID, "github", "1", "-json"}))
+
+		var diffs []pwManager.FieldDiff
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &diffs))
+		require.Equal(t, []pwManager.FieldDiff{
+			{Label: "Password", Type: "password", Change: pwManager.FieldChanged, Old: "********", New: "********"},
+			{Label: "url", Type: "text", Change: pwManager.FieldAdded, New: "https://github.com"},
+		}, diffs)
+
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"diff", testBundleID, "github", "1", "2", "-reveal"}))
+		require.Contains(t, stdout.String(), "hunter2")
+		require.Contains(t, stdout.String(), "hunter3")
+
+		require.ErrorContains(t, a.run(ctx, []string{"diff", testBundleID, "github", "0"}), "invalid version")
+		require.Error(t, a.run(ctx, []string{"diff", testBundleID, "github", "9"}))
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		require.NoError(t, a.run(ctx, []string{"restore", testBundleID, "github", "1"}))
+
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"get", testBundleID, "github", "password"}))
+		require.Equal(t, "hunter2\n", stdout.String())
+
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"history", testBundleID, "github", "-json"}))
+		var versions []pwManager.EntryVersion
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &versions))
+		require.Len(t, versions, 3)
+
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"diff", testBundleID, "github", "1", "-json"}))
+		require.JSONEq(t, "[]", stdout.String())
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"github.com/gradientsearch/vault-plugin-secrets-pwmanager/importer"
+)
+
+// ImportEntries adds the entries to the bundle with a single metadata update.
+func (u *unlocked) ImportEntries(ctx context.Context, ref string, entries []*pwManager.Entry) ([]pwManager.EntryMetadata, error) {
+	b, err := u.bundle(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.kv.ImportEntries(ctx, entries, b.metadata); err != nil {
+		return nil, fmt.Errorf("error importing entries: %w", err)
+	}
+
+	imported := []pwManager.EntryMetadata{}
+	for _, e := range entries {
+		imported = append(imported, e.Metadata)
+	}
+	return imported, nil
+}
+
+func cmdImport(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("import")
+	format := fs.String("format", "", "format of the export: bitwarden, 1pux, 1password-csv, keepass or csv")
+	dryRun := fs.Bool("dry-run", false, "print the report without importing the entries")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(importer.Formats, importer.Format(*format)) {
+		return fmt.Errorf("unknown -format %q", *format)
+	}
+
+	f, err := os.Open(pos[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := importer.Parse(importer.Format(*format), f)
+	if err != nil {
+		return err
+	}
+
+	if !*dryRun && len(res.Entries) > 0 {
+		st, err := a.store(ctx)
+		if err != nil {
+			return err
+		}
+		if u, ok := st.(*unlocked); ok {
+			defer u.lock()
+		}
+
+		if _, err := st.ImportEntries(ctx, pos[0], res.Entries); err != nil {
+			return err
+		}
+	}
+
+	if a.json {
+		return a.printJSON(res.Report)
+	}
+
+	action := "imported"
+	if *dryRun {
+		action = "would import"
+	}
+	fmt.Fprintf(a.stdout, "%s %d entries into %s\n", action, res.Report.Imported, pos[0])
+
+	rows := [][]string{}
+	for _, issues := range []struct {
+		status string
+		issues []importer.Issue
+	}{{"skipped", res.Report.Skipped}, {"ambiguous", res.Report.Ambiguous}} {
+		for _, i := range issues.issues {
+			rows = append(rows, []string{issues.status, orDash(i.Record), orDash(i.Field), i.Reason})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(a.stdout)
+	return a.table([]string{"STATUS", "RECORD", "FIELD", "REASON"}, rows)
+}
+
+// orDash returns s or "-" when s is empty so table columns stay aligned.
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,250 @@
+// Command pwmgr is a terminal client for the pwmanager secrets engine. It
+// logs in to Vault, unlocks the users UUK with their password and secret key
+// and reads and writes the encrypted bundle entries.
+//
+//	pwmgr login -addr https://vault:8200 -method userpass -username bob
+//	pwmgr bundles
+//	pwmgr entries personal
+//	pwmgr get personal github password
+//	pwmgr create personal -name github username=octocat password=hunter2
+//	pwmgr edit personal github password=hunter3
+//	pwmgr delete personal github
+//	pwmgr edit personal github -otp 'otpauth://totp/GitHub:octocat?secret=...'
+//	pwmgr otp personal github
+//	pwmgr generate -kind passphrase -words 6
+//	pwmgr create personal -name github -generate username=octocat
+//
+// Every edit of an entry is kept as a kv-v2 version. `pwmgr history` lists
+// the versions with their authors, `pwmgr diff` compares two versions field
+// by field, against the current version by default, and `pwmgr restore`
+// saves an older version as the new version.
+//
+//	pwmgr history personal github
+//	pwmgr diff personal github 2
+//	pwmgr restore personal github 2
+//
+// `pwmgr delete` moves an entry to the trash of its bundle. `pwmgr trash`
+// lists the deleted entries, `pwmgr undelete` restores one and `pwmgr purge`
+// destroys it. Entries left in the trash are destroyed by the plugin once
+// the trash retention of the mount has passed.
+//
+//	pwmgr trash personal
+//	pwmgr undelete personal github
+//
+// Files are attached to entries with `pwmgr attach`. They are encrypted with
+// the bundle key in chunks and uploaded through the plugin, which enforces
+// the attachment quota of the bundle. `pwmgr download` verifies every chunk
+// against the attachment manifest before the file is written.
+//
+//	pwmgr attach personal github recovery-codes.pdf
+//	pwmgr download personal github recovery-codes.pdf -o codes.pdf
+//
+// `pwmgr import` reads a Bitwarden JSON, 1Password 1PUX or CSV, KeePass XML
+// or generic CSV export and adds its records to a bundle as new entries. The
+// skipped records and fields and the fields that should be checked by hand
+// are reported, -dry-run only prints the report.
+//
+//	pwmgr import -format bitwarden -dry-run personal bitwarden_export.json
+//
+// `pwmgr export` writes the bundles, all of them by default, to a KeePass
+// KDBX 4 database protected by a password read from PWMGR_EXPORT_PASSWORD
+// or prompted for, or to a JSON archive encrypted to age or OpenPGP
+// recipients given with -r. -history also exports the earlier versions of
+// the entries. Entries are only decrypted in memory, the export is never
+// written as plaintext.
+//
+//	pwmgr export -format kdbx -history -o personal.kdbx personal
+//	pwmgr export -format age -r age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p > backup.age
+//	pwmgr export -format openpgp -r jane.asc personal > personal.json.gpg
+//
+// Generated passwords meet the generation rules defined on the mount,
+// -rules chooses the rules and defaults to the "default" rules.
+//
+// `pwmgr breach` reports the passwords found in a local copy of the Have I
+// Been Pwned range dataset. Only SHA-1 hashes are computed in memory and
+// nothing is sent to the network.
+//
+//	pwmgr breach -dataset ~/pwnedpasswords personal
+//
+// `pwmgr watchtower` reports passwords reused by several entries, weak
+// passwords by their estimated entropy, passwords not modified within
+// -max-age and password entries without a one-time password.
+//
+//	pwmgr watchtower -min-entropy 70 -max-age 4380h
+//
+// Every command accepts -json to print machine readable output. The password
+// and secret key are read from PWMGR_PASSWORD and PWMGR_SECRET_KEY when set,
+// otherwise they are prompted for.
+//
+// Deriving the key to unlock is slow by design. `pwmgr agent` keeps the
+// unlocked keys in memory and serves the other commands over a unix socket
+// until it has been idle for -idle-timeout. `pwmgr lock` locks it early.
+// With -ssh the agent is also an SSH agent for the SSH key entries.
+//
+//	pwmgr create personal -type ssh -name github -file "private key=$HOME/.ssh/id_ed25519"
+//	eval $(pwmgr agent -ssh &)
+//
+// `pwmgr exec` runs a command with entry fields in its environment. The
+// variables are given with -e or an -env-file of NAME=value lines where
+// values like pwmgr://personal/github/password are replaced by the field.
+// The values are only held in memory and passed to the child.
+//
+//	pwmgr exec -e GITHUB_TOKEN=pwmgr://ci/github/token -- make release
+//
+// `pwmgr render` renders text/template files to config files readable only
+// by the user. Templates read fields with {{ field "ci" "db" "password" }}
+// and with -watch are rendered again when an entry they use is updated.
+//
+//	pwmgr render -watch database.yml.tmpl config/database.yml
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// app holds the io and environment of a pwmgr invocation so commands can be
+// tested without a terminal.
+type app struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	// configDir holds the session file
+	configDir string
+
+	getenv  func(string) string
+	environ func() []string
+	// prompt reads a secret without echoing it
+	prompt func(label string) (string, error)
+
+	json bool
+
+	// lines reads prompted values when stdin isn't a terminal
+	lines *bufio.Reader
+}
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, a *app, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"login":       {"login [-addr addr] [-mount mount] [-method token|userpass] [-path path] [-username name]", cmdLogin},
+		"logout":      {"logout", cmdLogout},
+		"bundles":     {"bundles", cmdBundles},
+		"entries":     {"entries <bundle>", cmdEntries},
+		"get":         {"get <bundle> <entry> [field]", cmdGet},
+		"create":      {"create <bundle> -name name [-type password|ssh] [-tag tag] [-file label=path] [-otp uri] [-generate] [label=value ...]", cmdCreate},
+		"edit":        {"edit <bundle> <entry> [-name name] [-remove label] [-file label=path] [-otp uri] [label=value ...]", cmdEdit},
+		"delete":      {"delete <bundle> <entry>", cmdDelete},
+		"otp":         {"otp <bundle> <entry>", cmdOTP},
+		"history":     {"history <bundle> <entry>", cmdHistory},
+		"diff":        {"diff <bundle> <entry> <version> [version] [-reveal]", cmdDiff},
+		"restore":     {"restore <bundle> <entry> <version>", cmdRestore},
+		"trash":       {"trash <bundle>", cmdTrash},
+		"undelete":    {"undelete <bundle> <entry>", cmdUndelete},
+		"purge":       {"purge <bundle> <entry>", cmdPurge},
+		"attach":      {"attach <bundle> <entry> <file> [-name name]", cmdAttach},
+		"attachments": {"attachments <bundle> <entry>", cmdAttachments},
+		"download":    {"download <bundle> <entry> <attachment> [-o file]", cmdDownload},
+		"detach":      {"detach <bundle> <entry> <attachment>", cmdDetach},
+		"import":      {"import -format bitwarden|1pux|1password-csv|keepass|csv [-dry-run] <bundle> <file>", cmdImport},
+		"export":      {"export -format kdbx|age|openpgp [-history] [-r recipient ...] [-o file] [bundle ...]", cmdExport},
+		"breach":      {"breach -dataset dir [bundle ...]", cmdBreach},
+		"watchtower":  {"watchtower [-min-entropy bits] [-max-age 8760h] [bundle ...]", cmdWatchtower},
+		"generate":    {"generate [-kind random|pronounceable|passphrase] [-length n] [-words n] [-separator s] [-classes lower,upper,digits,symbols] [-exclude chars] [-rules default]", cmdGenerate},
+		"agent":       {"agent [-idle-timeout 15m] [-ssh] [-ssh-confirm]", cmdAgent},
+		"unlock":      {"unlock", cmdUnlock},
+		"lock":        {"lock", cmdLock},
+		"render":      {"render [-mode 0600] [-watch] [-interval 30s] <template> <output> [<template> <output> ...]", cmdRender},
+		"exec":        {"exec [-env-file file] [-e NAME=value] [-no-inherit] -- <command> [args ...]", cmdExec},
+	}
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	a := &app{
+		stdin:   os.Stdin,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+		getenv:  os.Getenv,
+		environ: os.Environ,
+	}
+	a.prompt = a.terminalPrompt
+
+	err := a.run(ctx, os.Args[1:])
+	var exit *exitError
+	if errors.As(err, &exit) {
+		os.Exit(exit.code)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pwmgr: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run dispatches args to a command.
+func (a *app) run(ctx context.Context, args []string) error {
+	if a.configDir == "" {
+		dir, err := defaultConfigDir(a.getenv)
+		if err != nil {
+			return err
+		}
+		a.configDir = dir
+	}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		a.usage()
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		a.usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return cmd.run(ctx, a, args[1:])
+}
+
+func (a *app) usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(a.stderr, "usage: pwmgr <command> [-json] [args]")
+	fmt.Fprintln(a.stderr)
+	for _, name := range names {
+		fmt.Fprintf(a.stderr, "  pwmgr %s\n", commands[name].usage)
+	}
+}
+
+// defaultConfigDir returns PWMGR_CONFIG_DIR or the pwmgr directory in the
+// users config directory.
+func defaultConfigDir(getenv func(string) string) (string, error) {
+	if dir := getenv("PWMGR_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config directory: %w", err)
+	}
+	return filepath.Join(dir, "pwmgr"), nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gradientsearch/vault-plugin-secrets-pwmanager/exporter"
+)
+
+const (
+	testEntityID  = "928e91c7-db18-9673-4342-6f731c7f561a"
+	testBundleID  = "0bbf993d-8e10-6dd0-1aa3-80019b69e332"
+	testPassword  = "correct horse battery staple"
+	testSecretKey = "A3K9Q2-ZP7M4X-W8R6T1-YB5N0C"
+)
+
+// fakeVault serves the pwmanager and kv-v2 endpoints used by pwmgr. The UUK
+// was built by the web client, see plugin/testdata/uuk_vectors.json.
+type fakeVault struct {
+	mu      sync.Mutex
+	uuk     json.RawMessage
+	history map[string][]json.RawMessage
+	created map[string][]time.Time
+	// trash holds the trash items keyed by entry path
+	trash map[string]map[string]interface{}
+	// bundleLists counts the requests listing the bundles
+	bundleLists int
+}
+
+func newFakeVault(t *testing.T) *httptest.Server {
+	data, err := os.ReadFile("../../testdata/uuk_vectors.json")
+	require.NoError(t, err)
+
+	var vectors struct {
+		UUK json.RawMessage `json:"uuk"`
+	}
+	require.NoError(t, json.Unmarshal(data, &vectors))
+
+	f := &fakeVault{uuk: vectors.UUK, history: map[string][]json.RawMessage{}, created: map[string][]time.Time{}, trash: map[string]map[string]interface{}{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "root" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case p == "auth/token/lookup-self":
+		writeData(w, map[string]interface{}{"entity_id": testEntityID})
+	case p == "identity/entity/id/"+testEntityID:
+		writeData(w, map[string]interface{}{"id": testEntityID, "name": "bob"})
+	case p == "pwmanager/users/"+testEntityID:
+		writeData(w, map[string]interface{}{"entity_id": testEntityID, "uuk": f.uuk})
+	case p == "pwmanager/bundles":
+		f.bundleLists++
+		writeData(w, map[string]interface{}{
+			"bundles": []map[string]interface{}{{
+				"id":              testBundleID,
+				"path":            "bundles/data/" + testEntityID + "/" + testBundleID,
+				"owner_entity_id": testEntityID,
+			}},
+			"shared_bundles": []interface{}{},
+		})
+	case p == "pwmanager/generator/rules/default":
+		writeData(w, map[string]interface{}{"min_length": 32, "required_classes": []string{"symbols"}})
+	case strings.HasPrefix(p, "pwmanager/bundles/"+testEntityID+"/"+testBundleID+"/trash"):
+		f.serveTrash(w, r, strings.TrimPrefix(p, "pwmanager/bundles/"+testEntityID+"/"+testBundleID+"/trash"))
+	case strings.HasPrefix(p, "pwmanager/bundles/"+testEntityID+"/"+testBundleID+"/attachments/"):
+		f.serveAttachments(w, r, strings.TrimPrefix(p, "pwmanager/bundles/"+testEntityID+"/"+testBundleID+"/attachments/"))
+	case strings.HasPrefix(p, "bundles/"):
+		f.serveKV(w, r, p)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+	}
+}
+
+func (f *fakeVault) serveKV(w http.ResponseWriter, r *http.Request, p string) {
+	switch r.Method {
+	case http.MethodGet:
+		if strings.HasPrefix(p, "bundles/metadata/") {
+			p = strings.Replace(p, "/metadata/", "/data/", 1)
+			if len(f.history[p]) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+				return
+			}
+			versions := map[string]interface{}{}
+			for i, ct := range f.created[p] {
+				versions[strconv.Itoa(i+1)] = map[string]interface{}{"created_time": ct, "deletion_time": "", "destroyed": false}
+			}
+			writeData(w, map[string]interface{}{"current_version": len(f.history[p]), "versions": versions})
+			return
+		}
+
+		version := len(f.history[p])
+		if v := r.URL.Query().Get("version"); v != "" {
+			version, _ = strconv.Atoi(v)
+		}
+		if version < 1 || version > len(f.history[p]) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		writeData(w, map[string]interface{}{
+			"data":     f.history[p][version-1],
+			"metadata": map[string]interface{}{"version": version, "created_time": f.created[p][version-1]},
+		})
+	case http.MethodPost, http.MethodPut:
+		var body struct {
+			Data    json.RawMessage `json:"data"`
+			Options map[string]int  `json:"options"`
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &body)
+		if cas, ok := body.Options["cas"]; ok && cas != len(f.history[p]) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+			return
+		}
+		f.history[p] = append(f.history[p], body.Data)
+		f.created[p] = append(f.created[p], time.Now().UTC())
+		writeData(w, map[string]int{"version": len(f.history[p])})
+	case http.MethodDelete:
+		p = strings.Replace(p, "/metadata/", "/data/", 1)
+		delete(f.history, p)
+		delete(f.created, p)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serveTrash serves the entry trash of the test bundle, p is the path after
+// trash.
+func (f *fakeVault) serveTrash(w http.ResponseWriter, r *http.Request, p string) {
+	entryPath, restore := strings.CutSuffix(strings.TrimPrefix(p, "/"), "/restore")
+	switch {
+	case r.Method == http.MethodGet && p == "":
+		items := []interface{}{}
+		for _, item := range f.trash {
+			items = append(items, item)
+		}
+		writeData(w, map[string]interface{}{"items": items})
+	case r.Method == http.MethodPost && p == "":
+		var body struct {
+			EntryPath string `json:"entry_path"`
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &body)
+		now := time.Now()
+		f.trash[body.EntryPath] = map[string]interface{}{
+			"id":          body.EntryPath,
+			"type":        "entry",
+			"deleted_by":  testEntityID,
+			"deleted":     now.Unix(),
+			"purge_after": now.Add(30 * 24 * time.Hour).Unix(),
+		}
+		writeData(w, map[string]interface{}{"item": f.trash[body.EntryPath]})
+	case r.Method == http.MethodPost && restore && f.trash[entryPath] != nil:
+		item := f.trash[entryPath]
+		delete(f.trash, entryPath)
+		writeData(w, map[string]interface{}{"item": item})
+	case r.Method == http.MethodDelete && f.trash[entryPath] != nil:
+		delete(f.trash, entryPath)
+		p := "bundles/data/" + testEntityID + "/" + testBundleID + "/entries/" + entryPath
+		delete(f.history, p)
+		delete(f.created, p)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors":["entry not found in trash"]}`))
+	}
+}
+
+// serveAttachments writes the chunks of the test bundle to the kv mount the
+// same way the plugin does and deletes them, p is the path after
+// attachments.
+func (f *fakeVault) serveAttachments(w http.ResponseWriter, r *http.Request, p string) {
+	kvPath := "bundles/data/" + testEntityID + "/" + testBundleID + "/attachments/" + p
+	if r.Method != http.MethodDelete {
+		f.serveKV(w, r, kvPath)
+		return
+	}
+
+	for k := range f.history {
+		if strings.HasPrefix(k, kvPath+"/") {
+			delete(f.history, k)
+			delete(f.created, k)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// testApp returns an app with prompts answered from the map.
+func testApp(t *testing.T, answers map[string]string) (*app, *bytes.Buffer) {
+	var stdout bytes.Buffer
+	a := &app{
+		stdin:     strings.NewReader(""),
+		stdout:    &stdout,
+		stderr:    io.Discard,
+		configDir: t.TempDir(),
+		getenv:    func(string) string { return "" },
+		environ:   func() []string { return nil },
+		prompt: func(label string) (string, error) {
+			v, ok := answers[label]
+			require.True(t, ok, "unexpected prompt %q", label)
+			return v, nil
+		},
+	}
+	return a, &stdout
+}
+
+func TestPwmgr(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, stdout := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+
+	// run runs pwmgr and returns its output
+	run := func(args ...string) string {
+		t.Helper()
+		stdout.Reset()
+		a.json = false
+		require.NoError(t, a.run(ctx, args))
+		return stdout.String()
+	}
+
+	t.Run("login", func(t *testing.T) {
+		out := run("login", "-addr", srv.URL)
+		require.Contains(t, out, "logged in as bob")
+
+		info, err := os.Stat(filepath.Join(a.configDir, "session.json"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("bundles", func(t *testing.T) {
+		var bundles []bundle
+		require.NoError(t, json.Unmarshal([]byte(run("bundles", "-json")), &bundles))
+		require.Len(t, bundles, 1)
+		require.Equal(t, testBundleID, bundles[0].ID)
+		require.Empty(t, bundles[0].Error)
+	})
+
+	t.Run("create and get", func(t *testing.T) {
+		run("create", testBundleID, "-name", "github", "-tag", "work", "username=octocat", "password=hunter2", "url=https://github.com")
+
+		require.Equal(t, "hunter2\n", run("get", testBundleID, "github", "password"))
+		require.Equal(t, "https://github.com\n", run("get", testBundleID, "github", "URL"))
+
+		var entries []map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(run("entries", testBundleID, "-json")), &entries))
+		require.Len(t, entries, 1)
+		require.Equal(t, "github", entries[0]["Name"])
+		require.Equal(t, "octocat", entries[0]["Value"])
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		run("edit", testBundleID, "github", "-name", "GitHub", "-remove", "url", "password=hunter3")
+
+		var e struct {
+			Name string
+			Tags []string
+			More struct{ Items []interface{} }
+		}
+		require.NoError(t, json.Unmarshal([]byte(run("get", testBundleID, "github", "-json")), &e))
+		require.Equal(t, "GitHub", e.Name)
+		require.Equal(t, []string{"work"}, e.Tags)
+		require.Empty(t, e.More.Items)
+		require.Equal(t, "hunter3\n", run("get", testBundleID, "github", "password"))
+	})
+
+	t.Run("otp", func(t *testing.T) {
+		require.Error(t, a.run(ctx, []string{"edit", testBundleID, "github", "-otp", "otpauth://totp/GitHub"}))
+
+		// RFC 4226 test secret
+		run("edit", testBundleID, "github", "-otp", "otpauth://hotp/GitHub:octocat?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=0")
+		require.Equal(t, "755224\n", run("otp", testBundleID, "github"))
+		require.Equal(t, "287082\n", run("otp", testBundleID, "github"))
+
+		run("edit", testBundleID, "github", "-otp", "otpauth://totp/GitHub:octocat?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8")
+		var code struct {
+			Code      string `json:"code"`
+			Type      string `json:"type"`
+			ExpiresAt string `json:"expires_at"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(run("otp", testBundleID, "github", "-json")), &code))
+		require.Len(t, code.Code, 8)
+		require.Equal(t, "totp", code.Type)
+		require.NotEmpty(t, code.ExpiresAt)
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		var p struct {
+			Value   string  `json:"value"`
+			Entropy float64 `json:"entropy"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(run("generate", "-length", "12", "-classes", "lower,digits", "-json")), &p))
+		require.Regexp(t, `^[a-z0-9]*[!#$%&*+\-=?@^_~][a-z0-9!#$%&*+\-=?@^_~]*$`, p.Value)
+		require.Len(t, p.Value, 32)
+		require.Greater(t, p.Entropy, 128.0)
+
+		require.ErrorContains(t, a.run(ctx, []string{"generate", "-rules", "missing", "-kind", "emoji"}), "unknown kind")
+
+		run("create", testBundleID, "-name", "generated", "-generate", "-kind", "passphrase", "-words", "6")
+		password := strings.TrimSpace(run("get", testBundleID, "generated", "password"))
+		require.GreaterOrEqual(t, len(password), 32)
+		require.Regexp(t, `[!#$%&*+\-=?@^_~]`, password)
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "recovery-codes.txt")
+		require.NoError(t, os.WriteFile(file, []byte("1234-5678\n"), 0o600))
+
+		require.Contains(t, run("attach", testBundleID, "github", file), "attached recovery-codes.txt")
+		require.Contains(t, run("attachments", testBundleID, "github"), "recovery-codes.txt")
+		require.Equal(t, "1234-5678\n", run("download", testBundleID, "github", "recovery-codes.txt"))
+
+		out := filepath.Join(t.TempDir(), "codes.txt")
+		run("download", testBundleID, "github", "recovery-codes.txt", "-o", out)
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, "1234-5678\n", string(data))
+
+		require.Contains(t, run("detach", testBundleID, "github", "recovery-codes.txt"), "detached recovery-codes.txt")
+		require.Equal(t, "[]\n", run("attachments", testBundleID, "github", "-json"))
+		require.Error(t, a.run(ctx, []string{"download", testBundleID, "github", "recovery-codes.txt"}))
+	})
+
+	t.Run("import", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "export.csv")
+		require.NoError(t, os.WriteFile(file, []byte("name,url,username,password,pin\n"+
+			"gitlab,https://gitlab.com,tanuki,hunter4,\n"+
+			"bank,https://bank.example.com,jane,hunter5,1234\n"), 0o600))
+
+		require.Error(t, a.run(ctx, []string{"import", "-format", "lastpass", testBundleID, file}))
+
+		out := run("import", "-format", "csv", "-dry-run", testBundleID, file)
+		require.Contains(t, out, "would import 2 entries")
+		require.Contains(t, out, "unknown column imported as a text field")
+		require.Error(t, a.run(ctx, []string{"get", testBundleID, "gitlab"}))
+
+		require.Contains(t, run("import", "-format", "csv", testBundleID, file), "imported 2 entries")
+		require.Equal(t, "hunter4\n", run("get", testBundleID, "gitlab", "password"))
+		require.Equal(t, "1234\n", run("get", testBundleID, "bank", "pin"))
+
+		var entries []map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(run("entries", testBundleID, "-json")), &entries))
+		require.Len(t, entries, 4)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		entity, err := openpgp.NewEntity("Jane", "", "jane@example.com", nil)
+		require.NoError(t, err)
+
+		var pub bytes.Buffer
+		require.NoError(t, entity.Serialize(&pub))
+		key := filepath.Join(t.TempDir(), "jane.gpg")
+		require.NoError(t, os.WriteFile(key, pub.Bytes(), 0o600))
+
+		require.ErrorContains(t, a.run(ctx, []string{"export", "-format", "age", testBundleID}), "recipient")
+		require.Error(t, a.run(ctx, []string{"export", "-format", "zip", testBundleID}))
+
+		out := filepath.Join(t.TempDir(), "export.gpg")
+		run("export", "-format", "openpgp", "-history", "-r", key, "-o", out, testBundleID)
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), "hunter3")
+
+		md, err := openpgp.ReadMessage(bytes.NewReader(data), openpgp.EntityList{entity}, nil, nil)
+		require.NoError(t, err)
+		var archive exporter.Archive
+		require.NoError(t, json.NewDecoder(md.UnverifiedBody).Decode(&archive))
+		require.Len(t, archive.Bundles, 1)
+		require.Len(t, archive.Bundles[0].Entries, 4)
+
+		for _, e := range archive.Bundles[0].Entries {
+			if e.Entry.Name != "GitHub" {
+				continue
+			}
+			password, _ := e.Entry.Field("password")
+			require.Equal(t, "hunter3", password)
+			require.NotEmpty(t, e.History)
+			password, _ = e.History[0].Entry.Field("password")
+			require.Equal(t, "hunter2", password)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		run("delete", testBundleID, "github")
+		require.Error(t, a.run(ctx, []string{"get", testBundleID, "github"}))
+
+		var trash []trashedEntry
+		require.NoError(t, json.Unmarshal([]byte(run("trash", testBundleID, "-json")), &trash))
+		require.Len(t, trash, 1)
+		require.Equal(t, "GitHub", trash[0].Name)
+		require.Equal(t, testEntityID, trash[0].DeletedBy)
+		require.True(t, trash[0].PurgeAfter.After(trash[0].Deleted))
+
+		require.Contains(t, run("undelete", testBundleID, "github"), "restored entry GitHub")
+		require.Contains(t, run("get", testBundleID, "github", "username"), "octocat")
+		require.Contains(t, run("trash", testBundleID), "NAME")
+
+		run("delete", testBundleID, "github")
+		require.Contains(t, run("purge", testBundleID, "github"), "purged entry GitHub")
+		require.Equal(t, "[]\n", run("trash", testBundleID, "-json"))
+		require.Error(t, a.run(ctx, []string{"undelete", testBundleID, "github"}))
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		a, _ := testApp(t, map[string]string{"Password": "wrong", "Secret key": testSecretKey})
+		s := &session{Addr: srv.URL, Token: "root", Mount: "pwmanager", EntityID: testEntityID}
+		_, err := a.unlock(ctx, s)
+		require.ErrorContains(t, err, "unable to unlock")
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		run("logout")
+		require.ErrorContains(t, a.run(ctx, []string{"bundles"}), "not logged in")
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// renderedEntry is an entry used by a template and the metadata it was
+// rendered with. The metadata version changes when the entry is updated.
+type renderedEntry struct {
+	Bundle   string
+	Entry    string
+	Metadata pwManager.EntryMetadata
+}
+
+// renderer renders a template with the helper functions reading entries
+// from the store. Each entry is only decrypted once per render.
+type renderer struct {
+	ctx context.Context
+	st  store
+
+	entries map[[2]string]*pwManager.Entry
+}
+
+func (r *renderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		// entry returns the entry, e.g. {{ (entry "personal" "github").Name }}
+		"entry": r.entry,
+		// field returns the field of the entry, e.g. {{ field "personal" "github" "password" }}
+		"field": r.field,
+		// ref returns the field referenced by a pwmgr:// reference
+		"ref": func(s string) (string, error) {
+			ref, err := parseRef(s)
+			if err != nil {
+				return "", err
+			}
+			return r.field(ref.Bundle, ref.Entry, ref.Field)
+		},
+		"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"quote":  strconv.Quote,
+		"trim":   strings.TrimSpace,
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+}
+
+func (r *renderer) entry(bundle, entry string) (*pwManager.Entry, error) {
+	key := [2]string{bundle, entry}
+	if e, ok := r.entries[key]; ok {
+		return e, nil
+	}
+
+	e, err := r.st.Entry(r.ctx, bundle, entry)
+	if err != nil {
+		return nil, err
+	}
+	r.entries[key] = e
+	return e, nil
+}
+
+func (r *renderer) field(bundle, entry, field string) (string, error) {
+	e, err := r.entry(bundle, entry)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := e.Field(field)
+	if !ok {
+		return "", fmt.Errorf("entry %q has no field %q", e.Name, field)
+	}
+	return value, nil
+}
+
+// renderTemplate renders the template text and returns the output and the
+// entries it used.
+func renderTemplate(ctx context.Context, st store, name, text string) ([]byte, []renderedEntry, error) {
+	r := &renderer{ctx: ctx, st: st, entries: map[[2]string]*pwManager.Entry{}}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(r.funcs()).Parse(text)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, nil, err
+	}
+
+	var used []renderedEntry
+	for key, e := range r.entries {
+		used = append(used, renderedEntry{Bundle: key[0], Entry: key[1], Metadata: e.Metadata})
+	}
+	return buf.Bytes(), used, nil
+}
+
+// changed reports whether any of the entries was updated or deleted since it
+// was rendered. Only the bundle metadata is read, the entries aren't
+// decrypted.
+func changed(ctx context.Context, st store, used []renderedEntry) (bool, error) {
+	bundles := map[string][]pwManager.EntryMetadata{}
+	for _, u := range used {
+		entries, ok := bundles[u.Bundle]
+		if !ok {
+			var err error
+			entries, err = st.Entries(ctx, u.Bundle)
+			if err != nil {
+				return false, err
+			}
+			bundles[u.Bundle] = entries
+		}
+
+		m, err := findEntry(entries, u.Entry)
+		if err != nil || m != u.Metadata {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// writeFile atomically replaces the file with data. The file is created with
+// perm regardless of the umask so it is never readable by others, even
+// briefly.
+func writeFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// parseMode parses an octal file mode. Modes giving others access are
+// rejected since the output contains secrets.
+func parseMode(s string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m&^0o777 != 0 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	if m&0o007 != 0 {
+		return 0, fmt.Errorf("mode %s gives others access to the rendered secrets", s)
+	}
+	return fs.FileMode(m), nil
+}
+
+// renderJob is a template and the file it is rendered to.
+type renderJob struct {
+	tmpl string
+	out  string
+	text string
+	used []renderedEntry
+}
+
+// render renders the template to its output file.
+func (j *renderJob) render(ctx context.Context, st store, perm fs.FileMode) error {
+	data, used, err := renderTemplate(ctx, st, filepath.Base(j.tmpl), j.text)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(j.out, data, perm); err != nil {
+		return err
+	}
+	j.used = used
+	return nil
+}
+
+func cmdRender(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("render")
+	mode := fs.String("mode", "0600", "mode of the rendered files")
+	watch := fs.Bool("watch", false, "re-render when an entry used by a template changes")
+	interval := fs.Duration("interval", 30*time.Second, "how often entries are checked for changes with -watch")
+	pos, err := parse(fs, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	if len(pos)%2 != 0 {
+		fs.Usage()
+		return fmt.Errorf("render: expected template and output pairs")
+	}
+	if *watch && *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+
+	perm, err := parseMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	var jobs []*renderJob
+	for i := 0; i < len(pos); i += 2 {
+		text, err := os.ReadFile(pos[i])
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, &renderJob{tmpl: pos[i], out: pos[i+1], text: string(text)})
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	for _, j := range jobs {
+		if err := j.render(ctx, st, perm); err != nil {
+			return fmt.Errorf("%s: %w", j.tmpl, err)
+		}
+		fmt.Fprintf(a.stderr, "rendered %s\n", j.out)
+	}
+
+	if !*watch {
+		return nil
+	}
+
+	t := time.NewTicker(*interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		for _, j := range jobs {
+			ok, err := changed(ctx, st, j.used)
+			if err == nil && ok {
+				err = j.render(ctx, st, perm)
+				if err == nil {
+					fmt.Fprintf(a.stderr, "rendered %s\n", j.out)
+				}
+			}
+			// keep the last rendered file and retry on the next tick
+			if err != nil && ctx.Err() == nil {
+				fmt.Fprintf(a.stderr, "%s: %s\n", j.tmpl, err)
+			}
+		}
+	}
+}
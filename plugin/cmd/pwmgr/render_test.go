@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	m, err := parseMode("0640")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), m)
+
+	_, err = parseMode("0644")
+	require.ErrorContains(t, err, "gives others access")
+
+	_, err = parseMode("rw")
+	require.ErrorContains(t, err, "invalid mode")
+}
+
+func TestRender(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, _ := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+	require.NoError(t, a.run(ctx, []string{"login", "-addr", srv.URL}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "db", "username=app", "password=hunter2", "host=db.internal"}))
+
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "netrc.tmpl")
+	out := filepath.Join(dir, "netrc")
+	require.NoError(t, os.WriteFile(tmpl, []byte(
+		`machine {{ field "`+testBundleID+`" "db" "host" }} login {{ (entry "`+testBundleID+`" "db").Metadata.Value }} password {{ ref "pwmgr://`+testBundleID+`/db/password" | quote }}`+"\n"), 0o600))
+
+	t.Run("render", func(t *testing.T) {
+		require.NoError(t, a.run(ctx, []string{"render", tmpl, out}))
+
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, "machine db.internal login app password \"hunter2\"\n", string(data))
+
+		info, err := os.Stat(out)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad.tmpl")
+		require.NoError(t, os.WriteFile(bad, []byte(`{{ field "`+testBundleID+`" "db" "port" }}`), 0o600))
+		require.ErrorContains(t, a.run(ctx, []string{"render", bad, filepath.Join(dir, "bad")}), `no field "port"`)
+
+		_, err := os.Stat(filepath.Join(dir, "bad"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		st, err := a.store(ctx)
+		require.NoError(t, err)
+
+		j := &renderJob{tmpl: tmpl, out: out, text: `{{ field "` + testBundleID + `" "db" "password" }}`}
+		require.NoError(t, j.render(ctx, st, 0o600))
+
+		ok, err := changed(ctx, st, j.used)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		e, err := st.Entry(ctx, testBundleID, "db")
+		require.NoError(t, err)
+		e.SetField("password", "hunter3")
+		_, err = st.PutEntry(ctx, testBundleID, e)
+		require.NoError(t, err)
+
+		ok, err = changed(ctx, st, j.used)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		watcher, _ := testApp(t, map[string]string{"Password": testPassword, "Secret key": testSecretKey})
+		watcher.configDir = a.configDir
+		done := make(chan error)
+		go func() {
+			done <- watcher.run(watchCtx, []string{"render", "-watch", "-interval", "10ms", tmpl, out})
+		}()
+
+		require.NoError(t, a.run(ctx, []string{"edit", testBundleID, "db", "host=db2.internal"}))
+		require.Eventually(t, func() bool {
+			data, err := os.ReadFile(out)
+			return err == nil && string(data) == "machine db2.internal login app password \"hunter3\"\n"
+		}, 5*time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"golang.org/x/term"
+)
+
+// session is the Vault login persisted between invocations. It contains the
+// Vault token but never the password, secret key or private key.
+type session struct {
+	Addr       string `json:"addr"`
+	Token      string `json:"token"`
+	Mount      string `json:"mount"`
+	EntityID   string `json:"entity_id"`
+	EntityName string `json:"entity_name"`
+}
+
+func (a *app) sessionPath() string {
+	return filepath.Join(a.configDir, "session.json")
+}
+
+// loadSession returns the session saved by login.
+func (a *app) loadSession() (*session, error) {
+	data, err := os.ReadFile(a.sessionPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("not logged in, run `pwmgr login`")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error reading session: %w", err)
+	}
+	return &s, nil
+}
+
+// saveSession writes the session readable only by the user.
+func (a *app) saveSession(s *session) error {
+	if err := os.MkdirAll(a.configDir, 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(a.sessionPath(), data, 0o600)
+}
+
+// client returns a pwmanager client authenticated with the session token.
+func (s *session) client() (*pwManager.Client, error) {
+	return pwManager.NewClient(s.Token, s.Addr)
+}
+
+// secret returns the value of the env var or prompts for it.
+func (a *app) secret(env, label string) (string, error) {
+	if v := a.getenv(env); v != "" {
+		return v, nil
+	}
+	return a.prompt(label)
+}
+
+// terminalPrompt reads a secret from the terminal without echoing it. When
+// stdin isn't a terminal a line is read from stdin instead.
+func (a *app) terminalPrompt(label string) (string, error) {
+	fmt.Fprintf(a.stderr, "%s: ", label)
+
+	if f, ok := a.stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		b, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(a.stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	if a.lines == nil {
+		a.lines = bufio.NewReader(a.stdin)
+	}
+	line, err := a.lines.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("error reading %s: %w", strings.ToLower(label), err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// removeSession deletes the session saved by login.
+func (a *app) removeSession() error {
+	err := os.Remove(a.sessionPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+// The SSH agent serves the SSH key entries of the unlocked bundles. It is
+// part of the unlock agent so keys are only available while it is unlocked
+// and are removed when it locks. Keys can't be added or removed with
+// ssh-add, they are managed as bundle entries.
+//
+// An entry with a `confirm` field set to true, or every key when the agent
+// runs with -ssh-confirm, is only used after the user confirmed it with the
+// SSH_ASKPASS program, the same as keys added with `ssh-add -c`.
+
+// sshKeyType is the entry type of SSH keys, see NewSSHKeyEntry.
+const sshKeyType = "ssh"
+
+var errSSHReadOnly = errors.New("keys are managed as pwmgr bundle entries")
+
+// sshKey is a private key read from an entry.
+type sshKey struct {
+	signer  ssh.Signer
+	raw     interface{}
+	comment string
+	confirm bool
+}
+
+// sshAgent implements the ssh agent protocol for the keys of an agent.
+type sshAgent struct {
+	ctx context.Context
+	ag  *agent
+
+	// confirmAll requires confirmation for every key
+	confirmAll bool
+	// confirm asks the user to confirm the use of a key
+	confirm func(prompt string) bool
+
+	// keys is guarded by ag.mu and cleared when the agent locks
+	keys []*sshKey
+}
+
+func newSSHAgent(ctx context.Context, ag *agent, confirmAll bool) *sshAgent {
+	s := &sshAgent{ctx: ctx, ag: ag, confirmAll: confirmAll, confirm: askpassConfirm}
+	ag.onLock(s.removeKeys)
+	return s
+}
+
+// sshAgentSocket returns PWMGR_SSH_AUTH_SOCK or the socket in the config
+// directory.
+func (a *app) sshAgentSocket() string {
+	if s := a.getenv("PWMGR_SSH_AUTH_SOCK"); s != "" {
+		return s
+	}
+	return filepath.Join(a.configDir, "ssh-agent.sock")
+}
+
+// serve serves the ssh agent protocol until ctx is done.
+func (s *sshAgent) serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			sshagent.ServeAgent(s, conn)
+		}()
+	}
+}
+
+// loadKeys reads the SSH keys of the unlocked bundles. ag.mu must be held.
+func (s *sshAgent) loadKeys() error {
+	u := s.ag.u
+	bundles, err := u.Bundles(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	var keys []*sshKey
+	for _, b := range bundles {
+		if b.Error != "" {
+			continue
+		}
+
+		bundleName := b.Name
+		if bundleName == "" {
+			bundleName = b.ID
+		}
+
+		for _, m := range b.metadata.Entries {
+			if m.Type != sshKeyType {
+				continue
+			}
+
+			e, err := b.kv.Entry(s.ctx, m)
+			if err != nil {
+				fmt.Fprintf(s.ag.a.stderr, "ssh: error reading %s/%s: %s\n", bundleName, m.Name, err)
+				continue
+			}
+
+			k, err := parseSSHKey(e.Field)
+			if err != nil {
+				fmt.Fprintf(s.ag.a.stderr, "ssh: %s/%s: %s\n", bundleName, e.Name, err)
+				continue
+			}
+			k.comment = bundleName + "/" + e.Name
+			k.confirm = k.confirm || s.confirmAll
+			keys = append(keys, k)
+		}
+	}
+
+	s.removeKeys()
+	s.keys = keys
+	return nil
+}
+
+// parseSSHKey parses the private key of an SSH key entry.
+func parseSSHKey(field func(string) (string, bool)) (*sshKey, error) {
+	pem, _ := field("private key")
+	if strings.TrimSpace(pem) == "" {
+		return nil, fmt.Errorf("entry has no private key")
+	}
+
+	var raw interface{}
+	var err error
+	if passphrase, _ := field("passphrase"); passphrase != "" {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(pem), []byte(passphrase))
+	} else {
+		raw, err = ssh.ParseRawPrivateKey([]byte(pem))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(raw)
+	if err != nil {
+		zeroSSHKey(raw)
+		return nil, err
+	}
+
+	confirm, _ := field("confirm")
+	return &sshKey{
+		signer:  signer,
+		raw:     raw,
+		confirm: strings.EqualFold(confirm, "true") || strings.EqualFold(confirm, "yes"),
+	}, nil
+}
+
+// removeKeys zeros and removes the keys. ag.mu must be held.
+func (s *sshAgent) removeKeys() {
+	for _, k := range s.keys {
+		zeroSSHKey(k.raw)
+	}
+	s.keys = nil
+}
+
+// zeroSSHKey overwrites the private key material. Copies made internally by
+// the crypto packages can't be reached and aren't zeroed.
+func zeroSSHKey(raw interface{}) {
+	switch k := raw.(type) {
+	case *rsa.PrivateKey:
+		for _, v := range append([]*big.Int{k.D, k.Precomputed.Dp, k.Precomputed.Dq, k.Precomputed.Qinv}, k.Primes...) {
+			if v != nil {
+				clear(v.Bits())
+			}
+		}
+	case *ecdsa.PrivateKey:
+		clear(k.D.Bits())
+	case *ed25519.PrivateKey:
+		clear(*k)
+	case ed25519.PrivateKey:
+		clear(k)
+	}
+}
+
+// key returns the key with the public key. ag.mu must be held.
+func (s *sshAgent) key(pub ssh.PublicKey) *sshKey {
+	wanted := pub.Marshal()
+	for _, k := range s.keys {
+		if bytes.Equal(k.signer.PublicKey().Marshal(), wanted) {
+			return k
+		}
+	}
+	return nil
+}
+
+// List returns the keys of the unlocked bundles. A locked agent has no keys.
+func (s *sshAgent) List() ([]*sshagent.Key, error) {
+	s.ag.mu.Lock()
+	defer s.ag.mu.Unlock()
+
+	if s.ag.u == nil {
+		return []*sshagent.Key{}, nil
+	}
+	s.ag.touch()
+
+	if err := s.loadKeys(); err != nil {
+		return nil, err
+	}
+
+	keys := []*sshagent.Key{}
+	for _, k := range s.keys {
+		pub := k.signer.PublicKey()
+		keys = append(keys, &sshagent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: k.comment})
+	}
+	return keys, nil
+}
+
+func (s *sshAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return s.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs the data with the key after the user confirmed it when
+// the key requires confirmation.
+func (s *sshAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags sshagent.SignatureFlags) (*ssh.Signature, error) {
+	s.ag.mu.Lock()
+	if s.ag.u == nil {
+		s.ag.mu.Unlock()
+		return nil, errors.New("agent is locked")
+	}
+	k := s.key(key)
+	if k == nil {
+		// the key may have been added since the keys were listed
+		if err := s.loadKeys(); err != nil {
+			s.ag.mu.Unlock()
+			return nil, err
+		}
+		k = s.key(key)
+	}
+	if k == nil {
+		s.ag.mu.Unlock()
+		return nil, errors.New("key not found")
+	}
+	comment, confirm := k.comment, k.confirm
+	s.ag.mu.Unlock()
+
+	// the agent isn't blocked while the user confirms
+	if confirm && !s.confirm(fmt.Sprintf("Allow use of key %s?\nKey fingerprint %s.", comment, ssh.FingerprintSHA256(key))) {
+		return nil, errors.New("use of key was not confirmed")
+	}
+
+	s.ag.mu.Lock()
+	defer s.ag.mu.Unlock()
+
+	// the agent may have locked or the keys reloaded while confirming
+	if s.ag.u == nil {
+		return nil, errors.New("agent is locked")
+	}
+	if k = s.key(key); k == nil {
+		return nil, errors.New("key not found")
+	}
+	s.ag.touch()
+
+	if flags == 0 {
+		return k.signer.Sign(rand.Reader, data)
+	}
+
+	as, ok := k.signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("key does not support non-default signature algorithms: %T", k.signer)
+	}
+
+	var algorithm string
+	switch flags {
+	case sshagent.SignatureFlagRsaSha256:
+		algorithm = ssh.KeyAlgoRSASHA256
+	case sshagent.SignatureFlagRsaSha512:
+		algorithm = ssh.KeyAlgoRSASHA512
+	default:
+		return nil, fmt.Errorf("unsupported signature flags: %d", flags)
+	}
+	return as.SignWithAlgorithm(rand.Reader, data, algorithm)
+}
+
+func (s *sshAgent) Add(key sshagent.AddedKey) error { return errSSHReadOnly }
+
+func (s *sshAgent) Remove(key ssh.PublicKey) error { return errSSHReadOnly }
+
+func (s *sshAgent) RemoveAll() error { return errSSHReadOnly }
+
+// Lock locks the unlock agent, `ssh-add -x`.
+func (s *sshAgent) Lock(passphrase []byte) error {
+	s.ag.mu.Lock()
+	defer s.ag.mu.Unlock()
+	s.ag.lock()
+	return nil
+}
+
+func (s *sshAgent) Unlock(passphrase []byte) error {
+	return errors.New("run `pwmgr unlock` to unlock the agent")
+}
+
+// Signers isn't used by ServeAgent, keys are only used through Sign so
+// confirmation can't be bypassed.
+func (s *sshAgent) Signers() ([]ssh.Signer, error) {
+	return nil, errors.New("signers are not available")
+}
+
+func (s *sshAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, sshagent.ErrExtensionUnsupported
+}
+
+// askpassConfirm asks the user to confirm with SSH_ASKPASS the same way
+// ssh-agent does. Without SSH_ASKPASS the use of the key is denied.
+func askpassConfirm(prompt string) bool {
+	askpass := os.Getenv("SSH_ASKPASS")
+	if askpass == "" {
+		return false
+	}
+
+	cmd := exec.Command(askpass, prompt)
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	return cmd.Run() == nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+func TestSSHAgent(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, _ := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+	require.NoError(t, a.run(ctx, []string{"login", "-addr", srv.URL}))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeKey := func(name string, key interface{}, passphrase string) string {
+		var block *pem.Block
+		var err error
+		if passphrase != "" {
+			block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+		} else {
+			block, err = ssh.MarshalPrivateKey(key, "")
+		}
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+		return path
+	}
+
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-type", "ssh", "-name", "deploy", "-file", "private key=" + writeKey("deploy", edKey, "")}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-type", "ssh", "-name", "prod", "-file", "private key=" + writeKey("prod", rsaKey, "s3cret"), "passphrase=s3cret", "confirm=true"}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "github", "password=hunter2"}))
+
+	agCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	ag := startAgent(t, a, time.Hour)
+
+	var mu sync.Mutex
+	var prompts []string
+	allow := false
+	s := newSSHAgent(agCtx, ag, false)
+	s.confirm = func(prompt string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		prompts = append(prompts, prompt)
+		return allow
+	}
+
+	l, err := listenAgent(a.sshAgentSocket())
+	require.NoError(t, err)
+	go s.serve(agCtx, l)
+
+	conn, err := net.Dial("unix", a.sshAgentSocket())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := sshagent.NewClient(conn)
+
+	t.Run("locked agent has no keys", func(t *testing.T) {
+		keys, err := client.List()
+		require.NoError(t, err)
+		require.Empty(t, keys)
+	})
+
+	require.NoError(t, a.run(ctx, []string{"unlock"}))
+
+	edPub, err := ssh.NewPublicKey(edKey.Public())
+	require.NoError(t, err)
+	rsaPub, err := ssh.NewPublicKey(rsaKey.Public())
+	require.NoError(t, err)
+
+	t.Run("list", func(t *testing.T) {
+		keys, err := client.List()
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+
+		comments := map[string]string{}
+		for _, k := range keys {
+			comments[string(k.Blob)] = k.Comment
+		}
+		require.Equal(t, map[string]string{
+			string(edPub.Marshal()):  testBundleID + "/deploy",
+			string(rsaPub.Marshal()): testBundleID + "/prod",
+		}, comments)
+	})
+
+	t.Run("sign", func(t *testing.T) {
+		sig, err := client.Sign(edPub, []byte("data"))
+		require.NoError(t, err)
+		require.NoError(t, edPub.Verify([]byte("data"), sig))
+		require.Empty(t, prompts)
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		_, err := client.SignWithFlags(rsaPub, []byte("data"), sshagent.SignatureFlagRsaSha256)
+		require.Error(t, err)
+		require.Len(t, prompts, 1)
+		require.Contains(t, prompts[0], testBundleID+"/prod")
+		require.Contains(t, prompts[0], ssh.FingerprintSHA256(rsaPub))
+
+		mu.Lock()
+		allow = true
+		mu.Unlock()
+
+		sig, err := client.SignWithFlags(rsaPub, []byte("data"), sshagent.SignatureFlagRsaSha256)
+		require.NoError(t, err)
+		require.Equal(t, ssh.KeyAlgoRSASHA256, sig.Format)
+		require.NoError(t, rsaPub.Verify([]byte("data"), sig))
+	})
+
+	t.Run("keys are read only", func(t *testing.T) {
+		require.Error(t, client.Add(sshagent.AddedKey{PrivateKey: edKey}))
+		require.Error(t, client.RemoveAll())
+	})
+
+	t.Run("lock removes keys", func(t *testing.T) {
+		ag.mu.Lock()
+		raw := s.keys[0].raw
+		ag.mu.Unlock()
+
+		require.NoError(t, a.run(ctx, []string{"lock"}))
+
+		keys, err := client.List()
+		require.NoError(t, err)
+		require.Empty(t, keys)
+
+		_, err = client.Sign(edPub, []byte("data"))
+		require.Error(t, err)
+
+		ag.mu.Lock()
+		require.Empty(t, s.keys)
+		ag.mu.Unlock()
+
+		switch k := raw.(type) {
+		case *ed25519.PrivateKey:
+			require.Equal(t, make([]byte, len(*k)), []byte(*k))
+		case *rsa.PrivateKey:
+			require.Zero(t, k.D.Sign())
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// trashedEntry is a deleted entry of a bundle that hasn't been purged yet.
+type trashedEntry struct {
+	pwManager.EntryMetadata
+	DeletedBy  string    `json:"deleted_by"`
+	Deleted    time.Time `json:"deleted"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// TrashedEntries returns the deleted entries of the bundle. Entries left in
+// the bundle metadata trash after the plugin purged them are skipped.
+func (u *unlocked) TrashedEntries(ctx context.Context, ref string) ([]trashedEntry, error) {
+	b, err := u.bundle(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := u.c.Trash().Entries(ctx, u.s.Mount, b.Owner, b.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing trash: %w", err)
+	}
+
+	byPath := map[string]pwManager.TrashItem{}
+	for _, item := range items {
+		byPath[item.ID] = item
+	}
+
+	entries := []trashedEntry{}
+	for _, m := range b.metadata.Trash {
+		item, ok := byPath[m.Path]
+		if !ok {
+			continue
+		}
+		entries = append(entries, trashedEntry{
+			EntryMetadata: m,
+			DeletedBy:     item.DeletedBy,
+			Deleted:       time.Unix(item.Deleted, 0),
+			PurgeAfter:    time.Unix(item.PurgeAfter, 0),
+		})
+	}
+	return entries, nil
+}
+
+// UndeleteEntry restores the deleted entry to the bundle.
+func (u *unlocked) UndeleteEntry(ctx context.Context, bundleRef, entryRef string) (pwManager.EntryMetadata, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	m, err := findEntry(b.metadata.Trash, entryRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	m, err = b.kv.RestoreTrashedEntry(ctx, u.s.Mount, m.ID)
+	if err != nil {
+		return pwManager.EntryMetadata{}, fmt.Errorf("error restoring entry: %w", err)
+	}
+	return m, nil
+}
+
+// PurgeEntry destroys the deleted entry before the trash retention ends.
+func (u *unlocked) PurgeEntry(ctx context.Context, bundleRef, entryRef string) (pwManager.EntryMetadata, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	m, err := findEntry(b.metadata.Trash, entryRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	if err := b.kv.PurgeTrashedEntry(ctx, u.s.Mount, m.ID); err != nil {
+		return pwManager.EntryMetadata{}, fmt.Errorf("error purging entry: %w", err)
+	}
+	return m, nil
+}
+
+func cmdTrash(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("trash")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	entries, err := st.TrashedEntries(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(entries)
+	}
+
+	rows := [][]string{}
+	for _, e := range entries {
+		rows = append(rows, []string{e.Name, e.ID, e.Deleted.Local().Format(time.DateTime), e.PurgeAfter.Local().Format(time.DateTime)})
+	}
+	return a.table([]string{"NAME", "ID", "DELETED", "PURGE AFTER"}, rows)
+}
+
+func cmdUndelete(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("undelete")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	m, err := st.UndeleteEntry(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	return a.printEntryMetadata(m, "restored")
+}
+
+func cmdPurge(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("purge")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	m, err := st.PurgeEntry(ctx, pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	return a.printEntryMetadata(m, "purged")
+}
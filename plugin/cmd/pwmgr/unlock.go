@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// store reads and writes the entries of the users bundles. It is
+// implemented by unlocked in process and by agentClient when an unlocked
+// agent is running.
+type store interface {
+	Bundles(ctx context.Context) ([]*bundle, error)
+	Entries(ctx context.Context, bundle string) ([]pwManager.EntryMetadata, error)
+	Entry(ctx context.Context, bundle, entry string) (*pwManager.Entry, error)
+	// EntriesIn and EntryIn read a bundle returned by Bundles without
+	// resolving the bundle again, so walking every entry doesn't list the
+	// bundles per entry.
+	EntriesIn(ctx context.Context, b *bundle) ([]pwManager.EntryMetadata, error)
+	EntryIn(ctx context.Context, b *bundle, entry string) (*pwManager.Entry, error)
+	// PutEntry creates the entry when it has no ID and updates it otherwise.
+	PutEntry(ctx context.Context, bundle string, e *pwManager.Entry) (pwManager.EntryMetadata, error)
+	DeleteEntry(ctx context.Context, bundle, entry string) (pwManager.EntryMetadata, error)
+
+	EntryVersions(ctx context.Context, bundle, entry string) ([]pwManager.EntryVersion, error)
+	// EntryVersion returns a kv-v2 version of the entry, 0 is the current
+	// version.
+	EntryVersion(ctx context.Context, bundle, entry string, version int) (*pwManager.Entry, error)
+	// RestoreEntry writes the version of the entry as its new version.
+	RestoreEntry(ctx context.Context, bundle, entry string, version int) (pwManager.EntryMetadata, error)
+
+	TrashedEntries(ctx context.Context, bundle string) ([]trashedEntry, error)
+	// UndeleteEntry moves the deleted entry from the trash back to the
+	// bundle.
+	UndeleteEntry(ctx context.Context, bundle, entry string) (pwManager.EntryMetadata, error)
+	PurgeEntry(ctx context.Context, bundle, entry string) (pwManager.EntryMetadata, error)
+
+	PutAttachment(ctx context.Context, bundle, entry, name string, data []byte) (pwManager.Attachment, error)
+	ReadAttachment(ctx context.Context, bundle, entry, attachment string) (pwManager.Attachment, []byte, error)
+	DeleteAttachment(ctx context.Context, bundle, entry, attachment string) (pwManager.Attachment, error)
+
+	// ImportEntries adds new entries to the bundle, see importer.Parse.
+	ImportEntries(ctx context.Context, bundle string, entries []*pwManager.Entry) ([]pwManager.EntryMetadata, error)
+}
+
+// unlocked is a session with the users private key decrypted. The bundles
+// opened with it are cached so bundle keys are only unwrapped once.
+type unlocked struct {
+	s      *session
+	c      *pwManager.Client
+	priKey jwk.Key
+	pubKey pwManager.PubKey
+
+	opened map[string]*bundle
+}
+
+// unlock decrypts the users UUK with their password and secret key.
+func (a *app) unlock(ctx context.Context, s *session) (*unlocked, error) {
+	password, err := a.secret("PWMGR_PASSWORD", "Password")
+	if err != nil {
+		return nil, err
+	}
+
+	secretKey, err := a.secret("PWMGR_SECRET_KEY", "Secret key")
+	if err != nil {
+		return nil, err
+	}
+
+	return unlockWith(ctx, s, password, secretKey)
+}
+
+// unlockWith decrypts the users UUK with the password and secret key.
+func unlockWith(ctx context.Context, s *session, password, secretKey string) (*unlocked, error) {
+	c, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := c.Users().Get(ctx, s.Mount, s.EntityID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving UUK: %w", err)
+	}
+
+	// a merged user's UUK is still salted with their previous entity id
+	uuk := entry.UUK.UUK()
+	priKey, err := uuk.DecryptEncPriKey([]byte(password), []byte(s.Mount), []byte(normalizeSecretKey(secretKey)), []byte(entry.SaltEntityID()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to unlock, check the password and secret key")
+	}
+
+	return &unlocked{
+		s:      s,
+		c:      c,
+		priKey: priKey,
+		pubKey: entry.UUK.PubKey,
+		opened: map[string]*bundle{},
+	}, nil
+}
+
+// normalizeSecretKey removes the dashes the web client adds when displaying
+// the secret key. The secret key is hex encoded so dashes are never part of it.
+func normalizeSecretKey(secretKey string) string {
+	return strings.ReplaceAll(strings.TrimSpace(secretKey), "-", "")
+}
+
+// lock zeros the private key and the bundle keys. The unlocked session can't
+// be used afterwards.
+func (u *unlocked) lock() {
+	for _, b := range u.opened {
+		b.kv.Lock()
+	}
+	u.opened = map[string]*bundle{}
+
+	if u.priKey != nil {
+		pwManager.ZeroPrivateKey(u.priKey)
+		u.priKey = nil
+	}
+}
+
+// bundle is a bundle owned by or shared with the user.
+type bundle struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Path         string `json:"path"`
+	Owner        string `json:"owner_entity_id"`
+	Shared       bool   `json:"shared"`
+	Capabilities string `json:"capabilities,omitempty"`
+	Error        string `json:"error,omitempty"`
+
+	kv       *pwManager.KVBundle
+	metadata *pwManager.BundleMetadata
+}
+
+// Bundles returns the bundles of the user with their latest metadata.
+// Bundles that can't be opened are returned with Error set.
+func (u *unlocked) Bundles(ctx context.Context) ([]*bundle, error) {
+	if u.priKey == nil {
+		return nil, fmt.Errorf("locked")
+	}
+
+	resp, err := u.c.Bundles().List(ctx, u.s.Mount)
+	if err != nil {
+		return nil, fmt.Errorf("error listing bundles: %w", err)
+	}
+
+	var bundles []*bundle
+	for _, b := range resp.Bundles {
+		bundles = append(bundles, &bundle{ID: b.ID, Path: b.Path, Owner: b.OwnerEntityID})
+	}
+	for _, b := range resp.SharedBundles {
+		bundles = append(bundles, &bundle{ID: b.ID, Path: b.Path, Owner: b.OwnerEntityID, Shared: true, Capabilities: b.Capabilities})
+	}
+
+	for _, b := range bundles {
+		if err := u.open(ctx, b); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			b.Error = err.Error()
+		}
+	}
+
+	return bundles, nil
+}
+
+// open unwraps the bundle key, unless the bundle was opened before, and reads
+// the bundle metadata. The key and metadata of a new bundle are created when
+// the user is the owner, the same as the web client does when a bundle is
+// first opened.
+func (u *unlocked) open(ctx context.Context, b *bundle) error {
+	if o, ok := u.opened[b.ID]; ok && o.Path == b.Path {
+		b.kv = o.kv
+	} else {
+		b.kv = u.c.KVBundle(b.Path)
+
+		err := b.kv.Unlock(ctx, u.s.EntityID, u.priKey)
+		if errors.Is(err, pwManager.ErrNotFound) && b.Owner == u.s.EntityID {
+			err = b.kv.Init(ctx, u.s.EntityID, u.pubKey, "")
+		}
+		if errors.Is(err, pwManager.ErrNotFound) {
+			return fmt.Errorf("no bundle key available for user")
+		}
+		if err != nil {
+			return err
+		}
+		u.opened[b.ID] = b
+	}
+
+	var err error
+	b.metadata, err = b.kv.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.Name = b.metadata.BundleName
+	return nil
+}
+
+// bundle returns the bundle with the id or name.
+func (u *unlocked) bundle(ctx context.Context, ref string) (*bundle, error) {
+	bundles, err := u.Bundles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findBundle(bundles, ref)
+}
+
+// findBundle returns the bundle with the id or name.
+func findBundle(bundles []*bundle, ref string) (*bundle, error) {
+	var found []*bundle
+	for _, b := range bundles {
+		if b.ID == ref || (b.Name != "" && strings.EqualFold(b.Name, ref)) {
+			found = append(found, b)
+		}
+	}
+
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("bundle %q not found", ref)
+	case len(found) > 1:
+		return nil, fmt.Errorf("bundle name %q is ambiguous, use the bundle id", ref)
+	case found[0].Error != "":
+		return nil, fmt.Errorf("bundle %q: %s", ref, found[0].Error)
+	}
+
+	return found[0], nil
+}
+
+// entry returns the metadata of the entry with the id or name.
+func (b *bundle) entry(ref string) (pwManager.EntryMetadata, error) {
+	return findEntry(b.metadata.Entries, ref)
+}
+
+// findEntry returns the metadata of the entry with the id or name.
+func findEntry(entries []pwManager.EntryMetadata, ref string) (pwManager.EntryMetadata, error) {
+	var found []pwManager.EntryMetadata
+	for _, m := range entries {
+		if m.ID == ref || strings.EqualFold(m.Name, ref) {
+			found = append(found, m)
+		}
+	}
+
+	switch {
+	case len(found) == 0:
+		return pwManager.EntryMetadata{}, fmt.Errorf("entry %q not found", ref)
+	case len(found) > 1:
+		return pwManager.EntryMetadata{}, fmt.Errorf("entry name %q is ambiguous, use the entry id", ref)
+	}
+
+	return found[0], nil
+}
+
+// Entries returns the entry metadata of the bundle.
+func (u *unlocked) Entries(ctx context.Context, ref string) ([]pwManager.EntryMetadata, error) {
+	b, err := u.bundle(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return b.metadata.Entries, nil
+}
+
+// Entry returns the decrypted entry. The entry metadata is the latest from
+// the bundle metadata.
+func (u *unlocked) Entry(ctx context.Context, bundleRef, entryRef string) (*pwManager.Entry, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := b.entry(entryRef)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := b.kv.Entry(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("error getting entry: %w", err)
+	}
+	e.Metadata = m
+	return e, nil
+}
+
+// openedBundle returns the bundle as opened by the last Bundles or resolves
+// it when it wasn't opened.
+func (u *unlocked) openedBundle(ctx context.Context, b *bundle) (*bundle, error) {
+	if o, ok := u.opened[b.ID]; ok && o.metadata != nil {
+		return o, nil
+	}
+	return u.bundle(ctx, b.ID)
+}
+
+// EntriesIn returns the entry metadata of a bundle returned by Bundles.
+func (u *unlocked) EntriesIn(ctx context.Context, b *bundle) ([]pwManager.EntryMetadata, error) {
+	o, err := u.openedBundle(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return o.metadata.Entries, nil
+}
+
+// EntryIn returns the decrypted entry of a bundle returned by Bundles.
+func (u *unlocked) EntryIn(ctx context.Context, b *bundle, entryRef string) (*pwManager.Entry, error) {
+	o, err := u.openedBundle(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := o.entry(entryRef)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := o.kv.Entry(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("error getting entry: %w", err)
+	}
+	e.Metadata = m
+	return e, nil
+}
+
+// PutEntry saves the entry to the bundle.
+func (u *unlocked) PutEntry(ctx context.Context, ref string, e *pwManager.Entry) (pwManager.EntryMetadata, error) {
+	b, err := u.bundle(ctx, ref)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	e.SyncMetadata()
+	if err := b.kv.PutEntry(ctx, e, b.metadata); err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+	return e.Metadata, nil
+}
+
+// DeleteEntry moves the entry to the trash of the bundle.
+func (u *unlocked) DeleteEntry(ctx context.Context, bundleRef, entryRef string) (pwManager.EntryMetadata, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	m, err := b.entry(entryRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	if _, err := b.kv.TrashEntry(ctx, u.s.Mount, m.ID); err != nil {
+		return pwManager.EntryMetadata{}, fmt.Errorf("error deleting entry: %w", err)
+	}
+	return m, nil
+}
+
+// EntryVersions returns the kv-v2 versions of the entry.
+func (u *unlocked) EntryVersions(ctx context.Context, bundleRef, entryRef string) ([]pwManager.EntryVersion, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := b.entry(entryRef)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := b.kv.EntryVersions(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("error listing entry versions: %w", err)
+	}
+	return versions, nil
+}
+
+// EntryVersion returns the decrypted version of the entry.
+func (u *unlocked) EntryVersion(ctx context.Context, bundleRef, entryRef string, version int) (*pwManager.Entry, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := b.entry(entryRef)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := b.kv.EntryVersion(ctx, m, version)
+	if err != nil {
+		return nil, fmt.Errorf("error getting entry version %d: %w", version, err)
+	}
+	return e, nil
+}
+
+// RestoreEntry writes the version of the entry as its new version.
+func (u *unlocked) RestoreEntry(ctx context.Context, bundleRef, entryRef string, version int) (pwManager.EntryMetadata, error) {
+	b, err := u.bundle(ctx, bundleRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	m, err := b.entry(entryRef)
+	if err != nil {
+		return pwManager.EntryMetadata{}, err
+	}
+
+	e, err := b.kv.RestoreEntry(ctx, m, version, b.metadata)
+	if err != nil {
+		return pwManager.EntryMetadata{}, fmt.Errorf("error restoring entry: %w", err)
+	}
+	return e.Metadata, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+	"github.com/gradientsearch/vault-plugin-secrets-pwmanager/generator"
+)
+
+const (
+	defaultMinEntropy = 60
+	defaultMaxAge     = 365 * 24 * time.Hour
+)
+
+// healthRef identifies an entry, and the field when the issue is with a
+// password field.
+type healthRef struct {
+	BundleID   string `json:"bundle_id"`
+	BundleName string `json:"bundle_name"`
+	EntryID    string `json:"entry_id"`
+	EntryName  string `json:"entry_name"`
+	Field      string `json:"field,omitempty"`
+}
+
+// reusedPassword is a password used by more than one entry.
+type reusedPassword struct {
+	Fields []healthRef `json:"fields"`
+}
+
+// weakPassword is a password with less than the minimum entropy.
+type weakPassword struct {
+	healthRef
+	Entropy float64 `json:"entropy"`
+}
+
+// oldPassword is an entry with passwords not modified within the maximum
+// age.
+type oldPassword struct {
+	healthRef
+	Modified time.Time `json:"modified"`
+}
+
+// healthReport is the result of `pwmgr watchtower`. Passwords are never part
+// of the report.
+type healthReport struct {
+	Reused     []reusedPassword `json:"reused"`
+	Weak       []weakPassword   `json:"weak"`
+	Old        []oldPassword    `json:"old"`
+	Missing2FA []healthRef      `json:"missing_2fa"`
+}
+
+// healthCheck builds a healthReport from the entries it's given.
+type healthCheck struct {
+	minEntropy float64
+	maxAge     time.Duration
+	now        time.Time
+
+	report healthReport
+	// reused groups the password fields by the SHA-256 of the password so
+	// the passwords aren't kept after the entry is checked
+	reused map[[sha256.Size]byte][]healthRef
+	order  [][sha256.Size]byte
+}
+
+func newHealthCheck(minEntropy float64, maxAge time.Duration, now time.Time) *healthCheck {
+	return &healthCheck{
+		minEntropy: minEntropy,
+		maxAge:     maxAge,
+		now:        now,
+		report: healthReport{
+			Reused:     []reusedPassword{},
+			Weak:       []weakPassword{},
+			Old:        []oldPassword{},
+			Missing2FA: []healthRef{},
+		},
+		reused: map[[sha256.Size]byte][]healthRef{},
+	}
+}
+
+// check adds the issues of the entry to the report.
+func (h *healthCheck) check(b *bundle, e *pwManager.Entry) {
+	ref := healthRef{BundleID: b.ID, BundleName: b.Name, EntryID: e.Metadata.ID, EntryName: e.Name}
+
+	hasPassword := false
+	for _, items := range [][]pwManager.Input{e.Core.Items, e.More.Items} {
+		for _, i := range items {
+			if i.Type != "password" || i.Value == "" {
+				continue
+			}
+			hasPassword = true
+
+			field := ref
+			field.Field = i.Label
+
+			sum := sha256.Sum256([]byte(i.Value))
+			if _, ok := h.reused[sum]; !ok {
+				h.order = append(h.order, sum)
+			}
+			h.reused[sum] = append(h.reused[sum], field)
+
+			if entropy := generator.EstimateEntropy(i.Value); entropy < h.minEntropy {
+				h.report.Weak = append(h.report.Weak, weakPassword{healthRef: field, Entropy: entropy})
+			}
+		}
+	}
+
+	// entries read from a kv-v2 mount without created times are skipped
+	if hasPassword && !e.Modified.IsZero() && h.now.Sub(e.Modified) > h.maxAge {
+		h.report.Old = append(h.report.Old, oldPassword{healthRef: ref, Modified: e.Modified})
+	}
+
+	if e.Type == "password" && hasPassword {
+		if _, ok, _ := e.OTP(); !ok {
+			h.report.Missing2FA = append(h.report.Missing2FA, ref)
+		}
+	}
+}
+
+// done returns the report. A password is reused when it's used by more than
+// one entry, the same password in two fields of an entry isn't reported.
+func (h *healthCheck) done() healthReport {
+	for _, sum := range h.order {
+		fields := h.reused[sum]
+		entries := map[string]bool{}
+		for _, f := range fields {
+			entries[f.BundleID+"/"+f.EntryID] = true
+		}
+		if len(entries) > 1 {
+			h.report.Reused = append(h.report.Reused, reusedPassword{Fields: fields})
+		}
+	}
+	clear(h.reused)
+	h.order = nil
+
+	sort.SliceStable(h.report.Weak, func(i, j int) bool {
+		return h.report.Weak[i].Entropy < h.report.Weak[j].Entropy
+	})
+	sort.SliceStable(h.report.Old, func(i, j int) bool {
+		return h.report.Old[i].Modified.Before(h.report.Old[j].Modified)
+	})
+	return h.report
+}
+
+func cmdWatchtower(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("watchtower")
+	minEntropy := fs.Float64("min-entropy", defaultMinEntropy, "passwords with less entropy in bits are weak")
+	maxAge := fs.Duration("max-age", defaultMaxAge, "passwords not modified for longer are old")
+	pos, err := parse(fs, args, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	st, err := a.store(ctx)
+	if err != nil {
+		return err
+	}
+	if u, ok := st.(*unlocked); ok {
+		defer u.lock()
+	}
+
+	h := newHealthCheck(*minEntropy, *maxAge, time.Now())
+	err = a.walkEntries(ctx, st, pos, func(b *bundle, e *pwManager.Entry) error {
+		h.check(b, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	report := h.done()
+
+	if a.json {
+		return a.printJSON(report)
+	}
+
+	rows := [][]string{}
+	row := func(issue string, r healthRef, detail string) {
+		rows = append(rows, []string{issue, r.BundleName, r.EntryName, r.EntryID, r.Field, detail})
+	}
+	for n, reused := range report.Reused {
+		for _, f := range reused.Fields {
+			row("reused", f, fmt.Sprintf("group %d, %d fields", n+1, len(reused.Fields)))
+		}
+	}
+	for _, w := range report.Weak {
+		row("weak", w.healthRef, strconv.FormatFloat(w.Entropy, 'f', 0, 64)+" bits")
+	}
+	for _, o := range report.Old {
+		row("old", o.healthRef, "modified "+o.Modified.Local().Format(time.DateOnly))
+	}
+	for _, r := range report.Missing2FA {
+		row("no 2fa", r, "")
+	}
+
+	if len(rows) == 0 {
+		fmt.Fprintln(a.stdout, "no issues found")
+		return nil
+	}
+	return a.table([]string{"ISSUE", "BUNDLE", "ENTRY", "ID", "FIELD", "DETAIL"}, rows)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchtower(t *testing.T) {
+	srv := newFakeVault(t)
+	ctx := context.Background()
+
+	a, stdout := testApp(t, map[string]string{
+		"Token":      "root",
+		"Password":   testPassword,
+		"Secret key": testSecretKey,
+	})
+	require.NoError(t, a.run(ctx, []string{"login", "-addr", srv.URL}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "github", "username=octocat", "password=hunter2"}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "gitlab", "username=octocat", "password=hunter2"}))
+	require.NoError(t, a.run(ctx, []string{"create", testBundleID, "-name", "bank", "-otp", "otpauth://totp/Bank:bob?secret=JBSWY3DPEHPK3PXP", "password=Xq7#vLp2$mZ9!rT4&wK8"}))
+
+	fv := srv.Config.Handler.(*fakeVault)
+	report := func(args ...string) healthReport {
+		stdout.Reset()
+		fv.bundleLists = 0
+		require.NoError(t, a.run(ctx, append([]string{"watchtower", "-json"}, args...)))
+		// the bundles are listed once, not per entry
+		require.Equal(t, 1, fv.bundleLists)
+
+		var r healthReport
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &r))
+		return r
+	}
+
+	t.Run("issues", func(t *testing.T) {
+		r := report()
+
+		require.Len(t, r.Reused, 1)
+		require.Len(t, r.Reused[0].Fields, 2)
+		require.Equal(t, "github", r.Reused[0].Fields[0].EntryName)
+		require.Equal(t, "gitlab", r.Reused[0].Fields[1].EntryName)
+		require.Equal(t, "Password", r.Reused[0].Fields[0].Field)
+
+		require.Len(t, r.Weak, 2)
+		require.Less(t, r.Weak[0].Entropy, float64(defaultMinEntropy))
+
+		require.Empty(t, r.Old)
+
+		require.Len(t, r.Missing2FA, 2)
+		for _, m := range r.Missing2FA {
+			require.NotEqual(t, "bank", m.EntryName)
+		}
+
+		require.NotContains(t, stdout.String(), "hunter2")
+	})
+
+	t.Run("old", func(t *testing.T) {
+		r := report("-max-age", "1ns")
+		require.Len(t, r.Old, 3)
+		require.False(t, r.Old[0].Modified.IsZero())
+	})
+
+	t.Run("table", func(t *testing.T) {
+		stdout.Reset()
+		require.NoError(t, a.run(ctx, []string{"watchtower", "-min-entropy", "0", testBundleID}))
+
+		out := stdout.String()
+		require.Contains(t, out, "ISSUE")
+		require.Equal(t, 2, strings.Count(out, "reused"))
+		require.Equal(t, 2, strings.Count(out, "no 2fa"))
+		require.NotContains(t, out, "weak")
+		require.NotContains(t, out, "hunter2")
+	})
+}
@@ -0,0 +1,50 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// ParseAgeRecipients parses age X25519 recipients. An argument that isn't an
+// age1 recipient is read as a recipients file with one recipient per line
+// and # comments.
+func ParseAgeRecipients(args []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "age1") {
+			r, err := age.ParseX25519Recipient(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipient %q: %w", arg, err)
+			}
+			recipients = append(recipients, r)
+			continue
+		}
+
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an age recipient or recipients file: %w", arg, err)
+		}
+		rs, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", arg, err)
+		}
+		recipients = append(recipients, rs...)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one age recipient is required")
+	}
+	return recipients, nil
+}
+
+// EncryptAge returns a writer that encrypts to the recipients, see
+// ParseAgeRecipients, and writes an age v1 file to w. The writer must be
+// closed to write the last chunk.
+func EncryptAge(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	return age.Encrypt(w, recipients...)
+}
@@ -0,0 +1,78 @@
+// Package exporter writes bundles to files that can leave pwmanager: KeePass
+// KDBX 4 databases protected by a password, and JSON archives encrypted to
+// age or OpenPGP recipients. Decrypted entries are only held in memory and
+// everything written is encrypted.
+package exporter
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// Format is the format of an export.
+type Format string
+
+const (
+	// KDBX is a KeePass KDBX 4 database
+	KDBX Format = "kdbx"
+	// Age is a JSON Archive encrypted with age, see https://age-encryption.org/v1
+	Age Format = "age"
+	// OpenPGP is a JSON Archive encrypted with OpenPGP
+	OpenPGP Format = "openpgp"
+)
+
+// Formats are all the supported formats.
+var Formats = []Format{KDBX, Age, OpenPGP}
+
+// ArchiveVersion is the version of the Archive layout.
+const ArchiveVersion = 1
+
+// Archive is the JSON document encrypted by the age and OpenPGP exports.
+type Archive struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Bundles []Bundle  `json:"bundles"`
+}
+
+// Bundle is an exported bundle.
+type Bundle struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is the current version of an entry with its earlier versions and
+// attachments.
+type Entry struct {
+	Entry    *pwManager.Entry `json:"entry"`
+	Modified time.Time        `json:"modified"`
+	// History are the earlier versions of the entry, oldest first
+	History     []Version    `json:"history,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Version is an earlier version of an entry.
+type Version struct {
+	Version  int              `json:"version"`
+	Modified time.Time        `json:"modified"`
+	Entry    *pwManager.Entry `json:"entry"`
+}
+
+// Attachment is a decrypted attachment of an entry.
+type Attachment struct {
+	pwManager.Attachment
+	Data []byte `json:"data"`
+}
+
+// WriteArchive writes the bundles as an Archive to w, which is expected to
+// be an encrypting writer, see EncryptAge and EncryptOpenPGP.
+func WriteArchive(w io.Writer, bundles []Bundle) error {
+	return json.NewEncoder(w).Encode(Archive{
+		Version: ArchiveVersion,
+		Created: time.Now().UTC(),
+		Bundles: bundles,
+	})
+}
@@ -0,0 +1,512 @@
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// KDBX 4 as written by KeePass 2.x and KeePassXC, see
+// https://keepass.info/help/kb/kdbx_4.html. The database is encrypted with
+// AES-256-CBC using a key derived from the password with Argon2id. Protected
+// values are additionally encrypted with the ChaCha20 inner random stream.
+const (
+	kdbxSignature1 = 0x9AA2D903
+	kdbxSignature2 = 0xB54BFB67
+	kdbxVersion    = 0x00040000
+
+	// outer header field ids
+	kdbxEndOfHeader      = 0
+	kdbxCipherID         = 2
+	kdbxCompressionFlags = 3
+	kdbxMasterSeed       = 4
+	kdbxEncryptionIV     = 7
+	kdbxKdfParameters    = 11
+
+	// inner header field ids
+	kdbxInnerEnd          = 0
+	kdbxInnerRandomStream = 1
+	kdbxInnerRandomKey    = 2
+	kdbxInnerBinary       = 3
+
+	kdbxGzip              = 1
+	kdbxChaCha20Stream    = 3
+	kdbxBlockSize         = 1 << 20
+	kdbxVariantVersion    = 0x0100
+	kdbxVariantUInt32     = 0x04
+	kdbxVariantUInt64     = 0x05
+	kdbxVariantByteArray  = 0x42
+	kdbxArgon2Version     = 0x13
+	kdbxGenerator         = "pwmgr"
+	kdbxRootGroup         = "pwmanager"
+	kdbxProtectedTrue     = "True"
+	kdbxEpochOffsetSecond = 62135596800
+)
+
+var (
+	kdbxAES256   = []byte{0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff}
+	kdbxArgon2id = []byte{0x9e, 0x29, 0x8b, 0x19, 0x56, 0xdb, 0x47, 0x73, 0xb2, 0x3d, 0xfc, 0x3e, 0xc6, 0xf0, 0xa1, 0xe6}
+)
+
+// kdbxKDF are the Argon2id parameters. Memory is in bytes.
+type kdbxKDF struct {
+	Memory      uint64
+	Iterations  uint64
+	Parallelism uint32
+}
+
+// kdbxArgon2 are close to the KeePassXC defaults, deriving the key takes
+// about a second.
+var kdbxArgon2 = kdbxKDF{Memory: 64 << 20, Iterations: 10, Parallelism: 2}
+
+type kdbxFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kdbxMeta `xml:"Meta"`
+	Root    struct {
+		Group kdbxGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type kdbxMeta struct {
+	Generator        string `xml:"Generator"`
+	DatabaseName     string `xml:"DatabaseName"`
+	MemoryProtection struct {
+		ProtectTitle    string `xml:"ProtectTitle"`
+		ProtectUserName string `xml:"ProtectUserName"`
+		ProtectPassword string `xml:"ProtectPassword"`
+		ProtectURL      string `xml:"ProtectURL"`
+		ProtectNotes    string `xml:"ProtectNotes"`
+	} `xml:"MemoryProtection"`
+	RecycleBinEnabled string `xml:"RecycleBinEnabled"`
+}
+
+// kdbxGroup is a group, the order of the fields is the order protected values
+// are encrypted in, see protect.
+type kdbxGroup struct {
+	UUID    string      `xml:"UUID"`
+	Name    string      `xml:"Name"`
+	Entries []kdbxEntry `xml:"Entry"`
+	Groups  []kdbxGroup `xml:"Group"`
+}
+
+type kdbxEntry struct {
+	UUID     string       `xml:"UUID"`
+	Times    kdbxTimes    `xml:"Times"`
+	Tags     string       `xml:"Tags,omitempty"`
+	Strings  []kdbxString `xml:"String"`
+	Binaries []kdbxBinary `xml:"Binary"`
+	History  *struct {
+		Entries []kdbxEntry `xml:"Entry"`
+	} `xml:"History,omitempty"`
+}
+
+type kdbxTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	Expires              string `xml:"Expires"`
+}
+
+type kdbxString struct {
+	Key   string `xml:"Key"`
+	Value struct {
+		Protected string `xml:"Protected,attr,omitempty"`
+		Value     string `xml:",chardata"`
+	} `xml:"Value"`
+}
+
+type kdbxBinary struct {
+	Key   string `xml:"Key"`
+	Value struct {
+		Ref int `xml:"Ref,attr"`
+	} `xml:"Value"`
+}
+
+// kdbxStandardFields are the KeePass fields entry inputs are mapped onto by
+// their label. Other inputs are written as custom fields.
+var kdbxStandardFields = []struct{ key, label string }{
+	{"UserName", "username"},
+	{"Password", "password"},
+	{"URL", "url"},
+	{"Notes", "notes"},
+}
+
+// WriteKDBX writes the bundles as a KDBX 4 database protected by the
+// password. Each bundle is a group of the database, the history of entries
+// is kept as KeePass entry history and attachments as entry attachments.
+func WriteKDBX(w io.Writer, password string, bundles []Bundle) error {
+	if password == "" {
+		return fmt.Errorf("a password is required")
+	}
+
+	f := kdbxFile{}
+	f.Meta.Generator = kdbxGenerator
+	f.Meta.DatabaseName = kdbxRootGroup
+	f.Meta.MemoryProtection.ProtectTitle = "False"
+	f.Meta.MemoryProtection.ProtectUserName = "False"
+	f.Meta.MemoryProtection.ProtectPassword = kdbxProtectedTrue
+	f.Meta.MemoryProtection.ProtectURL = "False"
+	f.Meta.MemoryProtection.ProtectNotes = "False"
+	f.Meta.RecycleBinEnabled = "False"
+
+	root, err := kdbxUUID("")
+	if err != nil {
+		return err
+	}
+	f.Root.Group = kdbxGroup{UUID: root, Name: kdbxRootGroup}
+
+	var binaries [][]byte
+	for _, b := range bundles {
+		id, err := kdbxUUID(b.ID)
+		if err != nil {
+			return err
+		}
+		g := kdbxGroup{UUID: id, Name: b.Name}
+		if g.Name == "" {
+			g.Name = b.ID
+		}
+
+		for _, e := range b.Entries {
+			entry, err := kdbxNewEntry(e.Entry, e.Modified, "")
+			if err != nil {
+				return err
+			}
+
+			keys := map[string]bool{}
+			for _, a := range e.Attachments {
+				key := uniqueKey(keys, a.Name)
+				bin := kdbxBinary{Key: key}
+				bin.Value.Ref = len(binaries)
+				binaries = append(binaries, a.Data)
+				entry.Binaries = append(entry.Binaries, bin)
+			}
+
+			if len(e.History) > 0 {
+				entry.History = &struct {
+					Entries []kdbxEntry `xml:"Entry"`
+				}{}
+				for _, v := range e.History {
+					h, err := kdbxNewEntry(v.Entry, v.Modified, entry.UUID)
+					if err != nil {
+						return err
+					}
+					entry.History.Entries = append(entry.History.Entries, h)
+				}
+				entry.Times.CreationTime = entry.History.Entries[0].Times.CreationTime
+			}
+
+			g.Entries = append(g.Entries, entry)
+		}
+		f.Root.Group.Groups = append(f.Root.Group.Groups, g)
+	}
+
+	innerKey := make([]byte, 64)
+	if _, err := rand.Read(innerKey); err != nil {
+		return err
+	}
+	streamKey := sha512.Sum512(innerKey)
+	stream, err := chacha20.NewUnauthenticatedCipher(streamKey[:32], streamKey[32:44])
+	if err != nil {
+		return err
+	}
+	f.Root.Group.protect(stream)
+
+	// the inner header and xml are compressed and encrypted together
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	writeKDBXInnerField(gz, kdbxInnerRandomStream, binary.LittleEndian.AppendUint32(nil, kdbxChaCha20Stream))
+	writeKDBXInnerField(gz, kdbxInnerRandomKey, innerKey)
+	for _, b := range binaries {
+		writeKDBXInnerField(gz, kdbxInnerBinary, append([]byte{0}, b...))
+	}
+	writeKDBXInnerField(gz, kdbxInnerEnd, nil)
+
+	io.WriteString(gz, xml.Header)
+	enc := xml.NewEncoder(gz)
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	masterSeed := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	salt := make([]byte, 32)
+	for _, b := range [][]byte{masterSeed, iv, salt} {
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+	}
+
+	header := kdbxHeader(masterSeed, iv, salt, kdbxArgon2)
+	transformed := kdbxArgon2.key(password, salt)
+
+	encKey := sha256.Sum256(append(append([]byte{}, masterSeed...), transformed...))
+	hmacKey := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformed...), 1))
+
+	block, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		return err
+	}
+	plaintext := pkcs7Pad(payload.Bytes(), aes.BlockSize)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	var out bytes.Buffer
+	out.Write(header)
+	headerHash := sha256.Sum256(header)
+	out.Write(headerHash[:])
+	out.Write(kdbxBlockHMAC(hmacKey[:], math.MaxUint64, header))
+
+	var index uint64
+	for len(ciphertext) > 0 {
+		n := min(len(ciphertext), kdbxBlockSize)
+		writeKDBXBlock(&out, hmacKey[:], index, ciphertext[:n])
+		ciphertext = ciphertext[n:]
+		index++
+	}
+	writeKDBXBlock(&out, hmacKey[:], index, nil)
+
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// kdbxNewEntry maps the inputs of e onto KeePass fields. History entries
+// have the UUID of the current entry.
+func kdbxNewEntry(e *pwManager.Entry, modified time.Time, id string) (kdbxEntry, error) {
+	if id == "" {
+		var err error
+		id, err = kdbxUUID(e.Metadata.ID)
+		if err != nil {
+			return kdbxEntry{}, err
+		}
+	}
+
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	t := kdbxTime(modified)
+	entry := kdbxEntry{
+		UUID:  id,
+		Times: kdbxTimes{CreationTime: t, LastModificationTime: t, LastAccessTime: t, Expires: "False"},
+		Tags:  strings.Join(e.Tags, ";"),
+	}
+
+	keys := map[string]bool{"Title": true}
+	entry.Strings = append(entry.Strings, newKDBXString("Title", e.Name, false))
+
+	var inputs []pwManager.Input
+	inputs = append(inputs, e.Core.Items...)
+	inputs = append(inputs, e.More.Items...)
+
+	used := make([]bool, len(inputs))
+	for _, sf := range kdbxStandardFields {
+		value := ""
+		for i, in := range inputs {
+			if !used[i] && strings.EqualFold(in.Label, sf.label) {
+				used[i] = true
+				value = in.Value
+				break
+			}
+		}
+		keys[sf.key] = true
+		entry.Strings = append(entry.Strings, newKDBXString(sf.key, value, sf.key == "Password"))
+	}
+
+	otp := false
+	for i, in := range inputs {
+		if used[i] || in.Value == "" {
+			continue
+		}
+		if in.Type == pwManager.OTPInputType && !otp {
+			// the KeePassXC otp field
+			otp = true
+			keys["otp"] = true
+			entry.Strings = append(entry.Strings, newKDBXString("otp", in.Value, true))
+			continue
+		}
+		key := uniqueKey(keys, in.Label)
+		entry.Strings = append(entry.Strings, newKDBXString(key, in.Value, in.Type == "password" || in.Type == pwManager.OTPInputType))
+	}
+
+	return entry, nil
+}
+
+func newKDBXString(key, value string, protected bool) kdbxString {
+	s := kdbxString{Key: key}
+	s.Value.Value = value
+	if protected {
+		s.Value.Protected = kdbxProtectedTrue
+	}
+	return s
+}
+
+// uniqueKey returns key, suffixed with a number when it is already in keys
+// ignoring case, and adds it to keys.
+func uniqueKey(keys map[string]bool, key string) string {
+	if strings.TrimSpace(key) == "" {
+		key = "field"
+	}
+
+	taken := func(k string) bool {
+		for used := range keys {
+			if strings.EqualFold(used, k) {
+				return true
+			}
+		}
+		return false
+	}
+
+	unique := key
+	for i := 2; taken(unique); i++ {
+		unique = key + " " + strconv.Itoa(i)
+	}
+	keys[unique] = true
+	return unique
+}
+
+// protect encrypts the protected values with the inner random stream in the
+// order they are written.
+func (g *kdbxGroup) protect(stream cipher.Stream) {
+	for i := range g.Entries {
+		g.Entries[i].protect(stream)
+	}
+	for i := range g.Groups {
+		g.Groups[i].protect(stream)
+	}
+}
+
+func (e *kdbxEntry) protect(stream cipher.Stream) {
+	for i := range e.Strings {
+		v := &e.Strings[i].Value
+		if v.Protected != kdbxProtectedTrue {
+			continue
+		}
+		b := []byte(v.Value)
+		stream.XORKeyStream(b, b)
+		v.Value = base64.StdEncoding.EncodeToString(b)
+	}
+	if e.History != nil {
+		for i := range e.History.Entries {
+			e.History.Entries[i].protect(stream)
+		}
+	}
+}
+
+// kdbxUUID returns the base64 encoded bytes of the uuid, or of a random uuid
+// when id isn't a uuid.
+func kdbxUUID(id string) (string, error) {
+	b, err := uuid.ParseUUID(id)
+	if err != nil {
+		b = make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// kdbxTime encodes t as the base64 little endian seconds since
+// 0001-01-01 UTC.
+func kdbxTime(t time.Time) string {
+	return base64.StdEncoding.EncodeToString(binary.LittleEndian.AppendUint64(nil, uint64(t.Unix()+kdbxEpochOffsetSecond)))
+}
+
+// key derives the transformed key from the password composite key.
+func (k kdbxKDF) key(password string, salt []byte) []byte {
+	pw := sha256.Sum256([]byte(password))
+	composite := sha256.Sum256(pw[:])
+	return argon2.IDKey(composite[:], salt, uint32(k.Iterations), uint32(k.Memory/1024), uint8(k.Parallelism), 32)
+}
+
+// kdbxHeader returns the outer header.
+func kdbxHeader(masterSeed, iv, salt []byte, kdf kdbxKDF) []byte {
+	var h bytes.Buffer
+	binary.Write(&h, binary.LittleEndian, uint32(kdbxSignature1))
+	binary.Write(&h, binary.LittleEndian, uint32(kdbxSignature2))
+	binary.Write(&h, binary.LittleEndian, uint32(kdbxVersion))
+
+	field := func(id byte, data []byte) {
+		h.WriteByte(id)
+		binary.Write(&h, binary.LittleEndian, uint32(len(data)))
+		h.Write(data)
+	}
+
+	var params bytes.Buffer
+	binary.Write(&params, binary.LittleEndian, uint16(kdbxVariantVersion))
+	variant := func(typ byte, key string, value []byte) {
+		params.WriteByte(typ)
+		binary.Write(&params, binary.LittleEndian, int32(len(key)))
+		params.WriteString(key)
+		binary.Write(&params, binary.LittleEndian, int32(len(value)))
+		params.Write(value)
+	}
+	variant(kdbxVariantByteArray, "$UUID", kdbxArgon2id)
+	variant(kdbxVariantByteArray, "S", salt)
+	variant(kdbxVariantUInt32, "P", binary.LittleEndian.AppendUint32(nil, kdf.Parallelism))
+	variant(kdbxVariantUInt64, "M", binary.LittleEndian.AppendUint64(nil, kdf.Memory))
+	variant(kdbxVariantUInt64, "I", binary.LittleEndian.AppendUint64(nil, kdf.Iterations))
+	variant(kdbxVariantUInt32, "V", binary.LittleEndian.AppendUint32(nil, kdbxArgon2Version))
+	params.WriteByte(0)
+
+	field(kdbxCipherID, kdbxAES256)
+	field(kdbxCompressionFlags, binary.LittleEndian.AppendUint32(nil, kdbxGzip))
+	field(kdbxMasterSeed, masterSeed)
+	field(kdbxEncryptionIV, iv)
+	field(kdbxKdfParameters, params.Bytes())
+	field(kdbxEndOfHeader, []byte("\r\n\r\n"))
+	return h.Bytes()
+}
+
+func writeKDBXInnerField(w io.Writer, id byte, data []byte) {
+	w.Write([]byte{id})
+	binary.Write(w, binary.LittleEndian, int32(len(data)))
+	w.Write(data)
+}
+
+// kdbxBlockHMAC returns the HMAC of the block with the index. The header
+// HMAC is computed as the block with the index 2^64-1.
+func kdbxBlockHMAC(hmacKey []byte, index uint64, data []byte) []byte {
+	key := sha512.Sum512(append(binary.LittleEndian.AppendUint64(nil, index), hmacKey...))
+	mac := hmac.New(sha256.New, key[:])
+	if index == math.MaxUint64 {
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+	binary.Write(mac, binary.LittleEndian, int32(len(data)))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func writeKDBXBlock(w *bytes.Buffer, hmacKey []byte, index uint64, data []byte) {
+	w.Write(kdbxBlockHMAC(hmacKey, index, data))
+	binary.Write(w, binary.LittleEndian, int32(len(data)))
+	w.Write(data)
+}
+
+func pkcs7Pad(b []byte, size int) []byte {
+	n := size - len(b)%size
+	return append(b, bytes.Repeat([]byte{byte(n)}, n)...)
+}
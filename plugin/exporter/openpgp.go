@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// ReadOpenPGPRecipients reads the public keys of the recipients from
+// armored or binary key files.
+func ReadOpenPGPRecipients(files []string) (openpgp.EntityList, error) {
+	var recipients openpgp.EntityList
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var keys openpgp.EntityList
+		if block, err := armor.Decode(bytes.NewReader(b)); err == nil {
+			keys, err = openpgp.ReadKeyRing(block.Body)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+		} else {
+			keys, err = openpgp.ReadKeyRing(bytes.NewReader(b))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+		}
+		recipients = append(recipients, keys...)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one OpenPGP recipient is required")
+	}
+	return recipients, nil
+}
+
+// EncryptOpenPGP returns a writer that encrypts to the recipients and writes
+// an unsigned binary OpenPGP message to w. The writer must be closed to
+// finish the message.
+func EncryptOpenPGP(w io.Writer, recipients openpgp.EntityList) (io.WriteCloser, error) {
+	return openpgp.Encrypt(w, recipients, nil, &openpgp.FileHints{IsBinary: true, FileName: "pwmanager.json"}, nil)
+}
@@ -236,12 +236,12 @@ func (t *TestHarness) WithUserpassAuth(mount string, users []string, adminUser s
 			userInfo.TokenPolicies = append(userInfo.TokenPolicies, fmt.Sprintf("%s/admin/default", mount))
 		}
 
-		if err := t.Client.Userpass().User("userpass", u, userInfo); err != nil {
+		if err := t.Client.Userpass().User(context.Background(), "userpass", u, userInfo); err != nil {
 			t.Testing.Fatalf("failed to create user %s", err)
 		}
 		t.Testing.Logf("successfully created user %s in /userpass\n", u)
 
-		if lr, err := t.Client.Userpass().Login("userpass", u, userInfo); err != nil {
+		if lr, err := t.Client.Userpass().Login(context.Background(), "userpass", u, userInfo); err != nil {
 			t.Testing.Fatalf("failed to create user %s", err)
 		} else {
 			t.Testing.Logf("successfully logged in user %s to /userpass\n", u)
@@ -255,8 +255,9 @@ func (t *TestHarness) WithUserpassAuth(mount string, users []string, adminUser s
 }
 
 func (t *TestHarness) WithAppRole() error {
+	ctx := context.Background()
 	mount := "approle"
-	err := t.Client.AppRole().Enable(mount)
+	err := t.Client.AppRole().Enable(ctx, mount)
 	if err != nil {
 		t.Testing.Fatalf("error creating app auth method: %s\n", err)
 	}
@@ -265,30 +266,34 @@ func (t *TestHarness) WithAppRole() error {
 
 	roleName := "pwmanager"
 
-	err = t.Client.AppRole().CreateRole(mount, roleName, data)
+	err = t.Client.AppRole().CreateRole(ctx, mount, roleName, data)
 	if err != nil {
 		t.Testing.Fatalf("error creating app role: %s\n", err)
 	}
 
-	rid, err := t.Client.AppRole().RoleID(mount, roleName)
+	rid, err := t.Client.AppRole().RoleID(ctx, mount, roleName)
 	if err != nil {
 		t.Testing.Fatalf("error creating app role: %s\n", err)
 	}
 
 	data = fmt.Sprintf(`{"role_id": "%s"}`, rid)
-	sd, err := t.Client.AppRole().SecretID(mount, roleName, data)
+	sd, err := t.Client.AppRole().SecretID(ctx, mount, roleName, data)
 	if err != nil {
 		t.Testing.Fatalf("error creating app role: %s\n", err)
 	}
 
 	// NOTE this is running in a container so vault is listening on 8200 not the docker exposed port!
-	data = fmt.Sprintf(`{"role_id": "%s", "secret_id": "%s", "url": "%s"}`, rid.Data.RoleID, sd.Data.SecretID, "127.0.0.1:8200")
-	err = t.Client.PwManager().Config("pwmanager", data)
+	config := Config{
+		RoleID:   rid.Data.RoleID,
+		SecretID: sd.Data.SecretID,
+		URL:      "127.0.0.1:8200",
+	}
+	err = t.Client.PwManager().Config(ctx, "pwmanager", config)
 	if err != nil {
 		t.Testing.Fatalf("error configuring pwmanager: %s\n", err)
 	}
 
-	err = t.Client.PwManager().Config("pwmanager", data)
+	err = t.Client.PwManager().Config(ctx, "pwmanager", config)
 	if err != nil {
 		t.Testing.Fatalf("error configuring pwmanager: %s\n", err)
 	}
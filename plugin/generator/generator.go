@@ -0,0 +1,504 @@
+// Package generator generates random passwords, pronounceable passwords and
+// diceware style passphrases. Rules defined by an admin on the pwmanager
+// mount, see `generator/rules`, are applied to every generated password so
+// all clients generate compliant passwords.
+package generator
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+// Class is a character class.
+type Class string
+
+const (
+	Lower   Class = "lower"
+	Upper   Class = "upper"
+	Digits  Class = "digits"
+	Symbols Class = "symbols"
+)
+
+// Classes are all the character classes.
+var Classes = []Class{Lower, Upper, Digits, Symbols}
+
+var charsets = map[Class]string{
+	Lower:   "abcdefghijklmnopqrstuvwxyz",
+	Upper:   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	Digits:  "0123456789",
+	Symbols: "!#$%&*+-=?@^_~",
+}
+
+// Kind is the kind of password generated.
+type Kind string
+
+const (
+	Random        Kind = "random"
+	Pronounceable Kind = "pronounceable"
+	Passphrase    Kind = "passphrase"
+)
+
+const (
+	defaultLength    = 20
+	defaultWords     = 5
+	defaultSeparator = "-"
+
+	// pronounceable passwords alternate consonants and vowels
+	consonants = "bcdfghjklmnprstvz"
+	vowels     = "aeiou"
+)
+
+// wordlist is 2048 common English words, 3 to 8 letters long, where the first
+// four letters of each word are unique. It is based on the BIP-39 English
+// wordlist.
+//
+//go:embed wordlist.txt
+var wordlist string
+
+// Words returns the passphrase wordlist.
+func Words() []string {
+	var words []string
+	s := bufio.NewScanner(strings.NewReader(wordlist))
+	for s.Scan() {
+		if w := strings.TrimSpace(s.Text()); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// Rules are the requirements a generated password must meet.
+type Rules struct {
+	// MinLength is the minimum number of characters
+	MinLength int `json:"min_length" mapstructure:"min_length"`
+	// MinWords is the minimum number of passphrase words
+	MinWords int `json:"min_words" mapstructure:"min_words"`
+	// RequiredClasses must each appear in the password
+	RequiredClasses []Class `json:"required_classes" mapstructure:"required_classes"`
+	// ExcludedChars never appear in the password, e.g. characters a system
+	// doesn't accept or that are easily confused like 0 and O
+	ExcludedChars string `json:"excluded_chars" mapstructure:"excluded_chars"`
+	// MinEntropy is the minimum estimated entropy in bits
+	MinEntropy float64 `json:"min_entropy" mapstructure:"min_entropy"`
+}
+
+// Validate checks the rules can be met.
+func (r Rules) Validate() error {
+	if r.MinLength < 0 || r.MinLength > 1024 {
+		return fmt.Errorf("min_length must be between 0 and 1024")
+	}
+	if r.MinWords < 0 || r.MinWords > 64 {
+		return fmt.Errorf("min_words must be between 0 and 64")
+	}
+	if r.MinEntropy < 0 || r.MinEntropy > 512 {
+		return fmt.Errorf("min_entropy must be between 0 and 512")
+	}
+
+	for _, c := range r.RequiredClasses {
+		charset, ok := charsets[c]
+		if !ok {
+			return fmt.Errorf("unknown character class %q", c)
+		}
+		if exclude(charset, r.ExcludedChars) == "" {
+			return fmt.Errorf("excluded_chars excludes every character of the required class %q", c)
+		}
+	}
+	return nil
+}
+
+// required reports whether the class is required.
+func (r Rules) required(c Class) bool {
+	for _, rc := range r.RequiredClasses {
+		if rc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error when the password doesn't meet the rules. The
+// entropy is estimated with EstimateEntropy.
+func (r Rules) Check(password string) error {
+	if n := len([]rune(password)); n < r.MinLength {
+		return fmt.Errorf("password must be at least %d characters", r.MinLength)
+	}
+
+	for _, c := range r.RequiredClasses {
+		if !strings.ContainsAny(password, charsets[c]) {
+			return fmt.Errorf("password must contain %s characters", c)
+		}
+	}
+
+	if r.ExcludedChars != "" && strings.ContainsAny(password, r.ExcludedChars) {
+		return fmt.Errorf("password must not contain any of %q", r.ExcludedChars)
+	}
+
+	if e := EstimateEntropy(password); e < r.MinEntropy {
+		return fmt.Errorf("password entropy of %.0f bits is below the minimum of %.0f bits", e, r.MinEntropy)
+	}
+	return nil
+}
+
+// Options configure the generated password. Rules take precedence over the
+// options e.g. the length is raised to the minimum length.
+type Options struct {
+	Kind Kind `json:"kind"`
+	// Length is the number of characters of random and pronounceable
+	// passwords
+	Length int `json:"length"`
+	// Words is the number of passphrase words
+	Words     int    `json:"words"`
+	Separator string `json:"separator"`
+	// Classes are the character classes of random passwords, every class
+	// appears in the password. Defaults to all classes.
+	Classes []Class `json:"classes"`
+	// Exclude are characters excluded in addition to the rules
+	Exclude string `json:"exclude"`
+}
+
+// Password is a generated password and its estimated entropy in bits.
+type Password struct {
+	Value   string  `json:"value"`
+	Entropy float64 `json:"entropy"`
+}
+
+// Generate generates a password meeting the rules.
+func Generate(opts Options, rules Rules) (Password, error) {
+	// the excluded characters of the options are validated with the rules so
+	// they can't exclude a required class
+	rules.ExcludedChars += opts.Exclude
+	if err := rules.Validate(); err != nil {
+		return Password{}, err
+	}
+
+	var p Password
+	var err error
+	switch opts.Kind {
+	case Random, "":
+		p, err = generateRandom(opts, rules)
+	case Pronounceable:
+		p, err = generatePronounceable(opts, rules)
+	case Passphrase:
+		p, err = generatePassphrase(opts, rules)
+	default:
+		return Password{}, fmt.Errorf("unknown kind %q", opts.Kind)
+	}
+	if err != nil {
+		return Password{}, err
+	}
+
+	if p.Entropy < rules.MinEntropy {
+		return Password{}, fmt.Errorf("password entropy of %.0f bits is below the minimum of %.0f bits", p.Entropy, rules.MinEntropy)
+	}
+
+	// the entropy estimate of Check doesn't apply to generated passwords
+	rules.MinEntropy = 0
+	if err := rules.Check(p.Value); err != nil {
+		return Password{}, err
+	}
+	return p, nil
+}
+
+// generateRandom picks characters uniformly from the classes. Passwords
+// missing a class are discarded so every class appears.
+func generateRandom(opts Options, rules Rules) (Password, error) {
+	selected := opts.Classes
+	if len(selected) == 0 {
+		selected = Classes
+	}
+
+	var classes []Class
+	for _, c := range append(append([]Class{}, selected...), rules.RequiredClasses...) {
+		if !containsClass(classes, c) {
+			classes = append(classes, c)
+		}
+	}
+
+	var alphabet string
+	var sets []string
+	for _, c := range classes {
+		charset, ok := charsets[c]
+		if !ok {
+			return Password{}, fmt.Errorf("unknown character class %q", c)
+		}
+		charset = exclude(charset, rules.ExcludedChars)
+		if charset == "" {
+			return Password{}, fmt.Errorf("every character of the class %q is excluded", c)
+		}
+		alphabet += charset
+		sets = append(sets, charset)
+	}
+
+	if len(alphabet) < 2 {
+		return Password{}, fmt.Errorf("excluded characters leave less than two characters")
+	}
+
+	bits := math.Log2(float64(len(alphabet)))
+	length := max(opts.Length, rules.MinLength, len(sets), int(math.Ceil(rules.MinEntropy/bits)))
+	if opts.Length == 0 {
+		length = max(length, defaultLength)
+	}
+
+	b := make([]byte, length)
+	for attempt := 0; attempt < 1000; attempt++ {
+		for i := range b {
+			n, err := randInt(len(alphabet))
+			if err != nil {
+				return Password{}, err
+			}
+			b[i] = alphabet[n]
+		}
+
+		if containsAll(string(b), sets) {
+			return Password{Value: string(b), Entropy: float64(length) * bits}, nil
+		}
+	}
+	return Password{}, fmt.Errorf("unable to generate a password with every character class, increase the length")
+}
+
+// generatePronounceable alternates consonants and vowels. A required upper
+// case letter replaces a random letter and required digits and symbols are
+// appended.
+func generatePronounceable(opts Options, rules Rules) (Password, error) {
+	cs := exclude(consonants, rules.ExcludedChars)
+	vs := exclude(vowels, rules.ExcludedChars)
+	if cs == "" || vs == "" {
+		return Password{}, fmt.Errorf("excluded characters leave no consonants or vowels")
+	}
+
+	var suffix []string
+	for _, c := range []Class{Digits, Symbols} {
+		if rules.required(c) {
+			set := exclude(charsets[c], rules.ExcludedChars)
+			if set == "" {
+				return Password{}, fmt.Errorf("excluded characters leave no characters of the required class %q", c)
+			}
+			suffix = append(suffix, set)
+		}
+	}
+
+	// entropy of the letters, starting with a consonant or a vowel
+	entropy := func(letters int) float64 {
+		e := 1 + float64(letters/2)*(math.Log2(float64(len(cs)))+math.Log2(float64(len(vs))))
+		if letters%2 == 1 {
+			e += math.Log2(float64(min(len(cs), len(vs))))
+		}
+		if rules.required(Upper) {
+			e += math.Log2(float64(letters))
+		}
+		for _, s := range suffix {
+			e += math.Log2(float64(len(s)))
+		}
+		return e
+	}
+
+	length := max(opts.Length, rules.MinLength, len(suffix)+1)
+	if opts.Length == 0 {
+		length = max(length, defaultLength)
+	}
+	for entropy(length-len(suffix)) < rules.MinEntropy {
+		length++
+	}
+	letters := length - len(suffix)
+
+	start, err := randInt(2)
+	if err != nil {
+		return Password{}, err
+	}
+
+	b := make([]byte, 0, length)
+	for i := 0; i < letters; i++ {
+		set := cs
+		if (i+start)%2 == 1 {
+			set = vs
+		}
+		c, err := randChar(set)
+		if err != nil {
+			return Password{}, err
+		}
+		b = append(b, c)
+	}
+
+	if rules.required(Upper) {
+		var allowed []int
+		for i, c := range b {
+			if !strings.ContainsRune(rules.ExcludedChars, unicode.ToUpper(rune(c))) {
+				allowed = append(allowed, i)
+			}
+		}
+		if len(allowed) == 0 {
+			return Password{}, fmt.Errorf("unable to generate a password with an upper case letter")
+		}
+
+		i, err := randInt(len(allowed))
+		if err != nil {
+			return Password{}, err
+		}
+		b[allowed[i]] = byte(unicode.ToUpper(rune(b[allowed[i]])))
+	}
+
+	for _, s := range suffix {
+		c, err := randChar(s)
+		if err != nil {
+			return Password{}, err
+		}
+		b = append(b, c)
+	}
+
+	return Password{Value: string(b), Entropy: entropy(letters)}, nil
+}
+
+// generatePassphrase picks words from the wordlist. When required, words
+// are capitalized, a digit is appended to a random word and the separator is
+// replaced by a random symbol.
+func generatePassphrase(opts Options, rules Rules) (Password, error) {
+	var words []string
+	for _, w := range Words() {
+		if rules.required(Upper) {
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		if rules.ExcludedChars == "" || !strings.ContainsAny(w, rules.ExcludedChars) {
+			words = append(words, w)
+		}
+	}
+	if len(words) < 2 {
+		return Password{}, fmt.Errorf("excluded characters leave no words")
+	}
+	bits := math.Log2(float64(len(words)))
+
+	sep := opts.Separator
+	if sep == "" {
+		sep = defaultSeparator
+	}
+	if rules.ExcludedChars != "" && strings.ContainsAny(sep, rules.ExcludedChars) {
+		return Password{}, fmt.Errorf("separator %q is excluded", sep)
+	}
+
+	digits := exclude(charsets[Digits], rules.ExcludedChars)
+	if rules.required(Digits) && digits == "" {
+		return Password{}, fmt.Errorf("excluded characters leave no characters of the required class %q", Digits)
+	}
+
+	var entropy float64
+	if rules.required(Symbols) && !strings.ContainsAny(sep, charsets[Symbols]) {
+		symbols := exclude(charsets[Symbols], rules.ExcludedChars)
+		if symbols == "" {
+			return Password{}, fmt.Errorf("excluded characters leave no characters of the required class %q", Symbols)
+		}
+		c, err := randChar(symbols)
+		if err != nil {
+			return Password{}, err
+		}
+		sep = string(c)
+		entropy += math.Log2(float64(len(symbols)))
+	}
+
+	n := max(opts.Words, rules.MinWords)
+	if opts.Words == 0 {
+		n = max(n, defaultWords)
+	}
+
+	var phrase []string
+	for len(phrase) < n || entropy+float64(len(phrase))*bits < rules.MinEntropy ||
+		len(strings.Join(phrase, sep)) < rules.MinLength {
+		i, err := randInt(len(words))
+		if err != nil {
+			return Password{}, err
+		}
+		phrase = append(phrase, words[i])
+	}
+	entropy += float64(len(phrase)) * bits
+
+	if rules.required(Digits) {
+		i, err := randInt(len(phrase))
+		if err != nil {
+			return Password{}, err
+		}
+		d, err := randChar(digits)
+		if err != nil {
+			return Password{}, err
+		}
+		phrase[i] += string(d)
+		entropy += math.Log2(float64(len(phrase) * len(digits)))
+	}
+
+	return Password{Value: strings.Join(phrase, sep), Entropy: entropy}, nil
+}
+
+// EstimateEntropy estimates the entropy of a password in bits assuming each
+// character was picked at random from the classes it uses. Passwords chosen
+// by people have far less entropy than estimated.
+func EstimateEntropy(password string) float64 {
+	pool := 0
+	for _, c := range Classes {
+		if strings.ContainsAny(password, charsets[c]) {
+			pool += len(charsets[c])
+		}
+	}
+	known := charsets[Lower] + charsets[Upper] + charsets[Digits] + charsets[Symbols]
+	for _, r := range password {
+		// other symbols and non ASCII characters
+		if !strings.ContainsRune(known, r) {
+			pool += 32
+			break
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(len([]rune(password))) * math.Log2(float64(pool))
+}
+
+// exclude removes the excluded characters from the charset.
+func exclude(charset, excluded string) string {
+	if excluded == "" {
+		return charset
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(excluded, r) {
+			return -1
+		}
+		return r
+	}, charset)
+}
+
+func containsClass(classes []Class, c Class) bool {
+	for _, cc := range classes {
+		if cc == c {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(s string, sets []string) bool {
+	for _, set := range sets {
+		if !strings.ContainsAny(s, set) {
+			return false
+		}
+	}
+	return true
+}
+
+// randInt returns a uniform random int in [0, n).
+func randInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+func randChar(set string) (byte, error) {
+	i, err := randInt(len(set))
+	if err != nil {
+		return 0, err
+	}
+	return set[i], nil
+}
@@ -0,0 +1,129 @@
+package generator
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWords(t *testing.T) {
+	words := Words()
+	require.Len(t, words, 2048)
+
+	prefixes := map[string]bool{}
+	for _, w := range words {
+		require.Regexp(t, `^[a-z]{3,8}$`, w)
+		p := w[:min(4, len(w))]
+		require.False(t, prefixes[p], "duplicate prefix %q", p)
+		prefixes[p] = true
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("random", func(t *testing.T) {
+		p, err := Generate(Options{}, Rules{})
+		require.NoError(t, err)
+		require.Len(t, p.Value, defaultLength)
+		for _, c := range Classes {
+			require.True(t, strings.ContainsAny(p.Value, charsets[c]), c)
+		}
+		require.InDelta(t, 20*math.Log2(76), p.Entropy, 0.001)
+
+		p, err = Generate(Options{Length: 8, Classes: []Class{Digits}}, Rules{})
+		require.NoError(t, err)
+		require.Regexp(t, `^[0-9]{8}$`, p.Value)
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		rules := Rules{MinLength: 16, RequiredClasses: []Class{Symbols}, ExcludedChars: "0O1lI"}
+		for i := 0; i < 50; i++ {
+			p, err := Generate(Options{Length: 8, Classes: []Class{Lower, Upper, Digits}}, rules)
+			require.NoError(t, err)
+			require.Len(t, p.Value, 16)
+			require.NoError(t, rules.Check(p.Value))
+			require.False(t, strings.ContainsAny(p.Value, "0O1lI"))
+		}
+
+		p, err := Generate(Options{Length: 8, Classes: []Class{Digits}}, Rules{MinEntropy: 64})
+		require.NoError(t, err)
+		require.Len(t, p.Value, 20)
+		require.GreaterOrEqual(t, p.Entropy, 64.0)
+	})
+
+	t.Run("pronounceable", func(t *testing.T) {
+		rules := Rules{RequiredClasses: []Class{Upper, Digits, Symbols}}
+		p, err := Generate(Options{Kind: Pronounceable, Length: 12}, rules)
+		require.NoError(t, err)
+		require.Len(t, p.Value, 12)
+		require.Regexp(t, `^[a-zA-Z]{10}[0-9][!#$%&*+\-=?@^_~]$`, p.Value)
+		require.NoError(t, rules.Check(p.Value))
+
+		letters := strings.ToLower(p.Value[:10])
+		for i := 1; i < len(letters); i++ {
+			require.NotEqual(t, strings.ContainsRune(vowels, rune(letters[i-1])), strings.ContainsRune(vowels, rune(letters[i])), letters)
+		}
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		p, err := Generate(Options{Kind: Passphrase}, Rules{})
+		require.NoError(t, err)
+		require.Len(t, strings.Split(p.Value, "-"), defaultWords)
+		require.InDelta(t, 55, p.Entropy, 0.001)
+
+		rules := Rules{MinWords: 4, RequiredClasses: []Class{Upper, Digits, Symbols}, ExcludedChars: "-"}
+		p, err = Generate(Options{Kind: Passphrase, Words: 3, Separator: " "}, rules)
+		require.NoError(t, err)
+		require.NoError(t, rules.Check(p.Value))
+		require.Len(t, strings.Fields(p.Value), 1)
+		for _, r := range p.Value {
+			require.True(t, unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(charsets[Symbols], r))
+		}
+
+		_, err = Generate(Options{Kind: Passphrase, Separator: "-"}, Rules{ExcludedChars: "-"})
+		require.ErrorContains(t, err, "separator")
+
+		p, err = Generate(Options{Kind: Passphrase, Words: 2}, Rules{MinLength: 40})
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, len(p.Value), 40)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		for _, r := range []Rules{
+			{MinLength: -1},
+			{RequiredClasses: []Class{"emoji"}},
+			{RequiredClasses: []Class{Digits}, ExcludedChars: "0123456789"},
+			{MinEntropy: 1000},
+		} {
+			require.Error(t, r.Validate(), r)
+			_, err := Generate(Options{}, r)
+			require.Error(t, err)
+		}
+	})
+
+	t.Run("excluded required class", func(t *testing.T) {
+		rules := Rules{RequiredClasses: []Class{Digits}}
+		for _, kind := range []Kind{Random, Pronounceable, Passphrase} {
+			_, err := Generate(Options{Kind: kind, Exclude: "0123456789"}, rules)
+			require.ErrorContains(t, err, "required class", kind)
+		}
+	})
+}
+
+func TestCheck(t *testing.T) {
+	rules := Rules{MinLength: 8, RequiredClasses: []Class{Digits}, ExcludedChars: " ", MinEntropy: 40}
+	require.NoError(t, rules.Check("hunter2hunter"))
+	require.ErrorContains(t, rules.Check("hunter2"), "at least 8")
+	require.ErrorContains(t, rules.Check("huntertwo"), "digits")
+	require.ErrorContains(t, rules.Check("hunter 2hunter"), "must not contain")
+	require.ErrorContains(t, rules.Check("12345678"), "entropy")
+}
+
+func TestEstimateEntropy(t *testing.T) {
+	require.Equal(t, 0.0, EstimateEntropy(""))
+	require.InDelta(t, 8*math.Log2(26), EstimateEntropy("password"), 0.001)
+	require.InDelta(t, 4*math.Log2(62), EstimateEntropy("aB3d"), 0.001)
+	require.InDelta(t, 3*math.Log2(26+32), EstimateEntropy("añb"), 0.001)
+}
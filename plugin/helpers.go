@@ -1,6 +1,13 @@
 package secretsengine
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
 
 // copied from vault/login_mfa.go in main vault repo
 // uuidRegex crafts a regex for use in URL paths, somewhat similar to framework.GenericNameRegex, but only accepting
@@ -12,3 +19,22 @@ import "fmt"
 func uuidRegex(name string) string {
 	return fmt.Sprintf("(?P<%s>[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})", name)
 }
+
+// clientErrorResponse maps an error returned by the pwmanagerClient to a response
+// with a matching status code. Unclassified errors are returned as an error response.
+func clientErrorResponse(err error) (*logical.Response, error) {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil, err
+	case errors.Is(err, ErrPermissionDenied):
+		return nil, logical.ErrPermissionDenied
+	case errors.Is(err, ErrNotFound):
+		return nil, logical.CodedError(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrCASMismatch):
+		return nil, logical.CodedError(http.StatusConflict, err.Error())
+	case errors.Is(err, ErrSealed):
+		return nil, logical.CodedError(http.StatusServiceUnavailable, err.Error())
+	}
+
+	return logical.ErrorResponse(err.Error()), nil
+}
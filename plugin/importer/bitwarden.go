@@ -0,0 +1,188 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// bitwardenExport is a Bitwarden JSON export, see
+// https://bitwarden.com/help/condition-bitwarden-import/.
+type bitwardenExport struct {
+	Encrypted   bool              `json:"encrypted"`
+	Folders     []bitwardenFolder `json:"folders"`
+	Collections []bitwardenFolder `json:"collections"`
+	Items       []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	Type          int              `json:"type"`
+	Name          string           `json:"name"`
+	Notes         string           `json:"notes"`
+	FolderID      string           `json:"folderId"`
+	CollectionIDs []string         `json:"collectionIds"`
+	Fields        []bitwardenField `json:"fields"`
+	Login         *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+		URIs     []struct {
+			URI string `json:"uri"`
+		} `json:"uris"`
+	} `json:"login"`
+	Card     map[string]string `json:"card"`
+	Identity map[string]string `json:"identity"`
+	SSHKey   *struct {
+		PrivateKey string `json:"privateKey"`
+		PublicKey  string `json:"publicKey"`
+	} `json:"sshKey"`
+	PasswordHistory []json.RawMessage `json:"passwordHistory"`
+}
+
+type bitwardenField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  int    `json:"type"`
+}
+
+// Bitwarden item types
+const (
+	bitwardenLogin    = 1
+	bitwardenNote     = 2
+	bitwardenCard     = 3
+	bitwardenIdentity = 4
+	bitwardenSSHKey   = 5
+)
+
+// Bitwarden custom field types
+const (
+	bitwardenText    = 0
+	bitwardenHidden  = 1
+	bitwardenBoolean = 2
+	bitwardenLinked  = 3
+)
+
+// bitwardenCardFields and bitwardenIdentityFields are the card and identity
+// properties in the order they are imported.
+var (
+	bitwardenCardFields = []property{
+		{"cardholder name", "text", "cardholderName"},
+		{"brand", "text", "brand"},
+		{"number", "password", "number"},
+		{"security code", "password", "code"},
+	}
+	bitwardenIdentityFields = []property{
+		{"title", "text", "title"},
+		{"first name", "text", "firstName"},
+		{"middle name", "text", "middleName"},
+		{"last name", "text", "lastName"},
+		{"company", "text", "company"},
+		{"email", "text", "email"},
+		{"phone", "text", "phone"},
+		{"address", "text", "address1"},
+		{"address 2", "text", "address2"},
+		{"address 3", "text", "address3"},
+		{"city", "text", "city"},
+		{"state", "text", "state"},
+		{"postal code", "text", "postalCode"},
+		{"country", "text", "country"},
+		{"ssn", "password", "ssn"},
+		{"passport number", "password", "passportNumber"},
+		{"license number", "password", "licenseNumber"},
+	}
+)
+
+func parseBitwarden(res *Result, r io.Reader) error {
+	var export bitwardenExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return err
+	}
+	if export.Encrypted {
+		return fmt.Errorf("encrypted exports are not supported, export the vault as unencrypted JSON")
+	}
+
+	folders := map[string]string{}
+	for _, f := range append(export.Folders, export.Collections...) {
+		folders[f.ID] = f.Name
+	}
+
+	for _, item := range export.Items {
+		rec := record{name: item.Name, notes: item.Notes}
+		if name, ok := folders[item.FolderID]; ok {
+			rec.tags = append(rec.tags, name)
+		}
+		for _, id := range item.CollectionIDs {
+			if name, ok := folders[id]; ok {
+				rec.tags = append(rec.tags, name)
+			}
+		}
+
+		switch item.Type {
+		case bitwardenLogin:
+			if item.Login != nil {
+				rec.username = item.Login.Username
+				rec.password = item.Login.Password
+				rec.otp = item.Login.TOTP
+				for _, u := range item.Login.URIs {
+					if u.URI != "" {
+						rec.urls = append(rec.urls, u.URI)
+					}
+				}
+			}
+		case bitwardenNote:
+		case bitwardenCard:
+			for _, f := range bitwardenCardFields {
+				rec.add(f.label, f.typ, item.Card[f.key])
+			}
+			if month, year := item.Card["expMonth"], item.Card["expYear"]; month != "" || year != "" {
+				if len(month) == 1 {
+					month = "0" + month
+				}
+				rec.add("expiration", "text", strings.Trim(month+"/"+year, "/"))
+			}
+			res.ambiguous(item.Name, "", "card imported as a password entry")
+		case bitwardenIdentity:
+			rec.username = item.Identity["username"]
+			for _, f := range bitwardenIdentityFields {
+				rec.add(f.label, f.typ, item.Identity[f.key])
+			}
+			res.ambiguous(item.Name, "", "identity imported as a password entry")
+		case bitwardenSSHKey:
+			rec.ssh = true
+			if item.SSHKey != nil {
+				rec.privateKey = item.SSHKey.PrivateKey
+				rec.publicKey = item.SSHKey.PublicKey
+			}
+		default:
+			res.skip(item.Name, "", fmt.Sprintf("unknown item type %d", item.Type))
+			continue
+		}
+
+		for _, f := range item.Fields {
+			switch f.Type {
+			case bitwardenText, bitwardenBoolean:
+				rec.add(f.Name, "text", f.Value)
+			case bitwardenHidden:
+				rec.add(f.Name, "password", f.Value)
+			case bitwardenLinked:
+				res.skip(item.Name, f.Name, "linked fields are not supported")
+			default:
+				res.skip(item.Name, f.Name, fmt.Sprintf("unknown field type %d", f.Type))
+			}
+		}
+
+		if len(item.PasswordHistory) > 0 {
+			res.skip(item.Name, "password history", "previous passwords are not imported")
+		}
+
+		res.add(rec)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvColumns maps the header names used by the CSV exports of 1Password,
+// Bitwarden, LastPass and most other password managers onto record
+// properties. Headers are compared case insensitively.
+var csvColumns = map[string]string{
+	"name":           "name",
+	"title":          "name",
+	"username":       "username",
+	"login_username": "username",
+	"user":           "username",
+	"login":          "username",
+	"password":       "password",
+	"login_password": "password",
+	"url":            "url",
+	"uri":            "url",
+	"login_uri":      "url",
+	"website":        "url",
+	"notes":          "notes",
+	"note":           "notes",
+	"extra":          "notes",
+	"totp":           "otp",
+	"otp":            "otp",
+	"otpauth":        "otp",
+	"login_totp":     "otp",
+	"tags":           "tags",
+	"folder":         "tags",
+	"grouping":       "tags",
+	"group":          "tags",
+	"archived":       "archived",
+	// columns without a value worth importing
+	"favorite": "",
+	"fav":      "",
+	"reprompt": "",
+	"type":     "",
+}
+
+func parseCSV(res *Result, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("error reading header: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	known := false
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		header[i] = h
+		property, ok := csvColumns[strings.ToLower(h)]
+		if !ok {
+			columns[i] = "field"
+			res.ambiguous("", h, "unknown column imported as a text field")
+			continue
+		}
+		columns[i] = property
+		known = known || property != ""
+	}
+	if !known {
+		return fmt.Errorf("header has no known columns")
+	}
+
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rec := record{}
+		archived := false
+		for i, value := range row {
+			if i >= len(columns) {
+				res.skip(fmt.Sprintf("line %d", line), "", "value without a column")
+				break
+			}
+			switch columns[i] {
+			case "name":
+				rec.name = value
+			case "username":
+				rec.username = value
+			case "password":
+				rec.password = value
+			case "url":
+				if value != "" {
+					rec.urls = append(rec.urls, value)
+				}
+			case "notes":
+				rec.notes = value
+			case "otp":
+				rec.otp = value
+			case "tags":
+				rec.tags = append(rec.tags, strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' })...)
+			case "archived":
+				archived = strings.EqualFold(value, "true") || value == "1"
+			case "field":
+				rec.add(header[i], "text", value)
+			}
+		}
+
+		if archived {
+			res.skip(rec.name, "", "archived items are not imported")
+			continue
+		}
+		res.add(rec)
+	}
+}
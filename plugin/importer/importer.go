@@ -0,0 +1,252 @@
+// Package importer converts the exports of other password managers into
+// pwmanager entries. Records are mapped onto the Core and More inputs of
+// password and SSH key entries and their folders, groups or tags become entry
+// tags. Anything that can't be mapped faithfully is listed in the Report so
+// it can be checked by hand after the import.
+package importer
+
+import (
+	"fmt"
+	"io"
+	neturl "net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	pwManager "github.com/gradientsearch/vault-plugin-secrets-pwmanager"
+)
+
+// Format is the format of an export.
+type Format string
+
+const (
+	// Bitwarden is an unencrypted Bitwarden JSON export
+	Bitwarden Format = "bitwarden"
+	// OnePUX is a 1Password .1pux export
+	OnePUX Format = "1pux"
+	// OnePasswordCSV is a 1Password CSV export
+	OnePasswordCSV Format = "1password-csv"
+	// KeePass is a KeePass 2 XML export
+	KeePass Format = "keepass"
+	// CSV is a CSV file with a header row, see csvColumns
+	CSV Format = "csv"
+)
+
+// Formats are all the supported formats.
+var Formats = []Format{Bitwarden, OnePUX, OnePasswordCSV, KeePass, CSV}
+
+// Issue is a record or field that was skipped or could not be mapped
+// unambiguously. Record is empty when the issue applies to every record.
+type Issue struct {
+	Record string `json:"record"`
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Report lists the entries imported and the issues found.
+type Report struct {
+	Imported int `json:"imported"`
+	// Skipped records and fields are not imported
+	Skipped []Issue `json:"skipped"`
+	// Ambiguous records and fields are imported but should be checked
+	Ambiguous []Issue `json:"ambiguous"`
+}
+
+// Result is the entries parsed from an export. The entries are new, see
+// KVBundle.ImportEntries.
+type Result struct {
+	Entries []*pwManager.Entry `json:"entries"`
+	Report  Report             `json:"report"`
+}
+
+// Parse parses the export read from r.
+func Parse(format Format, r io.Reader) (*Result, error) {
+	res := &Result{
+		Entries: []*pwManager.Entry{},
+		Report:  Report{Skipped: []Issue{}, Ambiguous: []Issue{}},
+	}
+
+	var err error
+	switch format {
+	case Bitwarden:
+		err = parseBitwarden(res, r)
+	case OnePUX:
+		err = parseOnePUX(res, r)
+	case OnePasswordCSV, CSV:
+		err = parseCSV(res, r)
+	case KeePass:
+		err = parseKeePass(res, r)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s export: %w", format, err)
+	}
+
+	res.Report.Imported = len(res.Entries)
+	return res, nil
+}
+
+// record is an item of an export before it is mapped onto an entry.
+type record struct {
+	name     string
+	username string
+	password string
+	urls     []string
+	notes    string
+	otp      string
+	fields   []field
+	tags     []string
+
+	// ssh records are imported as SSH key entries
+	ssh        bool
+	privateKey string
+	publicKey  string
+}
+
+// field is an additional input of a record.
+type field struct {
+	label string
+	// typ is the input type, text, password or date
+	typ   string
+	value string
+}
+
+// property is a property of an export item imported as a field.
+type property struct {
+	label string
+	typ   string
+	key   string
+}
+
+func (r *record) add(label, typ, value string) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	r.fields = append(r.fields, field{label: label, typ: typ, value: value})
+}
+
+func (res *Result) skip(record, field, reason string) {
+	res.Report.Skipped = append(res.Report.Skipped, Issue{Record: record, Field: field, Reason: reason})
+}
+
+func (res *Result) ambiguous(record, field, reason string) {
+	res.Report.Ambiguous = append(res.Report.Ambiguous, Issue{Record: record, Field: field, Reason: reason})
+}
+
+// add maps the record onto a new entry.
+func (res *Result) add(r record) {
+	r.name = strings.TrimSpace(r.name)
+	if r.name == "" {
+		r.name = "Imported item"
+		if len(r.urls) > 0 {
+			if u, err := neturl.Parse(r.urls[0]); err == nil && u.Hostname() != "" {
+				r.name = u.Hostname()
+			}
+		}
+		res.ambiguous(r.name, "name", "record has no name")
+	}
+
+	if r.username == "" && r.password == "" && len(r.urls) == 0 && r.notes == "" &&
+		r.otp == "" && len(r.fields) == 0 && r.privateKey == "" && r.publicKey == "" {
+		res.skip(r.name, "", "record is empty")
+		return
+	}
+
+	var e *pwManager.Entry
+	if r.ssh {
+		e = pwManager.NewSSHKeyEntry()
+		e.SetField("private key", r.privateKey)
+		e.SetField("public key", r.publicKey)
+		if r.password != "" {
+			e.SetField("passphrase", r.password)
+		}
+		addField(e, "text", "username", r.username)
+	} else {
+		e = pwManager.NewPasswordEntry()
+		e.SetField("username", r.username)
+		e.SetField("Password", r.password)
+	}
+	e.Name = r.name
+
+	for i, u := range r.urls {
+		label := "url"
+		if i > 0 {
+			label = "url " + strconv.Itoa(i+1)
+		}
+		addField(e, "text", label, u)
+	}
+
+	if r.otp != "" {
+		if uri, ok := res.otpURI(r); ok {
+			e.SetOTP(uri)
+		}
+	}
+
+	for _, f := range r.fields {
+		if label := addField(e, f.typ, f.label, f.value); !strings.EqualFold(label, f.label) {
+			res.ambiguous(r.name, f.label, fmt.Sprintf("field renamed to %q as the label is already used", label))
+		}
+	}
+	addField(e, "text", "notes", r.notes)
+
+	e.Tags = []string{}
+	for _, t := range r.tags {
+		if t = strings.TrimSpace(t); t != "" && !slices.Contains(e.Tags, t) {
+			e.Tags = append(e.Tags, t)
+		}
+	}
+
+	e.SyncMetadata()
+	res.Entries = append(res.Entries, e)
+}
+
+// otpURI returns the otpauth:// URI of the record OTP. Exports that only hold
+// the secret are assumed to be a TOTP with the default SHA1, 6 digits and 30
+// second period.
+func (res *Result) otpURI(r record) (string, bool) {
+	s := strings.TrimSpace(r.otp)
+	if strings.HasPrefix(s, "otpauth://") {
+		o, err := pwManager.ParseOTPAuthURI(s)
+		if err != nil {
+			res.skip(r.name, "otp", err.Error())
+			return "", false
+		}
+		return o.URI(), true
+	}
+
+	if strings.HasPrefix(s, "steam://") {
+		res.skip(r.name, "otp", "steam guard codes are not supported")
+		return "", false
+	}
+
+	q := neturl.Values{}
+	q.Set("secret", s)
+	q.Set("issuer", r.name)
+	o, err := pwManager.ParseOTPAuthURI((&neturl.URL{Scheme: "otpauth", Host: "totp", Path: "/" + r.username, RawQuery: q.Encode()}).String())
+	if err != nil {
+		res.skip(r.name, "otp", err.Error())
+		return "", false
+	}
+	res.ambiguous(r.name, "otp", "secret without parameters imported as a 6 digit, 30 second SHA1 TOTP")
+	return o.URI(), true
+}
+
+// addField appends a More input to e and returns its label. Labels already
+// used by an input of e are suffixed with a number.
+func addField(e *pwManager.Entry, typ, label, value string) string {
+	if strings.TrimSpace(value) == "" {
+		return label
+	}
+
+	unique := label
+	for i := 2; ; i++ {
+		if _, ok := e.Field(unique); !ok {
+			break
+		}
+		unique = label + " " + strconv.Itoa(i)
+	}
+
+	e.More.Items = append(e.More.Items, pwManager.Input{Type: typ, Label: unique, Placeholder: unique, Value: value})
+	return unique
+}
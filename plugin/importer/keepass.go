@@ -0,0 +1,140 @@
+package importer
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// keepassFile is a KeePass 2 XML export, the format written by KeePass
+// `File > Export > KeePass XML (2.x)` and KeePassXC `Export > XML`.
+type keepassFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    struct {
+		RecycleBinUUID string `xml:"RecycleBinUUID"`
+	} `xml:"Meta"`
+	Root struct {
+		Groups []keepassGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type keepassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keepassEntry `xml:"Entry"`
+	Groups  []keepassGroup `xml:"Group"`
+}
+
+// keepassEntry is an entry of a group. Earlier versions of the entry in
+// History are not decoded.
+type keepassEntry struct {
+	Tags    string `xml:"Tags"`
+	Strings []struct {
+		Key   string `xml:"Key"`
+		Value struct {
+			Value string `xml:",chardata"`
+			// ProtectInMemory is set on values the user marked as hidden
+			ProtectInMemory string `xml:"ProtectInMemory,attr"`
+			// Protected values are encrypted with the inner stream key of
+			// the database and can't be read from the XML
+			Protected string `xml:"Protected,attr"`
+		} `xml:"Value"`
+	} `xml:"String"`
+	Binaries []struct {
+		Key string `xml:"Key"`
+	} `xml:"Binary"`
+}
+
+// keepassOTPKeys hold the OTP of an entry. KeePassXC stores an otpauth URI
+// in `otp` and KeePass 2.47+ stores the base32 secret in
+// `TimeOtp-Secret-Base32`.
+var keepassOTPKeys = map[string]bool{"otp": true, "TimeOtp-Secret-Base32": true}
+
+func parseKeePass(res *Result, r io.Reader) error {
+	var f keepassFile
+	if err := xml.NewDecoder(r).Decode(&f); err != nil {
+		return err
+	}
+
+	// the root group is the database itself so its name is not a tag
+	for _, root := range f.Root.Groups {
+		for _, e := range root.Entries {
+			parseKeePassEntry(res, e, nil)
+		}
+		for _, g := range root.Groups {
+			parseKeePassGroup(res, g, nil, f.Meta.RecycleBinUUID)
+		}
+	}
+	return nil
+}
+
+func parseKeePassGroup(res *Result, g keepassGroup, path []string, recycleBin string) {
+	if recycleBin != "" && g.UUID == recycleBin {
+		if len(g.Entries) > 0 || len(g.Groups) > 0 {
+			res.skip("", g.Name, "entries in the recycle bin are not imported")
+		}
+		return
+	}
+
+	path = append(path[:len(path):len(path)], g.Name)
+	for _, e := range g.Entries {
+		parseKeePassEntry(res, e, path)
+	}
+	for _, child := range g.Groups {
+		parseKeePassGroup(res, child, path, recycleBin)
+	}
+}
+
+func parseKeePassEntry(res *Result, e keepassEntry, path []string) {
+	rec := record{}
+	for _, s := range e.Strings {
+		if s.Key == "Title" {
+			rec.name = s.Value.Value
+		}
+	}
+
+	for _, s := range e.Strings {
+		value := s.Value.Value
+		if strings.EqualFold(s.Value.Protected, "true") {
+			res.skip(rec.name, s.Key, "protected value is encrypted, export the database as XML")
+			continue
+		}
+
+		switch {
+		case s.Key == "Title":
+		case s.Key == "UserName":
+			rec.username = value
+		case s.Key == "Password":
+			rec.password = value
+		case s.Key == "URL":
+			if value != "" {
+				rec.urls = append(rec.urls, value)
+			}
+		case s.Key == "Notes":
+			rec.notes = value
+		case keepassOTPKeys[s.Key]:
+			rec.otp = value
+		case strings.HasPrefix(s.Key, "TimeOtp-") || strings.HasPrefix(s.Key, "HmacOtp-"):
+			if value != "" {
+				res.skip(rec.name, s.Key, "OTP settings other than a base32 TOTP secret are not supported")
+			}
+		case strings.EqualFold(s.Value.ProtectInMemory, "true"):
+			rec.add(s.Key, "password", value)
+		default:
+			rec.add(s.Key, "text", value)
+		}
+	}
+
+	for _, b := range e.Binaries {
+		res.skip(rec.name, b.Key, "attachments are not imported, attach the file with `pwmgr attach`")
+	}
+
+	if len(path) > 0 {
+		rec.tags = append(rec.tags, strings.Join(path, "/"))
+	}
+	for _, t := range strings.FieldsFunc(e.Tags, func(r rune) bool { return r == ',' || r == ';' }) {
+		rec.tags = append(rec.tags, t)
+	}
+
+	res.add(rec)
+}
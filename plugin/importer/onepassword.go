@@ -0,0 +1,235 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// onePUXExport is the export.data file of a 1Password .1pux export, see
+// https://support.1password.com/1pux-format/.
+type onePUXExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePUXItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePUXItem struct {
+	State        string `json:"state"`
+	CategoryUUID string `json:"categoryUuid"`
+	Details      struct {
+		LoginFields []struct {
+			Value       string `json:"value"`
+			Name        string `json:"name"`
+			FieldType   string `json:"fieldType"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		// Password is the password of Password category items
+		Password string `json:"password"`
+		Sections []struct {
+			Title  string        `json:"title"`
+			Fields []onePUXField `json:"fields"`
+		} `json:"sections"`
+		PasswordHistory []json.RawMessage `json:"passwordHistory"`
+	} `json:"details"`
+	Overview struct {
+		Title string   `json:"title"`
+		URL   string   `json:"url"`
+		Tags  []string `json:"tags"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+	} `json:"overview"`
+}
+
+// onePUXField is a section field. Value is an object with a single key, the
+// type of the value, e.g. {"concealed": "..."}.
+type onePUXField struct {
+	Title string                     `json:"title"`
+	ID    string                     `json:"id"`
+	Value map[string]json.RawMessage `json:"value"`
+}
+
+// 1Password item categories
+const (
+	onePUXLogin    = "001"
+	onePUXCard     = "002"
+	onePUXNote     = "003"
+	onePUXIdentity = "004"
+	onePUXPassword = "005"
+	onePUXDocument = "006"
+	onePUXSSHKey   = "114"
+)
+
+func parseOnePUX(res *Result, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return fmt.Errorf("1pux export is not a zip archive: %w", err)
+	}
+
+	f, err := zr.Open("export.data")
+	if err != nil {
+		return fmt.Errorf("1pux export has no export.data: %w", err)
+	}
+	defer f.Close()
+
+	var export onePUXExport
+	if err := json.NewDecoder(f).Decode(&export); err != nil {
+		return err
+	}
+
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			for _, item := range vault.Items {
+				parseOnePUXItem(res, item, vault.Attrs.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func parseOnePUXItem(res *Result, item onePUXItem, vault string) {
+	name := item.Overview.Title
+	if item.State != "" && item.State != "active" {
+		res.skip(name, "", fmt.Sprintf("%s items are not imported", item.State))
+		return
+	}
+
+	rec := record{name: name, notes: item.Details.NotesPlain, tags: append([]string{vault}, item.Overview.Tags...)}
+
+	switch item.CategoryUUID {
+	case onePUXLogin, onePUXPassword, onePUXNote:
+	case onePUXSSHKey:
+		rec.ssh = true
+	case onePUXDocument:
+		res.skip(name, "", "documents are not imported, attach the file with `pwmgr attach`")
+		return
+	case onePUXCard:
+		res.ambiguous(name, "", "credit card imported as a password entry")
+	case onePUXIdentity:
+		res.ambiguous(name, "", "identity imported as a password entry")
+	default:
+		res.ambiguous(name, "", fmt.Sprintf("category %s imported as a password entry", item.CategoryUUID))
+	}
+
+	rec.password = item.Details.Password
+	for _, f := range item.Details.LoginFields {
+		switch {
+		case f.Designation == "username":
+			rec.username = f.Value
+		case f.Designation == "password":
+			rec.password = f.Value
+		case f.FieldType == "P":
+			rec.add(f.Name, "password", f.Value)
+		case f.FieldType == "T" || f.FieldType == "E" || f.FieldType == "U":
+			rec.add(f.Name, "text", f.Value)
+		case f.Value != "":
+			res.skip(name, f.Name, fmt.Sprintf("login field of type %q is not supported", f.FieldType))
+		}
+	}
+
+	if item.Overview.URL != "" {
+		rec.urls = append(rec.urls, item.Overview.URL)
+	}
+	for _, u := range item.Overview.URLs {
+		if u.URL != "" && u.URL != item.Overview.URL {
+			rec.urls = append(rec.urls, u.URL)
+		}
+	}
+
+	for _, s := range item.Details.Sections {
+		for _, f := range s.Fields {
+			label := f.Title
+			if label == "" {
+				label = f.ID
+			}
+			parseOnePUXField(res, &rec, name, label, f.Value)
+		}
+	}
+
+	if len(item.Details.PasswordHistory) > 0 {
+		res.skip(name, "password history", "previous passwords are not imported")
+	}
+
+	res.add(rec)
+}
+
+// parseOnePUXField adds the value of a section field to rec.
+func parseOnePUXField(res *Result, rec *record, name, label string, value map[string]json.RawMessage) {
+	for typ, raw := range value {
+		var s string
+		_ = json.Unmarshal(raw, &s)
+
+		switch typ {
+		case "concealed", "creditCardNumber":
+			rec.add(label, "password", s)
+		case "string", "url", "phone", "menu", "creditCardType", "gender":
+			rec.add(label, "text", s)
+		case "email":
+			var email struct {
+				Address string `json:"email_address"`
+			}
+			if json.Unmarshal(raw, &email) == nil {
+				s = email.Address
+			}
+			rec.add(label, "text", s)
+		case "totp":
+			if rec.otp != "" {
+				res.skip(name, label, "only the first one-time password is imported")
+				continue
+			}
+			rec.otp = s
+		case "date":
+			var seconds int64
+			if json.Unmarshal(raw, &seconds) == nil && seconds != 0 {
+				rec.add(label, "date", time.Unix(seconds, 0).UTC().Format(time.DateOnly))
+			}
+		case "monthYear":
+			var ym int
+			if json.Unmarshal(raw, &ym) == nil && ym != 0 {
+				rec.add(label, "text", fmt.Sprintf("%02d/%d", ym%100, ym/100))
+			}
+		case "address":
+			var a map[string]string
+			_ = json.Unmarshal(raw, &a)
+			parts := []string{}
+			for _, k := range []string{"street", "city", "state", "zip", "country"} {
+				if a[k] != "" {
+					parts = append(parts, a[k])
+				}
+			}
+			rec.add(label, "text", strings.Join(parts, ", "))
+		case "sshKey":
+			var key struct {
+				PrivateKey string `json:"privateKey"`
+				Metadata   struct {
+					PublicKey string `json:"publicKey"`
+				} `json:"metadata"`
+			}
+			if json.Unmarshal(raw, &key) == nil {
+				rec.ssh = true
+				rec.privateKey = key.PrivateKey
+				rec.publicKey = key.Metadata.PublicKey
+			}
+		default:
+			if len(raw) > 0 && string(raw) != `""` && string(raw) != "null" {
+				res.skip(name, label, fmt.Sprintf("field of type %q is not supported", typ))
+			}
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package secretsengine
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	mapstructure "github.com/go-viper/mapstructure/v2"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	ACCOUNT_SCHEMA = "account"
+
+	// AccountPackageVersion is the version of the account package payload,
+	// imports reject packages of other versions.
+	AccountPackageVersion = 1
+
+	accountSigningKeyStoragePath = ACCOUNT_SCHEMA + "/signing_key"
+	accountTrustedKeysSchema     = ACCOUNT_SCHEMA + "/trusted_keys"
+	// accountImportedSchema holds the SHA-256 of the imported package
+	// payloads so a package is only imported once
+	accountImportedSchema = ACCOUNT_SCHEMA + "/imported"
+
+	// accountPackageDefaultTTL and accountPackageMaxTTL bound how long a
+	// package can be imported after its export
+	accountPackageDefaultTTL = time.Hour
+	accountPackageMaxTTL     = 7 * 24 * time.Hour
+)
+
+// pwmgrAccountPackage is a users account exported to move it to another
+// mount. The payload is a pwmgrAccountExport signed with the ed25519 signing
+// key of the exporting mount. The payload is kept as bytes so the signature
+// survives re-encoding of the package.
+type pwmgrAccountPackage struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// pwmgrAccountExport is the signed payload of an account package.
+type pwmgrAccountExport struct {
+	Version int   `json:"version"`
+	Created int64 `json:"created"`
+	// Expires is the unix time after which the package is rejected
+	Expires int64 `json:"expires"`
+	// Mount is the path of the mount the package is imported on
+	Mount    string               `json:"mount"`
+	EntityID string               `json:"entity_id"`
+	User     pwManagerUserEntry   `json:"user"`
+	Bundles  []pwmgrAccountBundle `json:"bundles"`
+}
+
+// pwmgrAccountImported records the import of a package.
+type pwmgrAccountImported struct {
+	EntityID string `json:"entity_id"`
+	Imported int64  `json:"imported"`
+}
+
+// pwmgrAccountBundle is an owned bundle of an exported account. The paths of
+// the secrets are relative to the bundle path.
+type pwmgrAccountBundle struct {
+	// Bundle is the bundle record, its users are only kept so they can be
+	// invited again after the import
+	Bundle      pwmgrBundle            `json:"bundle"`
+	Secrets     []pwmgrSnapshotSecret  `json:"secrets"`
+	Attachments []pwmgrAttachmentUsage `json:"attachments"`
+}
+
+// Exported names of the account types.
+type (
+	AccountPackage = pwmgrAccountPackage
+	AccountExport  = pwmgrAccountExport
+	AccountBundle  = pwmgrAccountBundle
+)
+
+// pathAccount extends the Vault API with the export of a users account into
+// a signed package and its import on another mount. Mounts only import
+// packages signed by a key registered under `account/trusted_keys`.
+func pathAccount(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "account/export",
+			Fields: map[string]*framework.FieldSchema{
+				"mount": {
+					Type:        framework.TypeString,
+					Description: "path of the mount the package is imported on",
+					Required:    true,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "how long the package can be imported, 1 hour by default and at most 7 days",
+					Default:     int(accountPackageDefaultTTL.Seconds()),
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathAccountExport,
+				},
+			},
+			HelpSynopsis:    pathAccountHelpSynopsis,
+			HelpDescription: pathAccountHelpDescription,
+		},
+		{
+			Pattern: "account/import",
+			Fields: map[string]*framework.FieldSchema{
+				"package": {
+					Type:        framework.TypeMap,
+					Description: "account package returned by account/export",
+					Required:    true,
+				},
+				"uuk": {
+					Type:        framework.TypeMap,
+					Description: "the UUK of the package rewrapped for the mount and the callers entity id",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAccountImport,
+				},
+			},
+			HelpSynopsis:    pathAccountHelpSynopsis,
+			HelpDescription: pathAccountHelpDescription,
+		},
+		{
+			Pattern: "account/signing_key",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathAccountSigningKeyRead,
+				},
+			},
+			HelpSynopsis:    pathAccountHelpSynopsis,
+			HelpDescription: pathAccountHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s", accountTrustedKeysSchema, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "name of the mount the key belongs to",
+					Required:    true,
+				},
+				"public_key": {
+					Type:        framework.TypeString,
+					Description: "base64 encoded ed25519 public key read from account/signing_key of the mount",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathAccountTrustedKeyRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathAccountTrustedKeyWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAccountTrustedKeyWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathAccountTrustedKeyDelete,
+				},
+			},
+			HelpSynopsis:    pathAccountHelpSynopsis,
+			HelpDescription: pathAccountHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/?$", accountTrustedKeysSchema),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathAccountTrustedKeysList,
+				},
+			},
+			HelpSynopsis:    pathAccountHelpSynopsis,
+			HelpDescription: pathAccountHelpDescription,
+		},
+	}
+}
+
+///////////////////////// export /////////////////////////
+
+// pathAccountExport returns the callers user entry and owned bundles with
+// their kv-v2 data as a package signed by the mount.
+func (b *pwManagerBackend) pathAccountExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	mount := strings.Trim(data.Get("mount").(string), "/")
+	if mount == "" {
+		return logical.ErrorResponse("mount is required"), nil
+	}
+	ttl := time.Duration(data.Get("ttl").(int)) * time.Second
+	if ttl <= 0 || ttl > accountPackageMaxTTL {
+		return logical.ErrorResponse("ttl must be between 1s and %s", accountPackageMaxTTL), nil
+	}
+
+	user, err := b.getUser(ctx, req.Storage, req.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return logical.ErrorResponse("user not registered"), nil
+	}
+	if b.c == nil {
+		return logical.ErrorResponse("pwmanager mount not configured. configure at /config"), nil
+	}
+
+	bundles, err := b.listBundles(ctx, req.Storage, req.EntityID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	export := pwmgrAccountExport{
+		Version:  AccountPackageVersion,
+		Created:  now.Unix(),
+		Expires:  now.Add(ttl).Unix(),
+		Mount:    mount,
+		EntityID: req.EntityID,
+		User:     *user,
+		Bundles:  []pwmgrAccountBundle{},
+	}
+	for _, pb := range bundles {
+		secrets, err := b.readBundleSecrets(ctx, pb.Path)
+		if err != nil {
+			return clientErrorResponse(fmt.Errorf("error reading bundle %s: %w", pb.ID, err))
+		}
+		for i := range secrets {
+			secrets[i].Path = strings.TrimPrefix(secrets[i].Path, pb.Path+"/")
+		}
+
+		attachments, err := listAttachmentUsage(ctx, req.Storage, pb.OwnerEntityID, pb.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		export.Bundles = append(export.Bundles, pwmgrAccountBundle{Bundle: pb, Secrets: secrets, Attachments: attachments})
+	}
+
+	payload, err := json.Marshal(export)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := getAccountSigningKey(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"package": pwmgrAccountPackage{
+				Payload:   payload,
+				Signature: ed25519.Sign(key, payload),
+				PublicKey: key.Public().(ed25519.PublicKey),
+			},
+		},
+	}, nil
+}
+
+///////////////////////// import /////////////////////////
+
+// pathAccountImport registers the caller with the user entry of a package
+// signed by a trusted mount and re-creates its bundles under the callers
+// entity id. Bundles are imported without users, who have to be invited
+// again.
+func (b *pwManagerBackend) pathAccountImport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var pkg pwmgrAccountPackage
+	raw, err := json.Marshal(data.Get("package"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &pkg); err != nil {
+		return logical.ErrorResponse("error decoding package: %s", err), nil
+	}
+
+	export, resp, err := b.verifyAccountPackage(ctx, req.Storage, pkg, strings.Trim(req.MountPoint, "/"))
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	// the 2SKD of the exported UUK is salted with the source mount and
+	// entity id, the client rewraps it for this mount and the caller. The
+	// bundle keys are wrapped with its public key which must not change.
+	var uuk pwManagerUUKEntry
+	if err := mapstructure.Decode(data.Get("uuk"), &uuk); err != nil {
+		return logical.ErrorResponse("error decoding uuk"), nil
+	}
+	if uuk.EncSymKey.Data == "" || !maps.Equal(uuk.PubKey, export.User.UUK.PubKey) {
+		return logical.ErrorResponse("uuk must be the UUK of the package rewrapped for this mount"), nil
+	}
+
+	// a package is imported once, replaying it for another entity would
+	// clone the account
+	importedPath := fmt.Sprintf("%s/%x", accountImportedSchema, sha256.Sum256(pkg.Payload))
+	lock := bundleMapOfMu.Lock(importedPath)
+	defer lock.Unlock()
+
+	imported, err := req.Storage.Get(ctx, importedPath)
+	if err != nil {
+		return nil, err
+	}
+	if imported != nil {
+		return logical.ErrorResponse("package already imported"), nil
+	}
+
+	registered, err := b.getUser(ctx, req.Storage, req.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if registered != nil {
+		return logical.ErrorResponse("user already registered"), nil
+	}
+	if b.c == nil {
+		return logical.ErrorResponse("pwmanager mount not configured. configure at /config"), nil
+	}
+
+	entity, err := b.c.Identity().EntityByID(ctx, req.EntityID)
+	if err != nil {
+		return clientErrorResponse(fmt.Errorf("error retrieving users Entity Name: %w", err))
+	}
+
+	for _, ab := range export.Bundles {
+		pb, err := getBundle(ctx, req.Storage, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, req.EntityID, ab.Bundle.ID))
+		if err != nil {
+			return nil, err
+		}
+		if pb != nil {
+			return logical.ErrorResponse("bundle %s already exists", ab.Bundle.ID), nil
+		}
+	}
+
+	// the kv-v2 data is written first so a failed import leaves no records
+	bundles := []pwmgrBundle{}
+	members := map[string][]string{}
+	for _, ab := range export.Bundles {
+		pb := pwmgrBundle{
+			ID:            ab.Bundle.ID,
+			Path:          fmt.Sprintf("bundles/data/%s/%s", req.EntityID, ab.Bundle.ID),
+			Created:       ab.Bundle.Created,
+			OwnerEntityID: req.EntityID,
+			Users:         []pwmgrUser{},
+		}
+
+		for _, s := range ab.Secrets {
+			path, ok := importSecretPath(s.Path, export.EntityID, req.EntityID)
+			if !ok {
+				continue
+			}
+			// the secrets are new, each version is written with the
+			// version written before it as cas
+			for cas, v := range s.Versions {
+				if _, err := b.c.write(ctx, fmt.Sprintf("/v1/%s/%s", pb.Path, path), kvWriteRequest{Data: v, Options: map[string]int{"cas": cas}}); err != nil {
+					return clientErrorResponse(fmt.Errorf("error writing %s of bundle %s: %w", path, pb.ID, err))
+				}
+			}
+		}
+
+		bundles = append(bundles, pb)
+		names := []string{}
+		for _, u := range ab.Bundle.Users {
+			names = append(names, u.EntityName)
+		}
+		members[pb.ID] = names
+	}
+
+	for i, pb := range bundles {
+		if err := setBundle(ctx, req.Storage, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, req.EntityID, pb.ID), pb); err != nil {
+			return nil, err
+		}
+		for _, usage := range export.Bundles[i].Attachments {
+			if err := setAttachmentUsage(ctx, req.Storage, req.EntityID, pb.ID, &usage); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	user := pwManagerUserEntry{EntityID: req.EntityID, UUK: uuk}
+	if err := b.setUserByEntityID(ctx, req.Storage, req.EntityID, &user); err != nil {
+		return nil, err
+	}
+	if err := b.setUserByName(ctx, req.Storage, entity.Name, req.EntityID); err != nil {
+		return nil, err
+	}
+
+	entry, err := logical.StorageEntryJSON(importedPath, pwmgrAccountImported{EntityID: req.EntityID, Imported: time.Now().Unix()})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bundles": bundles,
+			// members are the entity names of the users each bundle was
+			// shared with on the exporting mount
+			"members": members,
+		},
+	}, nil
+}
+
+// verifyAccountPackage returns the payload of the package when it is signed
+// by a trusted key, has a supported version, hasn't expired and was exported
+// for the mount.
+func (b *pwManagerBackend) verifyAccountPackage(ctx context.Context, s logical.Storage, pkg pwmgrAccountPackage, mount string) (*pwmgrAccountExport, *logical.Response, error) {
+	names, err := s.List(ctx, accountTrustedKeysSchema+"/")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trusted := false
+	for _, name := range names {
+		key, err := getAccountTrustedKey(ctx, s, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key != nil && bytes.Equal(key, pkg.PublicKey) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return nil, logical.ErrorResponse("package is not signed by a trusted key"), nil
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pkg.PublicKey), pkg.Payload, pkg.Signature) {
+		return nil, logical.ErrorResponse("invalid package signature"), nil
+	}
+
+	export := new(pwmgrAccountExport)
+	if err := json.Unmarshal(pkg.Payload, export); err != nil {
+		return nil, logical.ErrorResponse("error decoding package payload: %s", err), nil
+	}
+	if export.Version != AccountPackageVersion {
+		return nil, logical.ErrorResponse("unsupported package version %d", export.Version), nil
+	}
+	if time.Now().Unix() > export.Expires {
+		return nil, logical.ErrorResponse("package expired"), nil
+	}
+	if export.Mount != mount {
+		return nil, logical.ErrorResponse("package was exported for mount %q", export.Mount), nil
+	}
+	return export, nil, nil
+}
+
+// importSecretPath returns the path of an exported bundle secret on the
+// importing mount. The bundle key wrapped for the exporting entity is moved
+// to the importing entity and the keys of the other bundle users are
+// dropped.
+func importSecretPath(path, fromEntityID, toEntityID string) (string, bool) {
+	if strings.Contains(path, "..") || strings.HasPrefix(path, "/") {
+		return "", false
+	}
+	if entityID, ok := strings.CutPrefix(path, "keys/"); ok {
+		if entityID != fromEntityID {
+			return "", false
+		}
+		return "keys/" + toEntityID, true
+	}
+	return path, true
+}
+
+///////////////////////// keys /////////////////////////
+
+// pathAccountSigningKeyRead returns the public key the mount signs account
+// packages with. Other mounts register it as a trusted key.
+func (b *pwManagerBackend) pathAccountSigningKeyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := getAccountSigningKey(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		},
+	}, nil
+}
+
+func (b *pwManagerBackend) pathAccountTrustedKeyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := getAccountTrustedKey(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": base64.StdEncoding.EncodeToString(key),
+		},
+	}, nil
+}
+
+func (b *pwManagerBackend) pathAccountTrustedKeyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := base64.StdEncoding.DecodeString(data.Get("public_key").(string))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return logical.ErrorResponse("public_key must be a base64 encoded ed25519 public key"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", accountTrustedKeysSchema, data.Get("name").(string)), ed25519.PublicKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return nil, req.Storage.Put(ctx, entry)
+}
+
+func (b *pwManagerBackend) pathAccountTrustedKeyDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", accountTrustedKeysSchema, data.Get("name").(string)))
+}
+
+func (b *pwManagerBackend) pathAccountTrustedKeysList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, accountTrustedKeysSchema+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+// getAccountSigningKey returns the signing key of the mount and creates it
+// on first use.
+func getAccountSigningKey(ctx context.Context, s logical.Storage) (ed25519.PrivateKey, error) {
+	lock := bundleMapOfMu.Lock(accountSigningKeyStoragePath)
+	defer lock.Unlock()
+
+	entry, err := s.Get(ctx, accountSigningKeyStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		var seed []byte
+		if err := entry.DecodeJSON(&seed); err != nil {
+			return nil, fmt.Errorf("error reading signing key: %w", err)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	entry, err = logical.StorageEntryJSON(accountSigningKeyStoragePath, key.Seed())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func getAccountTrustedKey(ctx context.Context, s logical.Storage, name string) (ed25519.PublicKey, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", accountTrustedKeysSchema, name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var key []byte
+	if err := entry.DecodeJSON(&key); err != nil {
+		return nil, fmt.Errorf("error reading trusted key: %w", err)
+	}
+	return key, nil
+}
+
+// pathAccountHelpSynopsis summarizes the help text for account portability
+const pathAccountHelpSynopsis = `Move a users account between mounts.`
+
+// pathAccountHelpDescription describes the help text for account portability
+const pathAccountHelpDescription = `
+account/export returns the callers UUK and owned bundles with their kv-v2
+data in a package signed with the ed25519 key of the mount. Trashed bundles
+and bundles shared with the caller are not exported. The package can only be
+imported on the mount given by mount and until its ttl expires.
+
+account/import on another mount registers the caller with the UUK of the
+package, rewrapped by the client for the mount and the callers entity id,
+and re-creates the bundles under the callers entity id. The package must be
+signed by a key registered under account/trusted_keys/<name>, read it from
+account/signing_key of the exporting mount. A package is imported
+once. Imported bundles are not shared with anyone, the previous bundle users
+are returned so they can be invited again.
+`
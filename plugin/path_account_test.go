@@ -0,0 +1,277 @@
+package secretsengine
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAccount tests moving an account with a shared bundle to another mount.
+func TestAccount(t *testing.T) {
+	ctx := context.Background()
+
+	source, s := getTestBackend(t)
+	source.policyService = &MockPolicyService{}
+
+	// bob has a UUK built by the web client on the source mount
+	vectors := readUUKVectors(t)
+	ownerID := vectors.EntityID
+	janeID, _ := uuid.GenerateUUID()
+	for name, id := range map[string]string{"bob": ownerID, "jane": janeID} {
+		user := pwManagerUserEntry{EntityID: id, UUK: vectors.UUK}
+		if name == "jane" {
+			user.UUK = pwManagerUUKEntry{PubKey: PubKey{"kty": "RSA", "n": name}}
+		}
+		require.NoError(t, source.setUserByEntityID(ctx, s, id, &user))
+		require.NoError(t, source.setUserByName(ctx, s, name, id))
+	}
+
+	bundleID, err := testBundleCreate(t, source, s, ownerID)
+	require.NoError(t, err)
+	resp, err := source.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      fmt.Sprintf("bundles/%s/%s/users", ownerID, bundleID),
+		Storage:   s,
+		EntityID:  ownerID,
+		Data: map[string]interface{}{
+			"users": []pwmgrUser{{EntityName: "jane", Capabilities: "read,list"}},
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	pb, err := getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ownerID, bundleID))
+	require.NoError(t, err)
+
+	entryPath, _ := uuid.GenerateUUID()
+	attachmentID, _ := uuid.GenerateUUID()
+	usage := pwmgrAttachmentUsage{EntryPath: entryPath, ID: attachmentID, Chunks: map[string]int64{"manifest": 10, "0": 100}}
+	require.NoError(t, setAttachmentUsage(ctx, s, ownerID, bundleID, &usage))
+
+	sourceKV := &testKVVersions{versions: map[string][]map[string]interface{}{
+		pb.Path + "/keys/" + ownerID:      {{"key": "bob"}},
+		pb.Path + "/keys/" + janeID:       {{"key": "jane"}},
+		pb.Path + "/metadata/entries":     {{"entries": "v1"}},
+		pb.Path + "/entries/" + entryPath: {{"entry": "v1"}, {"entry": "v2"}},
+	}}
+	source.c = testVaultServer(t, sourceKV.handler)
+
+	newID, _ := uuid.GenerateUUID()
+	target, ts := getTestBackend(t)
+	targetKV := &testKVVersions{versions: map[string][]map[string]interface{}{}, casRequired: true}
+	target.c = testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/identity/entity/id/") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"id": newID, "name": "bob.new"}})
+			return
+		}
+		targetKV.handler(w, r)
+	})
+
+	request := func(b *pwManagerBackend, s logical.Storage, op logical.Operation, path, entityID string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		mount := "source/"
+		if b == target {
+			mount = "target/"
+		}
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: op, Path: path, Data: data, Storage: s, EntityID: entityID, MountPoint: mount})
+		require.NoError(t, err)
+		return resp
+	}
+
+	// pkg is the exported package as decoded by a client, exported is the
+	// package decoded by the Go client
+	var pkg map[string]interface{}
+	var exported AccountPackage
+	t.Run("export", func(t *testing.T) {
+		resp := request(source, s, logical.ReadOperation, "account/export", "unknown", map[string]interface{}{"mount": "target"})
+		require.True(t, resp.IsError())
+
+		resp = request(source, s, logical.ReadOperation, "account/export", ownerID, nil)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "mount")
+
+		resp = request(source, s, logical.ReadOperation, "account/export", ownerID, map[string]interface{}{"mount": "target", "ttl": "30d"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "ttl")
+
+		resp = request(source, s, logical.ReadOperation, "account/export", ownerID, map[string]interface{}{"mount": "target"})
+		require.False(t, resp.IsError(), resp.Error())
+		raw, err := json.Marshal(resp.Data["package"])
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &pkg))
+
+		require.NoError(t, json.Unmarshal(raw, &exported))
+		export, err := exported.Export()
+		require.NoError(t, err)
+		require.Equal(t, ownerID, export.EntityID)
+		require.Equal(t, "target", export.Mount)
+		require.Equal(t, export.Created+int64(accountPackageDefaultTTL.Seconds()), export.Expires)
+		require.Len(t, export.Bundles, 1)
+		require.Len(t, export.Bundles[0].Secrets, 4)
+		require.Equal(t, []pwmgrAttachmentUsage{usage}, export.Bundles[0].Attachments)
+	})
+
+	// uuk is the exported UUK rewrapped by the client for the target mount
+	// and the new entity id as decoded by the plugin
+	var uuk map[string]interface{}
+	t.Run("rewrap", func(t *testing.T) {
+		export, err := exported.Export()
+		require.NoError(t, err)
+		rewrapped := export.User.UUK.UUK()
+		require.NoError(t, rewrapped.Rewrap([]byte(vectors.Password), []byte(vectors.SecretKey), []byte(vectors.Mount), []byte(export.User.SaltEntityID()), []byte("target"), []byte(newID)))
+		raw, err := json.Marshal(rewrapped)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &uuk))
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		resp := request(target, ts, logical.UpdateOperation, "account/import", newID, map[string]interface{}{"package": pkg, "uuk": uuk})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "trusted")
+	})
+
+	resp = request(source, s, logical.ReadOperation, "account/signing_key", "", nil)
+	signingKey := resp.Data["public_key"].(string)
+	require.Nil(t, request(target, ts, logical.CreateOperation, "account/trusted_keys/source", "", map[string]interface{}{"public_key": signingKey}))
+	require.Equal(t, []string{"source"}, request(target, ts, logical.ListOperation, "account/trusted_keys/", "", nil).Data["keys"])
+
+	resp = request(target, ts, logical.CreateOperation, "account/trusted_keys/bad", "", map[string]interface{}{"public_key": "bm90IGEga2V5"})
+	require.True(t, resp.IsError())
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := map[string]interface{}{}
+		for k, v := range pkg {
+			tampered[k] = v
+		}
+		payload, _ := base64.StdEncoding.DecodeString(pkg["payload"].(string))
+		payload = []byte(strings.Replace(string(payload), `"v1"`, `"v0"`, 1))
+		tampered["payload"] = base64.StdEncoding.EncodeToString(payload)
+
+		resp := request(target, ts, logical.UpdateOperation, "account/import", newID, map[string]interface{}{"package": tampered, "uuk": uuk})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "signature")
+	})
+
+	// sign returns a package of the export signed by the source mount as
+	// decoded by a client
+	sign := func(export pwmgrAccountExport) map[string]interface{} {
+		key, err := getAccountSigningKey(ctx, s)
+		require.NoError(t, err)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		raw, err := json.Marshal(pwmgrAccountPackage{Payload: payload, Signature: ed25519.Sign(key, payload), PublicKey: key.Public().(ed25519.PublicKey)})
+		require.NoError(t, err)
+		var signed map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &signed))
+		return signed
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		now := time.Now()
+		resp := request(target, ts, logical.UpdateOperation, "account/import", newID, map[string]interface{}{"package": sign(pwmgrAccountExport{
+			Version: AccountPackageVersion,
+			Created: now.Add(-2 * time.Hour).Unix(),
+			Expires: now.Add(-time.Hour).Unix(),
+			Mount:   "target",
+		}), "uuk": uuk})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "expired")
+	})
+
+	t.Run("other mount", func(t *testing.T) {
+		resp := request(target, ts, logical.UpdateOperation, "account/import", newID, map[string]interface{}{"package": sign(pwmgrAccountExport{
+			Version: AccountPackageVersion,
+			Expires: time.Now().Add(time.Hour).Unix(),
+			Mount:   "other",
+		}), "uuk": uuk})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), `mount "other"`)
+	})
+
+	t.Run("other uuk", func(t *testing.T) {
+		other := map[string]interface{}{}
+		for k, v := range uuk {
+			other[k] = v
+		}
+		other["pub_key"] = map[string]interface{}{"kty": "RSA", "n": "other"}
+		resp := request(target, ts, logical.UpdateOperation, "account/import", newID, map[string]interface{}{"package": pkg, "uuk": other})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "uuk")
+	})
+
+	t.Run("import", func(t *testing.T) {
+		resp := request(target, ts, logical.UpdateOperation, "account/import", newID, map[string]interface{}{"package": pkg, "uuk": uuk})
+		require.False(t, resp.IsError(), resp.Error())
+		require.Equal(t, map[string][]string{bundleID: {"jane"}}, resp.Data["members"])
+
+		user, err := target.getUser(ctx, ts, newID)
+		require.NoError(t, err)
+		require.Equal(t, newID, user.EntityID)
+		require.Equal(t, vectors.UUK.PubKey, user.UUK.PubKey)
+
+		// the imported UUK is unlocked with the target mount and new id
+		imported := user.UUK.UUK()
+		_, err = imported.DecryptEncPriKey([]byte(vectors.Password), []byte("target"), []byte(vectors.SecretKey), []byte(newID))
+		require.NoError(t, err)
+
+		byName, err := ts.Get(ctx, "users/byName/bob.new")
+		require.NoError(t, err)
+		require.NotNil(t, byName)
+
+		bundles, err := target.listBundles(ctx, ts, newID)
+		require.NoError(t, err)
+		require.Len(t, bundles, 1)
+		newPath := fmt.Sprintf("bundles/data/%s/%s", newID, bundleID)
+		require.Equal(t, newPath, bundles[0].Path)
+		require.Equal(t, newID, bundles[0].OwnerEntityID)
+		require.Empty(t, bundles[0].Users)
+
+		require.Equal(t, map[string][]map[string]interface{}{
+			newPath + "/keys/" + newID:        {{"key": "bob"}},
+			newPath + "/metadata/entries":     {{"entries": "v1"}},
+			newPath + "/entries/" + entryPath: {{"entry": "v1"}, {"entry": "v2"}},
+		}, targetKV.versions)
+
+		attachments, err := listAttachmentUsage(ctx, ts, newID, bundleID)
+		require.NoError(t, err)
+		require.Equal(t, []pwmgrAttachmentUsage{usage}, attachments)
+
+		resp = request(target, ts, logical.UpdateOperation, "account/import", newID, map[string]interface{}{"package": pkg, "uuk": uuk})
+		require.True(t, resp.IsError())
+
+		// the package can't be replayed by another entity
+		otherID, _ := uuid.GenerateUUID()
+		resp = request(target, ts, logical.UpdateOperation, "account/import", otherID, map[string]interface{}{"package": pkg, "uuk": uuk})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "already imported")
+		other, err := target.getUser(ctx, ts, otherID)
+		require.NoError(t, err)
+		require.Nil(t, other)
+	})
+}
+
+func TestImportSecretPath(t *testing.T) {
+	for _, tt := range []struct {
+		path, want string
+		ok         bool
+	}{
+		{"entries/e1", "entries/e1", true},
+		{"keys/old", "keys/new", true},
+		{"keys/other", "", false},
+		{"../other/entries/e1", "", false},
+	} {
+		got, ok := importSecretPath(tt.path, "old", "new")
+		require.Equal(t, tt.ok, ok, tt.path)
+		require.Equal(t, tt.want, got, tt.path)
+	}
+}
@@ -0,0 +1,383 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	mapstructure "github.com/go-viper/mapstructure/v2"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	ATTACHMENT_SCHEMA = "attachments"
+
+	// defaultAttachmentQuota is the attachment size quota of a bundle in
+	// bytes when the mount config has no attachment_quota.
+	defaultAttachmentQuota = 100 << 20
+
+	// attachmentManifestChunk is the chunk name of an attachment manifest
+	attachmentManifestChunk = "manifest"
+)
+
+var attachmentChunkRegex = regexp.MustCompile(`^(manifest|0|[1-9][0-9]{0,5})$`)
+
+// pwmgrAttachmentUsage is the size of the stored chunks of an attachment.
+// Usage is stored at `attachments/<owner>/<bundle>/<entry path>/<id>`.
+type pwmgrAttachmentUsage struct {
+	EntryPath string `json:"entry_path"`
+	ID        string `json:"id"`
+	// Chunks are the sizes of the encrypted chunks keyed by chunk name
+	Chunks map[string]int64 `json:"chunks"`
+}
+
+// Size returns the size of the stored chunks.
+func (u pwmgrAttachmentUsage) Size() int64 {
+	var size int64
+	for _, s := range u.Chunks {
+		size += s
+	}
+	return size
+}
+
+// pwmgrAttachmentSize is an attachment listed by the attachments read.
+type pwmgrAttachmentSize struct {
+	EntryPath string `json:"entry_path"`
+	ID        string `json:"id"`
+	Size      int64  `json:"size"`
+}
+
+// AttachmentSize is the exported name of pwmgrAttachmentSize.
+type AttachmentSize = pwmgrAttachmentSize
+
+// pathAttachments extends the Vault API with the upload of encrypted entry
+// attachments. Chunks are encrypted by the client with the bundle key and
+// written to kv-v2 by the plugin at
+// `<bundle path>/attachments/<entry path>/<attachment id>/<chunk>` so the
+// attachment quota of the bundle is enforced. Clients read the chunks from
+// kv-v2 directly, the user policies don't grant writing them.
+func pathAttachments(b *pwManagerBackend) []*framework.Path {
+	bundleFields := map[string]*framework.FieldSchema{
+		"owner_entity_id": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "entity id of the bundle owner",
+			Required:    true,
+		},
+		"bundle_id": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "uuid of the bundle",
+			Required:    true,
+		},
+	}
+
+	attachmentFields := map[string]*framework.FieldSchema{
+		"owner_entity_id": bundleFields["owner_entity_id"],
+		"bundle_id":       bundleFields["bundle_id"],
+		"entry_path": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "kv-v2 path of the entry under entries/",
+			Required:    true,
+		},
+		"attachment_id": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "uuid of the attachment",
+			Required:    true,
+		},
+	}
+
+	chunkFields := map[string]*framework.FieldSchema{
+		"owner_entity_id": bundleFields["owner_entity_id"],
+		"bundle_id":       bundleFields["bundle_id"],
+		"entry_path":      attachmentFields["entry_path"],
+		"attachment_id":   attachmentFields["attachment_id"],
+		"chunk": {
+			Type:        framework.TypeString,
+			Description: "index of the chunk or manifest",
+			Required:    true,
+		},
+		"data": {
+			Type:        framework.TypeMap,
+			Description: "the chunk encrypted with the bundle key",
+			Required:    true,
+		},
+	}
+
+	bundlePattern := fmt.Sprintf("bundles/%s/%s/attachments", uuidRegex("owner_entity_id"), uuidRegex("bundle_id"))
+	attachmentPattern := fmt.Sprintf("%s/%s/%s", bundlePattern, uuidRegex("entry_path"), uuidRegex("attachment_id"))
+
+	return []*framework.Path{
+		{
+			Pattern: bundlePattern,
+			Fields:  bundleFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathAttachmentsRead,
+				},
+			},
+			HelpSynopsis:    pathAttachmentsHelpSynopsis,
+			HelpDescription: pathAttachmentsHelpDescription,
+		},
+		{
+			Pattern: attachmentPattern,
+			Fields:  attachmentFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathAttachmentDelete,
+				},
+			},
+			HelpSynopsis:    pathAttachmentsHelpSynopsis,
+			HelpDescription: pathAttachmentsHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s", attachmentPattern, framework.GenericNameRegex("chunk")),
+			Fields:  chunkFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathAttachmentChunkWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAttachmentChunkWrite,
+				},
+			},
+			HelpSynopsis:    pathAttachmentsHelpSynopsis,
+			HelpDescription: pathAttachmentsHelpDescription,
+		},
+	}
+}
+
+// pathAttachmentsRead returns the attachment quota and usage of the bundle.
+func (b *pwManagerBackend) pathAttachmentsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pb, resp, err := b.authorizeBundle(ctx, req, data, "read")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	quota, err := getAttachmentQuota(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := listAttachmentUsage(ctx, req.Storage, pb.OwnerEntityID, pb.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var used int64
+	attachments := []pwmgrAttachmentSize{}
+	for _, u := range usage {
+		used += u.Size()
+		attachments = append(attachments, pwmgrAttachmentSize{EntryPath: u.EntryPath, ID: u.ID, Size: u.Size()})
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"quota":       quota,
+			"used":        used,
+			"attachments": attachments,
+		},
+	}, nil
+}
+
+// pathAttachmentChunkWrite writes an encrypted chunk to kv-v2 when the
+// bundle stays within its attachment quota.
+func (b *pwManagerBackend) pathAttachmentChunkWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pb, resp, err := b.authorizeBundle(ctx, req, data, "update")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	if b.c == nil {
+		return logical.ErrorResponse("pwmanager mount not configured. configure at /config"), nil
+	}
+
+	chunk := data.Get("chunk").(string)
+	if !attachmentChunkRegex.MatchString(chunk) {
+		return logical.ErrorResponse("chunk must be an index or %s", attachmentManifestChunk), nil
+	}
+
+	var ee EncryptedEntry
+	if err := mapstructure.Decode(data.Get("data"), &ee); err != nil || ee.Entry == "" || ee.Iv == "" {
+		return logical.ErrorResponse("data must be an encrypted entry with entry and iv"), nil
+	}
+
+	// the chunk is hex encoded
+	size := int64(len(ee.Entry) / 2)
+
+	usageLock := bundleMapOfMu.Lock(attachmentStoragePath(pb.OwnerEntityID, pb.ID, "", ""))
+	defer usageLock.Unlock()
+
+	quota, err := getAttachmentQuota(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := listAttachmentUsage(ctx, req.Storage, pb.OwnerEntityID, pb.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	entryPath := data.Get("entry_path").(string)
+	attachmentID := data.Get("attachment_id").(string)
+
+	var used int64
+	usage := &pwmgrAttachmentUsage{EntryPath: entryPath, ID: attachmentID, Chunks: map[string]int64{}}
+	for _, u := range all {
+		if u.EntryPath == entryPath && u.ID == attachmentID {
+			usage = &u
+		}
+		used += u.Size()
+	}
+	used += size - usage.Chunks[chunk]
+
+	if used > quota {
+		return logical.ErrorResponse("attachment quota of %d bytes exceeded", quota), nil
+	}
+
+	// a rewritten chunk is destroyed first so that its previous versions
+	// don't keep data outside of the quota, the chunk is then always new
+	chunkPath := fmt.Sprintf("%s/%s/%s/%s/%s", pb.Path, ATTACHMENT_SCHEMA, entryPath, attachmentID, chunk)
+	if _, ok := usage.Chunks[chunk]; ok {
+		if err := b.c.delete(ctx, "/v1/"+strings.Replace(chunkPath, "/data/", "/metadata/", 1)); err != nil {
+			return clientErrorResponse(fmt.Errorf("error destroying chunk: %w", err))
+		}
+	}
+	if _, err := b.c.write(ctx, "/v1/"+chunkPath, kvWriteRequest{Data: ee, Options: map[string]int{"cas": 0}}); err != nil {
+		return clientErrorResponse(fmt.Errorf("error writing chunk: %w", err))
+	}
+
+	usage.Chunks[chunk] = size
+	if err := setAttachmentUsage(ctx, req.Storage, pb.OwnerEntityID, pb.ID, usage); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"quota": quota,
+			"used":  used,
+		},
+	}, nil
+}
+
+// pathAttachmentDelete destroys the chunks of an attachment.
+func (b *pwManagerBackend) pathAttachmentDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pb, resp, err := b.authorizeBundle(ctx, req, data, "update")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	if b.c == nil {
+		return logical.ErrorResponse("pwmanager mount not configured. configure at /config"), nil
+	}
+
+	entryPath := data.Get("entry_path").(string)
+	attachmentID := data.Get("attachment_id").(string)
+
+	metadataPath := strings.Replace(pb.Path, "/data/", "/metadata/", 1)
+	if err := b.destroyKVTree(ctx, fmt.Sprintf("%s/%s/%s/%s", metadataPath, ATTACHMENT_SCHEMA, entryPath, attachmentID)); err != nil {
+		return clientErrorResponse(err)
+	}
+
+	if err := req.Storage.Delete(ctx, attachmentStoragePath(pb.OwnerEntityID, pb.ID, entryPath, attachmentID)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// deleteEntryAttachments destroys the attachments of a purged entry.
+func (b *pwManagerBackend) deleteEntryAttachments(ctx context.Context, s logical.Storage, item *pwmgrTrashItem) error {
+	metadataPath := strings.Replace(item.BundlePath, "/data/", "/metadata/", 1)
+	if err := b.destroyKVTree(ctx, fmt.Sprintf("%s/%s/%s", metadataPath, ATTACHMENT_SCHEMA, item.ID)); err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("%s/%s/%s/%s/", ATTACHMENT_SCHEMA, item.OwnerEntityID, item.BundleID, item.ID)
+	return logical.ClearView(ctx, logical.NewStorageView(s, prefix))
+}
+
+///////////////////////// storage /////////////////////////
+
+func attachmentStoragePath(ownerEntityID, bundleID, entryPath, attachmentID string) string {
+	if entryPath == "" {
+		return fmt.Sprintf("%s/%s/%s", ATTACHMENT_SCHEMA, ownerEntityID, bundleID)
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s", ATTACHMENT_SCHEMA, ownerEntityID, bundleID, entryPath, attachmentID)
+}
+
+func setAttachmentUsage(ctx context.Context, s logical.Storage, ownerEntityID, bundleID string, usage *pwmgrAttachmentUsage) error {
+	entry, err := logical.StorageEntryJSON(attachmentStoragePath(ownerEntityID, bundleID, usage.EntryPath, usage.ID), usage)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// listAttachmentUsage returns the usage of every attachment of the bundle
+// ordered by entry path and attachment id.
+func listAttachmentUsage(ctx context.Context, s logical.Storage, ownerEntityID, bundleID string) ([]pwmgrAttachmentUsage, error) {
+	prefix := attachmentStoragePath(ownerEntityID, bundleID, "", "") + "/"
+	entryPaths, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := []pwmgrAttachmentUsage{}
+	for _, entryPath := range entryPaths {
+		ids, err := s.List(ctx, prefix+entryPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			entry, err := s.Get(ctx, prefix+entryPath+id)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				continue
+			}
+
+			var u pwmgrAttachmentUsage
+			if err := entry.DecodeJSON(&u); err != nil {
+				return nil, fmt.Errorf("error reading attachment usage: %w", err)
+			}
+			usage = append(usage, u)
+		}
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].EntryPath != usage[j].EntryPath {
+			return usage[i].EntryPath < usage[j].EntryPath
+		}
+		return usage[i].ID < usage[j].ID
+	})
+	return usage, nil
+}
+
+// getAttachmentQuota returns the attachment_quota of the mount config.
+func getAttachmentQuota(ctx context.Context, s logical.Storage) (int64, error) {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	if config == nil || config.AttachmentQuota <= 0 {
+		return defaultAttachmentQuota, nil
+	}
+	return config.AttachmentQuota, nil
+}
+
+// pathAttachmentsHelpSynopsis summarizes the help text for attachments
+const pathAttachmentsHelpSynopsis = `Upload encrypted entry attachments within the bundle quota.`
+
+// pathAttachmentsHelpDescription describes the help text for attachments
+const pathAttachmentsHelpDescription = `
+Attachments are encrypted by the client with the bundle key in chunks. The
+chunks and the encrypted manifest are written through
+bundles/<owner>/<bundle>/attachments/<entry path>/<attachment id>/<chunk>
+which enforces the attachment_quota of the mount config per bundle. The
+user policies only grant read and list on the attachments in kv-v2, the
+chunks are written by the plugin. Reading the bundle attachments returns
+the quota and the bytes used.
+`
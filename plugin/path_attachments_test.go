@@ -0,0 +1,132 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAttachments tests the upload of attachment chunks within the bundle
+// quota, the usage read and the delete of attachments.
+func TestAttachments(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	entityID, _ := uuid.GenerateUUID()
+	otherEntityID, _ := uuid.GenerateUUID()
+	bundleID, err := testBundleCreate(t, b, s, entityID)
+	require.NoError(t, err)
+
+	pb, err := getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, entityID, bundleID))
+	require.NoError(t, err)
+	metadataPath := strings.Replace(pb.Path, "/data/", "/metadata/", 1)
+
+	c, kv := testKVTree(t)
+	b.c = c
+
+	request := func(op logical.Operation, path, entityID string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+			EntityID:  entityID,
+		})
+	}
+
+	// chunk returns an encrypted chunk of size bytes
+	chunk := func(size int) map[string]interface{} {
+		return map[string]interface{}{"data": map[string]interface{}{
+			"entry": strings.Repeat("ab", size),
+			"iv":    "000000000000000000000000",
+		}}
+	}
+
+	config, err := logical.StorageEntryJSON(configStoragePath, &pwmgrConfig{AttachmentQuota: 1000})
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, config))
+
+	entryPath, _ := uuid.GenerateUUID()
+	attachmentID, _ := uuid.GenerateUUID()
+	attachmentPath := fmt.Sprintf("bundles/%s/%s/attachments/%s/%s", entityID, bundleID, entryPath, attachmentID)
+	usagePath := fmt.Sprintf("bundles/%s/%s/attachments", entityID, bundleID)
+
+	t.Run("upload", func(t *testing.T) {
+		resp, err := request(logical.CreateOperation, attachmentPath+"/0", otherEntityID, chunk(100))
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		resp, err = request(logical.CreateOperation, attachmentPath+"/first", entityID, chunk(100))
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		resp, err = request(logical.CreateOperation, attachmentPath+"/0", entityID, map[string]interface{}{"data": map[string]interface{}{}})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		for _, c := range []string{"0", "1", "manifest"} {
+			resp, err = request(logical.CreateOperation, attachmentPath+"/"+c, entityID, chunk(300))
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), resp.Error())
+		}
+		require.True(t, kv[fmt.Sprintf("%s/attachments/%s/%s/1", metadataPath, entryPath, attachmentID)])
+
+		// rewriting a chunk replaces its size
+		resp, err = request(logical.UpdateOperation, attachmentPath+"/1", entityID, chunk(400))
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.Equal(t, int64(1000), resp.Data["used"])
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		otherAttachmentID, _ := uuid.GenerateUUID()
+		resp, err := request(logical.CreateOperation, fmt.Sprintf("bundles/%s/%s/attachments/%s/%s/0", entityID, bundleID, entryPath, otherAttachmentID), entityID, chunk(1))
+		require.NoError(t, err)
+		require.ErrorContains(t, resp.Error(), "attachment quota of 1000 bytes exceeded")
+
+		resp, err = request(logical.ReadOperation, usagePath, entityID, nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(1000), resp.Data["quota"])
+		require.Equal(t, int64(1000), resp.Data["used"])
+		require.Equal(t, []pwmgrAttachmentSize{{EntryPath: entryPath, ID: attachmentID, Size: 1000}}, resp.Data["attachments"])
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		resp, err := request(logical.DeleteOperation, attachmentPath, entityID, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Empty(t, kv)
+
+		resp, err = request(logical.ReadOperation, usagePath, entityID, nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), resp.Data["used"])
+	})
+
+	t.Run("purged entries release their attachments", func(t *testing.T) {
+		resp, err := request(logical.CreateOperation, attachmentPath+"/0", entityID, chunk(500))
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		// the entry was removed from the bundle metadata
+		kv[metadataPath+"/entries/"+entryPath] = true
+		kv[metadataPath+"/metadata/entries"] = true
+
+		resp, err = request(logical.CreateOperation, fmt.Sprintf("bundles/%s/%s/trash", entityID, bundleID), entityID, map[string]interface{}{"entry_path": entryPath})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = request(logical.DeleteOperation, fmt.Sprintf("bundles/%s/%s/trash/%s", entityID, bundleID, entryPath), entityID, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Equal(t, map[string]bool{metadataPath + "/metadata/entries": true}, kv)
+
+		resp, err = request(logical.ReadOperation, usagePath, entityID, nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), resp.Data["used"])
+	})
+}
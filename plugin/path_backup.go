@@ -0,0 +1,503 @@
+package secretsengine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	neturl "net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// SnapshotVersion is the version of the snapshot layout, restores
+	// reject snapshots of other versions.
+	SnapshotVersion = 1
+
+	// snapshotAttempts is how often the records are read again when they
+	// change while a snapshot is taken.
+	snapshotAttempts = 5
+)
+
+// snapshotSchemas are the storage prefixes of the plugin records. A restore
+// only writes records under them.
+var snapshotSchemas = []string{USER_SCHEMA, BUNDLE_SCHEMA, TRASH_SCHEMA, ATTACHMENT_SCHEMA, GENERATOR_RULES_SCHEMA, ACCOUNT_SCHEMA}
+
+// pwmgrSnapshot is a backup of every plugin record and optionally of the
+// kv-v2 data of the bundles. The kv-v2 data is end-to-end encrypted but the
+// config record holds the AppRole secret id and the account signing key is
+// included, so snapshots are to be kept as secret as the mount config.
+type pwmgrSnapshot struct {
+	Version int   `json:"version"`
+	Created int64 `json:"created"`
+	// Records are the storage records keyed by their storage path
+	Records map[string]json.RawMessage `json:"records"`
+	// Secrets are the kv-v2 secrets of the bundles and trashed bundles,
+	// empty unless the snapshot was taken with include_kv_data
+	Secrets []pwmgrSnapshotSecret `json:"secrets,omitempty"`
+}
+
+// pwmgrSnapshotSecret is a kv-v2 secret with its readable versions oldest
+// first. Deleted and destroyed versions are left out so a restored secret
+// is renumbered.
+type pwmgrSnapshotSecret struct {
+	// Path is the kv-v2 data path of the secret
+	Path     string                   `json:"path"`
+	Versions []map[string]interface{} `json:"versions"`
+}
+
+// Exported names of the snapshot types.
+type (
+	Snapshot       = pwmgrSnapshot
+	SnapshotSecret = pwmgrSnapshotSecret
+)
+
+// pathBackup extends the Vault API with the admin endpoints to back up the
+// plugin storage and restore it into an empty mount.
+func pathBackup(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "backup",
+			Fields: map[string]*framework.FieldSchema{
+				"include_kv_data": {
+					Type:        framework.TypeBool,
+					Description: "also back up the kv-v2 data of the bundles",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathBackupRead,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathBackupRead,
+				},
+			},
+			HelpSynopsis:    pathBackupHelpSynopsis,
+			HelpDescription: pathBackupHelpDescription,
+		},
+		{
+			Pattern: "restore",
+			Fields: map[string]*framework.FieldSchema{
+				"snapshot": {
+					Type:        framework.TypeMap,
+					Description: "snapshot returned by backup",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRestoreWrite,
+				},
+			},
+			HelpSynopsis:    pathBackupHelpSynopsis,
+			HelpDescription: pathBackupHelpDescription,
+		},
+	}
+}
+
+///////////////////////// backup /////////////////////////
+
+// pathBackupRead returns a snapshot of the plugin storage.
+func (b *pwManagerBackend) pathBackupRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	records, err := snapshotRecords(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := pwmgrSnapshot{
+		Version: SnapshotVersion,
+		Created: time.Now().Unix(),
+		Records: records,
+	}
+
+	if data.Get("include_kv_data").(bool) {
+		if b.c == nil {
+			return logical.ErrorResponse("pwmanager mount not configured. configure at /config"), nil
+		}
+		if snapshot.Secrets, err = b.snapshotSecrets(ctx, records); err != nil {
+			return clientErrorResponse(fmt.Errorf("error reading kv data: %w", err))
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"snapshot": snapshot,
+		},
+	}, nil
+}
+
+// snapshotRecords reads every record of the storage. Vault storage has no
+// transactions so the records are read twice and the snapshot is only
+// returned when both reads match.
+func snapshotRecords(ctx context.Context, s logical.Storage) (map[string]json.RawMessage, error) {
+	read := func() (map[string]json.RawMessage, error) {
+		keys, err := logical.CollectKeys(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+
+		records := map[string]json.RawMessage{}
+		for _, k := range keys {
+			entry, err := s.Get(ctx, k)
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				records[k] = entry.Value
+			}
+		}
+		return records, nil
+	}
+
+	for attempt := 0; attempt < snapshotAttempts; attempt++ {
+		first, err := read()
+		if err != nil {
+			return nil, err
+		}
+		second, err := read()
+		if err != nil {
+			return nil, err
+		}
+		if maps.EqualFunc(first, second, func(a, b json.RawMessage) bool { return bytes.Equal(a, b) }) {
+			return first, nil
+		}
+	}
+	return nil, fmt.Errorf("storage changed during each of %d snapshot attempts", snapshotAttempts)
+}
+
+// snapshotBundlePaths returns the kv-v2 paths of the bundles and trashed
+// bundles of the records.
+func snapshotBundlePaths(records map[string]json.RawMessage) ([]string, error) {
+	paths := map[string]bool{}
+	for k, v := range records {
+		parts := strings.Split(k, "/")
+		switch {
+		case len(parts) == 4 && parts[0] == BUNDLE_SCHEMA && parts[2] == "bundles":
+			var pb pwmgrBundle
+			if err := json.Unmarshal(v, &pb); err != nil {
+				return nil, fmt.Errorf("error decoding bundle %s: %w", k, err)
+			}
+			paths[pb.Path] = true
+		case len(parts) == 4 && parts[0] == TRASH_SCHEMA && parts[3] == trashItemBundle:
+			var item pwmgrTrashItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return nil, fmt.Errorf("error decoding trash item %s: %w", k, err)
+			}
+			paths[item.BundlePath] = true
+		}
+	}
+
+	sorted := []string{}
+	for p := range paths {
+		if p != "" {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// snapshotSecrets reads the readable versions of every kv-v2 secret of the
+// bundles of the records.
+func (b *pwManagerBackend) snapshotSecrets(ctx context.Context, records map[string]json.RawMessage) ([]pwmgrSnapshotSecret, error) {
+	bundlePaths, err := snapshotBundlePaths(records)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []pwmgrSnapshotSecret{}
+	for _, p := range bundlePaths {
+		bundleSecrets, err := b.readBundleSecrets(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, bundleSecrets...)
+	}
+	return secrets, nil
+}
+
+// readBundleSecrets reads the readable versions of every kv-v2 secret under
+// the kv-v2 data path of a bundle.
+func (b *pwManagerBackend) readBundleSecrets(ctx context.Context, bundlePath string) ([]pwmgrSnapshotSecret, error) {
+	metadataPath := strings.Replace(bundlePath, "/data/", "/metadata/", 1)
+	keys, err := b.listKVTree(ctx, metadataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []pwmgrSnapshotSecret{}
+	for _, k := range keys {
+		secret, err := b.snapshotSecret(ctx, metadataPath+"/"+k, bundlePath+"/"+k)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret.Versions) > 0 {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// listKVTree returns the paths of every secret under the kv-v2 metadata
+// path relative to it.
+func (b *pwManagerBackend) listKVTree(ctx context.Context, metadataPath string) ([]string, error) {
+	secret, err := b.c.list(ctx, fmt.Sprintf("/v1/%s", metadataPath))
+	if errors.Is(err, ErrNotFound) || (err == nil && (secret == nil || secret.Data == nil)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		key, _ := k.(string)
+		if dir, ok := strings.CutSuffix(key, "/"); ok {
+			sub, err := b.listKVTree(ctx, metadataPath+"/"+dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range sub {
+				paths = append(paths, dir+"/"+s)
+			}
+			continue
+		}
+		paths = append(paths, key)
+	}
+	return paths, nil
+}
+
+// snapshotSecret reads the versions of the secret that are neither deleted
+// nor destroyed.
+func (b *pwManagerBackend) snapshotSecret(ctx context.Context, metadataPath, dataPath string) (pwmgrSnapshotSecret, error) {
+	s := pwmgrSnapshotSecret{Path: dataPath, Versions: []map[string]interface{}{}}
+
+	var metadata struct {
+		Versions map[string]struct {
+			DeletionTime string `json:"deletion_time"`
+			Destroyed    bool   `json:"destroyed"`
+		} `json:"versions"`
+	}
+	secret, err := b.c.read(ctx, fmt.Sprintf("/v1/%s", metadataPath))
+	if err != nil {
+		return s, err
+	}
+	if err := decodeData(secret, &metadata); err != nil {
+		return s, fmt.Errorf("error reading metadata of %s: %w", dataPath, err)
+	}
+
+	var versions []int
+	for v, m := range metadata.Versions {
+		n, err := strconv.Atoi(v)
+		if err != nil || m.Destroyed || m.DeletionTime != "" {
+			continue
+		}
+		versions = append(versions, n)
+	}
+	sort.Ints(versions)
+
+	for _, v := range versions {
+		secret, err := b.c.readParams(ctx, "/v1/"+dataPath, neturl.Values{"version": {strconv.Itoa(v)}})
+		if err != nil {
+			return s, err
+		}
+
+		var version struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := decodeData(secret, &version); err != nil {
+			return s, fmt.Errorf("error reading version %d of %s: %w", v, dataPath, err)
+		}
+		if version.Data != nil {
+			s.Versions = append(s.Versions, version.Data)
+		}
+	}
+	return s, nil
+}
+
+///////////////////////// restore /////////////////////////
+
+// pathRestoreWrite writes the records and kv-v2 data of a snapshot into an
+// empty mount and writes the policies of the users the bundles are shared
+// with.
+func (b *pwManagerBackend) pathRestoreWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var snapshot pwmgrSnapshot
+	raw, err := json.Marshal(data.Get("snapshot"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return logical.ErrorResponse("error decoding snapshot: %s", err), nil
+	}
+
+	if snapshot.Version != SnapshotVersion {
+		return logical.ErrorResponse("unsupported snapshot version %d", snapshot.Version), nil
+	}
+	for k, v := range snapshot.Records {
+		if !isSnapshotRecord(k) {
+			return logical.ErrorResponse("snapshot record %q is not a plugin record", k), nil
+		}
+		if !json.Valid(v) {
+			return logical.ErrorResponse("snapshot record %q is not valid json", k), nil
+		}
+	}
+
+	for _, s := range snapshot.Secrets {
+		if !strings.HasPrefix(s.Path, "bundles/data/") {
+			return logical.ErrorResponse("snapshot secret %q is not bundle data", s.Path), nil
+		}
+	}
+
+	keys, err := logical.CollectKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	// the schema version written when the mount was initialized is replaced
+	// by the one of the snapshot
+	if slices.ContainsFunc(keys, func(k string) bool { return k != schemaStoragePath }) {
+		return logical.ErrorResponse("a snapshot can only be restored into an empty mount"), nil
+	}
+	if err := req.Storage.Delete(ctx, schemaStoragePath); err != nil {
+		return nil, err
+	}
+
+	for k, v := range snapshot.Records {
+		if err := req.Storage.Put(ctx, &logical.StorageEntry{Key: k, Value: v}); err != nil {
+			return nil, fmt.Errorf("error restoring %s: %w", k, err)
+		}
+	}
+
+	// the records of a snapshot taken before a migration are migrated
+	if err := b.migrate(ctx, req.Storage); err != nil {
+		return nil, fmt.Errorf("records restored but migrating them failed: %w", err)
+	}
+
+	// the restored config is used to write the kv data and policies
+	if b.policyService == nil || (len(snapshot.Secrets) > 0 && b.c == nil) {
+		if err := b.Login(ctx); err != nil {
+			return clientErrorResponse(fmt.Errorf("records restored but login failed: %w", err))
+		}
+	}
+
+	// the secrets must be new, each version is written with the version
+	// written before it as cas
+	for _, s := range snapshot.Secrets {
+		for cas, v := range s.Versions {
+			if _, err := b.c.write(ctx, "/v1/"+s.Path, kvWriteRequest{Data: v, Options: map[string]int{"cas": cas}}); err != nil {
+				return clientErrorResponse(fmt.Errorf("records restored but writing %s failed: %w", s.Path, err))
+			}
+		}
+	}
+
+	policies, err := b.restorePolicies(ctx, req.Storage)
+	if err != nil {
+		return clientErrorResponse(fmt.Errorf("records restored but writing policies failed: %w", err))
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"records":  len(snapshot.Records),
+			"secrets":  len(snapshot.Secrets),
+			"policies": policies,
+		},
+	}, nil
+}
+
+// isSnapshotRecord reports if the storage path is a plugin record.
+func isSnapshotRecord(path string) bool {
+	if path == configStoragePath || path == schemaStoragePath {
+		return true
+	}
+	for _, schema := range snapshotSchemas {
+		if strings.HasPrefix(path, schema+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// restorePolicies writes the policy of every user with shared bundles and
+// returns the number of policies written.
+func (b *pwManagerBackend) restorePolicies(ctx context.Context, s logical.Storage) (int, error) {
+	// entity names may contain slashes
+	prefix := fmt.Sprintf("%s/byName/", USER_SCHEMA)
+	keys, err := logical.CollectKeysWithPrefix(ctx, s, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	entityNames := map[string]string{}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		entry, err := s.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if entry == nil {
+			continue
+		}
+		var user struct {
+			ID string `json:"id"`
+		}
+		if err := entry.DecodeJSON(&user); err != nil {
+			return 0, err
+		}
+		entityNames[user.ID] = name
+	}
+
+	owners, err := s.List(ctx, BUNDLE_SCHEMA+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, owner := range owners {
+		entityID := strings.TrimSuffix(owner, "/")
+		sbs, err := getSharedUserBundles(ctx, s, fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID))
+		if err != nil {
+			return written, err
+		}
+		if sbs == nil {
+			continue
+		}
+
+		name, ok := entityNames[entityID]
+		if !ok {
+			b.logger.Warn(fmt.Sprintf("no entity name for user with shared bundles: %s", entityID))
+			continue
+		}
+		if err := b.UpdateUserPolicy(ctx, sbs, name); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// pathBackupHelpSynopsis summarizes the help text for backup and restore
+const pathBackupHelpSynopsis = `Back up the plugin storage and restore it into an empty mount.`
+
+// pathBackupHelpDescription describes the help text for backup and restore
+const pathBackupHelpDescription = `
+backup returns a versioned snapshot of every plugin record: the users, their
+bundles and shared bundles, the trash, attachment usage, generator rules, the
+account signing and trusted keys, the config and the schema version. With
+include_kv_data the readable versions of the kv-v2 secrets of the bundles are
+included. The snapshot holds the AppRole secret id of the config and the
+account signing key and should be protected like them.
+
+restore writes a snapshot into a mount with no records, migrates the records
+of a snapshot with an older schema version, writes the kv-v2 data and then
+the policies of the users bundles are shared with. The kv-v2 secrets of the
+snapshot must not exist.
+`
@@ -0,0 +1,274 @@
+package secretsengine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// testKVVersions serves a kv-v2 mount with versions. Deleted versions are
+// listed in the metadata but can't be read, deleting the metadata destroys
+// every version. With casRequired writes must pass the current version as
+// cas like on a cas_required mount.
+type testKVVersions struct {
+	mu          sync.Mutex
+	versions    map[string][]map[string]interface{}
+	deleted     map[string]map[int]bool
+	casRequired bool
+}
+
+func (kv *testKVVersions) handler(w http.ResponseWriter, r *http.Request) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	reply := func(data interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}
+
+	switch {
+	case r.Method == "LIST":
+		prefix := strings.Replace(p, "/metadata/", "/data/", 1) + "/"
+		keys := map[string]bool{}
+		for s := range kv.versions {
+			if rest, ok := strings.CutPrefix(s, prefix); ok {
+				if dir, _, ok := strings.Cut(rest, "/"); ok {
+					keys[dir+"/"] = true
+				} else {
+					keys[rest] = true
+				}
+			}
+		}
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		list := []string{}
+		for k := range keys {
+			list = append(list, k)
+		}
+		sort.Strings(list)
+		reply(map[string]interface{}{"keys": list})
+	case r.Method == http.MethodDelete && strings.HasPrefix(p, "bundles/metadata/"):
+		delete(kv.versions, strings.Replace(p, "/metadata/", "/data/", 1))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "bundles/metadata/"):
+		dataPath := strings.Replace(p, "/metadata/", "/data/", 1)
+		if len(kv.versions[dataPath]) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		versions := map[string]interface{}{}
+		for i := range kv.versions[dataPath] {
+			deletion := ""
+			if kv.deleted[dataPath][i+1] {
+				deletion = "2024-01-01T00:00:00Z"
+			}
+			versions[strconv.Itoa(i+1)] = map[string]interface{}{"deletion_time": deletion, "destroyed": false}
+		}
+		reply(map[string]interface{}{"versions": versions})
+	case r.Method == http.MethodGet:
+		v, _ := strconv.Atoi(r.URL.Query().Get("version"))
+		if v < 1 || v > len(kv.versions[p]) || kv.deleted[p][v] {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		reply(map[string]interface{}{"data": kv.versions[p][v-1]})
+	case r.Method == http.MethodPost || r.Method == http.MethodPut:
+		var body struct {
+			Data    map[string]interface{} `json:"data"`
+			Options map[string]int         `json:"options"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if cas, ok := body.Options["cas"]; (kv.casRequired && !ok) || (ok && cas != len(kv.versions[p])) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+			return
+		}
+		kv.versions[p] = append(kv.versions[p], body.Data)
+		reply(map[string]interface{}{"version": len(kv.versions[p])})
+	}
+}
+
+// TestBackupRestore tests taking a snapshot of a mount with a shared bundle
+// and restoring it into a new mount.
+func TestBackupRestore(t *testing.T) {
+	b, s := getTestBackend(t)
+	b.policyService = &MockPolicyService{}
+	ctx := context.Background()
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: s}))
+
+	ownerID, _ := uuid.GenerateUUID()
+	janeID, _ := uuid.GenerateUUID()
+	for name, id := range map[string]string{"bob": ownerID, "team/jane": janeID} {
+		user := pwManagerUserEntry{EntityID: id}
+		user.UUK.PubKey = PubKey{"kty": "RSA"}
+		require.NoError(t, b.setUserByEntityID(ctx, s, id, &user))
+		require.NoError(t, b.setUserByName(ctx, s, name, id))
+	}
+
+	bundleID, err := testBundleCreate(t, b, s, ownerID)
+	require.NoError(t, err)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      fmt.Sprintf("bundles/%s/%s/users", ownerID, bundleID),
+		Storage:   s,
+		EntityID:  ownerID,
+		Data: map[string]interface{}{
+			"users": []pwmgrUser{{EntityName: "team/jane", Capabilities: "read,list"}},
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	pb, err := getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ownerID, bundleID))
+	require.NoError(t, err)
+
+	entryPath, _ := uuid.GenerateUUID()
+	kv := &testKVVersions{
+		versions: map[string][]map[string]interface{}{
+			pb.Path + "/keys/" + ownerID:        {{"key": "wrapped"}},
+			pb.Path + "/metadata/entries":       {{"entries": "v1"}, {"entries": "v2"}},
+			pb.Path + "/entries/" + entryPath:   {{"entry": "v1"}, {"entry": "v2"}, {"entry": "v3"}},
+			"bundles/data/other/not-a-bundle/x": {{"x": "y"}},
+		},
+		deleted: map[string]map[int]bool{pb.Path + "/entries/" + entryPath: {2: true}},
+	}
+	b.c = testVaultServer(t, kv.handler)
+
+	request := func(b *pwManagerBackend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: op, Path: path, Data: data, Storage: s})
+		require.NoError(t, err)
+		return resp
+	}
+
+	// snapshot returns the snapshot of the response as decoded by a client
+	snapshot := func(resp *logical.Response) map[string]interface{} {
+		t.Helper()
+		require.False(t, resp.IsError(), resp.Error())
+		raw, err := json.Marshal(resp.Data["snapshot"])
+		require.NoError(t, err)
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &m))
+		return m
+	}
+
+	t.Run("records only", func(t *testing.T) {
+		snap := snapshot(request(b, s, logical.ReadOperation, "backup", nil))
+		require.Equal(t, float64(SnapshotVersion), snap["version"])
+		require.Nil(t, snap["secrets"])
+
+		records := snap["records"].(map[string]interface{})
+		for _, k := range []string{
+			"users/byEntityID/" + ownerID,
+			"users/byName/team/jane",
+			fmt.Sprintf("bundles/%s/bundles/%s", ownerID, bundleID),
+			fmt.Sprintf("bundles/%s/sharedWithMe", janeID),
+		} {
+			require.Contains(t, records, k)
+		}
+	})
+
+	var snap map[string]interface{}
+	t.Run("with kv data", func(t *testing.T) {
+		snap = snapshot(request(b, s, logical.ReadOperation, "backup", map[string]interface{}{"include_kv_data": true}))
+
+		var secrets []pwmgrSnapshotSecret
+		raw, _ := json.Marshal(snap["secrets"])
+		require.NoError(t, json.Unmarshal(raw, &secrets))
+		require.Len(t, secrets, 3)
+
+		byPath := map[string][]map[string]interface{}{}
+		for _, s := range secrets {
+			byPath[s.Path] = s.Versions
+		}
+		require.Equal(t, []map[string]interface{}{{"entry": "v1"}, {"entry": "v3"}}, byPath[pb.Path+"/entries/"+entryPath])
+		require.Len(t, byPath[pb.Path+"/metadata/entries"], 2)
+		require.NotContains(t, byPath, "bundles/data/other/not-a-bundle/x")
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		restored, rs := getTestBackend(t)
+		require.NoError(t, restored.Initialize(ctx, &logical.InitializationRequest{Storage: rs}))
+		policies := &MockPolicyService{}
+		restored.policyService = policies
+		restoredKV := &testKVVersions{versions: map[string][]map[string]interface{}{}, casRequired: true}
+		restored.c = testVaultServer(t, restoredKV.handler)
+
+		resp := request(restored, rs, logical.UpdateOperation, "restore", map[string]interface{}{"snapshot": snap})
+		require.False(t, resp.IsError(), resp.Error())
+		require.Equal(t, 3, resp.Data["secrets"])
+		require.Equal(t, 1, resp.Data["policies"])
+		require.Equal(t, 1, policies.CallCount)
+
+		sb, err := restored.listSharedBundles(ctx, rs, janeID)
+		require.NoError(t, err)
+		require.Len(t, sb, 1)
+		require.Equal(t, bundleID, sb[0].ID)
+
+		user, err := restored.getUser(ctx, rs, ownerID)
+		require.NoError(t, err)
+		require.Equal(t, PubKey{"kty": "RSA"}, user.UUK.PubKey)
+
+		require.Equal(t, []map[string]interface{}{{"entry": "v1"}, {"entry": "v3"}}, restoredKV.versions[pb.Path+"/entries/"+entryPath])
+		require.Equal(t, []map[string]interface{}{{"key": "wrapped"}}, restoredKV.versions[pb.Path+"/keys/"+ownerID])
+
+		again := snapshot(request(restored, rs, logical.ReadOperation, "backup", nil))
+		require.Equal(t, snap["records"], again["records"])
+
+		// the mount isn't empty anymore
+		resp = request(restored, rs, logical.UpdateOperation, "restore", map[string]interface{}{"snapshot": snap})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "empty mount")
+	})
+
+	t.Run("restore over existing secrets", func(t *testing.T) {
+		restored, rs := getTestBackend(t)
+		require.NoError(t, restored.Initialize(ctx, &logical.InitializationRequest{Storage: rs}))
+		restored.policyService = &MockPolicyService{}
+		restoredKV := &testKVVersions{
+			versions:    map[string][]map[string]interface{}{pb.Path + "/entries/" + entryPath: {{"entry": "other"}}},
+			casRequired: true,
+		}
+		restored.c = testVaultServer(t, restoredKV.handler)
+
+		_, err := restored.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "restore",
+			Data:      map[string]interface{}{"snapshot": snap},
+			Storage:   rs,
+		})
+		require.ErrorContains(t, err, "check-and-set")
+		require.Equal(t, []map[string]interface{}{{"entry": "other"}}, restoredKV.versions[pb.Path+"/entries/"+entryPath])
+	})
+
+	t.Run("invalid snapshots", func(t *testing.T) {
+		restored, rs := getTestBackend(t)
+		restored.policyService = &MockPolicyService{}
+
+		for name, snap := range map[string]map[string]interface{}{
+			"version":     {"version": 2, "records": map[string]interface{}{}},
+			"record path": {"version": SnapshotVersion, "records": map[string]interface{}{"sys/policy": map[string]interface{}{}}},
+			"secret path": {"version": SnapshotVersion, "records": map[string]interface{}{}, "secrets": []interface{}{map[string]interface{}{"path": "secret/data/x", "versions": []interface{}{}}}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				resp := request(restored, rs, logical.UpdateOperation, "restore", map[string]interface{}{"snapshot": snap})
+				require.True(t, resp.IsError())
+			})
+		}
+	})
+}
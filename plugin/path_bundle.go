@@ -3,8 +3,10 @@ package secretsengine
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -47,6 +49,10 @@ type pwmgrBundle struct {
 	Users []pwmgrUser `json:"users"`
 
 	WALEntry bool `json:"wal_entry"`
+
+	// Frozen bundles were kept when their owner was offboarded. Their users
+	// can read them but no longer be changed.
+	Frozen bool `json:"frozen,omitempty"`
 }
 
 type pwmgrSharedBundle struct {
@@ -63,6 +69,13 @@ type pwmgrSharedBundle struct {
 
 type pwmgrSharedBundles map[string]pwmgrSharedBundle
 
+// Exported names of the bundle types.
+type (
+	Bundle       = pwmgrBundle
+	BundleUser   = pwmgrUser
+	SharedBundle = pwmgrSharedBundle
+)
+
 // pathBundle extends the Vault API with a `/bundle`
 // endpoint for the backend. You can choose whether
 // or not certain attributes should be displayed,
@@ -84,6 +97,28 @@ func pathBundle(b *pwManagerBackend) []*framework.Path {
 				logical.UpdateOperation: &framework.PathOperation{
 					Callback: b.pathBundleCreate,
 				},
+			},
+			HelpSynopsis:    pathBundleHelpSynopsis,
+			HelpDescription: pathBundleHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("bundles/%s/%s", uuidRegex("owner_entity_id"), uuidRegex("bundle_id")),
+			Fields: map[string]*framework.FieldSchema{
+				"owner_entity_id": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "entity id of the bundle owner",
+					Required:    true,
+				},
+				"bundle_id": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "uuid of the bundle",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathBundleReadOne,
+				},
 				logical.DeleteOperation: &framework.PathOperation{
 					Callback: b.pathBundleDelete,
 				},
@@ -106,7 +141,7 @@ func pathBundle(b *pwManagerBackend) []*framework.Path {
 				},
 				"users": {
 					Type:        framework.TypeSlice,
-					Description: "users for this bundle",
+					Description: "users for this bundle, the entity_name of a user is their entity name, entity id or auth alias as <auth mount>/<alias name>",
 					Required:    false,
 				},
 			},
@@ -147,6 +182,39 @@ func (b *pwManagerBackend) pathBundleRead(ctx context.Context, req *logical.Requ
 	}, nil
 }
 
+// pathBundleReadOne returns a single bundle to its owner or a bundle user
+func (b *pwManagerBackend) pathBundleReadOne(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ownerEntityID := data.Get("owner_entity_id").(string)
+	bundleID := data.Get("bundle_id").(string)
+
+	pb, err := getBundle(ctx, req.Storage, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ownerEntityID, bundleID))
+	if err != nil {
+		return nil, err
+	}
+
+	if pb == nil {
+		return nil, nil
+	}
+
+	isMember := req.EntityID == pb.OwnerEntityID
+	for _, u := range pb.Users {
+		if u.EntityID == req.EntityID {
+			isMember = true
+			break
+		}
+	}
+
+	if !isMember {
+		return logical.ErrorResponse("not authorized"), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bundle": pb,
+		},
+	}, nil
+}
+
 // pathBundleCreate updates the configuration for the backend
 func (b *pwManagerBackend) pathBundleCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	d, err := b.bundleCreate(ctx, req.Storage, req.EntityID)
@@ -272,27 +340,175 @@ func (b *pwManagerBackend) bundleCreate(ctx context.Context, s logical.Storage,
 
 ///////////////////////// bundle delete /////////////////////////
 
-// pathBundleDelete removes the configuration for the backend
+// pathBundleDelete removes the bundle from every bundle users shared bundles, updates their
+// policies and moves the bundle to the owners trash. Only the bundle owner can delete a bundle.
+// The encrypted bundle data in the kv-v2 store is destroyed when the trash is purged.
 func (b *pwManagerBackend) pathBundleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	return logical.ErrorResponse("not implemented"), nil
+	ownerEntityID := data.Get("owner_entity_id").(string)
+	bundleID := data.Get("bundle_id").(string)
+
+	if req.EntityID != ownerEntityID {
+		return logical.ErrorResponse("not authorized"), nil
+	}
+
+	bundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ownerEntityID, bundleID)
+
+	bundleLock := bundleMapOfMu.Lock(bundlePath)
+	defer bundleLock.Unlock()
+
+	pb, err := getBundle(ctx, req.Storage, bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if pb == nil {
+		return logical.ErrorResponse("bundle not found"), nil
+	}
+
+	// removing every user revokes their shared bundle and policy access
+	if err := b.removeBundleUsers(ctx, req.Storage, *pb, []pwmgrUser{}); err != nil {
+		return clientErrorResponse(err)
+	}
+
+	if err := b.trashBundleItem(ctx, req.Storage, *pb, req.EntityID); err != nil {
+		return nil, fmt.Errorf("error moving bundle to trash: %w", err)
+	}
+
+	if err := req.Storage.Delete(ctx, bundlePath); err != nil {
+		return nil, fmt.Errorf("error deleting bundle: %w", err)
+	}
+
+	return nil, nil
 }
 
 // /////////////////////// bundle create/update users /////////////////////////
 
+// setUsersEntityID resolves the share target in the EntityName of the new
+// users to the entity id and the registered entity name of the user.
 func (b *pwManagerBackend) setUsersEntityID(ctx context.Context, s logical.Storage, newUsers []pwmgrUser) ([]pwmgrUser, error) {
+	// names maps the entity ids of the users to their name, it is only
+	// read once a user is shared with by entity id or auth alias
+	var names map[string]string
 	users := []pwmgrUser{}
 	for _, nu := range newUsers {
-		userEntityID, err := b.getUserEntityIDByName(ctx, s, nu.EntityName)
-		if err != nil || len(userEntityID) == 0 {
-			return []pwmgrUser{}, fmt.Errorf("error retrieving new users entityID")
+		userEntityID, name, err := b.resolveBundleUser(ctx, s, nu.EntityName)
+		if err != nil {
+			return []pwmgrUser{}, err
+		}
+
+		if name == "" {
+			if names == nil {
+				if names, err = b.getUserEntityNames(ctx, s); err != nil {
+					return []pwmgrUser{}, err
+				}
+			}
+			name = names[userEntityID]
+		}
+		if name == "" {
+			return []pwmgrUser{}, fmt.Errorf("error retrieving new users entity name")
 		}
 
-		nu.EntityID = userEntityID
+		nu.EntityID, nu.EntityName = userEntityID, name
 		users = append(users, nu)
 	}
 	return users, nil
 }
 
+// resolveBundleUser returns the entity id of the user a bundle is shared
+// with and their entity name when the target is their name. The target is
+// an entity name, an entity id or an auth alias given as
+// `<auth mount>/<alias name>`. A target matching different users is
+// ambiguous.
+func (b *pwManagerBackend) resolveBundleUser(ctx context.Context, s logical.Storage, target string) (string, string, error) {
+	if target == "" {
+		return "", "", fmt.Errorf("missing bundle user")
+	}
+
+	// matches maps the entity id of the matched users to how they matched
+	matches := map[string][]string{}
+
+	// a renamed entity could have left its name to someone else
+	var staleErr error
+	if id, err := b.getUserEntityIDByName(ctx, s, target); err == nil {
+		if staleErr = b.checkUserName(ctx, s, target, id); staleErr == nil {
+			matches[id] = append(matches[id], "entity name")
+		}
+	}
+
+	if _, err := uuid.ParseUUID(target); err == nil {
+		user, err := b.getUser(ctx, s, target)
+		if err != nil {
+			return "", "", err
+		}
+		if user != nil {
+			matches[target] = append(matches[target], "entity id")
+		}
+	}
+
+	if i := strings.LastIndex(target, "/"); i > 0 && i < len(target)-1 && b.c != nil {
+		id, err := b.entityIDByAlias(ctx, target[:i], target[i+1:])
+		if err != nil {
+			return "", "", err
+		}
+		if id != "" {
+			user, err := b.getUser(ctx, s, id)
+			if err != nil {
+				return "", "", err
+			}
+			if user != nil {
+				matches[id] = append(matches[id], "auth alias")
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		if staleErr != nil {
+			return "", "", staleErr
+		}
+		return "", "", fmt.Errorf("no registered user matches `%s`", target)
+	case 1:
+		for id, how := range matches {
+			if slices.Contains(how, "entity name") {
+				return id, target, nil
+			}
+			return id, "", nil
+		}
+	}
+
+	ids := []string{}
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	candidates := []string{}
+	for _, id := range ids {
+		candidates = append(candidates, fmt.Sprintf("%s by %s", id, strings.Join(matches[id], " and ")))
+	}
+	return "", "", fmt.Errorf("`%s` is ambiguous, it matches the users %s: share by entity id", target, strings.Join(candidates, ", "))
+}
+
+// entityIDByAlias returns the id of the entity with the alias on the auth
+// mount or an empty id if the mount or alias don't exist.
+func (b *pwManagerBackend) entityIDByAlias(ctx context.Context, mount, aliasName string) (string, error) {
+	accessor, err := b.c.Identity().AuthMountAccessor(ctx, strings.TrimPrefix(mount, "auth/"))
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading auth mount %s: %w", mount, err)
+	}
+
+	id, err := b.c.Identity().EntityIDByAlias(ctx, accessor, aliasName)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error looking up alias %s/%s: %w", mount, aliasName, err)
+	}
+	return id, nil
+}
+
 // pathBundleWrite updates the configuration for the backend
 func (b *pwManagerBackend) pathBundleUsersWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	ownerEntityID, ok := d.GetOk("owner_entity_id")
@@ -339,6 +555,9 @@ func (b *pwManagerBackend) pathBundleUsersWrite(ctx context.Context, req *logica
 	if err != nil || pb == nil {
 		return logical.ErrorResponse("bundle not found"), nil
 	}
+	if pb.Frozen {
+		return logical.ErrorResponse("bundle is frozen"), nil
+	}
 
 	// The current edge cases are around data integrity.
 	// If a current bundle user was given less/more access
@@ -368,13 +587,13 @@ func (b *pwManagerBackend) pathBundleUsersWrite(ctx context.Context, req *logica
 
 	err = b.removeBundleUsers(ctx, req.Storage, *pb, users)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return clientErrorResponse(err)
 	}
 
 	/// modified users
 	err = b.updateModifiedUsers(ctx, req.Storage, *pb, modifiedUsers)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return clientErrorResponse(err)
 	}
 
 	pb.Users = users
@@ -392,19 +611,12 @@ func (b *pwManagerBackend) pathBundleUsersWrite(ctx context.Context, req *logica
 	}, nil
 }
 
-// getUserPubKeys retrieves the users public key from the KV store
+// getUserPubKeys retrieves the public key of the users resolved by
+// setUsersEntityID
 func (b *pwManagerBackend) getUserPubKeys(ctx context.Context, s logical.Storage, newUsers []pwmgrUser) (map[string]PubKey, error) {
 	usersPubKeys := map[string]PubKey{}
 
-	for i := range newUsers {
-		nu := &newUsers[i]
-		userEntityID, err := b.getUserEntityIDByName(ctx, s, nu.EntityName)
-		nu.EntityID = userEntityID
-
-		if err != nil {
-			return map[string]PubKey{}, fmt.Errorf("error retrieving new users entityID")
-		}
-
+	for _, nu := range newUsers {
 		userUUK, err := b.getUser(ctx, s, nu.EntityID)
 		if err != nil || userUUK == nil {
 			return map[string]PubKey{}, fmt.Errorf("error retrieving new users public key")
@@ -547,7 +759,7 @@ func (b *pwManagerBackend) removeBundleUsers(ctx context.Context, s logical.Stor
 				return err
 			}
 
-			err = b.UpdateUserPolicy(sbs, u.EntityName)
+			err = b.UpdateUserPolicy(ctx, sbs, u.EntityName)
 			if err != nil {
 				sharedBundleLock.Unlock()
 				return err
@@ -613,10 +825,10 @@ func (b *pwManagerBackend) updateModifiedUsers(ctx context.Context, s logical.St
 				return err
 			}
 
-			err = b.UpdateUserPolicy(sbs, mu.EntityName)
+			err = b.UpdateUserPolicy(ctx, sbs, mu.EntityName)
 			if err != nil {
 				sharedBundleLock.Unlock()
-				return fmt.Errorf("error updating user policy: %s", err)
+				return fmt.Errorf("error updating user policy: %w", err)
 			}
 			sharedBundleLock.Unlock()
 		}
@@ -624,7 +836,7 @@ func (b *pwManagerBackend) updateModifiedUsers(ctx context.Context, s logical.St
 	return nil
 }
 
-func (b *pwManagerBackend) UpdateUserPolicy(sbs pwmgrSharedBundles, entityName string) error {
+func (b *pwManagerBackend) UpdateUserPolicy(ctx context.Context, sbs pwmgrSharedBundles, entityName string) error {
 	tmpl, err := template.New("test").Parse(adminTmpl)
 	if err != nil {
 		return err
@@ -653,14 +865,19 @@ func (b *pwManagerBackend) UpdateUserPolicy(sbs pwmgrSharedBundles, entityName s
 		return err
 	}
 
-	// TODO find out if backend knows the mount we currently are in. if not we can
-	backendMount := "pwmanager"
-	policyName := fmt.Sprintf("%s/entity/%s", backendMount, entityName)
-	err = b.policyService.PutPolicy(policyName, tpl.String())
+	err = b.policyService.PutPolicy(ctx, userPolicyName(entityName), tpl.String())
 
 	return err
 }
 
+// userPolicyName returns the name of the policy granting the user access to
+// the bundles shared with them.
+func userPolicyName(entityName string) string {
+	// TODO find out if backend knows the mount we currently are in. if not we can
+	backendMount := "pwmanager"
+	return fmt.Sprintf("%s/entity/%s", backendMount, entityName)
+}
+
 ///////////////////////// bundle kv helper /////////////////////////
 
 func getBundle(ctx context.Context, s logical.Storage, path string) (*pwmgrBundle, error) {
@@ -767,6 +984,32 @@ func (b *pwManagerBackend) pathBundleExistenceCheck(ctx context.Context, req *lo
 	return out != nil, nil
 }
 
+// authorizeBundle returns the bundle of the owner_entity_id and bundle_id of
+// the request when the caller is the owner or a bundle user with the
+// capability. A response is returned when the request is rejected.
+func (b *pwManagerBackend) authorizeBundle(ctx context.Context, req *logical.Request, data *framework.FieldData, capability string) (*pwmgrBundle, *logical.Response, error) {
+	ownerEntityID := data.Get("owner_entity_id").(string)
+	bundleID := data.Get("bundle_id").(string)
+
+	pb, err := getBundle(ctx, req.Storage, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ownerEntityID, bundleID))
+	if err != nil {
+		return nil, nil, err
+	}
+	if pb == nil {
+		return nil, logical.ErrorResponse("bundle not found"), nil
+	}
+
+	if req.EntityID == pb.OwnerEntityID {
+		return pb, nil, nil
+	}
+	for _, u := range pb.Users {
+		if u.EntityID == req.EntityID && slices.Contains(strings.Split(u.Capabilities, ","), capability) {
+			return pb, nil, nil
+		}
+	}
+	return nil, logical.ErrorResponse("not authorized"), nil
+}
+
 // admin template
 var adminTmpl = `
 {{range $index, $bundle := . }}
@@ -774,6 +1017,10 @@ path "bundles/data/{{$bundle.Path}}/*" {
     capabilities = [{{$first := true}}{{range $bundle.Capabilities}}{{if $first}}{{$first = false}}{{else}}, {{end}}"{{.}}"{{end}} ]
 }
 
+path "bundles/data/{{$bundle.Path}}/attachments/*" {
+    capabilities = [ "read", "list" ]
+}
+
 path "bundles/metadata/{{$bundle.Path}}/*" {
     capabilities = [ {{$first := true}}{{range $bundle.Capabilities}}{{if $first}}{{$first = false}}{{else}}, {{end}}"{{.}}"{{end}} ]
 }
@@ -783,4 +1030,8 @@ path "bundles/metadata/{{$bundle.Path}}/*" {
 const pathBundleHelpSynopsis = `bundles endpoints allow users to create and share bundles.`
 
 // pathBundleHelpDescription describes the help text for the bundles
-const pathBundleHelpDescription = `bundles endpoints allow users to create and share bundles.`
+const pathBundleHelpDescription = `bundles endpoints allow users to create and share bundles.
+
+A bundle is shared with a user given by their entity name, their entity id
+or an alias of their entity as <auth mount>/<alias name>, e.g. ldap/jdoe.
+A target matching different users is rejected as ambiguous.`
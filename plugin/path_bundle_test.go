@@ -2,12 +2,17 @@ package secretsengine
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -33,6 +38,9 @@ func TestBundle(t *testing.T) {
 
 		err = testBundleUsersAdd(t, b, reqStorage, entityID, bundleID)
 		assert.NoError(t, err)
+
+		err = testBundleDelete(t, b, reqStorage, entityID, bundleID)
+		assert.NoError(t, err)
 		/*
 			err = testBundleUpdate(t, b, reqStorage, map[string]interface{}{
 				"role_id": bundleRoleID,
@@ -55,10 +63,26 @@ func TestBundle(t *testing.T) {
 	})
 }
 
-func testBundleDelete(t *testing.T, b logical.Backend, s logical.Storage, entityID string) error {
+func testBundleDelete(t *testing.T, b *pwManagerBackend, s logical.Storage, entityID string, bundleID string) error {
+	otherEntityID, _ := uuid.GenerateUUID()
 	resp, err := b.HandleRequest(context.Background(), &logical.Request{
 		Operation: logical.DeleteOperation,
-		Path:      configStoragePath,
+		Path:      fmt.Sprintf("bundles/%s/%s", entityID, bundleID),
+		Storage:   s,
+		EntityID:  otherEntityID,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if resp == nil || !resp.IsError() {
+		return fmt.Errorf("only the bundle owner should be able to delete a bundle")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      fmt.Sprintf("bundles/%s/%s", entityID, bundleID),
 		Storage:   s,
 		EntityID:  entityID,
 	})
@@ -70,6 +94,15 @@ func testBundleDelete(t *testing.T, b logical.Backend, s logical.Storage, entity
 	if resp != nil && resp.IsError() {
 		return resp.Error()
 	}
+
+	bundles, err := b.listBundles(context.Background(), s, entityID)
+	if err != nil {
+		return err
+	}
+
+	if len(bundles) != 0 {
+		return fmt.Errorf("should have 0 bundles after delete")
+	}
 	return nil
 }
 
@@ -236,11 +269,142 @@ func testBundleRead(t *testing.T, b logical.Backend, s logical.Storage, entityID
 	return nil
 }
 
+// TestBundleShareTargets tests sharing a bundle by entity name, entity id
+// and auth alias.
+func TestBundleShareTargets(t *testing.T) {
+	b, s := getTestBackend(t)
+	b.policyService = &MockPolicyService{}
+	ctx := context.Background()
+
+	ids := map[string]string{}
+	entities := &testEntities{entities: map[string]Entity{}}
+	for _, name := range []string{"bob", "jane", "tom", "ldap/tom"} {
+		ids[name], _ = uuid.GenerateUUID()
+		entities.set(Entity{ID: ids[name], Name: name})
+		require.NoError(t, b.setUserByEntityID(ctx, s, ids[name], &pwManagerUserEntry{EntityID: ids[name]}))
+		require.NoError(t, b.setUserByName(ctx, s, name, ids[name]))
+	}
+	aliases := map[string]string{"jdoe": ids["jane"], "tom": ids["tom"]}
+	entities.next = func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/auth":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"ldap/": map[string]interface{}{"accessor": "auth_ldap_1"}}})
+		case "/v1/identity/lookup/entity":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if id, ok := aliases[body["alias_name"]]; ok && body["alias_mount_accessor"] == "auth_ldap_1" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"id": id}})
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}
+	b.c = testVaultServer(t, entities.handler)
+
+	data, err := b.bundleCreate(ctx, s, ids["bob"])
+	require.NoError(t, err)
+	bundleID := path.Base(data["path"].(string))
+
+	share := func(target string) (*logical.Response, *pwmgrBundle) {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      fmt.Sprintf("bundles/%s/%s/users", ids["bob"], bundleID),
+			Storage:   s,
+			EntityID:  ids["bob"],
+			Data:      map[string]interface{}{"users": []pwmgrUser{{EntityName: target, Capabilities: "read,list"}}},
+		})
+		require.NoError(t, err)
+		pb, err := getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ids["bob"], bundleID))
+		require.NoError(t, err)
+		return resp, pb
+	}
+
+	for _, tc := range []struct {
+		target string
+		user   string
+	}{
+		{"jane", "jane"},
+		{"ldap/jdoe", "jane"},
+		{"auth/ldap/jdoe", "jane"},
+		{ids["tom"], "tom"},
+	} {
+		t.Run(tc.target, func(t *testing.T) {
+			resp, pb := share(tc.target)
+			require.False(t, resp.IsError(), resp.Error())
+			require.Contains(t, resp.Data["pubkeys"], ids[tc.user])
+			require.Len(t, pb.Users, 1)
+			require.Equal(t, ids[tc.user], pb.Users[0].EntityID)
+			require.Equal(t, tc.user, pb.Users[0].EntityName)
+		})
+	}
+
+	t.Run("no match", func(t *testing.T) {
+		for _, target := range []string{"ldap/nobody", "github/jdoe", "nobody"} {
+			resp, _ := share(target)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), "no registered user matches")
+		}
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		resp, pb := share("ldap/tom")
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "ambiguous")
+		require.Contains(t, resp.Error().Error(), ids["tom"]+" by auth alias")
+		require.Contains(t, resp.Error().Error(), ids["ldap/tom"]+" by entity name")
+		require.Equal(t, ids["tom"], pb.Users[0].EntityID)
+	})
+
+	t.Run("names read once", func(t *testing.T) {
+		counted := &testCountingStorage{Storage: s, prefix: USER_SCHEMA + "/byName/"}
+		targets := []pwmgrUser{}
+		for _, name := range []string{"jane", "tom", "ldap/tom"} {
+			targets = append(targets, pwmgrUser{EntityName: ids[name], Capabilities: "read,list"})
+		}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      fmt.Sprintf("bundles/%s/%s/users", ids["bob"], bundleID),
+			Storage:   counted,
+			EntityID:  ids["bob"],
+			Data:      map[string]interface{}{"users": targets},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), resp.Error())
+
+		// a lookup of each id as a name and one read of every name
+		require.Equal(t, len(targets)+len(ids), counted.gets)
+	})
+}
+
+// testCountingStorage counts the reads of the keys with the prefix.
+type testCountingStorage struct {
+	logical.Storage
+	prefix string
+	gets   int
+}
+
+func (s *testCountingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if strings.HasPrefix(key, s.prefix) {
+		s.gets++
+	}
+	return s.Storage.Get(ctx, key)
+}
+
 type MockPolicyService struct {
 	CallCount int
+	Deleted   []string
 }
 
-func (m *MockPolicyService) PutPolicy(name, rules string) error {
+func (m *MockPolicyService) PutPolicy(ctx context.Context, name, rules string) error {
 	m.CallCount++
 	return nil
 }
+
+func (m *MockPolicyService) DeletePolicy(ctx context.Context, name string) error {
+	m.Deleted = append(m.Deleted, name)
+	return nil
+}
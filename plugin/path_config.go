@@ -19,8 +19,20 @@ type pwmgrConfig struct {
 	RoleID   string `json:"role_id"`
 	SecretID string `json:"secret_id"`
 	URL      string `json:"url"`
+	// TrashRetention is how long deleted entries and bundles are kept in
+	// seconds, see defaultTrashRetention
+	TrashRetention int64 `json:"trash_retention"`
+	// AttachmentQuota is the size of the attachments a bundle can hold in
+	// bytes, see defaultAttachmentQuota
+	AttachmentQuota int64 `json:"attachment_quota"`
+	// UserVisibility is which users the directory shows a caller, see
+	// userVisibilityAll and userVisibilityGroups
+	UserVisibility string `json:"user_visibility"`
 }
 
+// Config is the exported name of pwmgrConfig.
+type Config = pwmgrConfig
+
 // pathConfig extends the Vault API with a `/config`
 // endpoint for the backend. You can choose whether
 // or not certain attributes should be displayed,
@@ -58,6 +70,31 @@ func pathConfig(b *pwManagerBackend) *framework.Path {
 					Sensitive: false,
 				},
 			},
+			"trash_retention": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long deleted entries and bundles are kept before they are destroyed, 30 days by default",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Trash retention",
+					Sensitive: false,
+				},
+			},
+			"attachment_quota": {
+				Type:        framework.TypeInt64,
+				Description: "Size of the attachments a bundle can hold in bytes, 100 MiB by default",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Attachment quota",
+					Sensitive: false,
+				},
+			},
+			"user_visibility": {
+				Type:          framework.TypeString,
+				Description:   "Which users the directory shows a caller: all users or only users in one of their groups, all by default",
+				AllowedValues: []interface{}{userVisibilityAll, userVisibilityGroups},
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "User visibility",
+					Sensitive: false,
+				},
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
@@ -98,8 +135,11 @@ func (b *pwManagerBackend) pathConfigRead(ctx context.Context, req *logical.Requ
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"role_id": config.RoleID,
-			"url":     config.URL,
+			"role_id":          config.RoleID,
+			"url":              config.URL,
+			"trash_retention":  config.TrashRetention,
+			"attachment_quota": config.AttachmentQuota,
+			"user_visibility":  config.UserVisibility,
 		},
 	}, nil
 }
@@ -138,6 +178,29 @@ func (b *pwManagerBackend) pathConfigWrite(ctx context.Context, req *logical.Req
 		return nil, fmt.Errorf("missing secret_id in configuration")
 	}
 
+	if retention, ok := data.GetOk("trash_retention"); ok {
+		if retention.(int) < 0 {
+			return logical.ErrorResponse("trash_retention must not be negative"), nil
+		}
+		config.TrashRetention = int64(retention.(int))
+	}
+
+	if quota, ok := data.GetOk("attachment_quota"); ok {
+		if quota.(int64) < 0 {
+			return logical.ErrorResponse("attachment_quota must not be negative"), nil
+		}
+		config.AttachmentQuota = quota.(int64)
+	}
+
+	if visibility, ok := data.GetOk("user_visibility"); ok {
+		switch visibility.(string) {
+		case userVisibilityAll, userVisibilityGroups:
+			config.UserVisibility = visibility.(string)
+		default:
+			return logical.ErrorResponse("user_visibility must be %s or %s", userVisibilityAll, userVisibilityGroups), nil
+		}
+	}
+
 	entry, err := logical.StorageEntryJSON(configStoragePath, config)
 	if err != nil {
 		return nil, err
@@ -30,22 +30,31 @@ func TestConfig(t *testing.T) {
 		assert.NoError(t, err)
 
 		err = testConfigRead(t, b, reqStorage, map[string]interface{}{
-			"role_id": roleID,
-			"url":     url,
+			"role_id":          roleID,
+			"url":              url,
+			"trash_retention":  int64(0),
+			"attachment_quota": int64(0),
+			"user_visibility":  "",
 		})
 
 		assert.NoError(t, err)
 
 		err = testConfigUpdate(t, b, reqStorage, map[string]interface{}{
-			"role_id": roleID,
-			"url":     "http://pwmgr:19090",
+			"role_id":          roleID,
+			"url":              "http://pwmgr:19090",
+			"trash_retention":  "24h",
+			"attachment_quota": 1 << 20,
+			"user_visibility":  "groups",
 		})
 
 		assert.NoError(t, err)
 
 		err = testConfigRead(t, b, reqStorage, map[string]interface{}{
-			"role_id": roleID,
-			"url":     "http://pwmgr:19090",
+			"role_id":          roleID,
+			"url":              "http://pwmgr:19090",
+			"trash_retention":  int64(86400),
+			"attachment_quota": int64(1 << 20),
+			"user_visibility":  "groups",
 		})
 
 		assert.NoError(t, err)
@@ -0,0 +1,317 @@
+package secretsengine
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+const (
+	// userVisibilityAll shows every registered user in the directory.
+	userVisibilityAll = "all"
+	// userVisibilityGroups shows the users sharing an identity group with
+	// the caller in the directory.
+	userVisibilityGroups = "groups"
+
+	// directoryDefaultLimit and directoryMaxLimit bound a directory page
+	directoryDefaultLimit = 20
+	directoryMaxLimit     = 100
+
+	// directoryDisplayNameKey is the entity metadata key holding the
+	// display name of a user
+	directoryDisplayNameKey = "display_name"
+
+	// directoryEntityTTL is how long the entity of a user read by a
+	// directory search is cached
+	directoryEntityTTL = 5 * time.Minute
+)
+
+// directoryEntity is a cached entity of a user, the entity is nil if it
+// doesn't exist.
+type directoryEntity struct {
+	entity  *Entity
+	fetched time.Time
+}
+
+// pwmgrDirectoryUser is a registered user a bundle can be shared with.
+type pwmgrDirectoryUser struct {
+	EntityID    string `json:"entity_id"`
+	EntityName  string `json:"entity_name"`
+	DisplayName string `json:"display_name,omitempty"`
+	// Fingerprint is the base64url SHA-256 JWK thumbprint of the public key
+	// of the user so it can be verified out of band
+	Fingerprint string `json:"fingerprint"`
+}
+
+// DirectoryUser is the exported name of pwmgrDirectoryUser.
+type DirectoryUser = pwmgrDirectoryUser
+
+// pathDirectory extends the Vault API with the directory of registered
+// users to pick who to share a bundle with.
+func pathDirectory(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "directory",
+			Fields: map[string]*framework.FieldSchema{
+				"query": {
+					Type:        framework.TypeString,
+					Description: "prefix of the entity name, an auth alias name or the display name of the users",
+				},
+				"after": {
+					Type:        framework.TypeString,
+					Description: "entity name the page starts after, the next of the previous page",
+				},
+				"limit": {
+					Type:        framework.TypeInt,
+					Description: "maximum number of users of the page",
+					Default:     directoryDefaultLimit,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathDirectoryRead,
+				},
+			},
+			HelpSynopsis:    pathDirectoryHelpSynopsis,
+			HelpDescription: pathDirectoryHelpDescription,
+		},
+	}
+}
+
+// pathDirectoryRead returns a page of the users visible to the caller
+// matching the query, ordered by entity name.
+func (b *pwManagerBackend) pathDirectoryRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if req.EntityID == "" {
+		return logical.ErrorResponse("the directory is only available to entities"), nil
+	}
+
+	limit := d.Get("limit").(int)
+	if limit <= 0 || limit > directoryMaxLimit {
+		return logical.ErrorResponse("limit must be between 1 and %d", directoryMaxLimit), nil
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	visibility := userVisibilityAll
+	if config != nil && config.UserVisibility != "" {
+		visibility = config.UserVisibility
+	}
+
+	if b.c == nil {
+		if err := b.Login(ctx); err != nil {
+			return clientErrorResponse(err)
+		}
+	}
+
+	users, next, err := b.searchDirectory(ctx, req.Storage, req.EntityID, visibility, strings.ToLower(d.Get("query").(string)), d.Get("after").(string), limit)
+	if err != nil {
+		return clientErrorResponse(err)
+	}
+
+	data := map[string]interface{}{
+		"users": users,
+	}
+	if next != "" {
+		data["next"] = next
+	}
+	return &logical.Response{Data: data}, nil
+}
+
+// searchDirectory returns up to limit users after the entity name matching
+// the lower case query and the entity name to continue after if there are
+// more. The caller isn't listed. The entity of a user is only read when the
+// groups are compared, the entity name doesn't match the query or the user
+// is on the page.
+func (b *pwManagerBackend) searchDirectory(ctx context.Context, s logical.Storage, callerID, visibility, query, after string, limit int) ([]pwmgrDirectoryUser, string, error) {
+	var callerGroups []any
+	if visibility == userVisibilityGroups {
+		caller, err := b.c.Identity().EntityByID(ctx, callerID)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading the entity of the caller: %w", err)
+		}
+		callerGroups = caller.GroupIds
+	}
+
+	names, err := logical.CollectKeys(ctx, logical.NewStorageView(s, fmt.Sprintf("%s/byName/", USER_SCHEMA)))
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(names)
+
+	users := []pwmgrDirectoryUser{}
+	for _, name := range names {
+		if name <= after {
+			continue
+		}
+
+		id, err := b.getUserEntityIDByName(ctx, s, name)
+		if err != nil {
+			return nil, "", err
+		}
+		if id == callerID {
+			continue
+		}
+
+		// a user whose entity doesn't exist is only found by name
+		var entity *Entity
+		fetched := false
+		nameMatches := strings.HasPrefix(strings.ToLower(name), query)
+		if visibility == userVisibilityGroups || !nameMatches {
+			if entity, err = b.directoryEntity(ctx, id); err != nil {
+				return nil, "", err
+			}
+			fetched = true
+		}
+
+		if visibility == userVisibilityGroups && (entity == nil || !sharesGroup(callerGroups, entity.GroupIds)) {
+			continue
+		}
+		if !nameMatches && (entity == nil || !entityMatches(*entity, query)) {
+			continue
+		}
+
+		user, err := b.getUser(ctx, s, id)
+		if err != nil {
+			return nil, "", err
+		}
+		if user == nil {
+			continue
+		}
+
+		if len(users) == limit {
+			return users, users[len(users)-1].EntityName, nil
+		}
+
+		if !fetched {
+			if entity, err = b.directoryEntity(ctx, id); err != nil {
+				return nil, "", err
+			}
+		}
+
+		du := pwmgrDirectoryUser{EntityID: id, EntityName: name, Fingerprint: pubKeyFingerprint(user.UUK.PubKey)}
+		if entity != nil {
+			du.DisplayName = entityDisplayName(*entity)
+		}
+		users = append(users, du)
+	}
+	return users, "", nil
+}
+
+// directoryEntity returns the entity of the user, it is read from Vault when
+// it isn't cached or was cached more than directoryEntityTTL ago. The entity
+// is nil if it doesn't exist.
+func (b *pwManagerBackend) directoryEntity(ctx context.Context, id string) (*Entity, error) {
+	now := time.Now()
+	b.directoryMu.Lock()
+	cached, ok := b.directoryEntities[id]
+	b.directoryMu.Unlock()
+	if ok && now.Sub(cached.fetched) < directoryEntityTTL {
+		return cached.entity, nil
+	}
+
+	var entity *Entity
+	e, err := b.c.Identity().EntityByID(ctx, id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("error reading entity %s: %w", id, err)
+	}
+	if err == nil {
+		entity = &e
+	}
+
+	b.directoryMu.Lock()
+	b.directoryEntities[id] = directoryEntity{entity: entity, fetched: now}
+	b.directoryMu.Unlock()
+	return entity, nil
+}
+
+// expireDirectoryEntities drops the entities cached before now minus
+// directoryEntityTTL.
+func (b *pwManagerBackend) expireDirectoryEntities(now time.Time) {
+	b.directoryMu.Lock()
+	defer b.directoryMu.Unlock()
+	for id, cached := range b.directoryEntities {
+		if now.Sub(cached.fetched) >= directoryEntityTTL {
+			delete(b.directoryEntities, id)
+		}
+	}
+}
+
+// entityMatches reports whether an alias or the display name of the entity
+// starts with the lower case query.
+func entityMatches(e Entity, query string) bool {
+	for _, a := range e.Aliases {
+		if strings.HasPrefix(strings.ToLower(a.Name), query) {
+			return true
+		}
+	}
+	displayName := entityDisplayName(e)
+	return displayName != "" && strings.HasPrefix(strings.ToLower(displayName), query)
+}
+
+// entityDisplayName returns the display name in the entity metadata.
+func entityDisplayName(e Entity) string {
+	metadata, _ := e.Metadata.(map[string]interface{})
+	name, _ := metadata[directoryDisplayNameKey].(string)
+	return name
+}
+
+// sharesGroup reports whether the group ids have a group in common.
+func sharesGroup(groups, other []any) bool {
+	for _, g := range groups {
+		for _, o := range other {
+			if g == o {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pubKeyFingerprint returns the base64url SHA-256 JWK thumbprint of the
+// public key or an empty fingerprint if the key can't be parsed.
+func pubKeyFingerprint(pubKey PubKey) string {
+	raw, err := json.Marshal(pubKey)
+	if err != nil {
+		return ""
+	}
+	key, err := jwk.ParseKey(raw)
+	if err != nil {
+		return ""
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+// pathDirectoryHelpSynopsis summarizes the help text for the directory
+const pathDirectoryHelpSynopsis = `Search the registered users to share bundles with.`
+
+// pathDirectoryHelpDescription describes the help text for the directory
+const pathDirectoryHelpDescription = `
+directory returns a page of the registered users ordered by entity name
+with their entity id, display name and the fingerprint of their public
+key. query filters the users whose entity name, auth alias name or
+display_name entity metadata starts with it, ignoring case. A page has up
+to limit users, next is set when there are more and is passed as after to
+read the next page.
+
+The user_visibility of the mount config restricts the users a caller sees:
+all, the default, shows every user and groups only the users in one of the
+identity groups of the caller. The entities of the users are cached for 5
+minutes, changes to their aliases, display name or groups can take as long
+to show.
+`
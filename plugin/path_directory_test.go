@@ -0,0 +1,113 @@
+package secretsengine
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDirectory tests searching and paging the users visible to a caller.
+func TestDirectory(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	entities := &testEntities{entities: map[string]Entity{}, reads: map[string]int{}, next: func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+	}}
+	b.c = testVaultServer(t, entities.handler)
+
+	ids := map[string]string{}
+	groups := map[string][]any{"bob": {"g1"}, "jane": {"g1"}, "tim": {"g1", "g2"}, "tom": {"g2"}}
+	for _, name := range []string{"bob", "jane", "tim", "tom"} {
+		ids[name], _ = uuid.GenerateUUID()
+		entities.set(Entity{ID: ids[name], Name: name, GroupIds: groups[name]})
+		require.NoError(t, b.setUserByEntityID(ctx, s, ids[name], &pwManagerUserEntry{EntityID: ids[name]}))
+		require.NoError(t, b.setUserByName(ctx, s, name, ids[name]))
+	}
+	entities.set(Entity{
+		ID:       ids["jane"],
+		Name:     "jane",
+		GroupIds: groups["jane"],
+		Aliases:  []Aliases{{Name: "jdoe", MountPath: "auth/ldap/"}},
+		Metadata: map[string]interface{}{"display_name": "Smith Jane"},
+	})
+
+	// the public key of RFC 7638 and its thumbprint
+	require.NoError(t, b.setUserByEntityID(ctx, s, ids["jane"], &pwManagerUserEntry{EntityID: ids["jane"], UUK: pwManagerUUKEntry{PubKey: PubKey{
+		"kty": "RSA",
+		"e":   "AQAB",
+		"n":   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}}}))
+	jane := pwmgrDirectoryUser{EntityID: ids["jane"], EntityName: "jane", DisplayName: "Smith Jane", Fingerprint: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"}
+
+	search := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "directory", Storage: s, EntityID: ids["bob"], Data: data})
+		require.NoError(t, err)
+		return resp
+	}
+	names := func(resp *logical.Response) []string {
+		t.Helper()
+		require.False(t, resp.IsError(), resp.Error())
+		names := []string{}
+		for _, u := range resp.Data["users"].([]pwmgrDirectoryUser) {
+			names = append(names, u.EntityName)
+		}
+		return names
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		resp := search(map[string]interface{}{"limit": 2})
+		require.Equal(t, []string{"jane", "tim"}, names(resp))
+		require.Equal(t, "tim", resp.Data["next"])
+		require.Equal(t, ids["tim"], resp.Data["users"].([]pwmgrDirectoryUser)[1].EntityID)
+
+		// only the entities of the users on the page are read
+		require.Equal(t, 1, entities.reads[ids["jane"]])
+		require.Equal(t, 1, entities.reads[ids["tim"]])
+		require.Zero(t, entities.reads[ids["tom"]])
+
+		resp = search(map[string]interface{}{"limit": 2, "after": "tim"})
+		require.Equal(t, []string{"tom"}, names(resp))
+		require.NotContains(t, resp.Data, "next")
+	})
+
+	t.Run("query", func(t *testing.T) {
+		require.Equal(t, []string{"tim", "tom"}, names(search(map[string]interface{}{"query": "T"})))
+		for _, query := range []string{"ja", "jd", "smi"} {
+			resp := search(map[string]interface{}{"query": query})
+			require.False(t, resp.IsError(), resp.Error())
+			require.Equal(t, []pwmgrDirectoryUser{jane}, resp.Data["users"])
+		}
+		require.Empty(t, names(search(map[string]interface{}{"query": "bob"})))
+
+		// the entities are read once and cached
+		require.Equal(t, 1, entities.reads[ids["jane"]])
+		require.Equal(t, 1, entities.reads[ids["tom"]])
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		b.expireDirectoryEntities(time.Now().Add(directoryEntityTTL))
+		require.Empty(t, b.directoryEntities)
+
+		resp := search(map[string]interface{}{"query": "jd"})
+		require.Equal(t, []pwmgrDirectoryUser{jane}, resp.Data["users"])
+		require.Equal(t, 2, entities.reads[ids["jane"]])
+	})
+
+	t.Run("groups", func(t *testing.T) {
+		require.NoError(t, s.Put(ctx, &logical.StorageEntry{Key: configStoragePath, Value: []byte(`{"user_visibility":"groups"}`)}))
+		require.Equal(t, []string{"jane", "tim"}, names(search(nil)))
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		resp := search(map[string]interface{}{"limit": directoryMaxLimit + 1})
+		require.True(t, resp.IsError())
+	})
+}
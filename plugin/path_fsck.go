@@ -0,0 +1,459 @@
+package secretsengine
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Codes of the inconsistencies reported by fsck.
+const (
+	// FsckUnknownRecord is a record outside of the plugin schemas.
+	FsckUnknownRecord = "unknown_record"
+	// FsckInvalidRecord is a user or bundle record that can't be decoded.
+	FsckInvalidRecord = "invalid_record"
+	// FsckStrayUserRecord is a record under users/ that is neither a user
+	// nor a user name, e.g. users/<id>.
+	FsckStrayUserRecord = "stray_user_record"
+	// FsckUserEntityIDMismatch is a user whose entity id differs from the
+	// one of its record path.
+	FsckUserEntityIDMismatch = "user_entity_id_mismatch"
+	// FsckDanglingUserName is a user name of an entity that isn't a user.
+	FsckDanglingUserName = "dangling_user_name"
+	// FsckUnnamedUser is a user without a user name. Bundles can't be
+	// shared with it and its policy can't be written.
+	FsckUnnamedUser = "unnamed_user"
+	// FsckBundleMismatch is a bundle whose id, owner or path differs from
+	// its record path.
+	FsckBundleMismatch = "bundle_mismatch"
+	// FsckOrphanedBundle is a bundle whose owner isn't a user and that
+	// wasn't frozen when the owner was offboarded.
+	FsckOrphanedBundle = "orphaned_bundle"
+	// FsckInterruptedBundleWrite is a bundle whose users write didn't
+	// complete.
+	FsckInterruptedBundleWrite = "interrupted_bundle_write"
+	// FsckUnknownBundleUser is a bundle user that isn't a user.
+	FsckUnknownBundleUser = "unknown_bundle_user"
+	// FsckMissingSharedBundle is a bundle user without the matching shared
+	// bundle.
+	FsckMissingSharedBundle = "missing_shared_bundle"
+	// FsckSharedBundleMismatch is a shared bundle whose capabilities or path
+	// differ from the bundle user.
+	FsckSharedBundleMismatch = "shared_bundle_mismatch"
+	// FsckDanglingSharedBundle is a shared bundle of a bundle that doesn't
+	// exist.
+	FsckDanglingSharedBundle = "dangling_shared_bundle"
+	// FsckSharedBundleNotMember is a shared bundle of a bundle that doesn't
+	// list the user.
+	FsckSharedBundleNotMember = "shared_bundle_not_member"
+)
+
+// pwmgrFsckIssue is an inconsistency of the plugin records. Issues without
+// a repair are reported only, e.g. because repairing them loses data.
+type pwmgrFsckIssue struct {
+	Code       string `json:"code"`
+	Path       string `json:"path"`
+	Message    string `json:"message"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+
+	repair func(ctx context.Context, s logical.Storage) error
+}
+
+// FsckIssue is the exported name of pwmgrFsckIssue.
+type FsckIssue = pwmgrFsckIssue
+
+// fsckRecords are the user and bundle records read by fsck.
+type fsckRecords struct {
+	users   map[string]*pwManagerUserEntry
+	names   map[string]string
+	bundles map[string]map[string]*pwmgrBundle
+	shared  map[string]pwmgrSharedBundles
+}
+
+// fsck collects the issues and the entities whose policy must be written
+// after their shared bundles are repaired.
+type fsck struct {
+	b        *pwManagerBackend
+	issues   []*pwmgrFsckIssue
+	policies map[string]bool
+}
+
+// pathFsck extends the Vault API with the admin endpoint to check and
+// repair the consistency of the plugin records.
+func pathFsck(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "fsck",
+			Fields: map[string]*framework.FieldSchema{
+				"repair": {
+					Type:        framework.TypeBool,
+					Description: "repair the issues that can be repaired, only on update",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathFsck,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathFsck,
+				},
+			},
+			HelpSynopsis:    pathFsckHelpSynopsis,
+			HelpDescription: pathFsckHelpDescription,
+		},
+	}
+}
+
+// pathFsck scans the plugin storage and reports its inconsistencies. An
+// update with repair repairs them and writes the policies of the users
+// whose shared bundles changed.
+func (b *pwManagerBackend) pathFsck(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	f := &fsck{b: b, policies: map[string]bool{}}
+	records, err := f.scan(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	f.checkUsers(records)
+	f.checkBundles(records)
+	f.checkSharedBundles(records)
+
+	slices.SortFunc(f.issues, func(x, y *pwmgrFsckIssue) int {
+		return cmp.Or(strings.Compare(x.Path, y.Path), strings.Compare(x.Code, y.Code), strings.Compare(x.Message, y.Message))
+	})
+
+	repaired := 0
+	if req.Operation == logical.UpdateOperation && data.Get("repair").(bool) {
+		if repaired, err = f.repair(ctx, req.Storage, records); err != nil {
+			return clientErrorResponse(fmt.Errorf("%d issues repaired: %w", repaired, err))
+		}
+	}
+
+	issues := []pwmgrFsckIssue{}
+	for _, issue := range f.issues {
+		issues = append(issues, *issue)
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"issues":   issues,
+			"repaired": repaired,
+		},
+	}, nil
+}
+
+// scan reads the user and bundle records and reports the records that
+// don't belong to a schema or can't be decoded.
+func (f *fsck) scan(ctx context.Context, s logical.Storage) (*fsckRecords, error) {
+	keys, err := logical.CollectKeys(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	r := &fsckRecords{
+		users:   map[string]*pwManagerUserEntry{},
+		names:   map[string]string{},
+		bundles: map[string]map[string]*pwmgrBundle{},
+		shared:  map[string]pwmgrSharedBundles{},
+	}
+
+	// decode reports records that can't be decoded, storage errors end the
+	// scan
+	var scanErr error
+	decode := func(key string, v interface{}) bool {
+		entry, err := s.Get(ctx, key)
+		if err != nil {
+			scanErr = err
+			return false
+		}
+		if entry == nil {
+			return false
+		}
+		if err := entry.DecodeJSON(v); err != nil {
+			f.add(FsckInvalidRecord, key, fmt.Sprintf("record can't be decoded: %s", err), nil)
+			return false
+		}
+		return true
+	}
+
+	for _, key := range keys {
+		if scanErr != nil {
+			return nil, scanErr
+		}
+
+		parts := strings.Split(key, "/")
+		switch {
+		case !isSnapshotRecord(key):
+			f.add(FsckUnknownRecord, key, "record is outside of the plugin schemas", nil)
+		case parts[0] == USER_SCHEMA && len(parts) == 3 && parts[1] == "byEntityID":
+			var user pwManagerUserEntry
+			if decode(key, &user) {
+				r.users[parts[2]] = &user
+			}
+		case strings.HasPrefix(key, USER_SCHEMA+"/byName/"):
+			// entity names may contain slashes
+			var name struct {
+				ID string `json:"id"`
+			}
+			if decode(key, &name) {
+				r.names[strings.TrimPrefix(key, USER_SCHEMA+"/byName/")] = name.ID
+			}
+		case parts[0] == USER_SCHEMA:
+			f.add(FsckStrayUserRecord, key, "record is neither a user nor a user name", func(ctx context.Context, s logical.Storage) error {
+				return s.Delete(ctx, key)
+			})
+		case parts[0] == BUNDLE_SCHEMA && len(parts) == 4 && parts[2] == "bundles":
+			var pb pwmgrBundle
+			if decode(key, &pb) {
+				if r.bundles[parts[1]] == nil {
+					r.bundles[parts[1]] = map[string]*pwmgrBundle{}
+				}
+				r.bundles[parts[1]][parts[3]] = &pb
+			}
+		case parts[0] == BUNDLE_SCHEMA && len(parts) == 3 && parts[2] == "sharedWithMe":
+			sbs := pwmgrSharedBundles{}
+			if decode(key, &sbs) {
+				r.shared[parts[1]] = sbs
+			}
+		case parts[0] == BUNDLE_SCHEMA:
+			f.add(FsckUnknownRecord, key, "record is neither a bundle nor shared bundles", nil)
+		}
+	}
+	return r, scanErr
+}
+
+func (f *fsck) add(code, path, message string, repair func(ctx context.Context, s logical.Storage) error) {
+	f.issues = append(f.issues, &pwmgrFsckIssue{Code: code, Path: path, Message: message, Repairable: repair != nil, repair: repair})
+}
+
+func (f *fsck) checkUsers(r *fsckRecords) {
+	named := map[string]bool{}
+	for name, id := range r.names {
+		named[id] = true
+		if r.users[id] != nil {
+			continue
+		}
+		path := fmt.Sprintf("%s/byName/%s", USER_SCHEMA, name)
+		f.add(FsckDanglingUserName, path, fmt.Sprintf("user name of entity %s that isn't a user", id), func(ctx context.Context, s logical.Storage) error {
+			user, err := f.b.getUser(ctx, s, id)
+			if err != nil || user != nil {
+				return err
+			}
+			return s.Delete(ctx, path)
+		})
+	}
+
+	for id, user := range r.users {
+		path := fmt.Sprintf("%s/byEntityID/%s", USER_SCHEMA, id)
+		if user.EntityID != id {
+			f.add(FsckUserEntityIDMismatch, path, fmt.Sprintf("user has entity id %q", user.EntityID), func(ctx context.Context, s logical.Storage) error {
+				user, err := f.b.getUser(ctx, s, id)
+				if err != nil || user == nil {
+					return err
+				}
+				user.EntityID = id
+				return f.b.setUserByEntityID(ctx, s, id, user)
+			})
+		}
+		if !named[id] {
+			f.add(FsckUnnamedUser, path, "user has no user name", nil)
+		}
+	}
+}
+
+func (f *fsck) checkBundles(r *fsckRecords) {
+	for owner, bundles := range r.bundles {
+		for id, pb := range bundles {
+			path := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, owner, id)
+			secretPath := fmt.Sprintf("bundles/data/%s/%s", owner, id)
+
+			if pb.ID != id || pb.OwnerEntityID != owner || pb.Path != secretPath {
+				f.add(FsckBundleMismatch, path, fmt.Sprintf("bundle has id %q, owner %q and path %q", pb.ID, pb.OwnerEntityID, pb.Path), func(ctx context.Context, s logical.Storage) error {
+					return updateFsckBundle(ctx, s, path, func(pb *pwmgrBundle) {
+						pb.ID, pb.OwnerEntityID, pb.Path = id, owner, secretPath
+					})
+				})
+			}
+			if r.users[owner] == nil && !pb.Frozen {
+				f.add(FsckOrphanedBundle, path, "bundle owner isn't a user", nil)
+			}
+			if pb.WALEntry {
+				f.add(FsckInterruptedBundleWrite, path, "bundle users write didn't complete", func(ctx context.Context, s logical.Storage) error {
+					return updateFsckBundle(ctx, s, path, func(pb *pwmgrBundle) { pb.WALEntry = false })
+				})
+			}
+
+			for _, u := range pb.Users {
+				f.checkBundleUser(r, path, id, owner, secretPath, u)
+			}
+		}
+	}
+}
+
+func (f *fsck) checkBundleUser(r *fsckRecords, path, id, owner, secretPath string, u pwmgrUser) {
+	entityID := u.EntityID
+	if r.users[entityID] == nil {
+		f.add(FsckUnknownBundleUser, path, fmt.Sprintf("bundle user %s isn't a user", entityID), func(ctx context.Context, s logical.Storage) error {
+			err := updateFsckBundle(ctx, s, path, func(pb *pwmgrBundle) {
+				pb.Users = slices.DeleteFunc(pb.Users, func(u pwmgrUser) bool { return u.EntityID == entityID })
+			})
+			if err != nil {
+				return err
+			}
+			return f.updateShared(ctx, s, entityID, func(sbs pwmgrSharedBundles) { delete(sbs, id) })
+		})
+		return
+	}
+
+	sharedPath := fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID)
+	sb, ok := r.shared[entityID][id]
+	switch {
+	case !ok:
+		f.add(FsckMissingSharedBundle, sharedPath, fmt.Sprintf("bundle %s is shared with the user but not in the shared bundles", id), func(ctx context.Context, s logical.Storage) error {
+			return f.updateShared(ctx, s, entityID, func(sbs pwmgrSharedBundles) {
+				sbs[id] = pwmgrSharedBundle{
+					ID:            id,
+					OwnerEntityID: owner,
+					Path:          secretPath,
+					Created:       time.Now().Unix(),
+					IsAdmin:       u.IsAdmin,
+					Capabilities:  u.Capabilities,
+				}
+			})
+		})
+	case sb.ID != id || sb.OwnerEntityID != owner || sb.Path != secretPath || sb.IsAdmin != u.IsAdmin || sb.Capabilities != u.Capabilities:
+		f.add(FsckSharedBundleMismatch, sharedPath, fmt.Sprintf("shared bundle %s differs from the bundle user", id), func(ctx context.Context, s logical.Storage) error {
+			return f.updateShared(ctx, s, entityID, func(sbs pwmgrSharedBundles) {
+				sb := sbs[id]
+				sb.ID, sb.OwnerEntityID, sb.Path = id, owner, secretPath
+				sb.IsAdmin, sb.Capabilities = u.IsAdmin, u.Capabilities
+				sbs[id] = sb
+			})
+		})
+	}
+}
+
+func (f *fsck) checkSharedBundles(r *fsckRecords) {
+	for entityID, sbs := range r.shared {
+		sharedPath := fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID)
+		for id, sb := range sbs {
+			remove := func(ctx context.Context, s logical.Storage) error {
+				return f.updateShared(ctx, s, entityID, func(sbs pwmgrSharedBundles) { delete(sbs, id) })
+			}
+
+			pb := r.bundles[sb.OwnerEntityID][id]
+			if pb == nil {
+				f.add(FsckDanglingSharedBundle, sharedPath, fmt.Sprintf("shared bundle %s of owner %s doesn't exist", id, sb.OwnerEntityID), remove)
+				continue
+			}
+			if !slices.ContainsFunc(pb.Users, func(u pwmgrUser) bool { return u.EntityID == entityID }) {
+				f.add(FsckSharedBundleNotMember, sharedPath, fmt.Sprintf("bundle %s isn't shared with the user", id), remove)
+			}
+		}
+	}
+}
+
+// repair repairs the issues in order and then writes the policies of the
+// users whose shared bundles changed. It returns how many issues were
+// repaired.
+func (f *fsck) repair(ctx context.Context, s logical.Storage, r *fsckRecords) (int, error) {
+	repaired := 0
+	for _, issue := range f.issues {
+		if issue.repair == nil {
+			continue
+		}
+		if err := issue.repair(ctx, s); err != nil {
+			return repaired, fmt.Errorf("error repairing %s %s: %w", issue.Code, issue.Path, err)
+		}
+		issue.Repaired = true
+		repaired++
+	}
+
+	if len(f.policies) == 0 {
+		return repaired, nil
+	}
+	if f.b.policyService == nil {
+		if err := f.b.Login(ctx); err != nil {
+			return repaired, fmt.Errorf("records repaired but login failed: %w", err)
+		}
+	}
+
+	entityNames := map[string]string{}
+	for name, id := range r.names {
+		entityNames[id] = name
+	}
+	for entityID := range f.policies {
+		name, ok := entityNames[entityID]
+		if !ok {
+			f.b.logger.Warn(fmt.Sprintf("no entity name to write the policy of repaired shared bundles: %s", entityID))
+			continue
+		}
+
+		sbs, err := getSharedUserBundles(ctx, s, fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID))
+		if err != nil {
+			return repaired, err
+		}
+		if err := f.b.UpdateUserPolicy(ctx, sbs, name); err != nil {
+			return repaired, fmt.Errorf("records repaired but writing the policy of %s failed: %w", name, err)
+		}
+	}
+	return repaired, nil
+}
+
+// updateShared changes the shared bundles of the user and marks its policy
+// to be written.
+func (f *fsck) updateShared(ctx context.Context, s logical.Storage, entityID string, fn func(sbs pwmgrSharedBundles)) error {
+	path := fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID)
+	lock := bundleMapOfMu.Lock(path)
+	defer lock.Unlock()
+
+	sbs, err := getSharedUserBundles(ctx, s, path)
+	if err != nil {
+		return err
+	}
+	if sbs == nil {
+		sbs = pwmgrSharedBundles{}
+	}
+	fn(sbs)
+
+	if err := setSharedUserBundles(ctx, s, path, sbs); err != nil {
+		return err
+	}
+	f.policies[entityID] = true
+	return nil
+}
+
+// updateFsckBundle changes the bundle record if it still exists.
+func updateFsckBundle(ctx context.Context, s logical.Storage, path string, fn func(pb *pwmgrBundle)) error {
+	lock := bundleMapOfMu.Lock(path)
+	defer lock.Unlock()
+
+	pb, err := getBundle(ctx, s, path)
+	if err != nil || pb == nil {
+		return err
+	}
+	fn(pb)
+	return setBundle(ctx, s, path, *pb)
+}
+
+// pathFsckHelpSynopsis summarizes the help text for fsck
+const pathFsckHelpSynopsis = `Check and repair the consistency of the plugin records.`
+
+// pathFsckHelpDescription describes the help text for fsck
+const pathFsckHelpDescription = `
+fsck scans the plugin storage and reports every inconsistency between the
+user, user name, bundle and shared bundle records with a code, the storage
+path of the record and whether it can be repaired. The bundle records are
+authoritative for the shared bundles of their users.
+
+An update with repair=true repairs the repairable issues and writes the
+policies of the users whose shared bundles changed. Issues whose repair
+loses data, like bundles of owners that aren't users, are only reported.
+`
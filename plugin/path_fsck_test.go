@@ -0,0 +1,168 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFsck tests reporting and repairing inconsistent user and bundle
+// records.
+func TestFsck(t *testing.T) {
+	b, s := getTestBackend(t)
+	policies := &MockPolicyService{}
+	b.policyService = policies
+	ctx := context.Background()
+
+	ids := map[string]string{}
+	for _, name := range []string{"bob", "jane", "tom", "ghost", "team/alice"} {
+		ids[name], _ = uuid.GenerateUUID()
+	}
+	for _, name := range []string{"bob", "jane", "tom", "team/alice"} {
+		require.NoError(t, b.setUserByEntityID(ctx, s, ids[name], &pwManagerUserEntry{EntityID: ids[name]}))
+	}
+	for _, name := range []string{"bob", "jane", "ghost", "team/alice"} {
+		require.NoError(t, b.setUserByName(ctx, s, name, ids[name]))
+	}
+
+	createBundle := func() string {
+		data, err := b.bundleCreate(ctx, s, ids["bob"])
+		require.NoError(t, err)
+		return path.Base(data["path"].(string))
+	}
+	bundlePath := func(id string) string {
+		return fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ids["bob"], id)
+	}
+	sharedPath := func(name string) string {
+		return fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, ids[name])
+	}
+
+	// shared is shared with jane but missing from her shared bundles
+	shared := createBundle()
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      fmt.Sprintf("bundles/%s/%s/users", ids["bob"], shared),
+		Storage:   s,
+		EntityID:  ids["bob"],
+		Data:      map[string]interface{}{"users": []pwmgrUser{{EntityName: "jane", Capabilities: "read,list"}}},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	// interrupted lists an unknown user and is in the shared bundles of tom
+	interrupted := createBundle()
+	pb, err := getBundle(ctx, s, bundlePath(interrupted))
+	require.NoError(t, err)
+	pb.WALEntry = true
+	pb.Users = []pwmgrUser{{EntityID: ids["ghost"], EntityName: "ghost"}}
+	require.NoError(t, setBundle(ctx, s, bundlePath(interrupted), *pb))
+	require.NoError(t, setSharedUserBundles(ctx, s, sharedPath("tom"), pwmgrSharedBundles{
+		interrupted: {ID: interrupted, OwnerEntityID: ids["bob"], Path: pb.Path},
+	}))
+
+	deleted, _ := uuid.GenerateUUID()
+	require.NoError(t, setSharedUserBundles(ctx, s, sharedPath("jane"), pwmgrSharedBundles{
+		deleted: {ID: deleted, OwnerEntityID: ids["bob"], Path: "bundles/data/" + ids["bob"] + "/" + deleted},
+	}))
+	require.NoError(t, setSharedUserBundles(ctx, s, sharedPath("ghost"), pwmgrSharedBundles{
+		interrupted: {ID: interrupted, OwnerEntityID: ids["bob"], Path: pb.Path},
+	}))
+
+	for k, v := range map[string]string{
+		USER_SCHEMA + "/" + ids["bob"]: `{}`,
+		bundlePath("invalid"):          `not json`,
+		"other/record":                 `{}`,
+	} {
+		require.NoError(t, s.Put(ctx, &logical.StorageEntry{Key: k, Value: []byte(v)}))
+	}
+
+	fsck := func(op logical.Operation, repair bool) ([]pwmgrFsckIssue, int) {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: op, Path: "fsck", Storage: s, Data: map[string]interface{}{"repair": repair}})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), resp.Error())
+		return resp.Data["issues"].([]pwmgrFsckIssue), resp.Data["repaired"].(int)
+	}
+	codes := func(issues []pwmgrFsckIssue) map[string]string {
+		m := map[string]string{}
+		for _, issue := range issues {
+			m[issue.Code] = issue.Path
+		}
+		return m
+	}
+
+	unrepairable := map[string]string{
+		FsckInvalidRecord: bundlePath("invalid"),
+		FsckUnknownRecord: "other/record",
+		FsckUnnamedUser:   USER_SCHEMA + "/byEntityID/" + ids["tom"],
+	}
+	want := map[string]string{
+		FsckStrayUserRecord:        USER_SCHEMA + "/" + ids["bob"],
+		FsckDanglingUserName:       USER_SCHEMA + "/byName/ghost",
+		FsckInterruptedBundleWrite: bundlePath(interrupted),
+		FsckUnknownBundleUser:      bundlePath(interrupted),
+		FsckMissingSharedBundle:    sharedPath("jane"),
+		FsckDanglingSharedBundle:   sharedPath("jane"),
+		FsckSharedBundleNotMember:  sharedPath("tom"),
+	}
+	for k, v := range unrepairable {
+		want[k] = v
+	}
+
+	t.Run("check", func(t *testing.T) {
+		issues, repaired := fsck(logical.ReadOperation, true)
+		require.Equal(t, want, codes(issues))
+		require.Zero(t, repaired)
+		require.Equal(t, 1, policies.CallCount)
+
+		// names with slashes are user names
+		for _, issue := range issues {
+			require.NotContains(t, issue.Path, "team/alice")
+		}
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		issues, repaired := fsck(logical.UpdateOperation, true)
+		require.Equal(t, want, codes(issues))
+		require.Equal(t, len(issues)-len(unrepairable), repaired)
+
+		sbs, err := getSharedUserBundles(ctx, s, sharedPath("jane"))
+		require.NoError(t, err)
+		require.Equal(t, []string{shared}, sharedBundleIDs(sbs))
+		require.Equal(t, "read,list", sbs[shared].Capabilities)
+
+		for _, name := range []string{"tom", "ghost"} {
+			sbs, err := getSharedUserBundles(ctx, s, sharedPath(name))
+			require.NoError(t, err)
+			require.Empty(t, sbs)
+		}
+
+		pb, err := getBundle(ctx, s, bundlePath(interrupted))
+		require.NoError(t, err)
+		require.False(t, pb.WALEntry)
+		require.Empty(t, pb.Users)
+
+		// the policies of jane and ghost are written, tom has no name
+		require.Equal(t, 3, policies.CallCount)
+
+		issues, _ = fsck(logical.ReadOperation, false)
+		require.Equal(t, unrepairable, codes(issues))
+
+		entityID, err := b.getUserEntityIDByName(ctx, s, "team/alice")
+		require.NoError(t, err)
+		require.Equal(t, ids["team/alice"], entityID)
+	})
+}
+
+func sharedBundleIDs(sbs pwmgrSharedBundles) []string {
+	keys := []string{}
+	for k := range sbs {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,223 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gradientsearch/vault-plugin-secrets-pwmanager/generator"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	GENERATOR_RULES_SCHEMA string = "generator/rules"
+
+	// DefaultGeneratorRules is the name of the rules clients apply when the
+	// user doesn't choose rules.
+	DefaultGeneratorRules = "default"
+)
+
+// GeneratorRules is the exported name of generator.Rules.
+type GeneratorRules = generator.Rules
+
+// pathGenerator extends the Vault API with a `/generator/rules` endpoint
+// for admin defined password generation rules. Clients read the rules and
+// generate passwords with the generator package so passwords never leave
+// the client.
+func pathGenerator(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s", GENERATOR_RULES_SCHEMA, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "name of the rules",
+					Required:    true,
+				},
+				"min_length": {
+					Type:        framework.TypeInt,
+					Description: "minimum number of characters",
+				},
+				"min_words": {
+					Type:        framework.TypeInt,
+					Description: "minimum number of passphrase words",
+				},
+				"required_classes": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "character classes that must appear, any of lower, upper, digits and symbols",
+				},
+				"excluded_chars": {
+					Type:        framework.TypeString,
+					Description: "characters that must not appear",
+				},
+				"min_entropy": {
+					Type:        framework.TypeFloat,
+					Description: "minimum estimated entropy in bits",
+				},
+			},
+			ExistenceCheck: b.pathGeneratorRulesExistenceCheck,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathGeneratorRulesRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathGeneratorRulesWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathGeneratorRulesWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathGeneratorRulesDelete,
+				},
+			},
+			HelpSynopsis:    pathGeneratorRulesHelpSynopsis,
+			HelpDescription: pathGeneratorRulesHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/?$", GENERATOR_RULES_SCHEMA),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathGeneratorRulesList,
+				},
+			},
+			HelpSynopsis:    pathGeneratorRulesListHelpSynopsis,
+			HelpDescription: pathGeneratorRulesListHelpDescription,
+		},
+	}
+}
+
+// pathGeneratorRulesExistenceCheck verifies if the rules exist.
+func (b *pwManagerBackend) pathGeneratorRulesExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, req.Path)
+	if err != nil {
+		return false, fmt.Errorf("existence check failed: %w", err)
+	}
+
+	return out != nil, nil
+}
+
+func (b *pwManagerBackend) pathGeneratorRulesRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rules, err := getGeneratorRules(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: generatorRulesResponseData(rules),
+	}, nil
+}
+
+func (b *pwManagerBackend) pathGeneratorRulesWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	rules, err := getGeneratorRules(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = new(generator.Rules)
+	}
+
+	if v, ok := data.GetOk("min_length"); ok {
+		rules.MinLength = v.(int)
+	}
+	if v, ok := data.GetOk("min_words"); ok {
+		rules.MinWords = v.(int)
+	}
+	if v, ok := data.GetOk("required_classes"); ok {
+		rules.RequiredClasses = nil
+		for _, c := range v.([]string) {
+			rules.RequiredClasses = append(rules.RequiredClasses, generator.Class(c))
+		}
+	}
+	if v, ok := data.GetOk("excluded_chars"); ok {
+		rules.ExcludedChars = v.(string)
+	}
+	if v, ok := data.GetOk("min_entropy"); ok {
+		rules.MinEntropy = v.(float64)
+	}
+
+	if err := rules.Validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(generatorRulesStoragePath(name), rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: generatorRulesResponseData(rules),
+	}, nil
+}
+
+func (b *pwManagerBackend) pathGeneratorRulesDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete(ctx, generatorRulesStoragePath(data.Get("name").(string)))
+}
+
+func (b *pwManagerBackend) pathGeneratorRulesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, GENERATOR_RULES_SCHEMA+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+func generatorRulesStoragePath(name string) string {
+	return fmt.Sprintf("%s/%s", GENERATOR_RULES_SCHEMA, name)
+}
+
+func getGeneratorRules(ctx context.Context, s logical.Storage, name string) (*generator.Rules, error) {
+	entry, err := s.Get(ctx, generatorRulesStoragePath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	rules := new(generator.Rules)
+	if err := entry.DecodeJSON(rules); err != nil {
+		return nil, fmt.Errorf("error reading generator rules: %w", err)
+	}
+	return rules, nil
+}
+
+func generatorRulesResponseData(r *generator.Rules) map[string]interface{} {
+	classes := []string{}
+	for _, c := range r.RequiredClasses {
+		classes = append(classes, string(c))
+	}
+
+	return map[string]interface{}{
+		"min_length":       r.MinLength,
+		"min_words":        r.MinWords,
+		"required_classes": classes,
+		"excluded_chars":   r.ExcludedChars,
+		"min_entropy":      r.MinEntropy,
+	}
+}
+
+const pathGeneratorRulesHelpSynopsis = `Manage password generation rules.`
+
+const pathGeneratorRulesHelpDescription = `
+Admins define the rules generated passwords must meet, e.g. a minimum
+length, required character classes and excluded characters. Clients read
+the rules and apply them when generating passwords. The "default" rules
+are used when the user doesn't choose rules.
+`
+
+const pathGeneratorRulesListHelpSynopsis = `List the password generation rules.`
+
+const pathGeneratorRulesListHelpDescription = `
+List the names of the password generation rules.
+`
@@ -0,0 +1,94 @@
+package secretsengine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGeneratorRules tests the creation, read, list, update and delete of
+// password generation rules.
+func TestGeneratorRules(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		})
+	}
+
+	t.Run("create", func(t *testing.T) {
+		resp, err := request(logical.CreateOperation, "generator/rules/default", map[string]interface{}{
+			"min_length":       16,
+			"required_classes": "upper,digits",
+			"excluded_chars":   "0O1l",
+			"min_entropy":      80,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	})
+
+	t.Run("read", func(t *testing.T) {
+		resp, err := request(logical.ReadOperation, "generator/rules/default", nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"min_length":       16,
+			"min_words":        0,
+			"required_classes": []string{"upper", "digits"},
+			"excluded_chars":   "0O1l",
+			"min_entropy":      80.0,
+		}, resp.Data)
+
+		resp, err = request(logical.ReadOperation, "generator/rules/missing", nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		resp, err := request(logical.UpdateOperation, "generator/rules/default", map[string]interface{}{
+			"min_words": 6,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.Equal(t, 6, resp.Data["min_words"])
+		require.Equal(t, 16, resp.Data["min_length"])
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		resp, err := request(logical.UpdateOperation, "generator/rules/default", map[string]interface{}{
+			"required_classes": "emoji",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		resp, err = request(logical.UpdateOperation, "generator/rules/default", map[string]interface{}{
+			"required_classes": "digits",
+			"excluded_chars":   "0123456789",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("list", func(t *testing.T) {
+		_, err := request(logical.CreateOperation, "generator/rules/wifi", map[string]interface{}{"min_words": 4})
+		require.NoError(t, err)
+
+		resp, err := request(logical.ListOperation, "generator/rules/", nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"default", "wifi"}, resp.Data["keys"])
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		_, err := request(logical.DeleteOperation, "generator/rules/wifi", nil)
+		require.NoError(t, err)
+
+		resp, err := request(logical.ReadOperation, "generator/rules/wifi", nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+}
@@ -0,0 +1,510 @@
+package secretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// What offboarding does with the bundles the user owns.
+const (
+	// offboardFreeze keeps the bundles read-only for their users.
+	offboardFreeze = "freeze"
+	// offboardDelete moves the bundles to the trash.
+	offboardDelete = "delete"
+	// offboardTransfer moves the bundles to another user. Bundles that
+	// aren't shared with that user are frozen as the user couldn't
+	// decrypt them.
+	offboardTransfer = "transfer"
+)
+
+// pwmgrOffboardReport is what offboarding a user changed.
+type pwmgrOffboardReport struct {
+	EntityID   string `json:"entity_id"`
+	EntityName string `json:"entity_name"`
+	// LeftBundles are the bundles shared with the user they were removed
+	// from
+	LeftBundles        []string `json:"left_bundles"`
+	DeletedBundles     []string `json:"deleted_bundles"`
+	TransferredBundles []string `json:"transferred_bundles"`
+	FrozenBundles      []string `json:"frozen_bundles"`
+	// DeletedKeys counts the bundle keys wrapped for the user that were
+	// deleted
+	DeletedKeys   int  `json:"deleted_keys"`
+	PolicyDeleted bool `json:"policy_deleted"`
+}
+
+// OffboardReport is the exported name of pwmgrOffboardReport.
+type OffboardReport = pwmgrOffboardReport
+
+// offboardFields are the options of offboarding a user.
+func offboardFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"owned_bundles": {
+			Type:          framework.TypeString,
+			Description:   "what happens to the bundles the user owns: freeze, delete or transfer",
+			Default:       offboardFreeze,
+			AllowedValues: []interface{}{offboardFreeze, offboardDelete, offboardTransfer},
+		},
+		"transfer_to": {
+			Type:        framework.TypeString,
+			Description: "entity name of the user the owned bundles are transferred to",
+		},
+	}
+}
+
+// pathOffboard extends the Vault API with the endpoint users deregister
+// themselves with. Admins offboard a user by deleting `users/<entity id>`.
+func pathOffboard(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "offboard",
+			Fields:  offboardFields(),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathOffboardWrite,
+				},
+			},
+			HelpSynopsis:    pathOffboardHelpSynopsis,
+			HelpDescription: pathOffboardHelpDescription,
+		},
+	}
+}
+
+// pathOffboardWrite offboards the caller.
+func (b *pwManagerBackend) pathOffboardWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.offboardUser(ctx, req.Storage, req.EntityID, data)
+}
+
+// offboardUser removes the user from the bundles shared with them, freezes,
+// deletes or transfers the bundles they own and deletes their user records
+// and policy. The user records are deleted last so a failed offboarding can
+// be run again.
+func (b *pwManagerBackend) offboardUser(ctx context.Context, s logical.Storage, entityID string, data *framework.FieldData) (*logical.Response, error) {
+	user, err := b.getUser(ctx, s, entityID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return logical.ErrorResponse("user not found"), nil
+	}
+
+	option := data.Get("owned_bundles").(string)
+	transferTo := ""
+	if option == offboardTransfer {
+		name := data.Get("transfer_to").(string)
+		if name == "" {
+			return logical.ErrorResponse("transfer_to is required to transfer the owned bundles"), nil
+		}
+		if transferTo, err = b.getUserEntityIDByName(ctx, s, name); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if transferTo == entityID {
+			return logical.ErrorResponse("owned bundles can't be transferred to the offboarded user"), nil
+		}
+	}
+
+	if b.c == nil || b.policyService == nil {
+		if err := b.Login(ctx); err != nil {
+			return clientErrorResponse(err)
+		}
+	}
+
+	report := &pwmgrOffboardReport{
+		EntityID:           entityID,
+		LeftBundles:        []string{},
+		DeletedBundles:     []string{},
+		TransferredBundles: []string{},
+		FrozenBundles:      []string{},
+	}
+	if report.EntityName, err = b.getUserEntityName(ctx, s, entityID); err != nil {
+		return nil, err
+	}
+
+	if err := b.leaveSharedBundles(ctx, s, entityID, report); err != nil {
+		return clientErrorResponse(fmt.Errorf("error removing the user from shared bundles: %w", err))
+	}
+
+	owned, err := b.listBundles(ctx, s, entityID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pb := range owned {
+		switch {
+		case option == offboardDelete:
+			err = b.offboardDeleteBundle(ctx, s, pb, report)
+		case option == offboardTransfer && slices.ContainsFunc(pb.Users, func(u pwmgrUser) bool { return u.EntityID == transferTo }):
+			var ownerKey bool
+			if ownerKey, err = b.transferBundle(ctx, s, pb, transferTo, false); err == nil {
+				if ownerKey {
+					report.DeletedKeys++
+				}
+				report.TransferredBundles = append(report.TransferredBundles, pb.ID)
+			}
+		default:
+			err = b.freezeBundle(ctx, s, pb, report)
+		}
+		if err != nil {
+			return clientErrorResponse(fmt.Errorf("error offboarding bundle %s: %w", pb.ID, err))
+		}
+	}
+
+	if report.EntityName != "" {
+		if err := b.policyService.DeletePolicy(ctx, userPolicyName(report.EntityName)); err != nil {
+			return clientErrorResponse(fmt.Errorf("error deleting the user policy: %w", err))
+		}
+		report.PolicyDeleted = true
+
+		if err := s.Delete(ctx, fmt.Sprintf("%s/byName/%s", USER_SCHEMA, report.EntityName)); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Delete(ctx, fmt.Sprintf("%s/byEntityID/%s", USER_SCHEMA, entityID)); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"report": report,
+		},
+	}, nil
+}
+
+// leaveSharedBundles removes the user and their wrapped bundle key from the
+// bundles shared with them.
+func (b *pwManagerBackend) leaveSharedBundles(ctx context.Context, s logical.Storage, entityID string, report *pwmgrOffboardReport) error {
+	// the shared bundles aren't locked while the bundles are, sharing locks
+	// the bundle first
+	sharedPath := fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID)
+	sbs, err := getSharedUserBundles(ctx, s, sharedPath)
+	if err != nil {
+		return err
+	}
+
+	for _, sb := range sbs {
+		bundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, sb.OwnerEntityID, sb.ID)
+		err := func() error {
+			bundleLock := bundleMapOfMu.Lock(bundlePath)
+			defer bundleLock.Unlock()
+
+			pb, err := getBundle(ctx, s, bundlePath)
+			if err != nil || pb == nil {
+				return err
+			}
+			pb.Users = slices.DeleteFunc(pb.Users, func(u pwmgrUser) bool { return u.EntityID == entityID })
+			return setBundle(ctx, s, bundlePath, *pb)
+		}()
+		if err != nil {
+			return err
+		}
+
+		deleted, err := b.deleteBundleKey(ctx, sb.Path, entityID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			report.DeletedKeys++
+		}
+		report.LeftBundles = append(report.LeftBundles, sb.ID)
+	}
+	slices.Sort(report.LeftBundles)
+
+	sharedLock := bundleMapOfMu.Lock(sharedPath)
+	defer sharedLock.Unlock()
+	return s.Delete(ctx, sharedPath)
+}
+
+// offboardDeleteBundle moves the bundle to the trash as deleting it does.
+func (b *pwManagerBackend) offboardDeleteBundle(ctx context.Context, s logical.Storage, pb pwmgrBundle, report *pwmgrOffboardReport) error {
+	bundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, pb.OwnerEntityID, pb.ID)
+	bundleLock := bundleMapOfMu.Lock(bundlePath)
+	defer bundleLock.Unlock()
+
+	current, err := getBundle(ctx, s, bundlePath)
+	if err != nil || current == nil {
+		return err
+	}
+	pb = *current
+
+	if err := b.removeBundleUsers(ctx, s, pb, []pwmgrUser{}); err != nil {
+		return err
+	}
+	if err := b.trashBundleItem(ctx, s, pb, pb.OwnerEntityID); err != nil {
+		return fmt.Errorf("error moving bundle to trash: %w", err)
+	}
+	if err := s.Delete(ctx, bundlePath); err != nil {
+		return err
+	}
+	report.DeletedBundles = append(report.DeletedBundles, pb.ID)
+	return nil
+}
+
+// freezeBundle makes the bundle read-only for its users and deletes the
+// bundle key of the owner.
+func (b *pwManagerBackend) freezeBundle(ctx context.Context, s logical.Storage, pb pwmgrBundle, report *pwmgrOffboardReport) error {
+	bundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, pb.OwnerEntityID, pb.ID)
+	bundleLock := bundleMapOfMu.Lock(bundlePath)
+	defer bundleLock.Unlock()
+
+	current, err := getBundle(ctx, s, bundlePath)
+	if err != nil || current == nil {
+		return err
+	}
+	pb = *current
+
+	users := []pwmgrUser{}
+	for _, u := range pb.Users {
+		u.IsAdmin = false
+		u.Capabilities = "read,list"
+		users = append(users, u)
+	}
+	if err := b.updateModifiedUsers(ctx, s, pb, users); err != nil {
+		return err
+	}
+
+	pb.Users = users
+	pb.Frozen = true
+	if err := setBundle(ctx, s, bundlePath, pb); err != nil {
+		return err
+	}
+
+	deleted, err := b.deleteBundleKey(ctx, pb.Path, pb.OwnerEntityID)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		report.DeletedKeys++
+	}
+	report.FrozenBundles = append(report.FrozenBundles, pb.ID)
+	return nil
+}
+
+// writeNewSecret writes the versions of a kv-v2 secret that must not exist
+// yet, the first with cas 0 and each later one with the version returned for
+// the one before it.
+func (b *pwManagerBackend) writeNewSecret(ctx context.Context, dataPath string, versions []map[string]interface{}) error {
+	cas := 0
+	for _, v := range versions {
+		secret, err := b.c.write(ctx, "/v1/"+dataPath, kvWriteRequest{Data: v, Options: map[string]int{"cas": cas}})
+		if err != nil {
+			return err
+		}
+
+		var resp struct {
+			Version int `json:"version"`
+		}
+		if err := decodeData(secret, &resp); err != nil {
+			return err
+		}
+		cas = resp.Version
+	}
+	return nil
+}
+
+// transferBundle copies the kv-v2 data of the bundle to the new owner, moves
+// its records and the shared bundles of its users and destroys the old
+// kv-v2 data. The bundle key of the old owner is dropped or, with
+// moveOwnerKey, becomes the key of the new owner. It reports whether the old
+// owner had a bundle key.
+func (b *pwManagerBackend) transferBundle(ctx context.Context, s logical.Storage, pb pwmgrBundle, newOwner string, moveOwnerKey bool) (bool, error) {
+	oldOwner := pb.OwnerEntityID
+	bundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, oldOwner, pb.ID)
+	newBundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, newOwner, pb.ID)
+	bundleLock := bundleMapOfMu.Lock(bundlePath)
+	defer bundleLock.Unlock()
+
+	current, err := getBundle(ctx, s, bundlePath)
+	if err != nil || current == nil {
+		return false, err
+	}
+	pb = *current
+
+	existing, err := getBundle(ctx, s, newBundlePath)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		return false, fmt.Errorf("new owner already has a bundle %s", pb.ID)
+	}
+
+	np := pb
+	np.Path = fmt.Sprintf("bundles/data/%s/%s", newOwner, pb.ID)
+	np.OwnerEntityID = newOwner
+	np.Users = slices.DeleteFunc(slices.Clone(pb.Users), func(u pwmgrUser) bool { return u.EntityID == newOwner })
+
+	secrets, err := b.readBundleSecrets(ctx, pb.Path)
+	if err != nil {
+		return false, err
+	}
+	ownerKey := false
+	for _, secret := range secrets {
+		rel := strings.TrimPrefix(secret.Path, pb.Path+"/")
+		if rel == "keys/"+oldOwner {
+			ownerKey = true
+			if !moveOwnerKey {
+				continue
+			}
+			rel = "keys/" + newOwner
+		}
+		if err := b.writeNewSecret(ctx, fmt.Sprintf("%s/%s", np.Path, rel), secret.Versions); err != nil {
+			return false, fmt.Errorf("error writing %s: %w", rel, err)
+		}
+	}
+
+	if err := setBundle(ctx, s, newBundlePath, np); err != nil {
+		return false, err
+	}
+
+	attachments, err := listAttachmentUsage(ctx, s, oldOwner, pb.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, usage := range attachments {
+		if err := setAttachmentUsage(ctx, s, newOwner, pb.ID, &usage); err != nil {
+			return false, err
+		}
+	}
+	if err := logical.ClearView(ctx, logical.NewStorageView(s, attachmentStoragePath(oldOwner, pb.ID, "", "")+"/")); err != nil {
+		return false, err
+	}
+
+	trashed, err := listTrashedEntries(ctx, s, oldOwner, pb.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range trashed {
+		item.OwnerEntityID, item.BundlePath = newOwner, np.Path
+		if err := setTrashItem(ctx, s, &item); err != nil {
+			return false, err
+		}
+	}
+	if err := logical.ClearView(ctx, logical.NewStorageView(s, fmt.Sprintf("%s/%s/%s/", TRASH_SCHEMA, oldOwner, pb.ID))); err != nil {
+		return false, err
+	}
+
+	// the users keep their shared bundles with the new path, the new owner
+	// reaches the bundle through their own bundles
+	for _, u := range pb.Users {
+		if err := b.moveSharedBundle(ctx, s, u, np); err != nil {
+			return false, err
+		}
+	}
+
+	if err := b.destroyKVTree(ctx, strings.Replace(pb.Path, "/data/", "/metadata/", 1)); err != nil {
+		return false, err
+	}
+	if err := s.Delete(ctx, bundlePath); err != nil {
+		return false, err
+	}
+	return ownerKey, nil
+}
+
+// moveSharedBundle points the shared bundle of the user to the transferred
+// bundle, or removes it for the new owner, and writes the user policy.
+func (b *pwManagerBackend) moveSharedBundle(ctx context.Context, s logical.Storage, u pwmgrUser, np pwmgrBundle) error {
+	sharedPath := fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, u.EntityID)
+	sharedLock := bundleMapOfMu.Lock(sharedPath)
+	defer sharedLock.Unlock()
+
+	sbs, err := getSharedUserBundles(ctx, s, sharedPath)
+	if err != nil {
+		return err
+	}
+	if sbs == nil {
+		sbs = pwmgrSharedBundles{}
+	}
+
+	if u.EntityID == np.OwnerEntityID {
+		delete(sbs, np.ID)
+	} else {
+		sb, ok := sbs[np.ID]
+		if !ok {
+			sb = pwmgrSharedBundle{ID: np.ID, Created: np.Created, IsAdmin: u.IsAdmin, Capabilities: u.Capabilities}
+		}
+		sb.OwnerEntityID, sb.Path = np.OwnerEntityID, np.Path
+		sbs[np.ID] = sb
+	}
+
+	if err := setSharedUserBundles(ctx, s, sharedPath, sbs); err != nil {
+		return err
+	}
+	return b.UpdateUserPolicy(ctx, sbs, u.EntityName)
+}
+
+// deleteBundleKey deletes the bundle key wrapped for the user and reports
+// whether it existed.
+func (b *pwManagerBackend) deleteBundleKey(ctx context.Context, bundlePath, entityID string) (bool, error) {
+	metadataPath := strings.Replace(bundlePath, "/data/", "/metadata/", 1)
+	keyPath := fmt.Sprintf("/v1/%s/keys/%s", metadataPath, entityID)
+
+	if _, err := b.c.read(ctx, keyPath); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, b.c.delete(ctx, keyPath)
+}
+
+// getUserEntityName returns the entity name the user is indexed by or "".
+func (b *pwManagerBackend) getUserEntityName(ctx context.Context, s logical.Storage, entityID string) (string, error) {
+	// entity names can contain slashes
+	names, err := logical.CollectKeys(ctx, logical.NewStorageView(s, fmt.Sprintf("%s/byName/", USER_SCHEMA)))
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		id, err := b.getUserEntityIDByName(ctx, s, name)
+		if err != nil {
+			return "", err
+		}
+		if id == entityID {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// getUserEntityNames maps the entity id of every named user to their name.
+func (b *pwManagerBackend) getUserEntityNames(ctx context.Context, s logical.Storage) (map[string]string, error) {
+	// entity names can contain slashes
+	names, err := logical.CollectKeys(ctx, logical.NewStorageView(s, fmt.Sprintf("%s/byName/", USER_SCHEMA)))
+	if err != nil {
+		return nil, err
+	}
+
+	entityNames := map[string]string{}
+	for _, name := range names {
+		id, err := b.getUserEntityIDByName(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		entityNames[id] = name
+	}
+	return entityNames, nil
+}
+
+// pathOffboardHelpSynopsis summarizes the help text for offboarding
+const pathOffboardHelpSynopsis = `Offboard the caller from the password manager.`
+
+// pathOffboardHelpDescription describes the help text for offboarding
+const pathOffboardHelpDescription = `
+offboard removes the caller from every bundle shared with them together with
+the bundle keys wrapped for them, handles the bundles they own and deletes
+their UUK, user name and policy. Admins offboard a user by deleting
+users/<entity id> with the same options.
+
+owned_bundles chooses what happens to the owned bundles. freeze, the
+default, keeps them read-only for their users, delete moves them to the
+trash and transfer moves them to the user named by transfer_to. Bundles
+that aren't shared with that user are frozen as they couldn't decrypt them.
+
+The response is a report of the bundles left, deleted, transferred and
+frozen and of the deleted keys and policy.
+`
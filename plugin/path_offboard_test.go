@@ -0,0 +1,152 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOffboard tests an admin offboarding a user who transfers their
+// bundles and a user offboarding themselves who deletes their bundles.
+func TestOffboard(t *testing.T) {
+	b, s := getTestBackend(t)
+	policies := &MockPolicyService{}
+	b.policyService = policies
+	kv := &testKVVersions{versions: map[string][]map[string]interface{}{}, casRequired: true}
+	entities := &testEntities{entities: map[string]Entity{}, next: kv.handler}
+	b.c = testVaultServer(t, entities.handler)
+	ctx := context.Background()
+
+	ids := map[string]string{}
+	for _, name := range []string{"bob", "jane", "tom"} {
+		ids[name], _ = uuid.GenerateUUID()
+		entities.set(Entity{ID: ids[name], Name: name})
+		require.NoError(t, b.setUserByEntityID(ctx, s, ids[name], &pwManagerUserEntry{EntityID: ids[name]}))
+		require.NoError(t, b.setUserByName(ctx, s, name, ids[name]))
+	}
+
+	request := func(op logical.Operation, path, entityID string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: op, Path: path, Data: data, Storage: s, EntityID: entityID})
+		require.NoError(t, err)
+		return resp
+	}
+
+	// createBundle creates a bundle with kv data and the keys of its users
+	createBundle := func(owner string, users ...string) string {
+		data, err := b.bundleCreate(ctx, s, ids[owner])
+		require.NoError(t, err)
+		bundlePath := data["path"].(string)
+		id := path.Base(bundlePath)
+
+		kv.versions[bundlePath+"/metadata/entries"] = []map[string]interface{}{{"entries": "v1"}, {"entries": "v2"}}
+		kv.versions[bundlePath+"/keys/"+ids[owner]] = []map[string]interface{}{{"key": owner}}
+		shares := []pwmgrUser{}
+		for _, u := range users {
+			kv.versions[bundlePath+"/keys/"+ids[u]] = []map[string]interface{}{{"key": u}}
+			shares = append(shares, pwmgrUser{EntityName: u, Capabilities: "create,read,update,list"})
+		}
+		if len(shares) > 0 {
+			resp := request(logical.CreateOperation, fmt.Sprintf("bundles/%s/%s/users", ids[owner], id), ids[owner], map[string]interface{}{"users": shares})
+			require.False(t, resp.IsError(), resp.Error())
+		}
+		return id
+	}
+
+	transferred := createBundle("bob", "jane", "tom")
+	frozen := createBundle("bob", "tom")
+	shared := createBundle("jane", "bob")
+	deleted := createBundle("tom", "jane")
+
+	sharedBundles := func(name string) pwmgrSharedBundles {
+		t.Helper()
+		sbs, err := getSharedUserBundles(ctx, s, fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, ids[name]))
+		require.NoError(t, err)
+		return sbs
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		resp := request(logical.DeleteOperation, "users/"+ids["bob"], "", map[string]interface{}{"owned_bundles": "transfer"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "transfer_to")
+
+		unknown, _ := uuid.GenerateUUID()
+		resp = request(logical.DeleteOperation, "users/"+unknown, "", nil)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "user not found")
+	})
+
+	t.Run("admin transfer", func(t *testing.T) {
+		resp := request(logical.DeleteOperation, "users/"+ids["bob"], "", map[string]interface{}{"owned_bundles": "transfer", "transfer_to": "jane"})
+		require.False(t, resp.IsError(), resp.Error())
+		require.Equal(t, &pwmgrOffboardReport{
+			EntityID:           ids["bob"],
+			EntityName:         "bob",
+			LeftBundles:        []string{shared},
+			DeletedBundles:     []string{},
+			TransferredBundles: []string{transferred},
+			FrozenBundles:      []string{frozen},
+			DeletedKeys:        3,
+			PolicyDeleted:      true,
+		}, resp.Data["report"])
+
+		user, err := b.getUser(ctx, s, ids["bob"])
+		require.NoError(t, err)
+		require.Nil(t, user)
+		_, err = b.getUserEntityIDByName(ctx, s, "bob")
+		require.Error(t, err)
+		require.Equal(t, []string{"pwmanager/entity/bob"}, policies.Deleted)
+
+		// bob left the bundle of jane
+		pb, err := getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ids["jane"], shared))
+		require.NoError(t, err)
+		require.Empty(t, pb.Users)
+		require.NotContains(t, kv.versions, pb.Path+"/keys/"+ids["bob"])
+
+		// the bundle shared with jane is hers now and still shared with tom
+		pb, err = getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ids["jane"], transferred))
+		require.NoError(t, err)
+		require.Equal(t, ids["jane"], pb.OwnerEntityID)
+		require.Len(t, pb.Users, 1)
+		require.Equal(t, ids["tom"], pb.Users[0].EntityID)
+		require.Equal(t, []map[string]interface{}{{"key": "jane"}}, kv.versions[pb.Path+"/keys/"+ids["jane"]])
+		require.Equal(t, []map[string]interface{}{{"entries": "v1"}, {"entries": "v2"}}, kv.versions[pb.Path+"/metadata/entries"])
+		require.NotContains(t, kv.versions, pb.Path+"/keys/"+ids["bob"])
+		require.NotContains(t, kv.versions, fmt.Sprintf("bundles/data/%s/%s/metadata/entries", ids["bob"], transferred))
+		require.NotContains(t, sharedBundles("jane"), transferred)
+		require.Equal(t, pb.Path, sharedBundles("tom")[transferred].Path)
+
+		// the bundle not shared with jane is frozen
+		pb, err = getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, ids["bob"], frozen))
+		require.NoError(t, err)
+		require.True(t, pb.Frozen)
+		require.Equal(t, "read,list", sharedBundles("tom")[frozen].Capabilities)
+		require.NotContains(t, kv.versions, pb.Path+"/keys/"+ids["bob"])
+
+		resp = request(logical.CreateOperation, fmt.Sprintf("bundles/%s/%s/users", ids["bob"], frozen), ids["bob"], map[string]interface{}{"users": []pwmgrUser{}})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "frozen")
+	})
+
+	t.Run("self delete", func(t *testing.T) {
+		resp := request(logical.UpdateOperation, "offboard", ids["tom"], map[string]interface{}{"owned_bundles": "delete"})
+		require.False(t, resp.IsError(), resp.Error())
+		report := resp.Data["report"].(*pwmgrOffboardReport)
+		require.ElementsMatch(t, []string{transferred, frozen}, report.LeftBundles)
+		require.Equal(t, []string{deleted}, report.DeletedBundles)
+
+		bundles, err := b.listBundles(ctx, s, ids["tom"])
+		require.NoError(t, err)
+		require.Empty(t, bundles)
+		item, err := getTrashItem(ctx, s, trashBundleStoragePath(ids["tom"], deleted))
+		require.NoError(t, err)
+		require.NotNil(t, item)
+		require.NotContains(t, sharedBundles("jane"), deleted)
+		require.Nil(t, sharedBundles("tom"))
+	})
+}
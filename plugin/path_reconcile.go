@@ -0,0 +1,410 @@
+package secretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// reconcileInterval is how often the periodic job reconciles every
+	// user with their Vault entity.
+	reconcileInterval = time.Hour
+
+	// reconcileCallerInterval is how often the entity of a caller is
+	// reconciled on their requests.
+	reconcileCallerInterval = 5 * time.Minute
+)
+
+// reconcileMu serializes migrating users to renamed and merged entities.
+var reconcileMu sync.Mutex
+
+// pwmgrReconcileReport lists the users migrated to the current name or id
+// of their Vault entity.
+type pwmgrReconcileReport struct {
+	// Renamed maps the entity id of renamed users to their new name
+	Renamed map[string]string `json:"renamed"`
+	// Merged maps the entity id of merged users to the id of the entity
+	// they were merged into
+	Merged map[string]string `json:"merged"`
+	// Missing are the users whose entity doesn't exist. They are migrated
+	// once the entity they were merged into makes a request.
+	Missing []string `json:"missing"`
+	// Conflicts are the changes that can't be migrated
+	Conflicts []string `json:"conflicts"`
+}
+
+// ReconcileReport is the exported name of pwmgrReconcileReport.
+type ReconcileReport = pwmgrReconcileReport
+
+func newReconcileReport() *pwmgrReconcileReport {
+	return &pwmgrReconcileReport{Renamed: map[string]string{}, Merged: map[string]string{}, Missing: []string{}, Conflicts: []string{}}
+}
+
+// pathReconcile extends the Vault API with the admin endpoint to reconcile
+// every user with their Vault entity.
+func pathReconcile(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "reconcile",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathReconcileWrite,
+				},
+			},
+			HelpSynopsis:    pathReconcileHelpSynopsis,
+			HelpDescription: pathReconcileHelpDescription,
+		},
+	}
+}
+
+// pathReconcileWrite reconciles every user and returns the report.
+func (b *pwManagerBackend) pathReconcileWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.c == nil || b.policyService == nil {
+		if err := b.Login(ctx); err != nil {
+			return clientErrorResponse(err)
+		}
+	}
+
+	report, err := b.reconcileUsers(ctx, req.Storage)
+	if err != nil {
+		return clientErrorResponse(err)
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"report": report,
+		},
+	}, nil
+}
+
+// HandleRequest reconciles the entity of the caller before the request is
+// handled, so a renamed or merged user keeps their bundles.
+func (b *pwManagerBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	if req.EntityID != "" && req.Storage != nil && b.c != nil && b.policyService != nil {
+		b.reconcileCaller(ctx, req.Storage, req.EntityID, time.Now())
+	}
+	return b.Backend.HandleRequest(ctx, req)
+}
+
+// reconcileCaller reconciles the entity of the caller at most once per
+// reconcileCallerInterval. Errors are logged, the request is handled with
+// the records as they are.
+func (b *pwManagerBackend) reconcileCaller(ctx context.Context, s logical.Storage, entityID string, now time.Time) {
+	b.reconciledMu.Lock()
+	if now.Sub(b.reconciled[entityID]) < reconcileCallerInterval {
+		b.reconciledMu.Unlock()
+		return
+	}
+	b.reconciled[entityID] = now
+	b.reconciledMu.Unlock()
+
+	entity, err := b.c.Identity().EntityByID(ctx, entityID)
+	if err != nil {
+		b.logger.Warn(fmt.Sprintf("error reading the entity of the caller %s: %s", entityID, err))
+		return
+	}
+	if err := b.reconcileEntity(ctx, s, entity, newReconcileReport()); err != nil {
+		b.logger.Error(fmt.Sprintf("error reconciling the entity of the caller %s: %s", entityID, err))
+	}
+}
+
+// periodicReconcile reconciles every user once per reconcileInterval.
+func (b *pwManagerBackend) periodicReconcile(ctx context.Context, s logical.Storage, now time.Time) error {
+	if b.c == nil || b.policyService == nil || now.Sub(b.lastReconcile) < reconcileInterval {
+		return nil
+	}
+	b.lastReconcile = now
+
+	report, err := b.reconcileUsers(ctx, s)
+	if err != nil {
+		return err
+	}
+	for _, c := range report.Conflicts {
+		b.logger.Warn(c)
+	}
+	return nil
+}
+
+// reconcileUsers reconciles the entity of every user.
+func (b *pwManagerBackend) reconcileUsers(ctx context.Context, s logical.Storage) (*pwmgrReconcileReport, error) {
+	ids, err := s.List(ctx, fmt.Sprintf("%s/byEntityID/", USER_SCHEMA))
+	if err != nil {
+		return nil, err
+	}
+
+	report := newReconcileReport()
+	for _, id := range ids {
+		// merged users are migrated with the entity they were merged into
+		if _, ok := report.Merged[id]; ok {
+			continue
+		}
+
+		entity, err := b.c.Identity().EntityByID(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			report.Missing = append(report.Missing, id)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		if err := b.reconcileEntity(ctx, s, entity, report); err != nil {
+			return report, err
+		}
+	}
+	report.Missing = slices.DeleteFunc(report.Missing, func(id string) bool {
+		_, ok := report.Merged[id]
+		return ok
+	})
+	return report, nil
+}
+
+// reconcileEntity migrates the users merged into the entity to its id and
+// the user of the entity to its current name.
+func (b *pwManagerBackend) reconcileEntity(ctx context.Context, s logical.Storage, entity Entity, report *pwmgrReconcileReport) error {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	user, err := b.getUser(ctx, s, entity.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, mergedID := range entity.mergedEntityIDs() {
+		merged, err := b.getUser(ctx, s, mergedID)
+		if err != nil {
+			return err
+		}
+		if merged == nil {
+			continue
+		}
+		if user != nil {
+			report.Conflicts = append(report.Conflicts, fmt.Sprintf("users %s and %s are merged into one entity, offboard one of them", mergedID, entity.ID))
+			continue
+		}
+		if err := b.mergeUser(ctx, s, mergedID, entity); err != nil {
+			return fmt.Errorf("error merging user %s into %s: %w", mergedID, entity.ID, err)
+		}
+		report.Merged[mergedID] = entity.ID
+		if user, err = b.getUser(ctx, s, entity.ID); err != nil {
+			return err
+		}
+	}
+
+	if user == nil || entity.Name == "" {
+		return nil
+	}
+	name, err := b.getUserEntityName(ctx, s, entity.ID)
+	if err != nil {
+		return err
+	}
+	if name == entity.Name {
+		return nil
+	}
+	if err := b.renameUser(ctx, s, entity.ID, name, entity.Name); err != nil {
+		return fmt.Errorf("error renaming user %s to %s: %w", entity.ID, entity.Name, err)
+	}
+	report.Renamed[entity.ID] = entity.Name
+	return nil
+}
+
+// renameUser moves the name index, the entity name of the bundle users and
+// the policy of the user to the new name. A stale index of another user
+// with the new name is replaced, that user is indexed again when their
+// entity is reconciled.
+func (b *pwManagerBackend) renameUser(ctx context.Context, s logical.Storage, entityID, oldName, newName string) error {
+	sbs, err := b.renameBundleUser(ctx, s, entityID, entityID, newName)
+	if err != nil {
+		return err
+	}
+
+	if err := b.setUserByName(ctx, s, newName, entityID); err != nil {
+		return err
+	}
+	if err := b.UpdateUserPolicy(ctx, sbs, newName); err != nil {
+		return err
+	}
+	if oldName == "" {
+		return nil
+	}
+	if err := s.Delete(ctx, fmt.Sprintf("%s/byName/%s", USER_SCHEMA, oldName)); err != nil {
+		return err
+	}
+	return b.policyService.DeletePolicy(ctx, userPolicyName(oldName))
+}
+
+// mergeUser moves the user merged into the entity to its id: the user
+// entry, the bundles they own with their bundle keys, the bundles shared
+// with them and their name index and policy. The UUK stays salted with the
+// merged entity id, see pwManagerUserEntry.UUKEntityID.
+func (b *pwManagerBackend) mergeUser(ctx context.Context, s logical.Storage, mergedID string, entity Entity) error {
+	user, err := b.getUser(ctx, s, mergedID)
+	if err != nil || user == nil {
+		return err
+	}
+	oldName, err := b.getUserEntityName(ctx, s, mergedID)
+	if err != nil {
+		return err
+	}
+
+	// the bundle keys wrapped for the merged entity are moved first, the
+	// user entry last so a failed merge is retried
+	sbs, err := b.renameBundleUser(ctx, s, mergedID, entity.ID, entity.Name)
+	if err != nil {
+		return err
+	}
+	sharedPath := fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entity.ID)
+	if err := setSharedUserBundles(ctx, s, sharedPath, sbs); err != nil {
+		return err
+	}
+	if err := s.Delete(ctx, fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, mergedID)); err != nil {
+		return err
+	}
+
+	owned, err := b.listBundles(ctx, s, mergedID)
+	if err != nil {
+		return err
+	}
+	for _, pb := range owned {
+		if _, err := b.transferBundle(ctx, s, pb, entity.ID, true); err != nil {
+			return fmt.Errorf("error moving bundle %s: %w", pb.ID, err)
+		}
+	}
+
+	// the UUK can only be rewrapped by the user, clients keep deriving its
+	// 2SKD with the entity id it was built with
+	if user.UUKEntityID == "" {
+		user.UUKEntityID = mergedID
+	}
+	user.EntityID = entity.ID
+	if err := b.setUserByEntityID(ctx, s, entity.ID, user); err != nil {
+		return err
+	}
+	if err := b.setUserByName(ctx, s, entity.Name, entity.ID); err != nil {
+		return err
+	}
+	if err := b.UpdateUserPolicy(ctx, sbs, entity.Name); err != nil {
+		return err
+	}
+	if oldName != "" && oldName != entity.Name {
+		if err := s.Delete(ctx, fmt.Sprintf("%s/byName/%s", USER_SCHEMA, oldName)); err != nil {
+			return err
+		}
+		if err := b.policyService.DeletePolicy(ctx, userPolicyName(oldName)); err != nil {
+			return err
+		}
+	}
+	return s.Delete(ctx, fmt.Sprintf("%s/byEntityID/%s", USER_SCHEMA, mergedID))
+}
+
+// renameBundleUser sets the entity id and name of the user in the bundles
+// shared with them. A changed entity id moves the bundle key wrapped for
+// the user to the new id. It returns the shared bundles of the user.
+func (b *pwManagerBackend) renameBundleUser(ctx context.Context, s logical.Storage, entityID, newEntityID, newName string) (pwmgrSharedBundles, error) {
+	sbs, err := getSharedUserBundles(ctx, s, fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID))
+	if err != nil {
+		return nil, err
+	}
+	if sbs == nil {
+		sbs = pwmgrSharedBundles{}
+	}
+
+	for _, sb := range sbs {
+		bundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, sb.OwnerEntityID, sb.ID)
+		err := func() error {
+			bundleLock := bundleMapOfMu.Lock(bundlePath)
+			defer bundleLock.Unlock()
+
+			pb, err := getBundle(ctx, s, bundlePath)
+			if err != nil || pb == nil {
+				return err
+			}
+			for i, u := range pb.Users {
+				if u.EntityID == entityID {
+					pb.Users[i].EntityID, pb.Users[i].EntityName = newEntityID, newName
+				}
+			}
+			return setBundle(ctx, s, bundlePath, *pb)
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		if newEntityID != entityID {
+			if err := b.moveBundleKey(ctx, sb.Path, entityID, newEntityID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sbs, nil
+}
+
+// moveBundleKey copies the versions of the bundle key wrapped for the
+// entity to the new entity id, which must not have a key yet, and deletes
+// the old key.
+func (b *pwManagerBackend) moveBundleKey(ctx context.Context, bundlePath, entityID, newEntityID string) error {
+	metadataPath := strings.Replace(bundlePath, "/data/", "/metadata/", 1)
+	key, err := b.snapshotSecret(ctx, fmt.Sprintf("%s/keys/%s", metadataPath, entityID), fmt.Sprintf("%s/keys/%s", bundlePath, entityID))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := b.writeNewSecret(ctx, fmt.Sprintf("%s/keys/%s", bundlePath, newEntityID), key.Versions); err != nil {
+		return err
+	}
+	_, err = b.deleteBundleKey(ctx, bundlePath, entityID)
+	return err
+}
+
+// checkUserName returns an error when the name index of the user is stale
+// because their entity was renamed, merged or deleted. A stale index is
+// reconciled so the name resolves again once the entity is migrated.
+func (b *pwManagerBackend) checkUserName(ctx context.Context, s logical.Storage, entityName, entityID string) error {
+	if b.c == nil {
+		return nil
+	}
+
+	entity, err := b.c.Identity().EntityByID(ctx, entityID)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("entity name `%s` is stale, its entity doesn't exist", entityName)
+	}
+	if err != nil {
+		return err
+	}
+	if entity.Name == entityName {
+		return nil
+	}
+
+	if err := b.reconcileEntity(ctx, s, entity, newReconcileReport()); err != nil {
+		b.logger.Error(fmt.Sprintf("error reconciling entity %s: %s", entityID, err))
+	}
+	return fmt.Errorf("entity name `%s` is stale, the entity was renamed", entityName)
+}
+
+// pathReconcileHelpSynopsis summarizes the help text for reconcile
+const pathReconcileHelpSynopsis = `Migrate users to the current name and id of their Vault entity.`
+
+// pathReconcileHelpDescription describes the help text for reconcile
+const pathReconcileHelpDescription = `
+Users are indexed by the name and id their Vault entity had when they
+registered. When an entity is renamed the name index, the entity name of
+the bundle users and the user policy are moved to the new name. When an
+entity is merged into another the user, their bundles, bundle keys and
+shared bundles are moved to the id of the entity they were merged into. The
+UUK stays salted with the merged entity id, which users/<entity_id> returns
+as uuk_entity_id for clients to unlock it with.
+
+The entity of a caller is reconciled on their requests and every user is
+reconciled hourly. reconcile runs it for every user now and returns which
+users were renamed, merged, have no entity or conflict. Bundles can't be
+shared with a name whose index is stale.
+`
@@ -0,0 +1,212 @@
+package secretsengine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// testEntities serves the identity entities of a test Vault server and
+// passes the other requests to next.
+type testEntities struct {
+	mu       sync.Mutex
+	entities map[string]Entity
+	next     http.HandlerFunc
+	// reads counts the lookups of each entity id
+	reads map[string]int
+}
+
+func (te *testEntities) set(e Entity) {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	te.entities[e.ID] = e
+}
+
+func (te *testEntities) handler(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutPrefix(r.URL.Path, "/v1/identity/entity/id/")
+	if !ok {
+		te.next(w, r)
+		return
+	}
+
+	te.mu.Lock()
+	e, ok := te.entities[id]
+	if te.reads != nil {
+		te.reads[id]++
+	}
+	te.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": e})
+}
+
+// TestReconcile tests migrating users to renamed and merged entities.
+func TestReconcile(t *testing.T) {
+	b, s := getTestBackend(t)
+	policies := &MockPolicyService{}
+	b.policyService = policies
+	kv := &testKVVersions{versions: map[string][]map[string]interface{}{}, casRequired: true}
+	entities := &testEntities{entities: map[string]Entity{}, next: kv.handler}
+	b.c = testVaultServer(t, entities.handler)
+	ctx := context.Background()
+
+	// bob has a UUK built by the web client
+	vectors := readUUKVectors(t)
+	ids := map[string]string{}
+	for _, name := range []string{"bob", "jane", "tom"} {
+		ids[name], _ = uuid.GenerateUUID()
+		user := &pwManagerUserEntry{EntityID: ids[name]}
+		if name == "bob" {
+			ids[name] = vectors.EntityID
+			user = &pwManagerUserEntry{EntityID: ids[name], UUK: vectors.UUK}
+		}
+		entities.set(Entity{ID: ids[name], Name: name})
+		require.NoError(t, b.setUserByEntityID(ctx, s, ids[name], user))
+		require.NoError(t, b.setUserByName(ctx, s, name, ids[name]))
+	}
+
+	request := func(op logical.Operation, path, entityID string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: op, Path: path, Data: data, Storage: s, EntityID: entityID})
+		require.NoError(t, err)
+		return resp
+	}
+	share := func(owner, id string, users ...string) *logical.Response {
+		t.Helper()
+		shares := []pwmgrUser{}
+		for _, u := range users {
+			shares = append(shares, pwmgrUser{EntityName: u, Capabilities: "read,list"})
+		}
+		return request(logical.CreateOperation, fmt.Sprintf("bundles/%s/%s/users", ids[owner], id), ids[owner], map[string]interface{}{"users": shares})
+	}
+	bundle := func(owner, id string) *pwmgrBundle {
+		t.Helper()
+		pb, err := getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, owner, id))
+		require.NoError(t, err)
+		return pb
+	}
+
+	data, err := b.bundleCreate(ctx, s, ids["bob"])
+	require.NoError(t, err)
+	bobBundle := path.Base(data["path"].(string))
+	kv.versions[data["path"].(string)+"/keys/"+ids["bob"]] = []map[string]interface{}{{"key": "bob"}}
+	require.False(t, share("bob", bobBundle, "jane").IsError())
+
+	data, err = b.bundleCreate(ctx, s, ids["jane"])
+	require.NoError(t, err)
+	janeBundle := path.Base(data["path"].(string))
+	kv.versions[data["path"].(string)+"/keys/"+ids["bob"]] = []map[string]interface{}{{"key": "bob"}, {"key": "bob.v2"}}
+	require.False(t, share("jane", janeBundle, "bob").IsError())
+
+	t.Run("rename", func(t *testing.T) {
+		entities.set(Entity{ID: ids["jane"], Name: "jane.doe"})
+
+		resp := share("bob", bobBundle, "jane")
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "stale")
+
+		// the stale name was reconciled
+		entityID, err := b.getUserEntityIDByName(ctx, s, "jane.doe")
+		require.NoError(t, err)
+		require.Equal(t, ids["jane"], entityID)
+		_, err = b.getUserEntityIDByName(ctx, s, "jane")
+		require.Error(t, err)
+		require.Equal(t, "jane.doe", bundle(ids["bob"], bobBundle).Users[0].EntityName)
+		require.Equal(t, []string{"pwmanager/entity/jane"}, policies.Deleted)
+
+		require.False(t, share("bob", bobBundle, "jane.doe").IsError())
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		newBob, _ := uuid.GenerateUUID()
+		delete(entities.entities, ids["bob"])
+		entities.set(Entity{ID: newBob, Name: "robert", MergedEntityIds: []interface{}{ids["bob"]}})
+
+		// bob is migrated on their first request as the merged entity
+		request(logical.ReadOperation, "bundles", newBob, nil)
+
+		user, err := b.getUser(ctx, s, newBob)
+		require.NoError(t, err)
+		require.NotNil(t, user)
+		user, err = b.getUser(ctx, s, ids["bob"])
+		require.NoError(t, err)
+		require.Nil(t, user)
+		entityID, err := b.getUserEntityIDByName(ctx, s, "robert")
+		require.NoError(t, err)
+		require.Equal(t, newBob, entityID)
+
+		// the UUK of bob is unlocked with the entity id returned by the
+		// users endpoint like the clients do
+		resp := request(logical.ReadOperation, "users/"+newBob, newBob, nil)
+		require.Equal(t, ids["bob"], resp.Data["uuk_entity_id"])
+		var entry UserEntry
+		raw, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &entry))
+		uuk := entry.UUK.UUK()
+		_, err = uuk.DecryptEncPriKey([]byte(vectors.Password), []byte(vectors.Mount), []byte(vectors.SecretKey), []byte(entry.SaltEntityID()))
+		require.NoError(t, err)
+
+		// the bundle of bob and its key are moved to the new entity
+		pb := bundle(newBob, bobBundle)
+		require.NotNil(t, pb)
+		require.Equal(t, newBob, pb.OwnerEntityID)
+		require.Equal(t, []map[string]interface{}{{"key": "bob"}}, kv.versions[pb.Path+"/keys/"+newBob])
+		require.Nil(t, bundle(ids["bob"], bobBundle))
+
+		// bob is a user of the bundle of jane with the new entity
+		pb = bundle(ids["jane"], janeBundle)
+		require.Len(t, pb.Users, 1)
+		require.Equal(t, newBob, pb.Users[0].EntityID)
+		require.Equal(t, "robert", pb.Users[0].EntityName)
+		require.Equal(t, []map[string]interface{}{{"key": "bob"}, {"key": "bob.v2"}}, kv.versions[pb.Path+"/keys/"+newBob])
+		require.NotContains(t, kv.versions, pb.Path+"/keys/"+ids["bob"])
+		sbs, err := getSharedUserBundles(ctx, s, fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, newBob))
+		require.NoError(t, err)
+		require.Equal(t, []string{janeBundle}, sharedBundleIDs(sbs))
+		require.Contains(t, policies.Deleted, "pwmanager/entity/bob")
+	})
+
+	t.Run("report", func(t *testing.T) {
+		entities.set(Entity{ID: ids["tom"], Name: "thomas"})
+		gone, _ := uuid.GenerateUUID()
+		require.NoError(t, b.setUserByEntityID(ctx, s, gone, &pwManagerUserEntry{EntityID: gone}))
+
+		resp := request(logical.UpdateOperation, "reconcile", "", nil)
+		require.False(t, resp.IsError(), resp.Error())
+		require.Equal(t, &pwmgrReconcileReport{
+			Renamed:   map[string]string{ids["tom"]: "thomas"},
+			Merged:    map[string]string{},
+			Missing:   []string{gone},
+			Conflicts: []string{},
+		}, resp.Data["report"])
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		entities.set(Entity{ID: ids["tom"], Name: "tom"})
+		now := time.Now()
+		b.reconciled[ids["tom"]] = now
+		b.reconcileCaller(ctx, s, ids["tom"], now.Add(time.Minute))
+		name, err := b.getUserEntityName(ctx, s, ids["tom"])
+		require.NoError(t, err)
+		require.Equal(t, "thomas", name)
+
+		b.reconcileCaller(ctx, s, ids["tom"], now.Add(reconcileCallerInterval))
+		name, err = b.getUserEntityName(ctx, s, ids["tom"])
+		require.NoError(t, err)
+		require.Equal(t, "tom", name)
+	})
+}
@@ -0,0 +1,251 @@
+package secretsengine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// schemaStoragePath holds the schema version of the plugin storage.
+	schemaStoragePath = "schema"
+)
+
+// pwmgrSchema is the schema version of the plugin storage. While a migration
+// runs, Migration is its version and Progress the last key it migrated, so
+// a migration interrupted by a restart resumes after that key.
+type pwmgrSchema struct {
+	Version   int    `json:"version"`
+	Migration int    `json:"migration,omitempty"`
+	Progress  string `json:"progress,omitempty"`
+}
+
+// migration changes the storage layout of one schema version, or the Vault
+// objects derived from the records. run must be idempotent: it is started
+// again from resume, the last key passed to checkpoint, when it didn't
+// complete.
+type migration struct {
+	version     int
+	description string
+	run         func(ctx context.Context, b *pwManagerBackend, s logical.Storage, resume string, checkpoint func(key string) error) error
+}
+
+// migrations are the storage migrations ordered by version. A new migration
+// is appended with the next version and never changed once released.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "record the schema version of mounts created before it was stored",
+		run: func(ctx context.Context, b *pwManagerBackend, s logical.Storage, resume string, checkpoint func(key string) error) error {
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "rewrite the user policies with the read and list rules of bundle attachments",
+		run: func(ctx context.Context, b *pwManagerBackend, s logical.Storage, resume string, checkpoint func(key string) error) error {
+			return b.migrateUserPolicies(ctx, s, resume, checkpoint)
+		},
+	},
+}
+
+// schemaMu serializes the migrations of initialize and restore.
+var schemaMu sync.Mutex
+
+// latestSchemaVersion returns the schema version written by this plugin.
+func latestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// pathSchema extends the Vault API with the admin endpoint to read the
+// schema version of the plugin storage.
+func pathSchema(b *pwManagerBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "schema",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathSchemaRead,
+				},
+			},
+			HelpSynopsis:    pathSchemaHelpSynopsis,
+			HelpDescription: pathSchemaHelpDescription,
+		},
+	}
+}
+
+// pathSchemaRead returns the stored and latest schema versions and the
+// migration in progress.
+func (b *pwManagerBackend) pathSchemaRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	schema, err := getSchema(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		schema = &pwmgrSchema{}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":        schema.Version,
+			"latest_version": latestSchemaVersion(),
+			"migration":      schema.Migration,
+			"progress":       schema.Progress,
+		},
+	}, nil
+}
+
+// migrate applies the migrations newer than the stored schema version in
+// order. A mount without records is new and gets the latest version, a mount
+// with records but no schema version predates it and is at version 0.
+func (b *pwManagerBackend) migrate(ctx context.Context, s logical.Storage) error {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+
+	schema, err := getSchema(ctx, s)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		keys, err := s.List(ctx, "")
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return setSchema(ctx, s, &pwmgrSchema{Version: latestSchemaVersion()})
+		}
+		schema = &pwmgrSchema{}
+	}
+
+	if schema.Version > latestSchemaVersion() {
+		return fmt.Errorf("storage schema version %d is newer than the supported version %d", schema.Version, latestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= schema.Version {
+			continue
+		}
+
+		resume := ""
+		if schema.Migration == m.version {
+			resume = schema.Progress
+			b.logger.Info(fmt.Sprintf("resuming storage migration %d after %q: %s", m.version, resume, m.description))
+		} else {
+			b.logger.Info(fmt.Sprintf("applying storage migration %d: %s", m.version, m.description))
+		}
+
+		schema.Migration = m.version
+		schema.Progress = resume
+		if err := setSchema(ctx, s, schema); err != nil {
+			return err
+		}
+
+		checkpoint := func(key string) error {
+			schema.Progress = key
+			return setSchema(ctx, s, schema)
+		}
+		if err := m.run(ctx, b, s, resume, checkpoint); err != nil {
+			return fmt.Errorf("error applying storage migration %d: %w", m.version, err)
+		}
+
+		schema = &pwmgrSchema{Version: m.version}
+		if err := setSchema(ctx, s, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateUserPolicies writes the policy of every user with shared bundles
+// again so a change of the policy template reaches the existing policies.
+// The mount logs in with its AppRole when the first policy is written.
+func (b *pwManagerBackend) migrateUserPolicies(ctx context.Context, s logical.Storage, resume string, checkpoint func(key string) error) error {
+	// entity names may contain slashes
+	prefix := fmt.Sprintf("%s/byName/", USER_SCHEMA)
+	return migrateKeys(ctx, s, prefix, resume, checkpoint, func(key string) error {
+		name := strings.TrimPrefix(key, prefix)
+		entityID, err := b.getUserEntityIDByName(ctx, s, name)
+		if err != nil {
+			return err
+		}
+		sbs, err := getSharedUserBundles(ctx, s, fmt.Sprintf("%s/%s/sharedWithMe", BUNDLE_SCHEMA, entityID))
+		if err != nil || sbs == nil {
+			return err
+		}
+
+		if b.policyService == nil {
+			if err := b.Login(ctx); err != nil {
+				return err
+			}
+		}
+		return b.UpdateUserPolicy(ctx, sbs, name)
+	})
+}
+
+// migrateKeys calls fn with the keys under the prefix in sorted order and
+// checkpoints each key after fn returns. Keys up to and including resume
+// were migrated before and are skipped.
+func migrateKeys(ctx context.Context, s logical.Storage, prefix, resume string, checkpoint func(key string) error, fn func(key string) error) error {
+	keys, err := logical.CollectKeysWithPrefix(ctx, s, prefix)
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if resume != "" && k <= resume {
+			continue
+		}
+		if err := fn(k); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		if err := checkpoint(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getSchema(ctx context.Context, s logical.Storage) (*pwmgrSchema, error) {
+	entry, err := s.Get(ctx, schemaStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var schema pwmgrSchema
+	if err := entry.DecodeJSON(&schema); err != nil {
+		return nil, fmt.Errorf("error decoding schema version: %w", err)
+	}
+	return &schema, nil
+}
+
+func setSchema(ctx context.Context, s logical.Storage, schema *pwmgrSchema) error {
+	entry, err := logical.StorageEntryJSON(schemaStoragePath, schema)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// pathSchemaHelpSynopsis summarizes the help text for the schema version
+const pathSchemaHelpSynopsis = `Read the schema version of the plugin storage.`
+
+// pathSchemaHelpDescription describes the help text for the schema version
+const pathSchemaHelpDescription = `
+The layout of the plugin records is versioned. When the plugin is mounted or
+upgraded the migrations newer than the stored version are applied in order,
+and a migration interrupted by a restart resumes after the last key it
+migrated. schema returns the stored version, the latest version of the
+plugin and the migration in progress.
+`
@@ -0,0 +1,103 @@
+package secretsengine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMigrate tests applying the migrations of new and existing mounts and
+// resuming an interrupted migration.
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	initialize := func(b *pwManagerBackend, s logical.Storage) error {
+		return b.Initialize(ctx, &logical.InitializationRequest{Storage: s})
+	}
+
+	t.Run("new mount", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		require.NoError(t, initialize(b, s))
+
+		schema, err := getSchema(ctx, s)
+		require.NoError(t, err)
+		require.Equal(t, &pwmgrSchema{Version: latestSchemaVersion()}, schema)
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "schema", Storage: s})
+		require.NoError(t, err)
+		require.Equal(t, latestSchemaVersion(), resp.Data["version"])
+		require.Equal(t, latestSchemaVersion(), resp.Data["latest_version"])
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		original := migrations
+		t.Cleanup(func() { migrations = original })
+
+		// the migration fails on the second user the first time it runs
+		var migrated []string
+		fail := true
+		migrations = append(append([]migration{}, original...), migration{
+			version:     latestSchemaVersion() + 1,
+			description: "test",
+			run: func(ctx context.Context, b *pwManagerBackend, s logical.Storage, resume string, checkpoint func(key string) error) error {
+				return migrateKeys(ctx, s, USER_SCHEMA+"/byName/", resume, checkpoint, func(key string) error {
+					if fail && len(migrated) == 1 {
+						fail = false
+						return errors.New("interrupted")
+					}
+					migrated = append(migrated, key)
+					return nil
+				})
+			},
+		})
+
+		b, s := getTestBackend(t)
+		for _, name := range []string{"bob", "jane", "tom"} {
+			require.NoError(t, b.setUserByName(ctx, s, name, name+"-id"))
+		}
+
+		require.ErrorContains(t, initialize(b, s), "interrupted")
+		schema, err := getSchema(ctx, s)
+		require.NoError(t, err)
+		require.Equal(t, &pwmgrSchema{Version: latestSchemaVersion() - 1, Migration: latestSchemaVersion(), Progress: "users/byName/bob"}, schema)
+
+		require.NoError(t, initialize(b, s))
+		require.Equal(t, []string{"users/byName/bob", "users/byName/jane", "users/byName/tom"}, migrated)
+		schema, err = getSchema(ctx, s)
+		require.NoError(t, err)
+		require.Equal(t, &pwmgrSchema{Version: latestSchemaVersion()}, schema)
+
+		// applied migrations aren't run again
+		require.NoError(t, initialize(b, s))
+		require.Len(t, migrated, 3)
+	})
+
+	t.Run("user policies", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		policies := &MockPolicyService{}
+		b.policyService = policies
+		require.NoError(t, setSchema(ctx, s, &pwmgrSchema{Version: 1}))
+
+		// jane has a shared bundle, tom has none
+		for _, name := range []string{"jane", "tom"} {
+			require.NoError(t, b.setUserByName(ctx, s, name, name+"-id"))
+		}
+		sbs := pwmgrSharedBundles{"b1": {ID: "b1", Path: "bundles/data/bob-id/b1", OwnerEntityID: "bob-id", Capabilities: "read,list"}}
+		require.NoError(t, setSharedUserBundles(ctx, s, BUNDLE_SCHEMA+"/jane-id/sharedWithMe", sbs))
+
+		require.NoError(t, initialize(b, s))
+		require.Equal(t, 1, policies.CallCount)
+		schema, err := getSchema(ctx, s)
+		require.NoError(t, err)
+		require.Equal(t, &pwmgrSchema{Version: latestSchemaVersion()}, schema)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		require.NoError(t, setSchema(ctx, s, &pwmgrSchema{Version: latestSchemaVersion() + 1}))
+		require.ErrorContains(t, initialize(b, s), "newer than the supported version")
+	})
+}
@@ -0,0 +1,625 @@
+package secretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	TRASH_SCHEMA = "trash"
+
+	// defaultTrashRetention is how long deleted entries and bundles are kept
+	// when the mount config has no trash_retention.
+	defaultTrashRetention = 30 * 24 * time.Hour
+
+	trashItemEntry  = "entry"
+	trashItemBundle = "bundle"
+)
+
+// pwmgrTrashItem is a deleted entry or bundle. The kv-v2 data of the item is
+// kept until PurgeAfter and destroyed by the periodic purge.
+//
+// Items are stored under `trash/<owner entity id>/<bundle id>/`, a deleted
+// bundle at `bundle` and deleted entries at `entries/<entry path>`.
+type pwmgrTrashItem struct {
+	// ID is the entry path of an entry and the bundle id of a bundle
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	OwnerEntityID string `json:"owner_entity_id"`
+	BundleID      string `json:"bundle_id"`
+	BundlePath    string `json:"bundle_path"`
+	// Bundle is the deleted bundle with its users so it can be restored
+	Bundle     *pwmgrBundle `json:"bundle,omitempty"`
+	DeletedBy  string       `json:"deleted_by"`
+	Deleted    int64        `json:"deleted"`
+	PurgeAfter int64        `json:"purge_after"`
+}
+
+// TrashItem is the exported name of pwmgrTrashItem.
+type TrashItem = pwmgrTrashItem
+
+// pathTrash extends the Vault API with the trash of deleted entries and
+// bundles. The entries of a bundle are encrypted so clients move a deleted
+// entry out of the bundle metadata and register its path with
+// `bundles/<owner>/<bundle>/trash`. Deleting a bundle moves it to the
+// owners `bundles/trash`.
+func pathTrash(b *pwManagerBackend) []*framework.Path {
+	bundleFields := map[string]*framework.FieldSchema{
+		"owner_entity_id": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "entity id of the bundle owner",
+			Required:    true,
+		},
+		"bundle_id": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "uuid of the bundle",
+			Required:    true,
+		},
+	}
+
+	entryFields := map[string]*framework.FieldSchema{
+		"owner_entity_id": bundleFields["owner_entity_id"],
+		"bundle_id":       bundleFields["bundle_id"],
+		"entry_path": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "kv-v2 path of the entry under entries/",
+			Required:    true,
+		},
+	}
+
+	trashedBundleFields := map[string]*framework.FieldSchema{
+		"bundle_id": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "uuid of the bundle",
+			Required:    true,
+		},
+	}
+
+	bundlePattern := fmt.Sprintf("bundles/%s/%s/trash", uuidRegex("owner_entity_id"), uuidRegex("bundle_id"))
+
+	return []*framework.Path{
+		{
+			Pattern: bundlePattern,
+			Fields:  entryFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathTrashEntriesRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathTrashEntryWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTrashEntryWrite,
+				},
+			},
+			HelpSynopsis:    pathTrashHelpSynopsis,
+			HelpDescription: pathTrashHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s", bundlePattern, uuidRegex("entry_path")),
+			Fields:  entryFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathTrashEntryPurge,
+				},
+			},
+			HelpSynopsis:    pathTrashHelpSynopsis,
+			HelpDescription: pathTrashHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/restore", bundlePattern, uuidRegex("entry_path")),
+			Fields:  entryFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTrashEntryRestore,
+				},
+			},
+			HelpSynopsis:    pathTrashHelpSynopsis,
+			HelpDescription: pathTrashHelpDescription,
+		},
+		{
+			Pattern: "bundles/trash",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathTrashBundlesRead,
+				},
+			},
+			HelpSynopsis:    pathTrashHelpSynopsis,
+			HelpDescription: pathTrashHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("bundles/trash/%s", uuidRegex("bundle_id")),
+			Fields:  trashedBundleFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathTrashBundlePurge,
+				},
+			},
+			HelpSynopsis:    pathTrashHelpSynopsis,
+			HelpDescription: pathTrashHelpDescription,
+		},
+		{
+			Pattern: fmt.Sprintf("bundles/trash/%s/restore", uuidRegex("bundle_id")),
+			Fields:  trashedBundleFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTrashBundleRestore,
+				},
+			},
+			HelpSynopsis:    pathTrashHelpSynopsis,
+			HelpDescription: pathTrashHelpDescription,
+		},
+	}
+}
+
+///////////////////////// entry trash /////////////////////////
+
+// pathTrashEntriesRead returns the deleted entries of the bundle to its
+// users.
+func (b *pwManagerBackend) pathTrashEntriesRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pb, resp, err := b.authorizeBundle(ctx, req, data, "read")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	items, err := listTrashedEntries(ctx, req.Storage, pb.OwnerEntityID, pb.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"items": items,
+		},
+	}, nil
+}
+
+// pathTrashEntryWrite moves an entry to the trash. The client must have
+// removed the entry from the bundle metadata, see checkTrashedEntry.
+func (b *pwManagerBackend) pathTrashEntryWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pb, resp, err := b.authorizeBundle(ctx, req, data, "delete")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	entryPath := data.Get("entry_path").(string)
+	if _, err := uuid.ParseUUID(entryPath); err != nil {
+		return logical.ErrorResponse("entry_path must be the uuid of the entry path"), nil
+	}
+
+	if b.c == nil {
+		return logical.ErrorResponse("pwmanager mount not configured. configure at /config"), nil
+	}
+	if reason, err := b.checkTrashedEntry(ctx, pb, entryPath); err != nil {
+		return clientErrorResponse(err)
+	} else if reason != "" {
+		return logical.ErrorResponse(reason), nil
+	}
+
+	retention, err := getTrashRetention(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	item := &pwmgrTrashItem{
+		ID:            entryPath,
+		Type:          trashItemEntry,
+		OwnerEntityID: pb.OwnerEntityID,
+		BundleID:      pb.ID,
+		BundlePath:    pb.Path,
+		DeletedBy:     req.EntityID,
+		Deleted:       now.Unix(),
+		PurgeAfter:    now.Add(retention).Unix(),
+	}
+
+	if err := setTrashItem(ctx, req.Storage, item); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"item": item,
+		},
+	}, nil
+}
+
+// checkTrashedEntry checks a deleted entry against the kv-v2 metadata of the
+// bundle and returns why it can't be trashed. The bundle metadata is
+// encrypted by the clients so the entries it lists can't be read, the entry
+// must be a secret of the bundle and the bundle metadata must have been
+// written since the entry was, as moving the entry out of it does.
+func (b *pwManagerBackend) checkTrashedEntry(ctx context.Context, pb *pwmgrBundle, entryPath string) (string, error) {
+	kv := b.c.KVBundle(pb.Path)
+	entry, err := kv.secretMetadata(ctx, "entries/"+entryPath)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Sprintf("entry %s not found in the bundle", entryPath), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading entry %s: %w", entryPath, err)
+	}
+
+	metadata, err := kv.secretMetadata(ctx, "metadata/entries")
+	if errors.Is(err, ErrNotFound) {
+		return "bundle metadata not found", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading bundle metadata: %w", err)
+	}
+
+	entryWritten := entry.Versions[strconv.Itoa(entry.CurrentVersion)].CreatedTime
+	metadataWritten := metadata.Versions[strconv.Itoa(metadata.CurrentVersion)].CreatedTime
+	if metadataWritten.Before(entryWritten) {
+		return fmt.Sprintf("entry %s was written after the bundle metadata, remove it from the bundle metadata first", entryPath), nil
+	}
+	return "", nil
+}
+
+// pathTrashEntryRestore removes an entry from the trash so it isn't purged.
+// The client then adds the entry back to the bundle metadata.
+func (b *pwManagerBackend) pathTrashEntryRestore(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pb, resp, err := b.authorizeBundle(ctx, req, data, "update")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	path := trashEntryStoragePath(pb.OwnerEntityID, pb.ID, data.Get("entry_path").(string))
+	item, err := getTrashItem(ctx, req.Storage, path)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return logical.ErrorResponse("entry not found in trash"), nil
+	}
+
+	if err := req.Storage.Delete(ctx, path); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"item": item,
+		},
+	}, nil
+}
+
+// pathTrashEntryPurge destroys a deleted entry before its retention ends.
+func (b *pwManagerBackend) pathTrashEntryPurge(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pb, resp, err := b.authorizeBundle(ctx, req, data, "delete")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	item, err := getTrashItem(ctx, req.Storage, trashEntryStoragePath(pb.OwnerEntityID, pb.ID, data.Get("entry_path").(string)))
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return logical.ErrorResponse("entry not found in trash"), nil
+	}
+
+	if err := b.purgeTrashItem(ctx, req.Storage, item); err != nil {
+		return clientErrorResponse(err)
+	}
+	return nil, nil
+}
+
+///////////////////////// bundle trash /////////////////////////
+
+// trashBundleItem moves the bundle to its owners trash. The bundle users are
+// kept with the item so they can be restored.
+func (b *pwManagerBackend) trashBundleItem(ctx context.Context, s logical.Storage, pb pwmgrBundle, entityID string) error {
+	retention, err := getTrashRetention(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return setTrashItem(ctx, s, &pwmgrTrashItem{
+		ID:            pb.ID,
+		Type:          trashItemBundle,
+		OwnerEntityID: pb.OwnerEntityID,
+		BundleID:      pb.ID,
+		BundlePath:    pb.Path,
+		Bundle:        &pb,
+		DeletedBy:     entityID,
+		Deleted:       now.Unix(),
+		PurgeAfter:    now.Add(retention).Unix(),
+	})
+}
+
+// pathTrashBundlesRead returns the deleted bundles of the caller.
+func (b *pwManagerBackend) pathTrashBundlesRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	bundleIDs, err := req.Storage.List(ctx, fmt.Sprintf("%s/%s/", TRASH_SCHEMA, req.EntityID))
+	if err != nil {
+		return nil, err
+	}
+
+	items := []pwmgrTrashItem{}
+	for _, id := range bundleIDs {
+		item, err := getTrashItem(ctx, req.Storage, trashBundleStoragePath(req.EntityID, strings.TrimSuffix(id, "/")))
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			items = append(items, *item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted < items[j].Deleted
+	})
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"items": items,
+		},
+	}, nil
+}
+
+// pathTrashBundleRestore restores a deleted bundle and shares it again with
+// its users.
+func (b *pwManagerBackend) pathTrashBundleRestore(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	bundleID := data.Get("bundle_id").(string)
+	trashPath := trashBundleStoragePath(req.EntityID, bundleID)
+
+	item, err := getTrashItem(ctx, req.Storage, trashPath)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil || item.Bundle == nil {
+		return logical.ErrorResponse("bundle not found in trash"), nil
+	}
+
+	bundlePath := fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, req.EntityID, bundleID)
+
+	bundleLock := bundleMapOfMu.Lock(bundlePath)
+	defer bundleLock.Unlock()
+
+	if err := setBundle(ctx, req.Storage, bundlePath, *item.Bundle); err != nil {
+		return nil, fmt.Errorf("error restoring bundle: %w", err)
+	}
+
+	if err := req.Storage.Delete(ctx, trashPath); err != nil {
+		return nil, err
+	}
+
+	if len(item.Bundle.Users) > 0 {
+		if err := b.updateModifiedUsers(ctx, req.Storage, *item.Bundle, item.Bundle.Users); err != nil {
+			return clientErrorResponse(err)
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bundle": item.Bundle,
+		},
+	}, nil
+}
+
+// pathTrashBundlePurge destroys a deleted bundle before its retention ends.
+func (b *pwManagerBackend) pathTrashBundlePurge(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	item, err := getTrashItem(ctx, req.Storage, trashBundleStoragePath(req.EntityID, data.Get("bundle_id").(string)))
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return logical.ErrorResponse("bundle not found in trash"), nil
+	}
+
+	if err := b.purgeTrashItem(ctx, req.Storage, item); err != nil {
+		return clientErrorResponse(err)
+	}
+	return nil, nil
+}
+
+///////////////////////// purge /////////////////////////
+
+// purgeTrash purges the items with an expired retention. It is run by the
+// backend PeriodicFunc. Items that fail to purge are retried on the next
+// run.
+func (b *pwManagerBackend) purgeTrash(ctx context.Context, s logical.Storage, now time.Time) error {
+	if b.c == nil {
+		return nil
+	}
+
+	owners, err := s.List(ctx, TRASH_SCHEMA+"/")
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, owner := range owners {
+		owner = strings.TrimSuffix(owner, "/")
+
+		bundleIDs, err := s.List(ctx, fmt.Sprintf("%s/%s/", TRASH_SCHEMA, owner))
+		if err != nil {
+			return err
+		}
+
+		for _, bundleID := range bundleIDs {
+			bundleID = strings.TrimSuffix(bundleID, "/")
+
+			items, err := listTrashedEntries(ctx, s, owner, bundleID)
+			if err != nil {
+				return err
+			}
+
+			bundleItem, err := getTrashItem(ctx, s, trashBundleStoragePath(owner, bundleID))
+			if err != nil {
+				return err
+			}
+			if bundleItem != nil {
+				items = append(items, *bundleItem)
+			}
+
+			for _, item := range items {
+				if item.PurgeAfter > now.Unix() {
+					continue
+				}
+				if err := b.purgeTrashItem(ctx, s, &item); err != nil {
+					errs = append(errs, fmt.Errorf("error purging %s %s: %w", item.Type, item.ID, err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purgeTrashItem destroys every kv-v2 version of the item and its attachments
+// and removes it from the trash. A purged bundle also purges its deleted
+// entries.
+func (b *pwManagerBackend) purgeTrashItem(ctx context.Context, s logical.Storage, item *pwmgrTrashItem) error {
+	if b.c == nil {
+		return fmt.Errorf("pwmanager mount not configured. configure at /config")
+	}
+
+	metadataPath := strings.Replace(item.BundlePath, "/data/", "/metadata/", 1)
+
+	switch item.Type {
+	case trashItemEntry:
+		if err := b.c.delete(ctx, fmt.Sprintf("/v1/%s/entries/%s", metadataPath, item.ID)); err != nil {
+			return err
+		}
+		if err := b.deleteEntryAttachments(ctx, s, item); err != nil {
+			return err
+		}
+		return s.Delete(ctx, trashEntryStoragePath(item.OwnerEntityID, item.BundleID, item.ID))
+	case trashItemBundle:
+		if err := b.destroyKVTree(ctx, metadataPath); err != nil {
+			return err
+		}
+		if err := logical.ClearView(ctx, logical.NewStorageView(s, attachmentStoragePath(item.OwnerEntityID, item.BundleID, "", "")+"/")); err != nil {
+			return err
+		}
+		if err := logical.ClearView(ctx, logical.NewStorageView(s, fmt.Sprintf("%s/%s/%s/", TRASH_SCHEMA, item.OwnerEntityID, item.BundleID))); err != nil {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown trash item type %q", item.Type)
+}
+
+// destroyKVTree destroys every secret under the kv-v2 metadata path.
+func (b *pwManagerBackend) destroyKVTree(ctx context.Context, metadataPath string) error {
+	secret, err := b.c.list(ctx, fmt.Sprintf("/v1/%s", metadataPath))
+	if errors.Is(err, ErrNotFound) || (err == nil && (secret == nil || secret.Data == nil)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		key, _ := k.(string)
+		if strings.HasSuffix(key, "/") {
+			if err := b.destroyKVTree(ctx, metadataPath+"/"+strings.TrimSuffix(key, "/")); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := b.c.delete(ctx, fmt.Sprintf("/v1/%s/%s", metadataPath, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+///////////////////////// storage /////////////////////////
+
+func trashEntryStoragePath(ownerEntityID, bundleID, entryPath string) string {
+	return fmt.Sprintf("%s/%s/%s/entries/%s", TRASH_SCHEMA, ownerEntityID, bundleID, entryPath)
+}
+
+func trashBundleStoragePath(ownerEntityID, bundleID string) string {
+	return fmt.Sprintf("%s/%s/%s/bundle", TRASH_SCHEMA, ownerEntityID, bundleID)
+}
+
+func getTrashItem(ctx context.Context, s logical.Storage, path string) (*pwmgrTrashItem, error) {
+	entry, err := s.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	item := new(pwmgrTrashItem)
+	if err := entry.DecodeJSON(item); err != nil {
+		return nil, fmt.Errorf("error reading trash item: %w", err)
+	}
+	return item, nil
+}
+
+func setTrashItem(ctx context.Context, s logical.Storage, item *pwmgrTrashItem) error {
+	path := trashBundleStoragePath(item.OwnerEntityID, item.BundleID)
+	if item.Type == trashItemEntry {
+		path = trashEntryStoragePath(item.OwnerEntityID, item.BundleID, item.ID)
+	}
+
+	entry, err := logical.StorageEntryJSON(path, item)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// listTrashedEntries returns the deleted entries of the bundle oldest first.
+func listTrashedEntries(ctx context.Context, s logical.Storage, ownerEntityID, bundleID string) ([]pwmgrTrashItem, error) {
+	paths, err := s.List(ctx, fmt.Sprintf("%s/%s/%s/entries/", TRASH_SCHEMA, ownerEntityID, bundleID))
+	if err != nil {
+		return nil, err
+	}
+
+	items := []pwmgrTrashItem{}
+	for _, p := range paths {
+		item, err := getTrashItem(ctx, s, trashEntryStoragePath(ownerEntityID, bundleID, p))
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			items = append(items, *item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted < items[j].Deleted
+	})
+	return items, nil
+}
+
+// getTrashRetention returns the trash_retention of the mount config.
+func getTrashRetention(ctx context.Context, s logical.Storage) (time.Duration, error) {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	if config == nil || config.TrashRetention <= 0 {
+		return defaultTrashRetention, nil
+	}
+	return time.Duration(config.TrashRetention) * time.Second, nil
+}
+
+// pathTrashHelpSynopsis summarizes the help text for the trash
+const pathTrashHelpSynopsis = `Restore or purge deleted entries and bundles.`
+
+// pathTrashHelpDescription describes the help text for the trash
+const pathTrashHelpDescription = `
+Deleted entries and bundles are moved to the trash and destroyed once the
+trash_retention of the mount config has passed. Until then they can be
+restored or purged early. Entries are listed per bundle under
+bundles/<owner>/<bundle>/trash and deleted bundles under bundles/trash. An
+entry is only moved to the trash once the bundle metadata was written after
+the entry's last version.
+`
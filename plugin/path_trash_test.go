@@ -0,0 +1,225 @@
+package secretsengine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// testKVTree serves LIST and DELETE of kv-v2 metadata paths for the secrets
+// in the returned set. Writes add the metadata path of the secret and, like
+// a cas_required mount, must create it with cas 0.
+func testKVTree(t *testing.T, secrets ...string) (*pwmanagerClient, map[string]bool) {
+	var mu sync.Mutex
+	kv := map[string]bool{}
+	// written is when the secrets were last written, the secrets are
+	// seeded a second apart in order
+	written := map[string]time.Time{}
+	for i, s := range secrets {
+		kv[s] = true
+		written[s] = time.Now().Add(time.Duration(i-len(secrets)) * time.Second)
+	}
+
+	c := testVaultServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		p := strings.TrimPrefix(r.URL.Path, "/v1/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("list") == "" && kv[p]:
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"current_version": 1,
+				"versions": map[string]interface{}{
+					"1": map[string]interface{}{"created_time": written[p], "deletion_time": "", "destroyed": false},
+				},
+			}})
+		case r.Method == "LIST" || r.Method == http.MethodGet:
+			keys := map[string]bool{}
+			for s := range kv {
+				if rest, ok := strings.CutPrefix(s, p+"/"); ok {
+					if dir, _, ok := strings.Cut(rest, "/"); ok {
+						keys[dir+"/"] = true
+					} else {
+						keys[rest] = true
+					}
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+				return
+			}
+			list := []string{}
+			for k := range keys {
+				list = append(list, k)
+			}
+			sort.Strings(list)
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": list}})
+		case r.Method == http.MethodPost || r.Method == http.MethodPut:
+			var body kvWriteRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			cas, ok := body.Options["cas"]
+			metadataPath := strings.Replace(p, "/data/", "/metadata/", 1)
+			if !ok || cas != 0 || kv[metadataPath] {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+				return
+			}
+			kv[metadataPath] = true
+			written[metadataPath] = time.Now()
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"version": 1}})
+		case r.Method == http.MethodDelete:
+			delete(kv, p)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	return c, kv
+}
+
+// TestTrash tests moving entries and bundles to the trash, restoring and
+// purging them.
+func TestTrash(t *testing.T) {
+	b, s := getTestBackend(t)
+	b.policyService = &MockPolicyService{}
+	ctx := context.Background()
+
+	entityID, _ := uuid.GenerateUUID()
+	otherEntityID, _ := uuid.GenerateUUID()
+	bundleID, err := testBundleCreate(t, b, s, entityID)
+	require.NoError(t, err)
+
+	pb, err := getBundle(ctx, s, fmt.Sprintf("%s/%s/bundles/%s", BUNDLE_SCHEMA, entityID, bundleID))
+	require.NoError(t, err)
+	metadataPath := strings.Replace(pb.Path, "/data/", "/metadata/", 1)
+
+	entryPath, _ := uuid.GenerateUUID()
+	otherEntryPath, _ := uuid.GenerateUUID()
+	// listedEntryPath was written after the bundle metadata, it is still
+	// listed in it
+	listedEntryPath, _ := uuid.GenerateUUID()
+	c, kv := testKVTree(t,
+		metadataPath+"/keys/"+entityID,
+		metadataPath+"/entries/"+entryPath,
+		metadataPath+"/entries/"+otherEntryPath,
+		metadataPath+"/metadata/entries",
+		metadataPath+"/entries/"+listedEntryPath,
+	)
+	b.c = c
+
+	request := func(op logical.Operation, path, entityID string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+			EntityID:  entityID,
+		})
+	}
+
+	trashPath := fmt.Sprintf("bundles/%s/%s/trash", entityID, bundleID)
+
+	t.Run("trash entry", func(t *testing.T) {
+		resp, err := request(logical.CreateOperation, trashPath, otherEntityID, map[string]interface{}{"entry_path": entryPath})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		resp, err = request(logical.CreateOperation, trashPath, entityID, map[string]interface{}{"entry_path": "not-a-uuid"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		unknownEntryPath, _ := uuid.GenerateUUID()
+		resp, err = request(logical.CreateOperation, trashPath, entityID, map[string]interface{}{"entry_path": unknownEntryPath})
+		require.NoError(t, err)
+		require.ErrorContains(t, resp.Error(), "not found in the bundle")
+
+		resp, err = request(logical.CreateOperation, trashPath, entityID, map[string]interface{}{"entry_path": listedEntryPath})
+		require.NoError(t, err)
+		require.ErrorContains(t, resp.Error(), "remove it from the bundle metadata first")
+
+		for _, p := range []string{entryPath, otherEntryPath} {
+			resp, err = request(logical.CreateOperation, trashPath, entityID, map[string]interface{}{"entry_path": p})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+
+		item := resp.Data["item"].(*pwmgrTrashItem)
+		require.Equal(t, otherEntryPath, item.ID)
+		require.Equal(t, entityID, item.DeletedBy)
+		require.Equal(t, int64(defaultTrashRetention/time.Second), item.PurgeAfter-item.Deleted)
+
+		resp, err = request(logical.ReadOperation, trashPath, entityID, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Data["items"], 2)
+	})
+
+	t.Run("restore entry", func(t *testing.T) {
+		resp, err := request(logical.UpdateOperation, fmt.Sprintf("%s/%s/restore", trashPath, entryPath), entityID, nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = request(logical.ReadOperation, trashPath, entityID, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Data["items"], 1)
+		require.True(t, kv[metadataPath+"/entries/"+entryPath])
+	})
+
+	t.Run("purge entry", func(t *testing.T) {
+		resp, err := request(logical.DeleteOperation, fmt.Sprintf("%s/%s", trashPath, otherEntryPath), entityID, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.False(t, kv[metadataPath+"/entries/"+otherEntryPath])
+
+		resp, err = request(logical.ReadOperation, trashPath, entityID, nil)
+		require.NoError(t, err)
+		require.Empty(t, resp.Data["items"])
+	})
+
+	t.Run("delete and restore bundle", func(t *testing.T) {
+		resp, err := request(logical.DeleteOperation, fmt.Sprintf("bundles/%s/%s", entityID, bundleID), entityID, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = request(logical.ReadOperation, "bundles/trash", entityID, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Data["items"], 1)
+
+		resp, err = request(logical.ReadOperation, "bundles/trash", otherEntityID, nil)
+		require.NoError(t, err)
+		require.Empty(t, resp.Data["items"])
+
+		resp, err = request(logical.UpdateOperation, fmt.Sprintf("bundles/trash/%s/restore", bundleID), entityID, nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		bundles, err := b.listBundles(ctx, s, entityID)
+		require.NoError(t, err)
+		require.Len(t, bundles, 1)
+	})
+
+	t.Run("periodic purge", func(t *testing.T) {
+		resp, err := request(logical.DeleteOperation, fmt.Sprintf("bundles/%s/%s", entityID, bundleID), entityID, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		require.NoError(t, b.purgeTrash(ctx, s, time.Now()))
+		require.NotEmpty(t, kv)
+
+		require.NoError(t, b.purgeTrash(ctx, s, time.Now().Add(defaultTrashRetention+time.Hour)))
+		require.Empty(t, kv)
+
+		resp, err = request(logical.ReadOperation, "bundles/trash", entityID, nil)
+		require.NoError(t, err)
+		require.Empty(t, resp.Data["items"])
+	})
+}
@@ -19,10 +19,19 @@ const (
 type pwManagerUserEntry struct {
 	EntityID string            `json:"entity_id" mapstructure:"entity_id"`
 	UUK      pwManagerUUKEntry `json:"uuk" mapstructure:"uuk"`
+	// UUKEntityID is the entity id the 2SKD of the UUK is salted with when
+	// it isn't EntityID, e.g. after the user was merged into another entity
+	UUKEntityID string `json:"uuk_entity_id,omitempty" mapstructure:"uuk_entity_id"`
 }
 
 type PubKey map[string]string
 
+// Exported names of the user types.
+type (
+	UserEntry = pwManagerUserEntry
+	UUKEntry  = pwManagerUUKEntry
+)
+
 // pwManagerUUKEntry defines the data required
 // for a Vault register to access and call the PwManager
 // token endpoints
@@ -39,11 +48,21 @@ type pwManagerUUKEntry struct {
 	PubKey PubKey `json:"pub_key" mapstructure:"pub_key"`
 }
 
+// SaltEntityID returns the entity id clients derive the 2SKD of the UUK
+// with.
+func (r *pwManagerUserEntry) SaltEntityID() string {
+	if r.UUKEntityID != "" {
+		return r.UUKEntityID
+	}
+	return r.EntityID
+}
+
 // toResponseData returns response data for a user
 func (r *pwManagerUserEntry) toResponseData() map[string]interface{} {
 	respData := map[string]interface{}{
-		"entity_id": r.EntityID,
-		"uuk":       r.UUK,
+		"entity_id":     r.EntityID,
+		"uuk":           r.UUK,
+		"uuk_entity_id": r.SaltEntityID(),
 	}
 
 	return respData
@@ -106,6 +125,8 @@ func pathUser(b *pwManagerBackend) []*framework.Path {
 					Type:        framework.TypeMap,
 					Description: "the users uuk",
 				},
+				"owned_bundles": offboardFields()["owned_bundles"],
+				"transfer_to":   offboardFields()["transfer_to"],
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ReadOperation: &framework.PathOperation{
@@ -127,6 +148,9 @@ func pathUser(b *pwManagerBackend) []*framework.Path {
 				logical.ReadOperation: &framework.PathOperation{
 					Callback: b.pathUsersGetUsers,
 				},
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathUsersList,
+				},
 			},
 			HelpSynopsis:    pathUserListHelpSynopsis,
 			HelpDescription: pathUserListHelpDescription,
@@ -157,6 +181,16 @@ func (b *pwManagerBackend) pathUsersGetUsers(ctx context.Context, req *logical.R
 
 }
 
+// pathUsersList makes a request to Vault storage to list the entity ids of registered users
+func (b *pwManagerBackend) pathUsersList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entriesByEntityID, err := req.Storage.List(ctx, fmt.Sprintf("%s/byEntityID/", USER_SCHEMA))
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entriesByEntityID), nil
+}
+
 // pathUsersRead makes a request to Vault storage to read a user and return response data
 func (b *pwManagerBackend) pathUsersRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	entry, err := b.getUser(ctx, req.Storage, d.Get("entity_id").(string))
@@ -218,14 +252,9 @@ func (b *pwManagerBackend) pathUsersWrite(ctx context.Context, req *logical.Requ
 	return nil, nil
 }
 
-// pathUsersDelete makes a request to Vault storage to delete a user
+// pathUsersDelete offboards a user, see offboardUser.
 func (b *pwManagerBackend) pathUsersDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", USER_SCHEMA, d.Get("entity_id").(string)))
-	if err != nil {
-		return nil, fmt.Errorf("error deleting pwManager user: %w", err)
-	}
-
-	return nil, nil
+	return b.offboardUser(ctx, req.Storage, d.Get("entity_id").(string), d)
 }
 
 // pathRegistersWrite makes a request to Vault storage to register a users UUK.
@@ -295,9 +324,9 @@ func (b *pwManagerBackend) pathRegistersWrite(ctx context.Context, req *logical.
 	// err = b.c.c.Sys().Mount(usersDefaultMountPath, &mi)
 	// //	TODO Delete user on error creating private vault
 
-	entity, err := b.c.Identity().EntityByID(req.EntityID)
+	entity, err := b.c.Identity().EntityByID(ctx, req.EntityID)
 	if err != nil {
-		return logical.ErrorResponse("error retrieving users Entity Name"), nil
+		return clientErrorResponse(fmt.Errorf("error retrieving users Entity Name: %w", err))
 	}
 
 	err = b.setUserByName(ctx, req.Storage, entity.Name, req.EntityID)
@@ -198,6 +198,7 @@ func TestUserRegister(t *testing.T) {
 }
 
 func TestRegisterUser(t *testing.T) {
+	ctx := context.Background()
 	mount := "pwmanager"
 
 	t.Log("Test Registering User")
@@ -249,7 +250,7 @@ func TestRegisterUser(t *testing.T) {
 		t.Logf("Register User")
 		{
 			for k, v := range users {
-				if err := v.Client.Users().Register(mount, v.UUK); err != nil {
+				if err := v.Client.Users().Register(ctx, mount, v.UUK); err != nil {
 					t.Fatalf("\t%s error registering user %s: %s", FAILURE, k, err)
 				}
 				t.Logf("\t%s should be able to register user %s\n", SUCCESS, k)
@@ -271,7 +272,7 @@ func TestRegisterUser(t *testing.T) {
 		{
 			for k, v := range users {
 
-				if err := v.Client.Users().Register(mount, v.UUK); err == nil {
+				if err := v.Client.Users().Register(ctx, mount, v.UUK); err == nil {
 					t.Fatalf("\t%sshould not be allowed to register %s more than once: %s", FAILURE, k, err)
 				}
 				t.Logf("\t%s should not be able to register %s twice\n", SUCCESS, k)
@@ -281,7 +282,7 @@ func TestRegisterUser(t *testing.T) {
 		t.Log("GET User")
 		{
 			for k, v := range users {
-				ue, err := v.Client.Users().Get(mount, v.LoginResponse.Auth.EntityID)
+				ue, err := v.Client.Users().Get(ctx, mount, v.LoginResponse.Auth.EntityID)
 				if err != nil || ue.UUK.EncryptedBy == "" {
 					t.Fatalf("\t%s %s should be able to get users UUK: %s", FAILURE, k, err)
 				}
@@ -291,12 +292,12 @@ func TestRegisterUser(t *testing.T) {
 
 		t.Log("GET another User")
 		{
-			if _, err := stephen.Client.Users().Get(mount, frank.LoginResponse.Auth.EntityID); err == nil {
+			if _, err := stephen.Client.Users().Get(ctx, mount, frank.LoginResponse.Auth.EntityID); err == nil {
 				t.Fatalf("\t%s stephen should not be able to get franks uuk: %s", FAILURE, err)
 			}
 			t.Logf("\t%s stephen should not be able to get franks uuk\n", SUCCESS)
 
-			if _, err := frank.Client.Users().Get(mount, stephen.LoginResponse.Auth.EntityID); err == nil {
+			if _, err := frank.Client.Users().Get(ctx, mount, stephen.LoginResponse.Auth.EntityID); err == nil {
 				t.Fatalf("\t%s frank should not be able to get stephens uuk: %s", FAILURE, err)
 			}
 			t.Logf("\t%s frank should not be able to get stephens uuk\n", SUCCESS)
@@ -305,7 +306,7 @@ func TestRegisterUser(t *testing.T) {
 		t.Log("Update User")
 		{
 			for k, v := range users {
-				if err := v.Client.Users().Update(mount, v.LoginResponse.Auth.EntityID, v.UUK); err != nil {
+				if err := v.Client.Users().Update(ctx, mount, v.LoginResponse.Auth.EntityID, v.UUK); err != nil {
 					t.Fatalf("\t%s error updating user %s: %s", FAILURE, k, err)
 				}
 				t.Logf("\t%s should be able to update user %s\n", SUCCESS, k)
@@ -314,12 +315,12 @@ func TestRegisterUser(t *testing.T) {
 
 		t.Log("Update another User")
 		{
-			if err := stephen.Client.Users().Update(mount, frank.LoginResponse.Auth.EntityID, stephen.UUK); err == nil {
+			if err := stephen.Client.Users().Update(ctx, mount, frank.LoginResponse.Auth.EntityID, stephen.UUK); err == nil {
 				t.Fatalf("\t%s stephen should not be able to update frank: %s", FAILURE, err)
 			}
 			t.Logf("\t%s stephen should not be able to update frank\n", SUCCESS)
 
-			if err := frank.Client.Users().Update(mount, stephen.LoginResponse.Auth.EntityID, stephen.UUK); err == nil {
+			if err := frank.Client.Users().Update(ctx, mount, stephen.LoginResponse.Auth.EntityID, stephen.UUK); err == nil {
 				t.Fatalf("\t%s frank should not be able to update stephen: %s", FAILURE, err)
 			}
 			t.Logf("\t%s frank should not be able to update stephen\n", SUCCESS)
@@ -327,13 +328,13 @@ func TestRegisterUser(t *testing.T) {
 
 		t.Log("List users")
 		{
-			us, err := stephen.Client.Users().List(mount)
+			us, err := stephen.Client.Users().List(ctx, mount)
 			if err != nil || len(users) != len(us) {
 				t.Fatalf("\t%s stephen should be able to list users: %s", FAILURE, err)
 			}
 			t.Logf("\t%s stephen should be able to list users", SUCCESS)
 
-			if _, err := frank.Client.Users().List(mount); err == nil {
+			if _, err := frank.Client.Users().List(ctx, mount); err == nil {
 				t.Fatalf("\t%s frank should not be able to list users: %s", FAILURE, err)
 			}
 			t.Logf("\t%s frank should not be able to list users", SUCCESS)
@@ -341,17 +342,17 @@ func TestRegisterUser(t *testing.T) {
 
 		t.Log("Delete User")
 		{
-			if err := stephen.Client.Users().Delete(mount, bob.LoginResponse.Auth.EntityID); err != nil {
+			if err := stephen.Client.Users().Delete(ctx, mount, bob.LoginResponse.Auth.EntityID); err != nil {
 				t.Fatalf("\t%s stephen should be able to delete user bob: %s", FAILURE, err)
 			}
 			t.Logf("\t%s stephen should be able to delete user bob", SUCCESS)
 
-			if err := frank.Client.Users().Delete(mount, bob.LoginResponse.Auth.EntityID); err == nil {
+			if err := frank.Client.Users().Delete(ctx, mount, bob.LoginResponse.Auth.EntityID); err == nil {
 				t.Fatalf("\t%s frank should not be able to delete user bob: %s", FAILURE, err)
 			}
 			t.Logf("\t%s frank should not be able to delete user bob", SUCCESS)
 
-			if err := frank.Client.Users().Delete(mount, frank.LoginResponse.Auth.EntityID); err == nil {
+			if err := frank.Client.Users().Delete(ctx, mount, frank.LoginResponse.Auth.EntityID); err == nil {
 				t.Fatalf("\t%s frank should not be able to delete user frank: %s", FAILURE, err)
 			}
 			t.Logf("\t%s frank should not be able to delete user frank", SUCCESS)
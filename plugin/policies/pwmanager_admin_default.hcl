@@ -4,4 +4,44 @@ path "pwmanager/users" {
 
 path "pwmanager/users/*" {
     capabilities = ["delete"]
-}
\ No newline at end of file
+}
+
+path "pwmanager/generator/rules" {
+    capabilities = ["list"]
+}
+
+path "pwmanager/generator/rules/*" {
+    capabilities = ["create", "read", "update", "delete"]
+}
+
+# backup is read or written with include_kv_data, restore fills an empty mount
+path "pwmanager/backup" {
+    capabilities = ["read", "update"]
+}
+
+path "pwmanager/restore" {
+    capabilities = ["update"]
+}
+
+# fsck reads the report, updating it with repair fixes the records
+path "pwmanager/fsck" {
+    capabilities = ["read", "update"]
+}
+
+path "pwmanager/reconcile" {
+    capabilities = ["update"]
+}
+
+path "pwmanager/schema" {
+    capabilities = ["read"]
+}
+
+# account packages are signed with the mount signing key and imported when
+# signed by a trusted key
+path "pwmanager/account/signing_key" {
+    capabilities = ["read"]
+}
+
+path "pwmanager/account/trusted_keys/*" {
+    capabilities = ["create", "read", "update", "delete", "list"]
+}
@@ -7,6 +7,56 @@ path "/sys/policies/acl/pwmanager/*" {
     capabilities = ["create", "read", "update", "patch", "delete", "list"]
 }
 
+# the plugin only touches the bundle keys, bundle metadata, entries and
+# attachments of a bundle. Purging the trash destroys deleted entries and
+# bundles, backups, offboarding and the trash read the versions of the
+# bundle secrets.
+path "bundles/metadata/+/+/" {
+    capabilities = ["list"]
+}
+
+path "bundles/metadata/+/+/keys/*" {
+    capabilities = ["read", "delete", "list"]
+}
+
+path "bundles/metadata/+/+/metadata/*" {
+    capabilities = ["read", "delete", "list"]
+}
+
+path "bundles/metadata/+/+/entries/*" {
+    capabilities = ["read", "delete", "list"]
+}
+
+path "bundles/metadata/+/+/attachments/*" {
+    capabilities = ["read", "delete", "list"]
+}
+
+# attachment chunks are written by the plugin to enforce the bundle quota,
+# restores, account imports and bundle transfers copy the bundle secrets
+path "bundles/data/+/+/keys/*" {
+    capabilities = ["create", "read", "update"]
+}
+
+path "bundles/data/+/+/metadata/*" {
+    capabilities = ["create", "read", "update"]
+}
+
+path "bundles/data/+/+/entries/*" {
+    capabilities = ["create", "read", "update"]
+}
+
+path "bundles/data/+/+/attachments/*" {
+    capabilities = ["create", "read", "update"]
+}
+
 path "identity/entity/id/+" {
     capabilities = ["read"]
-}
\ No newline at end of file
+}
+# bundles are shared by the alias of an entity on an auth mount
+path "sys/auth" {
+    capabilities = ["read"]
+}
+
+path "identity/lookup/entity" {
+    capabilities = ["update"]
+}
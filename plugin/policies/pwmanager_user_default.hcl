@@ -11,10 +11,41 @@ path "pwmanager/users/{{ identity.entity.id }}" {
     capabilities = ["update", "read"]
 }
 
-path "bundles/data/{{ identity.entity.id }}/*" {
+path "pwmanager/directory" {
+    capabilities = ["read"]
+}
+
+path "pwmanager/offboard" {
+    capabilities = ["update"]
+}
+
+path "pwmanager/account/export" {
+    capabilities = ["read"]
+}
+
+path "pwmanager/account/import" {
+    capabilities = ["update"]
+}
+
+# the bundle subtrees are listed instead of bundles/data/<id>/* so the
+# attachment chunks are only written by pwmanager/bundles/+/+/attachments/*
+# which enforces the attachment quota
+path "bundles/data/{{ identity.entity.id }}/+/keys/*" {
     capabilities = ["create", "read", "update", "patch", "delete", "list"]
 }
 
+path "bundles/data/{{ identity.entity.id }}/+/metadata/*" {
+    capabilities = ["create", "read", "update", "patch", "delete", "list"]
+}
+
+path "bundles/data/{{ identity.entity.id }}/+/entries/*" {
+    capabilities = ["create", "read", "update", "patch", "delete", "list"]
+}
+
+path "bundles/data/{{ identity.entity.id }}/+/attachments/*" {
+    capabilities = ["read", "list"]
+}
+
 path "bundles/metadata/{{ identity.entity.id }}/*" {
     capabilities = ["create", "read", "update", "patch", "delete", "list"]
 }
@@ -23,11 +54,47 @@ path "pwmanager/bundles" {
     capabilities = ["create", "read", "update", "patch", "delete", "list"]
 }
 
+path "pwmanager/bundles/+/+" {
+    capabilities = ["read", "delete"]
+}
+
 path "pwmanager/bundles/+/+/users" {
     capabilities = ["create", "read", "update", "patch", "list"]
 }
 
+path "pwmanager/bundles/+/+/trash" {
+    capabilities = ["create", "read", "update"]
+}
+
+path "pwmanager/bundles/+/+/trash/*" {
+    capabilities = ["update", "delete"]
+}
+
+path "pwmanager/bundles/+/+/attachments" {
+    capabilities = ["read"]
+}
+
+path "pwmanager/bundles/+/+/attachments/*" {
+    capabilities = ["create", "update", "delete"]
+}
+
+path "pwmanager/bundles/trash" {
+    capabilities = ["read"]
+}
+
+path "pwmanager/bundles/trash/*" {
+    capabilities = ["update", "delete"]
+}
+
 // User needs to know what their entity name is. 
 path "identity/entity/id/{{ identity.entity.id }}" {
     capabilities = ["read"]
+}
+
+path "pwmanager/generator/rules" {
+    capabilities = ["list"]
+}
+
+path "pwmanager/generator/rules/*" {
+    capabilities = ["read"]
 }
\ No newline at end of file